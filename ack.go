@@ -0,0 +1,163 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrNotAcknowledged = errors.New("acknowledged delivery is not enabled")
+
+// This file contains acknowledged delivery, which is used when PacketSession.Acknowledged is
+// set. Documents sent with Deliver() are stamped with a message ID and kept in the session's
+// Outbox, and the receiving side answers each Document with a message ID by sending an ack frame
+// holding the ID as soon as it reads the Document. The ack removes the Document from the Outbox.
+// Anything left in the Outbox when the connection is lost is sent again by ResendPending() once
+// the next connection is set up, so Documents are delivered at least once. The receiving side
+// records the IDs it has seen in Duplicates and drops Documents which arrive a second time.
+//
+// Ack frames are handled while the session reads, so the sending side has to keep reading, such
+// as by calling Incoming() or ReadDocument(), or its acks pile up in the connection.
+
+// DefaultDuplicateWindow and DefaultDuplicateEntries control how long and how many message IDs
+// are remembered for filtering duplicates when a session's Duplicates isn't set
+var (
+	DefaultDuplicateWindow  = 10 * time.Minute
+	DefaultDuplicateEntries = 10000
+)
+
+// errAckReceived is returned by readFrame() when an ack frame arrives instead of packet data
+var errAckReceived = errors.New("ack received")
+
+// errDuplicate is returned by decodeDocument() for a Document which has already been received
+var errDuplicate = errors.New("duplicate document")
+
+// maxAckIDSize is the longest message ID which can be acknowledged
+const maxAckIDSize = 255
+
+// Deliver sends a keyed Document and keeps it in the session's Outbox until the other side
+// acknowledges it. The Document is given a message ID with Stamp() if it doesn't have one, and the
+// ID is returned. Like SendRequest(), the Document passed in isn't changed. If sending fails, the
+// Document stays in the Outbox to be sent again by ResendPending().
+func (s *PacketSession) Deliver(doc *Document) (string, error) {
+
+	if !s.Acknowledged {
+		return "", s.wrapError(ErrNotAcknowledged)
+	}
+	msg := &Document{Items: doc.Items, sensitive: doc.sensitive}
+	if err := msg.Stamp(); err != nil {
+		return "", s.wrapError(err)
+	}
+	id, _ := msg.MessageID()
+	if len(id) > maxAckIDSize {
+		return "", s.wrapError(fmt.Errorf("%w: message ID is longer than %d bytes",
+			ErrInvalidMsg, maxAckIDSize))
+	}
+
+	if err := runInterceptors(s.Outbound, msg); err != nil {
+		return "", s.wrapError(err)
+	}
+	packet, err := s.flattenDocument(msg)
+	if err != nil {
+		return "", s.wrapError(err)
+	}
+	if err := s.outbox().Put(id, packet); err != nil {
+		return "", s.wrapError(err)
+	}
+	return id, s.Write(packet)
+}
+
+// ResendPending sends everything in the session's Outbox again. DialWithRetry() calls it for
+// sessions which have an Outbox once setup has finished; anything else which sets up sessions
+// with a shared Outbox should call it after each reconnect.
+func (s *PacketSession) ResendPending() error {
+
+	if !s.Acknowledged {
+		return s.wrapError(ErrNotAcknowledged)
+	}
+	err := s.outbox().PendingIter(func(id string, packet []byte) error {
+		return s.Write(packet)
+	})
+	return s.wrapError(err)
+}
+
+// outbox returns the session's Outbox, creating one which only lasts as long as the session if it
+// doesn't have one
+func (s *PacketSession) outbox() MessageStore {
+
+	if s.Outbox != nil {
+		return s.Outbox
+	}
+	s.ackLock.Lock()
+	defer s.ackLock.Unlock()
+	if s.localOutbox == nil {
+		s.localOutbox = NewMemoryMessageStore()
+	}
+	return s.localOutbox
+}
+
+// duplicates returns the cache used to filter duplicates, creating one which only lasts as long
+// as the session if it doesn't have one
+func (s *PacketSession) duplicates() DedupCache {
+
+	if s.Duplicates != nil {
+		return s.Duplicates
+	}
+	s.ackLock.Lock()
+	defer s.ackLock.Unlock()
+	if s.localDuplicates == nil {
+		s.localDuplicates = NewMemoryDedupCache(DefaultDuplicateWindow, DefaultDuplicateEntries)
+	}
+	return s.localDuplicates
+}
+
+// acknowledge sends an ack frame for a Document which was just received and reports whether it
+// has been received before. Documents without message IDs aren't acknowledged.
+func (s *PacketSession) acknowledge(doc *Document) (bool, error) {
+
+	id, err := doc.MessageID()
+	if err != nil || id == "" || len(id) > maxAckIDSize {
+		return false, nil
+	}
+
+	// Duplicates are acknowledged too, since the ack for the first copy may have been lost
+	if err := s.sendAck(id); err != nil {
+		return false, err
+	}
+	cache := s.duplicates()
+	if _, seen := cache.Get(id); seen {
+		return true, nil
+	}
+	cache.Put(id, nil)
+	return false, nil
+}
+
+// sendAck sends an ack frame for the specified message ID
+func (s *PacketSession) sendAck(id string) error {
+
+	s.frameLock.Lock()
+	defer s.frameLock.Unlock()
+
+	if err := s.writeFrame(s.output(), AckFrame, []byte(id)); err != nil {
+		return err
+	}
+	if s.writer != nil {
+		return s.writer.Flush()
+	}
+	return nil
+}
+
+// ackReceived marks the message acknowledged by an ack frame in the Outbox
+func (s *PacketSession) ackReceived(payload []byte) error {
+
+	if len(payload) == 0 || len(payload) > maxAckIDSize {
+		return ErrInvalidFrame
+	}
+	if err := s.outbox().MarkAcked(string(payload)); err != nil {
+		logWarning("session %s couldn't remove acknowledged message %s: %s", s.ID(),
+			string(payload), err.Error())
+	}
+	return errAckReceived
+}
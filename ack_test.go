@@ -0,0 +1,110 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// waitForAcks waits until an Outbox holds the specified number of packets
+func waitForAcks(t *testing.T, outbox *MemoryMessageStore, count int) {
+	deadline := time.Now().Add(time.Second)
+	for outbox.Len() != count {
+		if time.Now().After(deadline) {
+			t.Fatalf("Outbox has %d packets instead of %d", outbox.Len(), count)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	outbox := NewMemoryMessageStore()
+	duplicates := NewMemoryDedupCache(time.Minute, 0)
+	acknowledged := func(req, resp *PacketSession) {
+		req.Acknowledged = true
+		req.Outbox = outbox
+		resp.Acknowledged = true
+		resp.Duplicates = duplicates
+	}
+	requester, responder := sessionPair(t, acknowledged)
+
+	// The requester reads in the background so that it picks up the acks
+	requester.Incoming()
+	delivered := make([]*Document, 3)
+	for i := range delivered {
+		delivered[i] = testMessage(t, "ECHO", "n", int32(i))
+	}
+	go func() {
+		for _, doc := range delivered {
+			if _, err := requester.Deliver(doc); err != nil {
+				t.Errorf("Deliver failed: %s", err.Error())
+			}
+		}
+	}()
+	for i := int32(0); i < 3; i++ {
+		doc, err := responder.ReadDocument()
+		if err != nil {
+			t.Fatalf("Error reading delivered document: %s", err.Error())
+		}
+		fields, _ := doc.fieldMap()
+		if n, _ := fields.GetInt32("n"); n != i {
+			t.Fatalf("Document %d arrived out of order", n)
+		}
+	}
+	waitForAcks(t, outbox, 0)
+
+	// A Document which is sent but never acknowledged is left in the Outbox
+	go requester.Deliver(testMessage(t, "ECHO", "n", int32(10)))
+	if _, err := responder.Read(); err != nil {
+		t.Fatalf("Error reading delivered packet: %s", err.Error())
+	}
+	requester.Close()
+	responder.Close()
+	if outbox.Len() != 1 {
+		t.Fatalf("Unacknowledged document wasn't kept")
+	}
+
+	// It is sent again over the next connection, and a second copy of it is dropped
+	requester, responder = sessionPair(t, acknowledged)
+	requester.Incoming()
+	next := testMessage(t, "ECHO", "n", int32(11))
+	go func() {
+		requester.ResendPending()
+		outbox.PendingIter(func(id string, packet []byte) error {
+			return requester.Write(packet)
+		})
+		requester.Deliver(next)
+	}()
+	for _, want := range []int32{10, 11} {
+		doc, err := responder.ReadDocument()
+		if err != nil {
+			t.Fatalf("Error reading resent document: %s", err.Error())
+		}
+		fields, _ := doc.fieldMap()
+		if n, _ := fields.GetInt32("n"); n != want {
+			t.Fatalf("Got document %d instead of %d", n, want)
+		}
+	}
+	waitForAcks(t, outbox, 0)
+}
+
+func TestDeliverNotAcknowledged(t *testing.T) {
+	requester, _ := sessionPair(t, nil)
+	req := testMessage(t, "ECHO", "n", int32(1))
+	if _, err := requester.Deliver(req); !errors.Is(err, ErrNotAcknowledged) {
+		t.Fatalf("Deliver without acknowledged delivery returned %v", err)
+	}
+
+	// Both sides have to agree to it
+	clientConn, serverConn := net.Pipe()
+	requester = NewPacketRequester(clientConn)
+	requester.Acknowledged = true
+	responder := NewPacketResponder(serverConn, 4096)
+	go responder.InitResponder()
+	if err := requester.InitRequester(); !errors.Is(err, ErrSessionSetup) {
+		t.Fatalf("Setup with mismatched options returned %v", err)
+	}
+}
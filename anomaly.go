@@ -0,0 +1,74 @@
+package oganesson
+
+// AnomalyKind identifies the kind of suspicious data reported by an anomaly hook
+type AnomalyKind int
+
+const (
+	// AnomalyLargeSize means a declared size was close to or over a decoding limit
+	AnomalyLargeSize AnomalyKind = iota + 1
+
+	// AnomalyMalformedFrame means a session received a frame which broke the framing protocol
+	AnomalyMalformedFrame
+
+	// AnomalyResync means a DocumentStreamReader had to skip data to find the next Document
+	AnomalyResync
+)
+
+func (k AnomalyKind) String() string {
+	switch k {
+	case AnomalyLargeSize:
+		return "large size"
+	case AnomalyMalformedFrame:
+		return "malformed frame"
+	case AnomalyResync:
+		return "resync"
+	}
+	return "unknown"
+}
+
+// AnomalySizePercent is how close, as a percentage of a limit, a declared size must be to the
+// limit for it to be reported as an AnomalyLargeSize
+var AnomalySizePercent = uint64(90)
+
+// Anomaly describes suspicious data seen while reading. Anomalies don't necessarily mean an
+// attack -- a buggy peer can cause them, too -- but a pattern of them can be used to throttle or
+// ban a misbehaving peer.
+type Anomaly struct {
+	Kind AnomalyKind
+
+	// Peer identifies where the data came from. Sessions use the remote address of the
+	// connection; for other readers it is whatever the caller has set.
+	Peer string
+
+	// SessionID is the ID of the session which saw the anomaly. It is empty for readers which
+	// aren't part of a session.
+	SessionID string
+
+	// Size is the declared size, if known, and Limit the limit it was checked against for
+	// AnomalyLargeSize
+	Size  uint64
+	Limit uint64
+
+	// Count is the number of times in a row the anomaly has happened for AnomalyMalformedFrame
+	// and AnomalyResync
+	Count int
+
+	// Err is the error which was returned for the data, if any
+	Err error
+}
+
+// AnomalyHook is called when a reader sees an anomaly. It is called from the reading goroutine,
+// so it should return quickly.
+type AnomalyHook func(Anomaly)
+
+// report calls the hook if one has been set
+func (h AnomalyHook) report(a Anomaly) {
+	if h != nil {
+		h(a)
+	}
+}
+
+// nearLimit returns true if a size is close enough to a limit to be reported
+func nearLimit(size uint64, limit uint64) bool {
+	return limit > 0 && float64(size) >= float64(limit)*float64(AnomalySizePercent)/100
+}
@@ -0,0 +1,68 @@
+package oganesson
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestDecoderAnomalies(t *testing.T) {
+	anomalies := make([]Anomaly, 0)
+
+	d := NewDecoder(bytes.NewReader([]byte("\x0e\x00\x03ABC\x0e\x00\x0aABCDEFGHIJ")))
+	d.Limits.MaxSegmentSize = 8
+	d.Peer = "test"
+	d.OnAnomaly = func(a Anomaly) { anomalies = append(anomalies, a) }
+
+	if _, err := d.Next(); err != nil {
+		t.Fatalf("Decoder.Next failed on a small segment: %s", err.Error())
+	}
+	if len(anomalies) != 0 {
+		t.Fatalf("Decoder reported an anomaly for a small segment")
+	}
+
+	if _, err := d.Next(); err != ErrLimitExceeded {
+		t.Fatalf("Decoder.Next didn't enforce the segment size limit")
+	}
+	if len(anomalies) != 1 || anomalies[0].Kind != AnomalyLargeSize ||
+		anomalies[0].Peer != "test" {
+		t.Fatalf("Decoder didn't report an oversized segment")
+	}
+
+	if !nearLimit(90, 100) || nearLimit(89, 100) || nearLimit(1000, 0) {
+		t.Fatalf("nearLimit threshold mismatch")
+	}
+}
+
+func TestStreamAnomalies(t *testing.T) {
+	good := []byte("\x01\x01\x0e\x00\x03ABC\x02\x00\x00\x00\x00\x00\x00\x00\x01")
+	bad := []byte("\x01\x01\x0e\x00\x03ABC\x02\x00\x00\x00\x00\x00\x00\x00\x05")
+
+	stream := append(append([]byte(nil), bad...), good...)
+
+	anomalies := make([]Anomaly, 0)
+	sr := NewDocumentStreamReader(bytes.NewReader(stream))
+	sr.OnAnomaly = func(a Anomaly) { anomalies = append(anomalies, a) }
+
+	for {
+		doc, err := sr.Read()
+		if err == io.EOF {
+			t.Fatalf("Stream ended without reading the good Document")
+		}
+		if doc != nil {
+			break
+		}
+	}
+
+	if len(anomalies) == 0 {
+		t.Fatalf("Stream reader didn't report a resync")
+	}
+	for i, a := range anomalies {
+		if a.Kind != AnomalyResync || a.Count != i+1 {
+			t.Fatalf("Resync anomaly mismatch at index %d", i)
+		}
+	}
+	if sr.resyncs != 0 {
+		t.Fatalf("Resync count wasn't reset by a good Document")
+	}
+}
@@ -0,0 +1,45 @@
+package oganesson
+
+// DecodeArena hands out the memory for segment values from one large allocation instead of
+// allocating each value separately. Servers which decode bursts of messages can use one to cut
+// down on garbage collection work: decode a Document with an arena, handle it, call Release(), and
+// reuse the arena for the next one. An arena is not safe for concurrent use.
+type DecodeArena struct {
+	buffer []byte
+	used   int
+}
+
+// NewDecodeArena creates an arena with the specified number of bytes. Values which don't fit in
+// the arena's remaining space are allocated normally.
+func NewDecodeArena(size int) *DecodeArena {
+	return &DecodeArena{buffer: make([]byte, size)}
+}
+
+// Release makes all of the arena's memory available again. The memory which was used is wiped
+// so that secrets decoded into it don't linger until it is reused. Segments decoded using the
+// arena must not be used after calling it because their values will be overwritten.
+func (a *DecodeArena) Release() {
+	for i := range a.buffer[:a.used] {
+		a.buffer[i] = 0
+	}
+	a.used = 0
+}
+
+// Available returns the number of bytes left in the arena
+func (a *DecodeArena) Available() int {
+	return len(a.buffer) - a.used
+}
+
+// alloc returns a slice of the specified size. If the arena is nil or full, the slice is
+// allocated normally.
+func (a *DecodeArena) alloc(size uint64) []byte {
+
+	if a == nil || size > uint64(a.Available()) {
+		return make([]byte, size)
+	}
+
+	// The capacity is capped so that appending to one value can't overwrite the next
+	start := a.used
+	a.used += int(size)
+	return a.buffer[start:a.used:a.used]
+}
@@ -0,0 +1,55 @@
+package oganesson
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestDecodeArena(t *testing.T) {
+	doc := NewDocument()
+	doc.AttachString("testString", "abcdef")
+	doc.AttachInt64("testInt", 42)
+	data, err := doc.Flatten()
+	if err != nil {
+		t.Fatalf("Error flattening document: %s", err.Error())
+	}
+
+	arena := NewDecodeArena(64)
+	d := NewDecoder(bytes.NewReader(data))
+	d.Arena = arena
+
+	var out Document
+	if err := out.Decode(context.Background(), d); err != nil {
+		t.Fatalf("Document.Decode failed with an arena: %s", err.Error())
+	}
+	if v, _ := out.Items[0].(*Segment).GetString(); v != "abcdef" {
+		t.Fatalf("Arena-backed value mismatch: %s", v)
+	}
+
+	// DocStart + String + Int64 + DocEnd payloads
+	if arena.Available() != 64-(1+6+8+8) {
+		t.Fatalf("Arena usage mismatch: %d available", arena.Available())
+	}
+	if cap(out.Items[0].(*Segment).Value) != 6 {
+		t.Fatalf("Arena-backed value capacity wasn't capped")
+	}
+
+	value := out.Items[0].(*Segment).Value
+	arena.Release()
+	if arena.Available() != 64 {
+		t.Fatalf("Arena.Release didn't free the arena")
+	}
+	if !bytes.Equal(value, make([]byte, len(value))) {
+		t.Fatalf("Arena.Release didn't wipe the arena")
+	}
+
+	// Values which don't fit are allocated normally
+	if len(arena.alloc(100)) != 100 || arena.Available() != 64 {
+		t.Fatalf("Arena didn't fall back to a normal allocation")
+	}
+	var nilArena *DecodeArena
+	if len(nilArena.alloc(10)) != 10 {
+		t.Fatalf("Nil arena allocation failed")
+	}
+}
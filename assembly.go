@@ -0,0 +1,157 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+var ErrAssemblyBudget = errors.New("multipart assembly budget exceeded")
+
+// This file limits the memory used for putting multipart packets back together. When the start
+// of a multipart packet arrives, its announced size is reserved from the session's
+// MaxAssemblySize and from its AssemblyBudget, which may be shared with other sessions. A packet
+// which doesn't fit is rejected right away instead of being collected: Read() returns
+// ErrAssemblyBudget, the rest of the packet's frames are thrown away as they arrive, and a reject
+// frame is sent back so that the sender can stop. The session stays open. The reservation is
+// released once the packet is complete or abandoned.
+//
+// The sender finds out about the rejection when it reads the reject frame, which cancels the
+// transfer as though CancelTransfer() had been called, so its Write() returns
+// ErrTransferCanceled. This only happens if something is reading the sending side while the
+// packet is being sent, such as the reader started by SendRequest() or Incoming().
+
+// errRejectReceived is returned by readFrame() when a reject frame arrives instead of packet data
+var errRejectReceived = errors.New("reject received")
+
+// AssemblyBudget limits the total memory used by multipart packets being put back together by
+// all of the sessions which share it. It is safe for concurrent use.
+type AssemblyBudget struct {
+	lock  sync.Mutex
+	limit uint64
+	used  uint64
+}
+
+// DefaultAssemblyBudget is used by sessions whose AssemblyBudget isn't set, so it limits all of
+// them together. It has no limit until SetLimit() is called.
+var DefaultAssemblyBudget = NewAssemblyBudget(0)
+
+// NewAssemblyBudget creates a budget allowing the specified number of bytes. Zero means no limit.
+func NewAssemblyBudget(limit uint64) *AssemblyBudget {
+	return &AssemblyBudget{limit: limit}
+}
+
+// SetLimit changes the number of bytes the budget allows. Reservations which have already been
+// made aren't affected.
+func (b *AssemblyBudget) SetLimit(limit uint64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.limit = limit
+}
+
+// Used returns the number of bytes reserved for packets being put back together
+func (b *AssemblyBudget) Used() uint64 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.used
+}
+
+// reserve takes the specified number of bytes from the budget if they fit
+func (b *AssemblyBudget) reserve(size uint64) bool {
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.limit > 0 && (size > b.limit || b.used > b.limit-size) {
+		return false
+	}
+	b.used += size
+	return true
+}
+
+// release returns reserved bytes to the budget
+func (b *AssemblyBudget) release(size uint64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.used -= size
+}
+
+// assemblyBudget returns the budget used by the session
+func (s *PacketSession) assemblyBudget() *AssemblyBudget {
+	if s.AssemblyBudget != nil {
+		return s.AssemblyBudget
+	}
+	return DefaultAssemblyBudget
+}
+
+// reserveAssembly reserves the memory for a multipart packet which is starting to arrive. If it
+// doesn't fit, the packet is rejected.
+func (s *PacketSession) reserveAssembly(size uint64) error {
+
+	s.recvTransfers++
+
+	// Offline sessions, which put captured packets back together, aren't limited
+	if s.Connection == nil {
+		return nil
+	}
+
+	var limit uint64
+	switch {
+	case s.MaxAssemblySize > 0 && size > s.MaxAssemblySize:
+		limit = s.MaxAssemblySize
+	case !s.assemblyBudget().reserve(size):
+		limit = s.assemblyBudget().Used()
+	default:
+		s.incoming.reserved = size
+		return nil
+	}
+
+	s.OnAnomaly.report(Anomaly{Kind: AnomalyLargeSize, Peer: s.peer(), SessionID: s.ID(),
+		Size: size, Limit: limit, Err: ErrAssemblyBudget})
+	s.skipContinued = true
+	if err := s.sendReject(s.recvTransfers); err != nil {
+		return err
+	}
+	return ErrAssemblyBudget
+}
+
+// resetIncoming clears the packet being received, releasing the memory reserved for it
+func (s *PacketSession) resetIncoming() {
+	if s.incoming.reserved > 0 {
+		s.assemblyBudget().release(s.incoming.reserved)
+	}
+	s.incoming = incomingPacket{}
+}
+
+// sendReject sends a reject frame for a multipart packet. Transfers are numbered the same way on
+// both sides, so the frame holds the number of multipart packets received so far.
+func (s *PacketSession) sendReject(transfer uint64) error {
+
+	payload := make([]byte, 8)
+	binary.BigEndian.PutUint64(payload, transfer)
+
+	s.frameLock.Lock()
+	defer s.frameLock.Unlock()
+
+	if err := s.writeFrame(s.output(), RejectFrame, payload); err != nil {
+		return err
+	}
+	if s.writer != nil {
+		return s.writer.Flush()
+	}
+	return nil
+}
+
+// rejectReceived cancels the transfer rejected by a reject frame if it is still being sent
+func (s *PacketSession) rejectReceived(payload []byte) error {
+
+	if len(payload) != 8 {
+		return ErrInvalidFrame
+	}
+	id := binary.BigEndian.Uint64(payload)
+	if err := s.CancelTransfer(id); err == nil {
+		logWarning("session %s: transfer %d was rejected by the other side", s.ID(), id)
+	}
+	return errRejectReceived
+}
@@ -0,0 +1,74 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestAssemblyBudget(t *testing.T) {
+	requester, responder := sessionPair(t, nil)
+	budget := NewAssemblyBudget(5000)
+	responder.AssemblyBudget = budget
+
+	// Closing the sessions unblocks any writer still waiting on the responder
+	var writers sync.WaitGroup
+	t.Cleanup(func() {
+		responder.Close()
+		requester.Close()
+		writers.Wait()
+	})
+	write := func(packet []byte) {
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			requester.Write(packet)
+		}()
+	}
+
+	// The requester needs a reader to see the reject frame
+	requester.Incoming()
+
+	small := bytes.Repeat([]byte{1}, 4500)
+	write(small)
+	packet, err := responder.Read()
+	if err != nil || !bytes.Equal(packet, small) {
+		t.Fatalf("Packet within the budget wasn't received: %v", err)
+	}
+	if budget.Used() != 0 {
+		t.Fatalf("Reservation wasn't released: %d bytes", budget.Used())
+	}
+
+	// A packet over the budget is rejected, and the session goes on working
+	writeErr := make(chan error, 1)
+	writers.Add(1)
+	go func() {
+		defer writers.Done()
+		writeErr <- requester.Write(bytes.Repeat([]byte{2}, 20000))
+		requester.Write(small)
+	}()
+	if _, err := responder.Read(); !errors.Is(err, ErrAssemblyBudget) {
+		t.Fatalf("Packet over the budget returned %v", err)
+	}
+	packet, err = responder.Read()
+	if err != nil || !bytes.Equal(packet, small) {
+		t.Fatalf("Packet after a rejected one wasn't received: %v", err)
+	}
+	if err := <-writeErr; !errors.Is(err, ErrTransferCanceled) {
+		t.Fatalf("Rejected transfer wasn't canceled: %v", err)
+	}
+	if responder.State() == StateClosed || budget.Used() != 0 {
+		t.Fatalf("Rejection closed the session or kept its reservation")
+	}
+
+	// The session's own cap is applied on top of the budget
+	budget.SetLimit(0)
+	responder.MaxAssemblySize = 4200
+	write(small)
+	if _, err := responder.Read(); !errors.Is(err, ErrAssemblyBudget) {
+		t.Fatalf("Packet over MaxAssemblySize returned %v", err)
+	}
+}
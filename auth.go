@@ -0,0 +1,193 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+var ErrAuthFailed = errors.New("authentication failed")
+
+// Authenticator checks the identity of the requesting side of a PacketSession during setup. If a
+// session has one, it is run right after the buffer size has been negotiated by InitRequester()
+// and InitResponder(), so the responder can turn away a peer before any application data is
+// exchanged. Both sides of a session must be configured with the same kind of Authenticator.
+type Authenticator interface {
+	// Prove is called by the requesting side to send its credentials to the responder
+	Prove(s *PacketSession) error
+
+	// Verify is called by the responding side to check the requester's credentials. It returns
+	// an error if the requester should be rejected.
+	Verify(s *PacketSession) error
+}
+
+// Packets sent by the responder after running its Authenticator
+var (
+	authAccepted = []byte{1}
+	authRejected = []byte{0}
+)
+
+// authenticate runs the session's Authenticator, if it has one, once setup has finished. If
+// authentication fails, the connection is closed.
+func (s *PacketSession) authenticate(isRequester bool) error {
+
+	if s.Authenticator == nil {
+		return nil
+	}
+
+	var err error
+	if isRequester {
+		err = s.proveIdentity()
+	} else {
+		err = s.verifyIdentity()
+	}
+	if err != nil {
+		s.isInit = false
+		s.setClosed(CloseAuthFailed, err)
+		s.Connection.Close()
+	}
+	return err
+}
+
+func (s *PacketSession) proveIdentity() error {
+
+	if err := s.Authenticator.Prove(s); err != nil {
+		return err
+	}
+
+	result, err := s.Read()
+	if err != nil {
+		return err
+	}
+	if string(result) != string(authAccepted) {
+		return ErrAuthFailed
+	}
+	return nil
+}
+
+func (s *PacketSession) verifyIdentity() error {
+
+	verifyErr := s.Authenticator.Verify(s)
+	if verifyErr != nil {
+		// If the connection itself failed, there's no point in telling the requester anything
+		if s.CloseReason() != CloseNone {
+			return verifyErr
+		}
+
+		// The requester is told that it was rejected, but not why
+		s.Write(authRejected)
+		if !errors.Is(verifyErr, ErrAuthFailed) {
+			verifyErr = fmt.Errorf("%w: %s", ErrAuthFailed, verifyErr.Error())
+		}
+		return verifyErr
+	}
+	return s.Write(authAccepted)
+}
+
+// PSKAuthenticator authenticates the requester with a pre-shared key using HMAC-SHA256
+// challenge-response. The responder sends a random challenge and the requester answers with an
+// HMAC of the challenge, the session's handshake transcript, and, on TLS connections, the
+// session's ChannelBinding(). The key itself is never sent and a recorded response can't be
+// replayed on another session.
+//
+// Authentication is one-way: the responder never proves that it knows the key. On connections
+// without TLS there is also nothing to tie the response to the channel, so an attacker who can
+// sit between the two sides can relay the challenge and response and take over the session
+// afterward. Use TLS when either of these matters.
+type PSKAuthenticator struct {
+	Key []byte
+}
+
+// pskChallengeSize is the number of random bytes sent by the responder
+const pskChallengeSize = 32
+
+// Prove answers the responder's challenge
+func (a PSKAuthenticator) Prove(s *PacketSession) error {
+
+	if len(a.Key) == 0 {
+		return ErrEmptyData
+	}
+
+	challenge, err := s.Read()
+	if err != nil {
+		return err
+	}
+	if len(challenge) != pskChallengeSize {
+		return ErrAuthFailed
+	}
+	return s.Write(a.response(s, challenge))
+}
+
+// Verify sends a challenge to the requester and checks its response
+func (a PSKAuthenticator) Verify(s *PacketSession) error {
+
+	if len(a.Key) == 0 {
+		return ErrEmptyData
+	}
+
+	challenge := make([]byte, pskChallengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return err
+	}
+	if err := s.Write(challenge); err != nil {
+		return err
+	}
+
+	response, err := s.Read()
+	if err != nil {
+		return err
+	}
+	if !SecureCompare(response, a.response(s, challenge)) {
+		return ErrAuthFailed
+	}
+	return nil
+}
+
+// response calculates the correct response to a challenge for the session
+func (a PSKAuthenticator) response(s *PacketSession, challenge []byte) []byte {
+	mac := hmac.New(sha256.New, a.Key)
+	mac.Write(challenge)
+	if s.handshake != nil {
+		mac.Write(s.handshake.TranscriptHash)
+	}
+	if binding, err := s.ChannelBinding(); err == nil {
+		mac.Write(binding)
+	}
+	return mac.Sum(nil)
+}
+
+// TokenAuthenticator authenticates the requester with a bearer token, such as an API key. The
+// token is sent as-is, so it should only be used over an encrypted connection.
+type TokenAuthenticator struct {
+	// Token is sent by the requester
+	Token string
+
+	// Check is used by the responder to decide whether a token is acceptable. It returns an error
+	// if the token should be rejected.
+	Check func(token string) error
+}
+
+// Prove sends the token to the responder
+func (a TokenAuthenticator) Prove(s *PacketSession) error {
+	if a.Token == "" {
+		return ErrEmptyData
+	}
+	return s.Write([]byte(a.Token))
+}
+
+// Verify receives the requester's token and checks it
+func (a TokenAuthenticator) Verify(s *PacketSession) error {
+
+	token, err := s.Read()
+	if err != nil {
+		return err
+	}
+	if a.Check == nil {
+		return ErrAuthFailed
+	}
+	return a.Check(string(token))
+}
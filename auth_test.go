@@ -0,0 +1,101 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestPSKAuthenticator(t *testing.T) {
+	auth := PSKAuthenticator{Key: []byte("shared secret")}
+	authenticators := func(requesterAuth Authenticator) func(req, resp *PacketSession) {
+		return func(req, resp *PacketSession) {
+			req.Authenticator = requesterAuth
+			resp.Authenticator = auth
+		}
+	}
+	requester, responder, reqErr, respErr := setupSessionPair(authenticators(auth))
+	if reqErr != nil || respErr != nil {
+		t.Fatalf("PSK authentication failed: %v / %v", reqErr, respErr)
+	}
+
+	go requester.Write([]byte("hello"))
+	if packet, err := responder.Read(); err != nil || string(packet) != "hello" {
+		t.Fatalf("Session unusable after authentication")
+	}
+
+	requester, responder, reqErr, respErr = setupSessionPair(
+		authenticators(PSKAuthenticator{Key: []byte("wrong secret")}))
+	if !errors.Is(respErr, ErrAuthFailed) {
+		t.Fatalf("Responder accepted the wrong key: %v", respErr)
+	}
+	if !errors.Is(reqErr, ErrAuthFailed) {
+		t.Fatalf("Requester wasn't told it was rejected: %v", reqErr)
+	}
+	if responder.CloseReason() != CloseAuthFailed || requester.CloseReason() != CloseAuthFailed {
+		t.Fatalf("Close reason mismatch after failed authentication: %s / %s",
+			requester.CloseReason(), responder.CloseReason())
+	}
+	if err := responder.Write([]byte("hello")); !errors.Is(err, ErrNoInit) {
+		t.Fatalf("Rejected session is still usable")
+	}
+}
+
+func TestPSKChannelBinding(t *testing.T) {
+	config := testTLSConfig(t)
+	auth := PSKAuthenticator{Key: []byte("shared secret")}
+
+	clientConn, serverConn := net.Pipe()
+	requester := NewPacketRequester(tls.Client(clientConn, config))
+	requester.Authenticator = auth
+	responder := NewPacketResponder(tls.Server(serverConn, config), 32767)
+	responder.Authenticator = auth
+
+	errChan := make(chan error)
+	go func() {
+		errChan <- requester.InitRequester()
+	}()
+	if err := responder.InitResponder(); err != nil {
+		t.Fatalf("PSK authentication over TLS failed: %s", err.Error())
+	}
+	if err := <-errChan; err != nil {
+		t.Fatalf("Requester init failed: %s", err.Error())
+	}
+
+	// The same handshake over a different channel has to give a different response
+	challenge := make([]byte, pskChallengeSize)
+	relayed := PacketSession{isInit: true, Connection: clientConn, handshake: requester.handshake}
+	if bytes.Equal(auth.response(requester, challenge), auth.response(&relayed, challenge)) {
+		t.Fatalf("PSK response isn't bound to the channel")
+	}
+}
+
+func TestTokenAuthenticator(t *testing.T) {
+	check := func(token string) error {
+		if token != "letmein" {
+			return errors.New("unknown token")
+		}
+		return nil
+	}
+
+	authenticators := func(token string) func(req, resp *PacketSession) {
+		return func(req, resp *PacketSession) {
+			req.Authenticator = TokenAuthenticator{Token: token}
+			resp.Authenticator = TokenAuthenticator{Check: check}
+		}
+	}
+
+	_, _, reqErr, respErr := setupSessionPair(authenticators("letmein"))
+	if reqErr != nil || respErr != nil {
+		t.Fatalf("Token authentication failed: %v / %v", reqErr, respErr)
+	}
+
+	_, _, reqErr, respErr = setupSessionPair(authenticators("guess"))
+	if !errors.Is(respErr, ErrAuthFailed) || !errors.Is(reqErr, ErrAuthFailed) {
+		t.Fatalf("Bad token wasn't rejected: %v / %v", reqErr, respErr)
+	}
+}
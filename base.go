@@ -2,7 +2,6 @@ package oganesson
 
 import (
 	"errors"
-	"time"
 )
 
 // This file contains code for wire-level 'packet' handling -- transmission of a byte slice over
@@ -37,13 +36,19 @@ var ErrMultipartSession = errors.New("multipart session error")
 var ErrInvalidMultipartMsg = errors.New("invalid multipart message")
 var ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
 var ErrHashMismatch = errors.New("hash mismatch")
+var ErrReplayDetected = errors.New("frame replay detected")
+var ErrFrameChecksum = errors.New("frame header checksum mismatch")
+var ErrVersionMismatch = errors.New("no common protocol version")
+var ErrFrozen = errors.New("document is frozen")
+var ErrBufferTooSmall = errors.New("buffer too small")
 
 // Constants and Configurable Globals
 
 // MaxCommandLength is the maximum number of bytes a command is permitted to be. Note that
-// bulk transfers are not subject to this restriction -- just the initial command.
+// bulk transfers are not subject to this restriction -- just the initial command. It and
+// DefaultBufferSize are only the defaults for new sessions; use WithMaxCommandLength() and
+// WithBufferSize() to change them for a single session.
 const MinCommandLength = 35
 
 var MaxCommandLength = 16384
 var DefaultBufferSize = uint16(65535)
-var PacketSessionTimeout = 30 * time.Second
@@ -0,0 +1,60 @@
+package oganesson
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// EncodeBatch flattens a batch of Documents using several goroutines at once. The output for all
+// of the Documents is carved out of a single allocation, so encoding a large batch doesn't leave
+// lots of small buffers for the garbage collector. If workers is zero or less, GOMAXPROCS is used.
+// If any Documents can't be flattened, the error for the first of them is returned.
+func EncodeBatch(docs []*Document, workers int) ([][]byte, error) {
+
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	var totalSize uint64
+	for i, doc := range docs {
+		if doc == nil {
+			return nil, fmt.Errorf("%w: document %d is nil", ErrEmptyData, i)
+		}
+		totalSize += doc.GetSize()
+	}
+
+	buffer := make([]byte, totalSize)
+	out := make([][]byte, len(docs))
+	var offset uint64
+	for i, doc := range docs {
+		size := doc.GetSize()
+		out[i] = buffer[offset : offset+size : offset+size]
+		offset += size
+	}
+
+	errs := make([]error, len(docs))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				_, errs[i] = docs[i].FlattenTo(out[i])
+			}
+		}()
+	}
+	for i := range docs {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,35 @@
+package oganesson
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestEncodeBatch(t *testing.T) {
+	docs := make([]*Document, 100)
+	for i := range docs {
+		docs[i] = NewDocument()
+		docs[i].AttachInt32("index", int32(i))
+		docs[i].AttachString("testString", "abcdef")
+	}
+
+	out, err := EncodeBatch(docs, 4)
+	if err != nil {
+		t.Fatalf("EncodeBatch failed: %s", err.Error())
+	}
+	if len(out) != len(docs) {
+		t.Fatalf("EncodeBatch output count mismatch: %d", len(out))
+	}
+	for i, doc := range docs {
+		flat, _ := doc.Flatten()
+		if !bytes.Equal(out[i], flat) {
+			t.Fatalf("EncodeBatch output mismatch for document %d", i)
+		}
+	}
+
+	docs[50] = nil
+	if _, err := EncodeBatch(docs, 0); !errors.Is(err, ErrEmptyData) {
+		t.Fatalf("EncodeBatch didn't catch a nil document")
+	}
+}
@@ -0,0 +1,177 @@
+package oganesson
+
+import (
+	"errors"
+	"math"
+	"sync"
+)
+
+var ErrBudgetExceeded = errors.New("memory budget exceeded")
+
+// This file contains MemoryBudget, which counts the payload bytes allocated while decoding so
+// that servers parsing untrusted input for many tenants can put a hard cap on each of them. A
+// budget is set in DecodeLimits, so it follows the limits through every decode path: a
+// PacketSession's Limits, the limits passed to ReadDocument(), Document.ReadLimited(), and so on.
+//
+// Each segment's payload is charged to the budget before it is allocated, so a segment which
+// doesn't fit is refused without allocating anything. Bytes charged while decoding a Document
+// stay charged until the Document's ReleaseMemory() is called, or are released right away if the
+// decode fails. A budget which is never released caps the total decoded over its lifetime.
+//
+// Budgets can be nested with NewChild(), so a per-document budget can also count against the
+// budget of the session it arrived on.
+
+// MemoryBudget counts the bytes allocated while decoding and refuses allocations past its limit.
+// It is safe for concurrent use.
+type MemoryBudget struct {
+	lock    sync.Mutex
+	limit   uint64
+	used    uint64
+	parents []*MemoryBudget
+}
+
+// NewMemoryBudget creates a budget allowing the specified number of bytes. Zero means no limit,
+// which is useful for just counting.
+func NewMemoryBudget(limit uint64) *MemoryBudget {
+	return &MemoryBudget{limit: limit}
+}
+
+// NewChild creates a budget allowing the specified number of bytes whose charges also count
+// against this budget
+func (b *MemoryBudget) NewChild(limit uint64) *MemoryBudget {
+	return &MemoryBudget{limit: limit, parents: []*MemoryBudget{b}}
+}
+
+// Limit returns the number of bytes the budget allows
+func (b *MemoryBudget) Limit() uint64 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.limit
+}
+
+// Used returns the number of bytes currently charged to the budget
+func (b *MemoryBudget) Used() uint64 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.used
+}
+
+// Charge takes the specified number of bytes from the budget and all of its parents. If any of
+// them doesn't have room, nothing is charged and ErrBudgetExceeded is returned. A nil budget
+// accepts everything.
+func (b *MemoryBudget) Charge(size uint64) error {
+
+	if b == nil || size == 0 {
+		return nil
+	}
+
+	b.lock.Lock()
+	if b.limit > 0 && (size > b.limit || b.used > b.limit-size) {
+		b.lock.Unlock()
+		return ErrBudgetExceeded
+	}
+	b.used += size
+	b.lock.Unlock()
+
+	for i, parent := range b.parents {
+		if err := parent.Charge(size); err != nil {
+			for _, charged := range b.parents[:i] {
+				charged.Release(size)
+			}
+			b.releaseLocal(size)
+			return err
+		}
+	}
+	return nil
+}
+
+// Release returns bytes charged with Charge() to the budget and its parents
+func (b *MemoryBudget) Release(size uint64) {
+
+	if b == nil || size == 0 {
+		return
+	}
+	b.releaseLocal(size)
+	for _, parent := range b.parents {
+		parent.Release(size)
+	}
+}
+
+// releaseLocal returns bytes to this budget only. The count never goes below zero, so releasing
+// after Reset() is harmless.
+func (b *MemoryBudget) releaseLocal(size uint64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if size > b.used {
+		size = b.used
+	}
+	b.used -= size
+}
+
+// Reset forgets all of the charges made against this budget. Its parents aren't affected.
+func (b *MemoryBudget) Reset() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.used = 0
+}
+
+// available returns the number of bytes which could be charged right now. A nil budget has no
+// limit.
+func (b *MemoryBudget) available() uint64 {
+
+	if b == nil {
+		return math.MaxUint64
+	}
+
+	b.lock.Lock()
+	out := uint64(math.MaxUint64)
+	if b.limit > 0 {
+		out = 0
+		if b.used < b.limit {
+			out = b.limit - b.used
+		}
+	}
+	b.lock.Unlock()
+
+	for _, parent := range b.parents {
+		if parentOut := parent.available(); parentOut < out {
+			out = parentOut
+		}
+	}
+	return out
+}
+
+// descendsFrom returns true if the budget is other or charges against it
+func (b *MemoryBudget) descendsFrom(other *MemoryBudget) bool {
+	if b == other {
+		return true
+	}
+	for _, parent := range b.parents {
+		if parent.descendsFrom(other) {
+			return true
+		}
+	}
+	return false
+}
+
+// stricterBudget combines two budgets, keeping in mind that nil means no budget. If one of them
+// already counts against the other it is used, and otherwise charges go to both.
+func stricterBudget(a *MemoryBudget, b *MemoryBudget) *MemoryBudget {
+	switch {
+	case a == nil:
+		return b
+	case b == nil || a.descendsFrom(b):
+		return a
+	case b.descendsFrom(a):
+		return b
+	}
+	return &MemoryBudget{parents: []*MemoryBudget{a, b}}
+}
+
+// ReleaseMemory returns the bytes charged while the Document was decoded to the MemoryBudget
+// they were charged to. It should be called once the Document's data is no longer needed.
+func (doc *Document) ReleaseMemory() {
+	doc.budget.Release(doc.budgetUsed)
+	doc.budget = nil
+	doc.budgetUsed = 0
+}
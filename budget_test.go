@@ -0,0 +1,124 @@
+package oganesson
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	session := NewMemoryBudget(100)
+	doc := session.NewChild(60)
+
+	if err := doc.Charge(50); err != nil {
+		t.Fatalf("Charge failed within budget: %s", err.Error())
+	}
+	if session.Used() != 50 || doc.Used() != 50 {
+		t.Fatalf("Charge didn't count against the parent: %d, %d", session.Used(), doc.Used())
+	}
+	if err := doc.Charge(20); err != ErrBudgetExceeded {
+		t.Fatalf("Charge didn't enforce the child's limit")
+	}
+	if session.Used() != 50 {
+		t.Fatalf("Failed charge was counted against the parent: %d", session.Used())
+	}
+
+	other := session.NewChild(0)
+	if err := other.Charge(60); err != ErrBudgetExceeded {
+		t.Fatalf("Charge didn't enforce the parent's limit")
+	}
+	if other.Used() != 0 {
+		t.Fatalf("Failed charge was counted against the child: %d", other.Used())
+	}
+
+	doc.Release(50)
+	if session.Used() != 0 || doc.Used() != 0 {
+		t.Fatalf("Release mismatch: %d, %d", session.Used(), doc.Used())
+	}
+
+	var nilBudget *MemoryBudget
+	if err := nilBudget.Charge(1 << 40); err != nil {
+		t.Fatalf("nil budget refused a charge")
+	}
+}
+
+func TestDecodeLimitsRestrictBudget(t *testing.T) {
+	session := NewMemoryBudget(100)
+	call := session.NewChild(10)
+
+	limits := DecodeLimits{Budget: session}.Restrict(DecodeLimits{Budget: call})
+	if limits.Budget != call {
+		t.Fatalf("Restrict didn't use the child budget")
+	}
+	if (DecodeLimits{Budget: session}).IsZero() {
+		t.Fatalf("IsZero ignored the budget")
+	}
+
+	other := NewMemoryBudget(20)
+	limits = DecodeLimits{Budget: session}.Restrict(DecodeLimits{Budget: other})
+	if err := limits.Budget.Charge(15); err != nil {
+		t.Fatalf("Charge failed within the combined budget: %s", err.Error())
+	}
+	if session.Used() != 15 || other.Used() != 15 {
+		t.Fatalf("Combined budget didn't charge both: %d, %d", session.Used(), other.Used())
+	}
+}
+
+func TestDocumentMemoryBudget(t *testing.T) {
+	doc := NewDocument()
+	doc.AttachString("testString", strings.Repeat("a", 100))
+	doc.AttachInt64("testInt", 42)
+
+	data, err := doc.Flatten()
+	if err != nil {
+		t.Fatalf("Error flattening document: %s", err.Error())
+	}
+
+	budget := NewMemoryBudget(150)
+	var readDoc Document
+	if err := readDoc.UnflattenLimited(data, DecodeLimits{Budget: budget}); err != nil {
+		t.Fatalf("UnflattenLimited failed within budget: %s", err.Error())
+	}
+	used := budget.Used()
+	if used == 0 {
+		t.Fatalf("Decoding wasn't charged to the budget")
+	}
+
+	var secondDoc Document
+	if err := secondDoc.UnflattenLimited(data, DecodeLimits{Budget: budget}); err !=
+		ErrBudgetExceeded {
+		t.Fatalf("UnflattenLimited didn't enforce the budget: %v", err)
+	}
+	if budget.Used() != used {
+		t.Fatalf("Failed decode wasn't released: %d != %d", budget.Used(), used)
+	}
+
+	readDoc.ReleaseMemory()
+	if budget.Used() != 0 {
+		t.Fatalf("ReleaseMemory didn't release the document's charge: %d", budget.Used())
+	}
+	if err := secondDoc.UnflattenLimited(data, DecodeLimits{Budget: budget}); err != nil {
+		t.Fatalf("UnflattenLimited failed after release: %s", err.Error())
+	}
+}
+
+func TestSegmentMapMemoryBudget(t *testing.T) {
+	sm := make(SegmentMap)
+	sm.SetString("test1", "ABCDEF")
+	sm.SetString("test2", "GHIJKL")
+
+	var buffer bytes.Buffer
+	if err := sm.Write(&buffer); err != nil {
+		t.Fatalf("Error writing segment map: %s", err.Error())
+	}
+
+	budget := NewMemoryBudget(15)
+	readMap := make(SegmentMap)
+	err := readMap.ReadLimited(bytes.NewReader(buffer.Bytes()), DecodeLimits{Budget: budget})
+	if err != ErrBudgetExceeded {
+		t.Fatalf("ReadLimited didn't enforce the budget: %v", err)
+	}
+	if budget.Used() != 0 {
+		t.Fatalf("Failed read wasn't released: %d", budget.Used())
+	}
+}
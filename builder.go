@@ -0,0 +1,205 @@
+package oganesson
+
+import (
+	"fmt"
+)
+
+// DocumentBuilder assembles a Document from named fields. If it is given a Schema, each field is
+// checked against it as it is added and Build() makes sure that no required fields are missing,
+// so protocol mistakes are caught by the sender instead of the peer. The fields are stored in the
+// Document as a single map, in the schema's field order if it has one and in key order otherwise.
+type DocumentBuilder struct {
+	schema *Schema
+	fields SegmentMap
+}
+
+// NewDocumentBuilder creates a new DocumentBuilder. The schema may be nil, in which case no
+// validation is performed.
+func NewDocumentBuilder(schema *Schema) *DocumentBuilder {
+	return &DocumentBuilder{schema, make(SegmentMap)}
+}
+
+// Add adds a field to the document being built. If the field already exists, its value is
+// replaced. ErrReservedKey is returned for names starting with ReservedKeyPrefix; header fields
+// are set with SetHeader() instead.
+func (b *DocumentBuilder) Add(name string, seg Segment) error {
+	if IsReservedKey(name) {
+		return fmt.Errorf("%w '%s'", ErrReservedKey, name)
+	}
+	if b.schema != nil {
+		if err := b.schema.ValidateField(name, seg); err != nil {
+			return err
+		}
+	}
+	b.fields[name] = seg
+	return nil
+}
+
+// AddInt8 adds a field of the specified type to the document being built
+func (b *DocumentBuilder) AddInt8(name string, value int8) error {
+	var seg Segment
+	if err := seg.SetInt8(value); err != nil {
+		return err
+	}
+	return b.Add(name, seg)
+}
+
+// AddUInt8 adds a field of the specified type to the document being built
+func (b *DocumentBuilder) AddUInt8(name string, value uint8) error {
+	var seg Segment
+	if err := seg.SetUInt8(value); err != nil {
+		return err
+	}
+	return b.Add(name, seg)
+}
+
+// AddInt16 adds a field of the specified type to the document being built
+func (b *DocumentBuilder) AddInt16(name string, value int16) error {
+	var seg Segment
+	if err := seg.SetInt16(value); err != nil {
+		return err
+	}
+	return b.Add(name, seg)
+}
+
+// AddUInt16 adds a field of the specified type to the document being built
+func (b *DocumentBuilder) AddUInt16(name string, value uint16) error {
+	var seg Segment
+	if err := seg.SetUInt16(value); err != nil {
+		return err
+	}
+	return b.Add(name, seg)
+}
+
+// AddInt32 adds a field of the specified type to the document being built
+func (b *DocumentBuilder) AddInt32(name string, value int32) error {
+	var seg Segment
+	if err := seg.SetInt32(value); err != nil {
+		return err
+	}
+	return b.Add(name, seg)
+}
+
+// AddUInt32 adds a field of the specified type to the document being built
+func (b *DocumentBuilder) AddUInt32(name string, value uint32) error {
+	var seg Segment
+	if err := seg.SetUInt32(value); err != nil {
+		return err
+	}
+	return b.Add(name, seg)
+}
+
+// AddInt64 adds a field of the specified type to the document being built
+func (b *DocumentBuilder) AddInt64(name string, value int64) error {
+	var seg Segment
+	if err := seg.SetInt64(value); err != nil {
+		return err
+	}
+	return b.Add(name, seg)
+}
+
+// AddUInt64 adds a field of the specified type to the document being built
+func (b *DocumentBuilder) AddUInt64(name string, value uint64) error {
+	var seg Segment
+	if err := seg.SetUInt64(value); err != nil {
+		return err
+	}
+	return b.Add(name, seg)
+}
+
+// AddBool adds a field of the specified type to the document being built
+func (b *DocumentBuilder) AddBool(name string, value bool) error {
+	var seg Segment
+	if err := seg.SetBool(value); err != nil {
+		return err
+	}
+	return b.Add(name, seg)
+}
+
+// AddFloat32 adds a field of the specified type to the document being built
+func (b *DocumentBuilder) AddFloat32(name string, value float32) error {
+	var seg Segment
+	if err := seg.SetFloat32(value); err != nil {
+		return err
+	}
+	return b.Add(name, seg)
+}
+
+// AddFloat64 adds a field of the specified type to the document being built
+func (b *DocumentBuilder) AddFloat64(name string, value float64) error {
+	var seg Segment
+	if err := seg.SetFloat64(value); err != nil {
+		return err
+	}
+	return b.Add(name, seg)
+}
+
+// AddString adds a field of the specified type to the document being built
+func (b *DocumentBuilder) AddString(name string, value string) error {
+	var seg Segment
+	if err := seg.SetString(value); err != nil {
+		return err
+	}
+	return b.Add(name, seg)
+}
+
+// AddBinary adds a field of the specified type to the document being built
+func (b *DocumentBuilder) AddBinary(name string, value []byte) error {
+	var seg Segment
+	if err := seg.SetBinary(value); err != nil {
+		return err
+	}
+	return b.Add(name, seg)
+}
+
+// SetHeader sets the header section of the document being built, replacing any header fields
+// which were set earlier
+func (b *DocumentBuilder) SetHeader(h DocumentHeader) error {
+	return h.writeFields(b.fields)
+}
+
+// Build creates a Document from the fields which have been added. If the builder has a schema, an
+// error listing any missing required fields is returned.
+func (b *DocumentBuilder) Build() (*Document, error) {
+
+	if b.schema != nil {
+		if err := b.schema.Validate(b.fields); err != nil {
+			return nil, err
+		}
+	}
+
+	items, err := orderedItems(b.fields, b.schema.orderedKeys(b.fields))
+	if err != nil {
+		return nil, err
+	}
+	out := NewDocument()
+	out.Items = items
+	return out, nil
+}
+
+// keyedItems returns the items of a keyed Document holding the specified fields in key order
+func keyedItems(fields SegmentMap) ([]SegContainer, error) {
+	return orderedItems(fields, fields.Keys())
+}
+
+// orderedItems returns the items of a keyed Document holding the specified fields in the order
+// of the keys given
+func orderedItems(fields SegmentMap, keys []string) ([]SegContainer, error) {
+
+	var mapIndex Segment
+	if err := mapIndex.SetMapIndex(fields); err != nil {
+		return nil, err
+	}
+
+	out := make([]SegContainer, 0, len(fields)*2+1)
+	out = append(out, &mapIndex)
+	for _, name := range keys {
+		var key Segment
+		if err := key.SetString(name); err != nil {
+			return nil, err
+		}
+		value := fields[name]
+		out = append(out, &key, &value)
+	}
+	return out, nil
+}
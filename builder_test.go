@@ -0,0 +1,96 @@
+package oganesson
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testMessage creates a keyed Document with the specified message code. The fields are given as
+// pairs of a name and a value which Segment.Set() accepts.
+func testMessage(t *testing.T, code string, fields ...interface{}) *Document {
+	if len(fields)%2 != 0 {
+		t.Fatalf("Test message fields must be name and value pairs")
+	}
+
+	b := NewDocumentBuilder(nil)
+	if err := b.AddString(MsgCodeField, code); err != nil {
+		t.Fatalf("Error building test message: %s", err.Error())
+	}
+	for i := 0; i < len(fields); i += 2 {
+		var seg Segment
+		if err := seg.Set(fields[i+1]); err != nil {
+			t.Fatalf("Error setting test message field %v: %s", fields[i], err.Error())
+		}
+		if err := b.Add(fields[i].(string), seg); err != nil {
+			t.Fatalf("Error adding test message field %v: %s", fields[i], err.Error())
+		}
+	}
+	doc, err := b.Build()
+	if err != nil {
+		t.Fatalf("Error building test message: %s", err.Error())
+	}
+	return doc
+}
+
+func TestDocumentBuilder(t *testing.T) {
+	schema := NewSchema(
+		FieldSpec{Name: "user", Type: DFStringType, Required: true},
+		FieldSpec{Name: "port", Type: DFUInt16Type, Required: true,
+			Check: func(seg Segment) error {
+				if port, _ := seg.GetUInt16(); port < 1024 {
+					return errors.New("port must be at least 1024")
+				}
+				return nil
+			}},
+		FieldSpec{Name: "comment", Type: DFStringType},
+	)
+
+	b := NewDocumentBuilder(schema)
+	if err := b.AddString("user", "admin"); err != nil {
+		t.Fatalf("DocumentBuilder.AddString failed: %s", err.Error())
+	}
+	if err := b.AddString("port", "1234"); !errors.Is(err, ErrTypeError) {
+		t.Fatalf("DocumentBuilder didn't catch a field type mismatch")
+	}
+	if err := b.AddUInt16("port", 80); !errors.Is(err, ErrConstraint) {
+		t.Fatalf("DocumentBuilder didn't catch a constraint violation")
+	}
+	if err := b.AddInt32("unknown", 1); !errors.Is(err, ErrUnknownField) {
+		t.Fatalf("DocumentBuilder didn't catch an unknown field")
+	}
+
+	_, err := b.Build()
+	if !errors.Is(err, ErrMissingField) || !strings.Contains(err.Error(), "port") {
+		t.Fatalf("DocumentBuilder.Build didn't catch a missing field")
+	}
+
+	if err := b.AddUInt16("port", 2000); err != nil {
+		t.Fatalf("DocumentBuilder.AddUInt16 failed: %s", err.Error())
+	}
+	doc, err := b.Build()
+	if err != nil {
+		t.Fatalf("DocumentBuilder.Build failed: %s", err.Error())
+	}
+
+	// The fields are stored as a map with the keys in sorted order
+	if len(doc.Items) != 5 {
+		t.Fatalf("DocumentBuilder.Build item count mismatch: wanted 5, got %d", len(doc.Items))
+	}
+	if err := doc.Validate(); err != nil {
+		t.Fatalf("Built document failed validation: %s", err.Error())
+	}
+	key, err := doc.Items[1].(*Segment).GetString()
+	if err != nil || key != "port" {
+		t.Fatalf("DocumentBuilder.Build key order mismatch")
+	}
+
+	// Builders without schemas accept anything
+	b = NewDocumentBuilder(nil)
+	if err := b.AddInt32("unknown", 1); err != nil {
+		t.Fatalf("DocumentBuilder without a schema rejected a field: %s", err.Error())
+	}
+	if _, err := b.Build(); err != nil {
+		t.Fatalf("DocumentBuilder without a schema failed to build: %s", err.Error())
+	}
+}
@@ -0,0 +1,175 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+var ErrBadCapture = errors.New("invalid capture file")
+
+// This file handles capture files, which hold the frames sent and received by a session after
+// setup so that its traffic can be examined offline or replayed into a Handler for regression
+// tests. A capture file starts with a header made up of captureMagic, the format version, and a
+// flags byte. Each frame follows as a 12-byte record header -- the time in Unix nanoseconds, the
+// direction, the frame type, and the payload size -- and the payload. Payloads are recorded
+// without sequence numbers or checksums, so they look the same however the session was set up.
+
+const (
+	captureMagic         = "OGCAP"
+	captureVersion       = uint8(1)
+	captureRecordHdrSize = 12
+
+	// Flags in the capture file header
+	captureCompressed       = uint8(1)
+	captureSharedDictionary = uint8(2)
+
+	// Directions of recorded frames
+	captureInbound  = uint8(0)
+	captureOutbound = uint8(1)
+)
+
+// RecordTo makes the session write every frame it sends or receives from then on to w as a
+// capture file, which ReplaySession() can read back. Frames sent during setup aren't recorded.
+// Passing nil stops recording. If writing to w fails, recording stops and a warning is logged.
+func (s *PacketSession) RecordTo(w io.Writer) {
+	s.captureLock.Lock()
+	defer s.captureLock.Unlock()
+	s.capture = w
+	s.captureStarted = false
+}
+
+// recordFrame adds a frame to the capture file if the session is recording
+func (s *PacketSession) recordFrame(direction uint8, frameType uint8, payload []byte) {
+
+	s.captureLock.Lock()
+	defer s.captureLock.Unlock()
+	if s.capture == nil || s.State() < StateReady {
+		return
+	}
+
+	// The header is written with the first frame because whether or not packets are compressed
+	// isn't known until setup has finished
+	var buf []byte
+	if !s.captureStarted {
+		var flags uint8
+		if s.Compression != nil {
+			flags |= captureCompressed
+		}
+		if s.sharedDictionary {
+			flags |= captureSharedDictionary
+		}
+		buf = append([]byte(captureMagic), captureVersion, flags)
+	}
+
+	buf = binary.BigEndian.AppendUint64(buf, uint64(time.Now().UnixNano()))
+	buf = append(buf, direction, frameType)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(payload)))
+	buf = append(buf, payload...)
+	if _, err := s.capture.Write(buf); err != nil {
+		logWarning("session %s stopped recording: %s", s.id, err.Error())
+		s.capture = nil
+		return
+	}
+	s.captureStarted = true
+}
+
+// ReplaySession reads a capture file and passes each Document received by the recorded session
+// to the handler, in order, so that a protocol handler can be debugged or tested against real
+// traffic without a connection. The handler's replies are returned in the same order, one for
+// each Document. Frames the recorded session sent are skipped. Captures of sessions which
+// compressed packets with a shared dictionary can't be replayed.
+func ReplaySession(r io.Reader, handler Handler) ([]*Document, error) {
+
+	header := make([]byte, len(captureMagic)+2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrBadCapture, err.Error())
+	}
+	if string(header[:len(captureMagic)]) != captureMagic {
+		return nil, ErrBadCapture
+	}
+	if header[len(captureMagic)] != captureVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrBadCapture,
+			header[len(captureMagic)])
+	}
+	flags := header[len(captureMagic)+1]
+	if flags&captureSharedDictionary != 0 {
+		return nil, fmt.Errorf("%w: packets are compressed with a shared dictionary",
+			ErrUnsupportedAlgorithm)
+	}
+
+	replay := offlineSession(flags&captureCompressed != 0)
+
+	ctx := context.Background()
+	out := make([]*Document, 0)
+	record := make([]byte, captureRecordHdrSize)
+	for {
+		if _, err := io.ReadFull(r, record); err != nil {
+			if err == io.EOF {
+				return out, nil
+			}
+			return out, fmt.Errorf("%w: %s", ErrBadCapture, err.Error())
+		}
+		payload := make([]byte, binary.BigEndian.Uint16(record[10:]))
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return out, fmt.Errorf("%w: %s", ErrBadCapture, err.Error())
+		}
+
+		frameType := record[9]
+		if record[8] != captureInbound || frameType == CreditFrame ||
+			frameType == DictionaryFrame || frameType == AckFrame || frameType == RejectFrame ||
+			frameType == FilterFrame {
+			continue
+		}
+
+		packet, done, err := replay.addOfflineFrame(frameType, payload)
+		if err != nil {
+			return out, err
+		}
+		if !done {
+			continue
+		}
+
+		doc := NewDocument()
+		if err := doc.Unflatten(packet); err != nil {
+			return out, err
+		}
+		reply, err := handler(ctx, doc)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, reply)
+	}
+}
+
+// offlineSession returns a session which puts packets back together from frames which have
+// already been read, such as from a capture file. It never touches a connection.
+func offlineSession(compressed bool) *PacketSession {
+	out := &PacketSession{isInit: true}
+	if compressed {
+		out.Compression = &CompressionPolicy{}
+	}
+	return out
+}
+
+// addOfflineFrame adds a frame to the packet being put back together by an offline session and
+// returns the packet, decompressed if needed, once it is complete
+func (s *PacketSession) addOfflineFrame(frameType uint8, payload []byte) ([]byte, bool, error) {
+
+	packet, done, err := s.addFrame(frameType, payload)
+	if err != nil {
+		s.resetIncoming()
+		return nil, false, err
+	}
+	if !done {
+		return nil, false, nil
+	}
+	s.resetIncoming()
+	packet, err = s.decompressPacket(packet)
+	return packet, true, err
+}
@@ -0,0 +1,107 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// recordExchange records the requester's side of a session while the responder sends it the
+// specified strings as Documents, and returns the capture file
+func recordExchange(t *testing.T, requester, responder *PacketSession, values []string) []byte {
+
+	var capture bytes.Buffer
+	requester.RecordTo(&capture)
+	for _, value := range values {
+		doc := NewDocument()
+		doc.AttachString("value", value)
+		go responder.WriteDocument(doc)
+		if _, err := requester.ReadDocument(); err != nil {
+			t.Fatalf("Error reading document: %s", err.Error())
+		}
+	}
+
+	// Sent frames are recorded, too, but aren't replayed
+	go responder.Read()
+	if err := requester.Write([]byte("ignored")); err != nil {
+		t.Fatalf("Error writing packet: %s", err.Error())
+	}
+	requester.RecordTo(nil)
+	return capture.Bytes()
+}
+
+// replayValues replays a capture file and returns the strings from the Documents it holds
+func replayValues(capture []byte) ([]string, error) {
+
+	var out []string
+	_, err := ReplaySession(bytes.NewReader(capture),
+		func(ctx context.Context, doc *Document) (*Document, error) {
+			value, err := doc.Items[0].(*Segment).GetString()
+			out = append(out, value)
+			return nil, err
+		})
+	return out, err
+}
+
+func TestRecordReplay(t *testing.T) {
+	values := []string{"first", strings.Repeat("multipart", 2000), "last"}
+
+	requester, responder := sessionPair(t, nil)
+	requester.MaxCommandLength = 0
+	capture := recordExchange(t, requester, responder, values)
+	replayed, err := replayValues(capture)
+	if err != nil {
+		t.Fatalf("Error replaying capture: %s", err.Error())
+	}
+	if strings.Join(replayed, ",") != strings.Join(values, ",") {
+		t.Fatalf("Replayed documents don't match the recorded ones")
+	}
+
+	// Replies from the handler come back in order
+	replies, err := ReplaySession(bytes.NewReader(capture),
+		func(ctx context.Context, doc *Document) (*Document, error) {
+			return doc, nil
+		})
+	if err != nil || len(replies) != len(values) {
+		t.Fatalf("Wrong replies from replay: %d, %v", len(replies), err)
+	}
+
+	if _, err := replayValues(capture[:len(capture)-1]); !errors.Is(err, ErrBadCapture) {
+		t.Fatalf("Truncated capture wasn't caught: %v", err)
+	}
+	if _, err := replayValues([]byte("not a capture")); !errors.Is(err, ErrBadCapture) {
+		t.Fatalf("Bad capture header wasn't caught: %v", err)
+	}
+}
+
+func TestRecordReplayCompressed(t *testing.T) {
+	values := []string{strings.Repeat("compressible ", 500), "short"}
+	compressed := func(dict []byte) func(req, resp *PacketSession) {
+		return func(req, resp *PacketSession) {
+			req.Compression = &CompressionPolicy{MinSize: 16}
+			req.CompressionDictionary = dict
+			resp.Compression = &CompressionPolicy{MinSize: 16}
+			resp.CompressionDictionary = dict
+		}
+	}
+
+	requester, responder := sessionPair(t, compressed(nil))
+	replayed, err := replayValues(recordExchange(t, requester, responder, values))
+	if err != nil {
+		t.Fatalf("Error replaying compressed capture: %s", err.Error())
+	}
+	if strings.Join(replayed, ",") != strings.Join(values, ",") {
+		t.Fatalf("Replayed compressed documents don't match the recorded ones")
+	}
+
+	dict := []byte("compressible")
+	requester, responder = sessionPair(t, compressed(dict))
+	_, err = replayValues(recordExchange(t, requester, responder, values))
+	if !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Fatalf("Capture with a shared dictionary was replayed: %v", err)
+	}
+}
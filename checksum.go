@@ -0,0 +1,116 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"io"
+)
+
+// This file handles the frame header checksums used when PacketSession.FrameChecksums is set.
+// Each frame header gets a fourth byte holding a CRC-8 of the first three. Frame boundaries are
+// only known from the size in the previous header, so without the checksum a single corrupted
+// size throws off every frame after it. With it, a bad header is caught right away, and the
+// reader can slide forward one byte at a time until it finds a header which checks out.
+
+// frameChecksum calculates the CRC-8 (polynomial 0x07) of a frame header
+func frameChecksum(header []byte) uint8 {
+
+	var crc uint8
+	for _, b := range header {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// writeCheckedFrame is the same as WriteFrame(), but the header includes a checksum
+func writeCheckedFrame(w io.Writer, frameType uint8, payload []byte) error {
+	payloadLen := len(payload)
+
+	buffer := make([]byte, payloadLen+4)
+	buffer[0] = frameType
+	buffer[1] = uint8((payloadLen >> 8) & 255)
+	buffer[2] = uint8(payloadLen & 255)
+	buffer[3] = frameChecksum(buffer[:3])
+	copy(buffer[4:], payload)
+
+	_, err := w.Write(buffer)
+	return err
+}
+
+// validFrameHeader returns true if a checksummed header could start a frame which fits in a
+// buffer of the specified size
+func validFrameHeader(header []byte, bufferSize int) bool {
+
+	if header[0] < SingleFrame || header[0] >= FrameUpperBound {
+		return false
+	}
+	payloadSize := int(header[1])<<8 + int(header[2])
+	if payloadSize == 0 || payloadSize+4 > bufferSize {
+		return false
+	}
+	return frameChecksum(header[:3]) == header[3]
+}
+
+// readCheckedFrame reads a frame with a checksummed header into a DataFrame. If the header is
+// bad, ErrFrameChecksum is returned and the next call searches for the next good header,
+// starting with the byte after the bad one. The skipped frame is then accounted for by
+// readFrame() and checkSequence().
+func (s *PacketSession) readCheckedFrame(chunk *DataFrame) error {
+
+	chunk.index = 0
+	header := make([]byte, 4)
+	if s.badHeader != nil {
+		copy(header, s.badHeader)
+		skipped := uint64(0)
+		for !validFrameHeader(header, len(chunk.buffer)) {
+			copy(header, header[1:])
+			if _, err := io.ReadFull(s.Connection, header[3:]); err != nil {
+				return err
+			}
+			skipped++
+		}
+		s.badHeader = nil
+
+		// The bytes skipped are the bad frame's header and its payload, which starts with a
+		// sequence number if they are in use
+		overhead := uint64(4)
+		if s.SequenceNumbers {
+			overhead += sequenceSize
+		}
+		if skipped > overhead {
+			s.skippedPayload = skipped - overhead
+		}
+		s.resynced = true
+	} else {
+		if _, err := io.ReadFull(s.Connection, header); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return ErrIO
+			}
+			return err
+		}
+		if !validFrameHeader(header, len(chunk.buffer)) {
+			s.badHeader = header
+			s.skipContinued = true
+			return ErrFrameChecksum
+		}
+	}
+
+	payloadSize := int(header[1])<<8 + int(header[2])
+	copy(chunk.buffer, header[:3])
+	if _, err := io.ReadFull(s.Connection, chunk.buffer[3:payloadSize+3]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrSize
+		}
+		return err
+	}
+
+	chunk.index = payloadSize + 3
+	return nil
+}
@@ -0,0 +1,136 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFrameChecksum(t *testing.T) {
+	// Standard check value for CRC-8 with polynomial 0x07
+	if crc := frameChecksum([]byte("123456789")); crc != 0xf4 {
+		t.Fatalf("CRC-8 mismatch: %x", crc)
+	}
+
+	checksums := func(responderChecksums bool) func(req, resp *PacketSession) {
+		return func(req, resp *PacketSession) {
+			req.FrameChecksums = true
+			resp.FrameChecksums = responderChecksums
+			resp.BufferSize = 1024
+		}
+	}
+	requester, responder := sessionPair(t, checksums(true))
+
+	// The size in the header of the multipart packet's second frame is corrupted
+	var corrupted bytes.Buffer
+	part := bytes.Repeat([]byte("x"), 1000)
+	writeCheckedFrame(&corrupted, MultipartFrameStart, []byte("3000"))
+	writeCheckedFrame(&corrupted, MultipartFrame, part)
+	start := corrupted.Len()
+	writeCheckedFrame(&corrupted, MultipartFrame, part)
+	corrupted.Bytes()[start+1] ^= 0x40
+	writeCheckedFrame(&corrupted, MultipartFrameFinal, part)
+
+	go func() {
+		requester.Write([]byte("first"))
+		requester.Connection.Write(corrupted.Bytes())
+		requester.Write(bytes.Repeat([]byte("after"), 500))
+	}()
+
+	if packet, err := responder.Read(); err != nil || string(packet) != "first" {
+		t.Fatalf("Failed to read checksummed packet: %v", err)
+	}
+	if _, err := responder.Read(); !errors.Is(err, ErrFrameChecksum) {
+		t.Fatalf("Corrupted frame header wasn't caught: %v", err)
+	}
+
+	// The rest of the corrupted packet is skipped and the session carries on
+	packet, err := responder.Read()
+	if err != nil {
+		t.Fatalf("Failed to read packet after corruption: %s", err.Error())
+	}
+	if !bytes.Equal(packet, bytes.Repeat([]byte("after"), 500)) {
+		t.Fatalf("Packet mismatch after corruption")
+	}
+	if responder.CloseReason() != CloseNone {
+		t.Fatalf("Corrupted frame closed the session")
+	}
+
+	_, _, reqErr, respErr := setupSessionPair(checksums(false))
+	if !errors.Is(reqErr, ErrSessionSetup) && !errors.Is(respErr, ErrSessionSetup) {
+		t.Fatalf("Mismatched checksum settings weren't caught")
+	}
+}
+
+// corruptingConn flips a bit in the size of the first frame header it writes once corrupt is set
+type corruptingConn struct {
+	net.Conn
+	corrupt atomic.Bool
+}
+
+func (c *corruptingConn) Write(p []byte) (int, error) {
+	if c.corrupt.CompareAndSwap(true, false) {
+		p = append([]byte(nil), p...)
+		p[1] ^= 0x40
+	}
+	return c.Conn.Write(p)
+}
+
+func TestFrameChecksumResync(t *testing.T) {
+	var conn *corruptingConn
+	requester, responder := sessionPair(t, func(req, resp *PacketSession) {
+		conn = &corruptingConn{Conn: req.Connection}
+		req.Connection = conn
+		resp.BufferSize = 1024
+		for _, s := range []*PacketSession{req, resp} {
+			s.FrameChecksums = true
+			s.SequenceNumbers = true
+			s.FlowControl = true
+		}
+	})
+	errChan := make(chan error)
+	defer requester.Close()
+	defer responder.Close()
+
+	go func() {
+		requester.Write([]byte("first"))
+		conn.corrupt.Store(true)
+		requester.Write(bytes.Repeat([]byte("lost"), 100))
+		requester.Write([]byte("second"))
+		errChan <- requester.Write([]byte("third"))
+	}()
+
+	if packet, err := responder.Read(); err != nil || string(packet) != "first" {
+		t.Fatalf("Failed to read packet before corruption: %v", err)
+	}
+	if _, err := responder.Read(); !errors.Is(err, ErrFrameChecksum) {
+		t.Fatalf("Corrupted frame header wasn't caught: %v", err)
+	}
+	for _, expected := range []string{"second", "third"} {
+		if packet, err := responder.Read(); err != nil || string(packet) != expected {
+			t.Fatalf("Failed to read packet after corruption: %v", err)
+		}
+	}
+	if err := <-errChan; err != nil {
+		t.Fatalf("Write failed: %s", err.Error())
+	}
+	if responder.CloseReason() != CloseNone {
+		t.Fatalf("Corrupted frame closed the session: %s", responder.CloseReason())
+	}
+
+	// The skipped frame's payload has to be counted as received so that the sender gets its
+	// credit back
+	requester.flowLock.Lock()
+	used := responder.flowWindow() - requester.sendCredit
+	requester.flowLock.Unlock()
+	responder.flowLock.Lock()
+	received := responder.recvUnacked
+	responder.flowLock.Unlock()
+	if used != received {
+		t.Fatalf("Credit lost to the skipped frame: %d bytes sent, %d received", used, received)
+	}
+}
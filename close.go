@@ -0,0 +1,148 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+)
+
+// CloseReason describes why a PacketSession stopped working
+type CloseReason int
+
+const (
+	// CloseNone means the session is still open
+	CloseNone CloseReason = iota
+
+	// CloseLocal means Close() was called on the session
+	CloseLocal
+
+	// ClosePeer means the other side closed the connection
+	ClosePeer
+
+	// CloseTimeout means a read or write didn't finish before the session's timeout
+	CloseTimeout
+
+	// CloseProtocolError means the other side sent data which broke the framing protocol
+	CloseProtocolError
+
+	// CloseNetworkError means the connection failed for some other reason
+	CloseNetworkError
+
+	// CloseAuthFailed means the session's Authenticator or VerifyPeer callback rejected the peer,
+	// or the peer rejected this side
+	CloseAuthFailed
+
+	// CloseSetupFailed means InitRequester() or InitResponder() failed for some other reason, such
+	// as the two sides asking for different options
+	CloseSetupFailed
+)
+
+func (r CloseReason) String() string {
+	switch r {
+	case CloseNone:
+		return "open"
+	case CloseLocal:
+		return "closed locally"
+	case ClosePeer:
+		return "closed by peer"
+	case CloseTimeout:
+		return "timed out"
+	case CloseProtocolError:
+		return "protocol error"
+	case CloseNetworkError:
+		return "network error"
+	case CloseAuthFailed:
+		return "authentication failed"
+	case CloseSetupFailed:
+		return "setup failed"
+	}
+	return "unknown"
+}
+
+// Close closes the session's connection and all of its subscriptions. Documents queued by Send()
+// and anything in the write buffer are sent first.
+func (s *PacketSession) Close() error {
+	s.setState(StateDraining, nil)
+	s.stopSender()
+	flushErr := s.Flush()
+	s.setClosed(CloseLocal, nil)
+	s.closeSubscriptions()
+	if err := s.Connection.Close(); err != nil {
+		return err
+	}
+	return flushErr
+}
+
+// CloseReason returns why the session stopped working or CloseNone if it is still usable
+func (s *PacketSession) CloseReason() CloseReason {
+	s.closeLock.Lock()
+	defer s.closeLock.Unlock()
+	return s.closeReason
+}
+
+// LastError returns the error which ended the session. It is nil if the session is still open or
+// was closed with Close().
+func (s *PacketSession) LastError() error {
+	s.closeLock.Lock()
+	defer s.closeLock.Unlock()
+	return s.lastError
+}
+
+// noteError checks an error returned by the session's I/O code and, if it means that the session
+// can no longer be used, records it as the reason the session ended.
+func (s *PacketSession) noteError(err error) {
+	if reason := closeReasonFor(err); reason != CloseNone {
+		s.setClosed(reason, err)
+	}
+}
+
+// setClosed records how the session ended. Only the first reason is kept because the errors which
+// follow it are usually just side effects.
+func (s *PacketSession) setClosed(reason CloseReason, err error) {
+	s.closeLock.Lock()
+	first := s.closeReason == CloseNone
+	if first {
+		s.closeReason = reason
+		s.lastError = err
+	}
+	s.closeLock.Unlock()
+
+	if first {
+		s.setState(StateClosed, err)
+	}
+}
+
+// closeReasonFor works out whether an error ends a session and, if it does, why
+func closeReasonFor(err error) CloseReason {
+
+	if err == nil {
+		return CloseNone
+	}
+
+	switch {
+	case errors.Is(err, ErrAuthFailed), errors.Is(err, ErrPeerRejected):
+		return CloseAuthFailed
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return ClosePeer
+	case errors.Is(err, net.ErrClosed):
+		return CloseLocal
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return CloseTimeout
+	case errors.Is(err, ErrInvalidFrame), errors.Is(err, ErrInvalidMultipartFrame),
+		errors.Is(err, ErrMultipartSession), errors.Is(err, ErrSize), errors.Is(err, ErrIO),
+		errors.Is(err, ErrLimitExceeded), errors.Is(err, ErrReplayDetected):
+		return CloseProtocolError
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return CloseTimeout
+		}
+		return CloseNetworkError
+	}
+	return CloseNone
+}
@@ -0,0 +1,70 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestCloseReason(t *testing.T) {
+	requester, responder := sessionPair(t, nil)
+
+	if responder.CloseReason() != CloseNone || responder.LastError() != nil {
+		t.Fatalf("New session isn't open")
+	}
+
+	if err := requester.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err.Error())
+	}
+	if requester.CloseReason() != CloseLocal || requester.LastError() != nil {
+		t.Fatalf("Close reason mismatch for local close: %s", requester.CloseReason())
+	}
+
+	if _, err := responder.Read(); err == nil {
+		t.Fatalf("Read succeeded on a closed connection")
+	}
+	if responder.CloseReason() != ClosePeer {
+		t.Fatalf("Close reason mismatch for peer close: %s", responder.CloseReason())
+	}
+	if !errors.Is(responder.LastError(), io.EOF) {
+		t.Fatalf("LastError mismatch for peer close: %v", responder.LastError())
+	}
+
+	// Garbage instead of a frame
+	requester, responder = sessionPair(t, nil)
+	go requester.Connection.Write([]byte{1, 2, 3, 4})
+	if _, err := responder.Read(); !errors.Is(err, ErrInvalidFrame) {
+		t.Fatalf("Read didn't reject a bad frame")
+	}
+	if responder.CloseReason() != CloseProtocolError {
+		t.Fatalf("Close reason mismatch for bad frame: %s", responder.CloseReason())
+	}
+}
+
+func TestCloseReasonFor(t *testing.T) {
+	if closeReasonFor(nil) != CloseNone || closeReasonFor(ErrEmptyData) != CloseNone {
+		t.Fatalf("closeReasonFor treated a harmless error as fatal")
+	}
+	if closeReasonFor(fmt.Errorf("read: %w", os.ErrDeadlineExceeded)) != CloseTimeout {
+		t.Fatalf("closeReasonFor didn't detect a timeout")
+	}
+}
+
+func TestSessionAnomalies(t *testing.T) {
+	requester, responder := sessionPair(t, nil)
+
+	anomalies := make([]Anomaly, 0)
+	responder.OnAnomaly = func(a Anomaly) { anomalies = append(anomalies, a) }
+
+	go requester.Connection.Write([]byte{1, 2, 3, 4})
+	responder.Read()
+	if len(anomalies) != 1 || anomalies[0].Kind != AnomalyMalformedFrame ||
+		anomalies[0].Count != 1 || anomalies[0].Peer == "" ||
+		anomalies[0].SessionID != responder.ID() {
+		t.Fatalf("Session didn't report a malformed frame")
+	}
+}
@@ -0,0 +1,105 @@
+package oganesson
+
+import (
+	"sort"
+)
+
+// CompressionPolicy is a single place to decide what data is worth compressing. Anything which
+// compresses segments or whole frames is expected to ask the policy rather than keep its own
+// settings, so that all compression follows the same rules.
+type CompressionPolicy struct {
+	// MinSize is the smallest payload, in bytes, which will be compressed. Small payloads rarely
+	// get any smaller and just cost CPU time.
+	MinSize uint64
+
+	// Types enables or disables compression for individual segment type codes. Variable-sized
+	// types which aren't in it, or all of them if it is nil, may be compressed. Fixed-size types
+	// are never compressed.
+	Types map[uint8]bool
+
+	// AllowKeys, if not empty, limits compression to the values of these map keys
+	AllowKeys []string
+
+	// DenyKeys prevents the values of these map keys from being compressed. It takes precedence
+	// over AllowKeys.
+	DenyKeys []string
+}
+
+// DefaultCompressionPolicy is used when no other policy has been given
+var DefaultCompressionPolicy = CompressionPolicy{MinSize: 256}
+
+// ShouldCompress returns true if the policy permits compressing a segment. The key is the map key
+// the segment belongs to, or an empty string if it isn't part of a map, in which case the key
+// lists are ignored.
+func (p CompressionPolicy) ShouldCompress(key string, seg Segment) bool {
+
+	if fixedSegmentSize(seg.Type) != 0 || !isTypeCodeValid(seg.Type) {
+		return false
+	}
+	if enabled, ok := p.Types[seg.Type]; ok && !enabled {
+		return false
+	}
+
+	if key != "" && !p.allowsKey(key) {
+		return false
+	}
+
+	return p.ShouldCompressFrame(uint64(len(seg.Value)))
+}
+
+// ShouldCompressFrame returns true if the policy permits compressing a frame of the specified
+// size. Only the size limit applies to frames.
+func (p CompressionPolicy) ShouldCompressFrame(size uint64) bool {
+	return size > 0 && size >= p.MinSize
+}
+
+// allowsKey checks a map key against the allow and deny lists
+func (p CompressionPolicy) allowsKey(key string) bool {
+
+	for _, k := range p.DenyKeys {
+		if k == key {
+			return false
+		}
+	}
+
+	if len(p.AllowKeys) == 0 {
+		return true
+	}
+	for _, k := range p.AllowKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// BuildCompressionDictionary creates a preset dictionary for deflate from the field names of a
+// set of schemas. Small messages don't have enough data of their own for deflate to find much to
+// work with, but most of their bytes are map keys, so priming the compressor with the keys lets
+// even small messages shrink. Each key is written the way it appears in a flattened SegmentMap,
+// followed by the type code of its value. Both sides must build the dictionary from the same
+// schemas in the same order.
+func BuildCompressionDictionary(schemas ...*Schema) []byte {
+
+	var out []byte
+	seen := make(map[string]bool)
+	for _, schema := range schemas {
+		names := make([]string, 0, len(schema.fields))
+		for name := range schema.fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+
+			key := Segment{DFStringType, []byte(name)}
+			out = key.AppendTo(out)
+			out = append(out, schema.fields[name].Type)
+		}
+	}
+	return out
+}
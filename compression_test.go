@@ -0,0 +1,48 @@
+package oganesson
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompressionPolicy(t *testing.T) {
+	big := Segment{DFStringType, []byte(strings.Repeat("a", 300))}
+	small := Segment{DFStringType, []byte("abc")}
+
+	p := DefaultCompressionPolicy
+	if !p.ShouldCompress("", big) {
+		t.Fatalf("ShouldCompress rejected a large string")
+	}
+	if p.ShouldCompress("", small) {
+		t.Fatalf("ShouldCompress accepted a string below the minimum size")
+	}
+	if p.ShouldCompress("", Segment{DFInt64Type, make([]byte, 8)}) {
+		t.Fatalf("ShouldCompress accepted a fixed-size type")
+	}
+
+	p.Types = map[uint8]bool{DFStringType: false}
+	if p.ShouldCompress("", big) {
+		t.Fatalf("ShouldCompress ignored the type list")
+	}
+	p.Types = map[uint8]bool{DFBinaryType: false}
+	if !p.ShouldCompress("", big) {
+		t.Fatalf("ShouldCompress rejected a type which isn't in the type list")
+	}
+	p.Types = nil
+
+	p.AllowKeys = []string{"body", "secret"}
+	p.DenyKeys = []string{"secret"}
+	if !p.ShouldCompress("body", big) {
+		t.Fatalf("ShouldCompress rejected an allowed key")
+	}
+	if p.ShouldCompress("secret", big) {
+		t.Fatalf("ShouldCompress accepted a denied key")
+	}
+	if p.ShouldCompress("other", big) {
+		t.Fatalf("ShouldCompress accepted a key not in the allow list")
+	}
+
+	if !p.ShouldCompressFrame(1024) || p.ShouldCompressFrame(16) {
+		t.Fatalf("ShouldCompressFrame size check failed")
+	}
+}
@@ -0,0 +1,134 @@
+package oganesson
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"sync"
+)
+
+var ErrTypeNameTaken = errors.New("type name already registered")
+var ErrUnregisteredType = errors.New("type not registered")
+
+// Values which are only known by an interface, such as an attachment which can be one of several
+// types, need to carry the name of their concrete type so that the right type can be recreated
+// when they are decoded. This works much like encoding/gob: each concrete type is registered
+// under a name which both sides agree on. A tagged value is stored in a Binary segment holding a
+// 1-byte name length, the name, and then the flattened segment for the value itself.
+
+var concreteRegistry struct {
+	sync.RWMutex
+	byName map[string]reflect.Type
+	byType map[reflect.Type]string
+}
+
+// RegisterConcreteType records a type under the specified name for use with SetConcrete() and
+// GetConcrete(). The prototype is any value of the type. Its type must be usable with
+// Segment.Set() and a pointer to it with Segment.Get(). Registering the same name and type again
+// is harmless, but a name or type can't be registered twice with different partners. It is
+// intended to be called during program initialization.
+func RegisterConcreteType(name string, prototype interface{}) error {
+
+	if name == "" || len(name) > 255 || prototype == nil {
+		return ErrInvalidKey
+	}
+	t := reflect.TypeOf(prototype)
+
+	concreteRegistry.Lock()
+	defer concreteRegistry.Unlock()
+
+	if concreteRegistry.byName == nil {
+		concreteRegistry.byName = make(map[string]reflect.Type)
+		concreteRegistry.byType = make(map[reflect.Type]string)
+	}
+
+	existingType, nameFound := concreteRegistry.byName[name]
+	existingName, typeFound := concreteRegistry.byType[t]
+	if nameFound && typeFound && existingType == t && existingName == name {
+		return nil
+	}
+	if nameFound || typeFound {
+		return ErrTypeNameTaken
+	}
+
+	concreteRegistry.byName[name] = t
+	concreteRegistry.byType[t] = name
+	return nil
+}
+
+// SetConcrete sets the Segment to a value tagged with the name of its registered type
+func (seg *Segment) SetConcrete(value interface{}) error {
+
+	if value == nil {
+		return ErrEmptyData
+	}
+
+	concreteRegistry.RLock()
+	name, ok := concreteRegistry.byType[reflect.TypeOf(value)]
+	concreteRegistry.RUnlock()
+	if !ok {
+		return ErrUnregisteredType
+	}
+
+	// Pointers are registered as-is, so if a pointer type doesn't implement one of the marshaler
+	// interfaces, the value it points to is encoded instead
+	var inner Segment
+	err := inner.Set(value)
+	if v := reflect.ValueOf(value); err == ErrTypeError && v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return ErrEmptyData
+		}
+		err = inner.Set(v.Elem().Interface())
+	}
+	if err != nil {
+		return err
+	}
+
+	var payload bytes.Buffer
+	payload.WriteByte(uint8(len(name)))
+	payload.WriteString(name)
+	if err := inner.Write(&payload); err != nil {
+		return err
+	}
+	return seg.SetBinary(payload.Bytes())
+}
+
+// GetConcrete decodes a value stored by SetConcrete() and returns it as the type it was registered
+// with
+func (seg Segment) GetConcrete() (interface{}, error) {
+
+	payload, err := seg.GetBinary()
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) < 1 || len(payload) < 1+int(payload[0]) {
+		return nil, ErrSegmentSize
+	}
+	name := string(payload[1 : 1+int(payload[0])])
+
+	concreteRegistry.RLock()
+	t, ok := concreteRegistry.byName[name]
+	concreteRegistry.RUnlock()
+	if !ok {
+		return nil, ErrUnregisteredType
+	}
+
+	inner, err := UnflattenSegment(payload[1+int(payload[0]):])
+	if err != nil {
+		return nil, err
+	}
+
+	if t.Kind() == reflect.Ptr {
+		out := reflect.New(t.Elem())
+		if err := inner.Get(out.Interface()); err != nil {
+			return nil, err
+		}
+		return out.Interface(), nil
+	}
+
+	out := reflect.New(t)
+	if err := inner.Get(out.Interface()); err != nil {
+		return nil, err
+	}
+	return out.Elem().Interface(), nil
+}
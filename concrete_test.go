@@ -0,0 +1,58 @@
+package oganesson
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConcreteTypes(t *testing.T) {
+	if err := RegisterConcreteType("test.time", time.Time{}); err != nil {
+		t.Fatalf("RegisterConcreteType failed: %s", err.Error())
+	}
+	if err := RegisterConcreteType("test.id", &textID{}); err != nil {
+		t.Fatalf("RegisterConcreteType failed for pointer type: %s", err.Error())
+	}
+	if err := RegisterConcreteType("test.int", int32(0)); err != nil {
+		t.Fatalf("RegisterConcreteType failed for basic type: %s", err.Error())
+	}
+	if err := RegisterConcreteType("test.time", time.Time{}); err != nil {
+		t.Fatalf("RegisterConcreteType rejected a repeat registration: %s", err.Error())
+	}
+	if err := RegisterConcreteType("test.time", int64(0)); err != ErrTypeNameTaken {
+		t.Fatalf("RegisterConcreteType allowed a name to be reused")
+	}
+
+	var seg Segment
+	now := time.Now().UTC()
+	values := []interface{}{now, &textID{"abc"}, int32(42)}
+	for _, value := range values {
+		if err := seg.SetConcrete(value); err != nil {
+			t.Fatalf("SetConcrete failed for %T: %s", value, err.Error())
+		}
+
+		out, err := seg.GetConcrete()
+		if err != nil {
+			t.Fatalf("GetConcrete failed for %T: %s", value, err.Error())
+		}
+		switch v := out.(type) {
+		case time.Time:
+			if !v.Equal(now) {
+				t.Fatalf("GetConcrete time.Time mismatch")
+			}
+		case *textID:
+			if v.value != "abc" {
+				t.Fatalf("GetConcrete *textID mismatch: %s", v.value)
+			}
+		case int32:
+			if v != 42 {
+				t.Fatalf("GetConcrete int32 mismatch: %d", v)
+			}
+		default:
+			t.Fatalf("GetConcrete returned unexpected type %T", out)
+		}
+	}
+
+	if err := seg.SetConcrete(uint16(1)); err != ErrUnregisteredType {
+		t.Fatalf("SetConcrete accepted an unregistered type")
+	}
+}
@@ -0,0 +1,130 @@
+package oganesson
+
+import (
+	"context"
+	"io"
+)
+
+// MaxInternedKeys is the largest number of map keys a Decoder with InternKeys set keeps. Keys
+// past the limit are still decoded, but they aren't interned.
+var MaxInternedKeys = 4096
+
+// Decoder reads a stream of Segments from an io.Reader one at a time. It is useful for processing
+// data which is too large to comfortably decode all at once.
+type Decoder struct {
+	// Limits is applied across all of the segments read by the Decoder
+	Limits DecodeLimits
+
+	// OnAnomaly, if set, is called for segments which come close to or exceed the limits. Peer is
+	// passed along in the Anomaly to identify the source of the data.
+	OnAnomaly AnomalyHook
+	Peer      string
+
+	// Arena, if set, is used to allocate the values of the segments read
+	Arena *DecodeArena
+
+	// Profile is the byte order of the data being read. Segments are always converted to network
+	// order, so they can be used the same way no matter which profile was used.
+	Profile EncodingProfile
+
+	// InternKeys makes the Decoder keep a single copy of each map key it decodes, so that reading
+	// many maps with the same keys doesn't allocate a new string for every one of them. The keys
+	// are kept for as long as the Decoder is.
+	InternKeys bool
+
+	// WideLargeContainers makes the Decoder read the item counts of LargeMap, LargeList, and
+	// KeyedMap segments as 64-bit values. It must match the setting of the Encoder which wrote
+	// the data. The segments read hold the usual 32-bit count, and ErrSize is returned for counts
+	// which don't fit in one.
+	WideLargeContainers bool
+
+	r            io.Reader
+	bytesRead    uint64
+	segmentCount uint64
+
+	// charged is the number of payload bytes charged to Limits.Budget
+	charged uint64
+
+	keys map[string]string
+}
+
+// NewDecoder creates a new Decoder which reads from the specified Reader
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// Next reads the next Segment from the stream
+func (d *Decoder) Next() (Segment, error) {
+	return d.NextContext(context.Background())
+}
+
+// NextContext reads the next Segment from the stream unless the context has been canceled, in
+// which case the context's error is returned instead.
+func (d *Decoder) NextContext(ctx context.Context) (Segment, error) {
+
+	var out Segment
+	if err := ctx.Err(); err != nil {
+		return out, err
+	}
+
+	if d.Limits.MaxSegmentCount > 0 && d.segmentCount >= d.Limits.MaxSegmentCount {
+		return out, ErrLimitExceeded
+	}
+
+	maxPayload := d.Limits.MaxSegmentSize
+	if d.Limits.MaxSize > 0 {
+		if d.bytesRead >= d.Limits.MaxSize {
+			return out, ErrLimitExceeded
+		}
+		maxPayload = stricterLimit(maxPayload, d.Limits.MaxSize-d.bytesRead)
+	}
+
+	segSize, err := out.readLimited(d.r, maxPayload, d.Arena, d.Limits.Budget, d.Profile,
+		d.WideLargeContainers)
+	switch err {
+	case nil:
+	case ErrLimitExceeded:
+		d.OnAnomaly.report(Anomaly{Kind: AnomalyLargeSize, Peer: d.Peer, Limit: maxPayload,
+			Err: err})
+		return out, err
+	case ErrBudgetExceeded:
+		d.OnAnomaly.report(Anomaly{Kind: AnomalyLargeSize, Peer: d.Peer,
+			Limit: d.Limits.Budget.Limit(), Err: err})
+		return out, err
+	default:
+		return out, err
+	}
+	d.charged += uint64(len(out.Value))
+	if nearLimit(uint64(len(out.Value)), d.Limits.MaxSegmentSize) {
+		d.OnAnomaly.report(Anomaly{Kind: AnomalyLargeSize, Peer: d.Peer,
+			Size: uint64(len(out.Value)), Limit: d.Limits.MaxSegmentSize})
+	}
+
+	d.bytesRead += segSize
+	d.segmentCount++
+	if d.Limits.MaxSize > 0 && d.bytesRead > d.Limits.MaxSize {
+		return out, ErrLimitExceeded
+	}
+	return out, nil
+}
+
+// key converts the value of a map key segment to a string, interning it if InternKeys is set
+func (d *Decoder) key(value []byte) string {
+
+	if !d.InternKeys {
+		return string(value)
+	}
+
+	// Looking up a converted byte slice doesn't allocate
+	if out, ok := d.keys[string(value)]; ok {
+		return out
+	}
+	out := string(value)
+	if d.keys == nil {
+		d.keys = make(map[string]string)
+	}
+	if len(d.keys) < MaxInternedKeys {
+		d.keys[out] = out
+	}
+	return out
+}
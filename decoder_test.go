@@ -0,0 +1,161 @@
+package oganesson
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"unsafe"
+)
+
+func TestDecoderNext(t *testing.T) {
+	d := NewDecoder(bytes.NewReader([]byte("\x0e\x00\x03ABC\x0b\x01")))
+
+	seg, err := d.Next()
+	if err != nil {
+		t.Fatalf("Decoder.Next failed on first segment: %s", err.Error())
+	}
+	if seg.Type != DFStringType || string(seg.Value) != "ABC" {
+		t.Fatalf("Decoder.Next data mismatch: %d,%s", seg.Type, string(seg.Value))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := d.NextContext(ctx); err != context.Canceled {
+		t.Fatalf("Decoder.NextContext didn't stop for a canceled context")
+	}
+
+	seg, err = d.NextContext(context.Background())
+	if err != nil {
+		t.Fatalf("Decoder.NextContext failed on second segment: %s", err.Error())
+	}
+	if seg.Type != DFBoolType {
+		t.Fatalf("Decoder.NextContext type mismatch: %d", seg.Type)
+	}
+}
+
+func TestDocumentReadContext(t *testing.T) {
+	doc := NewDocument()
+	doc.AttachString("testString", "abcdef")
+	doc.AttachInt64("testInt", 42)
+
+	data, err := doc.Flatten()
+	if err != nil {
+		t.Fatalf("Error flattening document: %s", err.Error())
+	}
+
+	var readDoc Document
+	if err := readDoc.ReadContext(context.Background(), bytes.NewReader(data)); err != nil {
+		t.Fatalf("Document.ReadContext failed: %s", err.Error())
+	}
+	if len(readDoc.Items) != 2 {
+		t.Fatalf("Document.ReadContext read %d items, expected 2", len(readDoc.Items))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = readDoc.ReadContext(ctx, bytes.NewReader(data))
+	if err != context.Canceled {
+		t.Fatalf("Document.ReadContext didn't stop for a canceled context")
+	}
+}
+
+func TestLenientDocumentEnd(t *testing.T) {
+	defer func() {
+		SetLenientDocumentEnd(false)
+		SetLogger(nil)
+	}()
+
+	missingEnd := []byte("\x01\x01\x0e\x00\x03ABC")
+	badCount := []byte("\x01\x01\x0e\x00\x03ABC\x02\x00\x00\x00\x00\x00\x00\x00\x05")
+
+	var doc Document
+	if err := doc.Unflatten(missingEnd); err == nil {
+		t.Fatalf("Strict decoding accepted a missing DocumentEnd")
+	}
+	if err := doc.Unflatten(badCount); err != ErrSize {
+		t.Fatalf("Strict decoding accepted a bad DocumentEnd count")
+	}
+
+	warnings := make([]string, 0)
+	SetLogger(func(msg string) { warnings = append(warnings, msg) })
+	SetLenientDocumentEnd(true)
+
+	for _, frame := range [][]byte{missingEnd, badCount} {
+		if err := doc.Unflatten(frame); err != nil {
+			t.Fatalf("Lenient decoding failed: %s", err.Error())
+		}
+		if len(doc.Items) != 1 {
+			t.Fatalf("Lenient decoding item count mismatch: %d", len(doc.Items))
+		}
+		if _, err := ViewDocument(frame); err != nil {
+			t.Fatalf("Lenient ViewDocument failed: %s", err.Error())
+		}
+	}
+	if len(warnings) != 4 {
+		t.Fatalf("Lenient decoding warning count mismatch: %d", len(warnings))
+	}
+
+	// Only the DocumentEnd segment may be missing. A segment cut short is still an error.
+	for _, frame := range [][]byte{missingEnd[:3], missingEnd[:4], missingEnd[:6]} {
+		if err := doc.Unflatten(frame); err != ErrSegmentSize {
+			t.Fatalf("Lenient decoding accepted a truncated segment: %v", err)
+		}
+		if _, err := ViewDocument(frame); err == nil {
+			t.Fatalf("Lenient ViewDocument accepted a truncated segment")
+		}
+	}
+}
+
+func TestDecoderInternKeys(t *testing.T) {
+	sm := make(SegmentMap)
+	sm.SetString("attachment", "value")
+
+	var buffer bytes.Buffer
+	for i := 0; i < 2; i++ {
+		if err := sm.Write(&buffer); err != nil {
+			t.Fatalf("Error writing segment map: %s", err.Error())
+		}
+	}
+	data := buffer.Bytes()
+
+	keyData := func(intern bool) [2]*byte {
+		d := NewDecoder(bytes.NewReader(data))
+		d.InternKeys = intern
+		var out [2]*byte
+		for i := range out {
+			readMap := make(SegmentMap)
+			if err := readMap.Decode(d); err != nil {
+				t.Fatalf("Error decoding segment map: %s", err.Error())
+			}
+			for key := range readMap {
+				out[i] = unsafe.StringData(key)
+			}
+		}
+		return out
+	}
+
+	if keys := keyData(true); keys[0] != keys[1] {
+		t.Fatalf("Decoder didn't intern the repeated key")
+	}
+	if keys := keyData(false); keys[0] == keys[1] {
+		t.Fatalf("Decoder interned keys without InternKeys set")
+	}
+
+	d := NewDecoder(bytes.NewReader(data))
+	d.InternKeys = true
+	first := make(SegmentMapOf[string])
+	second := make(SegmentMapOf[string])
+	if err := first.Decode(d); err != nil {
+		t.Fatalf("Error decoding keyed map: %s", err.Error())
+	}
+	if err := second.Decode(d); err != nil {
+		t.Fatalf("Error decoding keyed map: %s", err.Error())
+	}
+	for key := range first {
+		for other := range second {
+			if unsafe.StringData(key) != unsafe.StringData(other) {
+				t.Fatalf("Decoder didn't intern the keys of a SegmentMapOf")
+			}
+		}
+	}
+}
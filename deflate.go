@@ -0,0 +1,188 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math"
+)
+
+// This file handles packet compression for sessions with a Compression policy. Every packet
+// starts with a byte saying whether or not the rest is compressed, so each side decides for
+// itself what to compress. During setup, each side sends an ID for its CompressionDictionary,
+// and the dictionary is only used if the IDs match.
+
+// Markers at the start of packets sent by sessions which use compression
+const (
+	packetRaw      = uint8(0)
+	packetDeflated = uint8(1)
+)
+
+// dictionaryIDSize is the size of the ID sent for a compression dictionary during setup
+const dictionaryIDSize = 8
+
+// UsingSharedDictionary returns true if both sides of the session have the same compression
+// dictionary and are using it
+func (s *PacketSession) UsingSharedDictionary() bool {
+	return s.sharedDictionary
+}
+
+// dictionaryID returns the ID sent for a compression dictionary, which is all zeroes if there
+// isn't one
+func dictionaryID(dict []byte) []byte {
+	if len(dict) == 0 {
+		return make([]byte, dictionaryIDSize)
+	}
+	sum := sha256.Sum256(dict)
+	return sum[:dictionaryIDSize]
+}
+
+// startCompression finds out whether the other side has the same compression dictionary once
+// setup has agreed on compression. Like startFlowControl(), the requester sends first.
+func (s *PacketSession) startCompression(isRequester bool) error {
+
+	if s.Compression == nil {
+		return nil
+	}
+
+	id := dictionaryID(s.CompressionDictionary)
+	if isRequester {
+		if err := s.sendDictionaryID(id); err != nil {
+			return err
+		}
+	}
+
+	chunk := NewDataFrame(s.BufferSize)
+	payload, err := s.readFrame(chunk)
+	for err == errCreditReceived {
+		payload, err = s.readFrame(chunk)
+	}
+	if err != nil {
+		return err
+	}
+	if chunk.GetType() != DictionaryFrame || len(payload) != dictionaryIDSize {
+		return ErrSessionSetup
+	}
+	s.sharedDictionary = len(s.CompressionDictionary) > 0 && bytes.Equal(payload, id)
+
+	if !isRequester {
+		return s.sendDictionaryID(id)
+	}
+	return nil
+}
+
+// sendDictionaryID sends the ID of the session's compression dictionary during setup
+func (s *PacketSession) sendDictionaryID(id []byte) error {
+	if err := s.writeDataFrame(s.output(), DictionaryFrame, id); err != nil {
+		return err
+	}
+	return s.flushFrames()
+}
+
+// dictionary returns the preset dictionary for compressing and decompressing packets
+func (s *PacketSession) dictionary() []byte {
+	if s.sharedDictionary {
+		return s.CompressionDictionary
+	}
+	return nil
+}
+
+// compressPacket adds the compression marker to a packet, compressing it if the policy says it is
+// worth trying and it actually gets smaller. The caller must hold sendLock.
+func (s *PacketSession) compressPacket(packet []byte) ([]byte, error) {
+
+	if s.Compression == nil {
+		return packet, nil
+	}
+	if !s.Compression.ShouldCompressFrame(uint64(len(packet))) {
+		return append([]byte{packetRaw}, packet...), nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(packetDeflated)
+	if s.deflater == nil {
+		// The faster levels don't look for matches in the dictionary for input as small as the
+		// messages it is meant for
+		level := flate.DefaultCompression
+		if s.sharedDictionary {
+			level = flate.BestCompression
+		}
+
+		var err error
+		s.deflater, err = flate.NewWriterDict(&buf, level, s.dictionary())
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		s.deflater.Reset(&buf)
+	}
+	if _, err := s.deflater.Write(packet); err != nil {
+		return nil, err
+	}
+	if err := s.deflater.Close(); err != nil {
+		return nil, err
+	}
+
+	if buf.Len() > len(packet) {
+		return append([]byte{packetRaw}, packet...), nil
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressPacket removes the compression marker from a packet and decompresses it if needed.
+// The decompressed size is held to the session's MaxSize limit and to the room left in its
+// MemoryBudget. The buffer is only needed until the packet is decoded, so it isn't charged to the
+// budget. The caller must hold readLock.
+func (s *PacketSession) decompressPacket(packet []byte) ([]byte, error) {
+
+	if s.Compression == nil {
+		return packet, nil
+	}
+	if len(packet) == 0 {
+		return nil, ErrInvalidMsg
+	}
+
+	switch packet[0] {
+	case packetRaw:
+		return packet[1:], nil
+	case packetDeflated:
+	default:
+		return nil, ErrInvalidMsg
+	}
+
+	r := bytes.NewReader(packet[1:])
+	if s.inflater == nil {
+		s.inflater = flate.NewReaderDict(r, s.dictionary())
+	} else if err := s.inflater.(flate.Resetter).Reset(r, s.dictionary()); err != nil {
+		return nil, err
+	}
+
+	limit := MaxDecodedSize
+	if s.Limits.MaxSize > 0 && s.Limits.MaxSize < limit {
+		limit = s.Limits.MaxSize
+	}
+	budgetLimit := s.Limits.Budget.available()
+	if budgetLimit < limit {
+		limit = budgetLimit
+	}
+	readLimit := int64(math.MaxInt64)
+	if limit < math.MaxInt64 {
+		readLimit = int64(limit) + 1
+	}
+
+	out, err := io.ReadAll(io.LimitReader(s.inflater, readLimit))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidMsg, err.Error())
+	}
+	if uint64(len(out)) > limit {
+		if uint64(len(out)) > budgetLimit {
+			return nil, ErrBudgetExceeded
+		}
+		return nil, ErrLimitExceeded
+	}
+	return out, nil
+}
@@ -0,0 +1,101 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestBuildCompressionDictionary(t *testing.T) {
+	login := NewSchema(FieldSpec{Name: "Username", Type: DFStringType},
+		FieldSpec{Name: "Password", Type: DFStringType})
+	status := NewSchema(FieldSpec{Name: "Username", Type: DFStringType},
+		FieldSpec{Name: "Status", Type: DFInt32Type})
+
+	dict := BuildCompressionDictionary(login, status)
+	if !bytes.Equal(dict, BuildCompressionDictionary(login, status)) {
+		t.Fatalf("Dictionary isn't deterministic")
+	}
+	for _, name := range []string{"Username", "Password", "Status"} {
+		if bytes.Count(dict, []byte(name)) != 1 {
+			t.Fatalf("Dictionary doesn't hold key %s exactly once", name)
+		}
+	}
+}
+
+func TestSessionCompression(t *testing.T) {
+	schema := NewSchema(FieldSpec{Name: "RecipientAddress", Type: DFStringType},
+		FieldSpec{Name: "MessageIdentifier", Type: DFStringType},
+		FieldSpec{Name: "DeliveryTimestamp", Type: DFInt64Type})
+	dict := BuildCompressionDictionary(schema)
+
+	msg := make(SegmentMap)
+	msg.SetString("RecipientAddress", "bob@example.com")
+	msg.SetString("MessageIdentifier", "a1b2")
+	msg.SetInt64("DeliveryTimestamp", 1700000000)
+	var buf bytes.Buffer
+	msg.Write(&buf)
+	small := buf.Bytes()
+
+	// A small message only shrinks with the help of the dictionary
+	compressed := func(requesterDict, responderDict []byte) func(req, resp *PacketSession) {
+		return func(req, resp *PacketSession) {
+			req.Compression = &CompressionPolicy{MinSize: 16}
+			req.CompressionDictionary = requesterDict
+			resp.Compression = &CompressionPolicy{MinSize: 16}
+			resp.CompressionDictionary = responderDict
+		}
+	}
+	sizes := make([]int, 0, 2)
+	for _, responderDict := range [][]byte{dict, nil} {
+		requester, responder := sessionPair(t, compressed(dict, responderDict))
+		if requester.UsingSharedDictionary() != (responderDict != nil) {
+			t.Fatalf("Dictionary negotiation mismatch")
+		}
+
+		requester.sendLock.Lock()
+		compressed, err := requester.compressPacket(small)
+		requester.sendLock.Unlock()
+		if err != nil {
+			t.Fatalf("Error compressing packet: %s", err.Error())
+		}
+		sizes = append(sizes, len(compressed))
+
+		large := []byte(strings.Repeat("compressible ", 1000))
+		go func() {
+			requester.Write(small)
+			requester.Write(large)
+			requester.Write([]byte("tiny"))
+		}()
+		for _, want := range [][]byte{small, large, []byte("tiny")} {
+			received, err := responder.Read()
+			if err != nil {
+				t.Fatalf("Error reading compressed packet: %s", err.Error())
+			}
+			if !bytes.Equal(received, want) {
+				t.Fatalf("Packet mismatch after compression")
+			}
+		}
+	}
+	if sizes[0] >= len(small) || sizes[0] >= sizes[1] {
+		t.Fatalf("Dictionary didn't help: %d bytes with it, %d without, %d raw", sizes[0],
+			sizes[1], len(small))
+	}
+
+	// Decompressed packets are held to the session's limits
+	requester, responder := sessionPair(t, compressed(nil, nil))
+	responder.Limits.MaxSize = 1000
+	go requester.Write(make([]byte, 5000))
+	if _, err := responder.Read(); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("Decompression limit wasn't applied: %v", err)
+	}
+	requester, responder = sessionPair(t, compressed(nil, nil))
+	responder.Limits.Budget = NewMemoryBudget(1000)
+	go requester.Write(make([]byte, 5000))
+	if _, err := responder.Read(); !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("Decompression budget wasn't applied: %v", err)
+	}
+}
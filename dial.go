@@ -0,0 +1,181 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// ContextDialer opens connections for outbound sessions. It is implemented by net.Dialer and
+// ProxyDialer.
+type ContextDialer interface {
+	DialContext(ctx context.Context, network string, addr string) (net.Conn, error)
+}
+
+// DialRequester connects to a TCP address using the specified dialer and sets up a requester
+// session. A net.Dialer is used if dialer is nil. The connection is closed if the context ends
+// during setup.
+func DialRequester(ctx context.Context, dialer ContextDialer, addr string) (*PacketSession,
+	error) {
+	return dialSession(ctx, dialer, addr, nil)
+}
+
+// BackoffPolicy controls how DialWithRetry() waits between connection attempts. Fields which are
+// zero are taken from DefaultBackoffPolicy, except for MaxAttempts and Jitter.
+type BackoffPolicy struct {
+	// InitialDelay is the wait after the first failed attempt. Each wait after that is Multiplier
+	// times longer than the last, up to MaxDelay.
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Multiplier   float64
+
+	// Jitter randomly shortens each wait by up to this fraction of it, so that clients which lost
+	// their connections at the same time don't all come back at once. It should be from 0 to 1.
+	Jitter float64
+
+	// MaxAttempts is the most connection attempts made. Zero means keep trying until the context
+	// ends.
+	MaxAttempts int
+
+	// Configure, if set, is called for each new session before setup so that options such as
+	// FlowControl or an Authenticator can be applied
+	Configure func(s *PacketSession)
+
+	// Dialer opens the connections, such as through a ProxyDialer. A net.Dialer is used if it is
+	// nil.
+	Dialer ContextDialer
+}
+
+// DefaultBackoffPolicy is used for any settings left out of the policy passed to DialWithRetry()
+var DefaultBackoffPolicy = BackoffPolicy{
+	InitialDelay: 100 * time.Millisecond,
+	MaxDelay:     30 * time.Second,
+	Multiplier:   2,
+	Jitter:       0.2,
+}
+
+// DialWithRetry connects to a TCP address and sets up a requester session, trying again with
+// exponential backoff if the connection or setup fails. Failures which trying again won't fix,
+// such as the peer rejecting this side's credentials or options, are returned right away. If the
+// context ends first, its error is returned.
+func DialWithRetry(ctx context.Context, addr string, policy BackoffPolicy) (*PacketSession,
+	error) {
+
+	policy = policy.withDefaults()
+	delay := policy.InitialDelay
+
+	for attempt := 1; ; attempt++ {
+		session, err := dialSession(ctx, policy.Dialer, addr, policy.Configure)
+		if err == nil {
+			return session, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !retryable(err) {
+			return nil, err
+		}
+		if policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts {
+			return nil, fmt.Errorf("giving up on %s after %d attempts: %w", addr, attempt, err)
+		}
+
+		wait := policy.jittered(delay)
+		logWarning("connecting to %s failed, trying again in %s: %s", addr, wait, err.Error())
+		if err := sleepContext(ctx, wait); err != nil {
+			return nil, err
+		}
+		delay = policy.nextDelay(delay)
+	}
+}
+
+// jittered shortens a delay by a random amount of up to the policy's Jitter
+func (p BackoffPolicy) jittered(delay time.Duration) time.Duration {
+	return delay - time.Duration(rand.Float64()*p.Jitter*float64(delay))
+}
+
+// nextDelay returns the delay which follows the specified one
+func (p BackoffPolicy) nextDelay(delay time.Duration) time.Duration {
+	delay = time.Duration(float64(delay) * p.Multiplier)
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// sleepContext waits for the specified amount of time or until the context ends, in which case
+// the context's error is returned
+func sleepContext(ctx context.Context, wait time.Duration) error {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// withDefaults fills in the settings which were left out of a policy
+func (p BackoffPolicy) withDefaults() BackoffPolicy {
+	if p.InitialDelay <= 0 {
+		p.InitialDelay = DefaultBackoffPolicy.InitialDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = DefaultBackoffPolicy.MaxDelay
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = DefaultBackoffPolicy.Multiplier
+	}
+	return p
+}
+
+// dialSession makes a single attempt at connecting and setting up a requester session. The
+// connection is closed if the context ends during setup.
+func dialSession(ctx context.Context, dialer ContextDialer, addr string,
+	configure func(s *PacketSession)) (*PacketSession, error) {
+
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	session := NewPacketRequester(conn)
+	if configure != nil {
+		configure(session)
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	if err := session.InitRequester(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// Documents which weren't acknowledged over the last connection are sent again
+	if session.Acknowledged && session.Outbox != nil {
+		if err := session.ResendPending(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return session, nil
+}
+
+// retryable returns false for errors which mean that the peer turned this side down, so trying
+// again would get the same result
+func retryable(err error) bool {
+	return !errors.Is(err, ErrAuthFailed) && !errors.Is(err, ErrPeerRejected) &&
+		!errors.Is(err, ErrSessionSetup) && !errors.Is(err, ErrEncryptionRequired) &&
+		!errors.Is(err, ErrProxyAuth) && !errors.Is(err, ErrVersionMismatch)
+}
@@ -0,0 +1,96 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDialWithRetry(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error setting up listener: %s", err.Error())
+	}
+	defer listener.Close()
+
+	// The first two connections are dropped before setup can finish
+	go func() {
+		for i := 0; i < 2; i++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		responder := NewPacketResponder(conn, 4096)
+		if responder.InitResponder() == nil {
+			responder.Write([]byte("connected"))
+		}
+	}()
+
+	policy := BackoffPolicy{InitialDelay: time.Millisecond, MaxAttempts: 5, Jitter: 0.5}
+	session, err := DialWithRetry(context.Background(), listener.Addr().String(), policy)
+	if err != nil {
+		t.Fatalf("DialWithRetry failed: %s", err.Error())
+	}
+	defer session.Close()
+	if packet, err := session.Read(); err != nil || string(packet) != "connected" {
+		t.Fatalf("Session from DialWithRetry doesn't work: %v", err)
+	}
+
+	// Nothing is listening any more, so the attempts run out
+	addr := listener.Addr().String()
+	listener.Close()
+	policy.MaxAttempts = 3
+	if _, err := DialWithRetry(context.Background(), addr, policy); err == nil {
+		t.Fatalf("DialWithRetry succeeded without a listener")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	policy.MaxAttempts = 0
+	if _, err := DialWithRetry(ctx, addr, policy); err != context.Canceled {
+		t.Fatalf("DialWithRetry ignored its context: %v", err)
+	}
+}
+
+func TestDialWithRetryPermanentError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error setting up listener: %s", err.Error())
+	}
+	defer listener.Close()
+
+	accepted := make(chan bool, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- true
+			NewPacketResponder(conn, 4096).InitResponder()
+		}
+	}()
+
+	// Mismatched options won't get any better by trying again
+	policy := BackoffPolicy{InitialDelay: time.Millisecond, MaxAttempts: 5,
+		Configure: func(s *PacketSession) {
+			s.SequenceNumbers = true
+		}}
+	_, err = DialWithRetry(context.Background(), listener.Addr().String(), policy)
+	if !errors.Is(err, ErrSessionSetup) {
+		t.Fatalf("Wrong error for mismatched options: %v", err)
+	}
+	if len(accepted) != 1 {
+		t.Fatalf("Permanent error was retried %d times", len(accepted))
+	}
+}
@@ -0,0 +1,243 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// This file contains a dissector for the raw bytes of a session, such as one direction of a TCP
+// stream saved from a packet capture with tcpdump and Wireshark's "Follow TCP Stream". It is
+// meant for debugging production problems when a capture is all there is to go on.
+
+// DissectOptions controls the output of Dissect()
+type DissectOptions struct {
+	// SequenceNumbers, FrameChecksums, and Compression must match the settings of the session
+	// which sent the data. They are ignored if the data starts with a setup frame, which says
+	// which ones the session used.
+	SequenceNumbers bool
+	FrameChecksums  bool
+	Compression     bool
+
+	// Dump controls how reassembled Documents are printed
+	Dump DumpOptions
+}
+
+// frameNames holds the names of frame types printed by Dissect()
+var frameNames = map[uint8]string{
+	SingleFrame:          "single",
+	MultipartFrameStart:  "multipart start",
+	MultipartFrame:       "multipart",
+	MultipartFrameFinal:  "multipart final",
+	SessionSetupRequest:  "setup request",
+	SessionSetupResponse: "setup response",
+	TopicFrame:           "topic",
+	CreditFrame:          "credit",
+	CancelFrame:          "cancel",
+	DictionaryFrame:      "dictionary",
+	AckFrame:             "ack",
+	RejectFrame:          "reject",
+	FilterFrame:          "filter",
+}
+
+// Dissect reads the data sent by one side of a session and writes a description of each frame to
+// w, prefixed by its offset in the data. Packets are put back together from their frames and
+// printed as Documents, or as hex if they can't be decoded. Setup and version negotiation at the
+// start of the data are described as well. Data which doesn't start at a frame boundary can't be
+// dissected. Packets compressed with a shared dictionary can't be decompressed.
+func Dissect(r io.Reader, w io.Writer, opts DissectOptions) error {
+
+	d := dissector{r: r, w: w, opts: opts}
+	if err := d.setup(); err != nil {
+		return err
+	}
+	packets := offlineSession(d.opts.Compression)
+
+	headerSize := 3
+	if d.opts.FrameChecksums {
+		headerSize = 4
+	}
+	for {
+		frameStart := d.offset
+		header, err := d.read(headerSize)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if d.opts.FrameChecksums && frameChecksum(header[:3]) != header[3] {
+			d.printf(frameStart, "bad frame header checksum [% x]\n", header)
+			return ErrFrameChecksum
+		}
+		frameType := header[0]
+		payload, err := d.read(int(header[1])<<8 + int(header[2]))
+		if err != nil {
+			return err
+		}
+
+		line := fmt.Sprintf("%s frame, %d bytes", frameName(frameType), len(payload))
+		if d.opts.SequenceNumbers {
+			if len(payload) < sequenceSize {
+				d.printf(frameStart, "%s, too short for a sequence number\n", line)
+				return ErrInvalidFrame
+			}
+			line += fmt.Sprintf(", sequence %d", binary.BigEndian.Uint64(payload))
+			payload = payload[sequenceSize:]
+		}
+		if detail := frameDetail(frameType, payload); detail != "" {
+			line += ": " + detail
+		}
+		d.printf(frameStart, "%s\n", line)
+
+		switch frameType {
+		case CreditFrame, DictionaryFrame, AckFrame, RejectFrame, FilterFrame:
+			continue
+		}
+		packet, done, err := packets.addOfflineFrame(frameType, payload)
+		if err != nil {
+			d.printf(frameStart, "  packet error: %s\n", err.Error())
+			continue
+		}
+		if done {
+			d.printPacket(frameStart, packet)
+		}
+	}
+}
+
+// dissector holds the state of a call to Dissect()
+type dissector struct {
+	r      io.Reader
+	w      io.Writer
+	opts   DissectOptions
+	offset int
+}
+
+// read reads the specified number of bytes. It returns io.EOF only if there was no data left at
+// all.
+func (d *dissector) read(size int) ([]byte, error) {
+
+	out := make([]byte, size)
+	n, err := io.ReadFull(d.r, out)
+	if err == io.ErrUnexpectedEOF {
+		d.printf(d.offset, "data ends partway through a frame (%d of %d bytes)\n", n, size)
+		err = ErrSize
+	}
+	d.offset += n
+	return out, err
+}
+
+// printf writes a line of output prefixed by an offset
+func (d *dissector) printf(offset int, format string, args ...interface{}) {
+	fmt.Fprintf(d.w, "%08x  "+format, append([]interface{}{offset}, args...)...)
+}
+
+// setup describes the setup frame and version negotiation at the start of the data, if they are
+// there, and takes the session's options from the setup flags
+func (d *dissector) setup() error {
+
+	first, err := d.read(1)
+	if err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+	if first[0] != SessionSetupRequest && first[0] != SessionSetupResponse {
+		// The data starts with a regular frame, so put the byte back for the frame loop
+		d.r = io.MultiReader(strings.NewReader(string(first)), d.r)
+		d.offset = 0
+		return nil
+	}
+
+	rest, err := d.read(3)
+	if err != nil {
+		return err
+	}
+	flags := rest[2]
+	d.printf(0, "%s, buffer size %d, flags 0x%02x\n", frameName(first[0]),
+		int(rest[0])<<8+int(rest[1]), flags)
+
+	d.opts.SequenceNumbers = flags&setupSequenceNumbers != 0
+	d.opts.FrameChecksums = flags&setupFrameChecksums != 0
+	d.opts.Compression = flags&setupCompression != 0
+	if flags&setupVersions == 0 {
+		return nil
+	}
+
+	start := d.offset
+	if first[0] == SessionSetupResponse {
+		version, err := d.read(1)
+		if err != nil {
+			return err
+		}
+		d.printf(start, "version chosen: %d\n", version[0])
+		return nil
+	}
+
+	count, err := d.read(1)
+	if err != nil {
+		return err
+	}
+	versions, err := d.read(int(count[0]))
+	if err != nil {
+		return err
+	}
+	d.printf(start, "versions offered: %v\n", versions)
+	return nil
+}
+
+// printPacket prints a reassembled packet as a Document, or as hex if it isn't one
+func (d *dissector) printPacket(offset int, packet []byte) {
+
+	opts := d.opts.Dump
+	if opts.MaxValueLength == 0 {
+		opts.MaxValueLength = 32
+	}
+
+	doc := NewDocument()
+	if err := doc.Unflatten(packet); err != nil {
+		d.printf(offset, "  packet of %d bytes isn't a Document (%s): %s\n", len(packet),
+			err.Error(), dumpBinary(packet, opts))
+		return
+	}
+
+	var sb strings.Builder
+	doc.Dump(&sb, opts)
+	for _, line := range strings.Split(strings.TrimSuffix(sb.String(), "\n"), "\n") {
+		d.printf(offset, "  %s\n", line)
+	}
+}
+
+// frameName returns the name of a frame type for Dissect()
+func frameName(frameType uint8) string {
+	if name, ok := frameNames[frameType]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown (%d)", frameType)
+}
+
+// frameDetail describes the contents of frames which don't hold packet data
+func frameDetail(frameType uint8, payload []byte) string {
+
+	switch frameType {
+	case MultipartFrameStart:
+		return "total size " + string(payload)
+	case TopicFrame, AckFrame:
+		return fmt.Sprintf("%q", payload)
+	case CreditFrame, CancelFrame, RejectFrame:
+		if len(payload) == 8 {
+			return fmt.Sprintf("%d", binary.BigEndian.Uint64(payload))
+		}
+	case DictionaryFrame:
+		return fmt.Sprintf("%x", payload)
+	case FilterFrame:
+		if topic, filters, err := parseFilterFrame(payload); err == nil {
+			return fmt.Sprintf("%q, %d subscribers", topic, len(filters))
+		}
+	}
+	return ""
+}
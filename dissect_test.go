@@ -0,0 +1,85 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+// tappedConn keeps a copy of everything written to a connection
+type tappedConn struct {
+	net.Conn
+	sent bytes.Buffer
+}
+
+func (c *tappedConn) Write(b []byte) (int, error) {
+	c.sent.Write(b)
+	return c.Conn.Write(b)
+}
+
+func TestDissect(t *testing.T) {
+	var tap *tappedConn
+	requester, responder := sessionPair(t, func(req, resp *PacketSession) {
+		tap = &tappedConn{Conn: req.Connection}
+		req.Connection = tap
+		for _, s := range []*PacketSession{req, resp} {
+			s.SequenceNumbers = true
+			s.FrameChecksums = true
+		}
+	})
+
+	for _, value := range []string{"hello", strings.Repeat("x", 5000)} {
+		doc := NewDocument()
+		doc.AttachString("greeting", value)
+		go requester.WriteDocument(doc)
+		if _, err := responder.ReadDocument(); err != nil {
+			t.Fatalf("Error reading document: %s", err.Error())
+		}
+	}
+
+	var out strings.Builder
+	if err := Dissect(bytes.NewReader(tap.sent.Bytes()), &out, DissectOptions{}); err != nil {
+		t.Fatalf("Error dissecting session: %s", err.Error())
+	}
+	for _, want := range []string{"00000000  setup request, buffer size", "versions offered: [1]",
+		"single frame, 27 bytes, sequence 0", "multipart start frame", "multipart final frame",
+		`String "hello"`, "Document (1 items)"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("Dissector output is missing '%s':\n%s", want, out.String())
+		}
+	}
+
+	// Data cut off partway through a frame is reported
+	truncated := tap.sent.Bytes()[:tap.sent.Len()-10]
+	err := Dissect(bytes.NewReader(truncated), &out, DissectOptions{})
+	if !errors.Is(err, ErrSize) {
+		t.Fatalf("Truncated data wasn't caught: %v", err)
+	}
+}
+
+func TestDissectWithoutSetup(t *testing.T) {
+	doc := NewDocument()
+	doc.AttachInt32("count", 7)
+	packet, err := doc.Flatten()
+	if err != nil {
+		t.Fatalf("Error flattening document: %s", err.Error())
+	}
+
+	var data bytes.Buffer
+	WriteFrame(&data, TopicFrame, []byte("news"))
+	WriteFrame(&data, SingleFrame, packet)
+
+	var out strings.Builder
+	if err := Dissect(&data, &out, DissectOptions{}); err != nil {
+		t.Fatalf("Error dissecting frames: %s", err.Error())
+	}
+	if !strings.HasPrefix(out.String(), `00000000  topic frame, 4 bytes: "news"`) ||
+		!strings.Contains(out.String(), "00000007  single frame") ||
+		!strings.Contains(out.String(), "Int32 7") {
+		t.Fatalf("Wrong dissector output:\n%s", out.String())
+	}
+}
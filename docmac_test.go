@@ -0,0 +1,42 @@
+package oganesson
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDocumentHMAC(t *testing.T) {
+	b := NewDocumentBuilder(nil)
+	b.AddString(MsgCodeField, "PING")
+	b.AddInt32("n", 5)
+	doc, _ := b.Build()
+	key := []byte("shared secret")
+
+	if err := doc.Authenticate(key); err != nil {
+		t.Fatalf("Authenticate failed: %s", err.Error())
+	}
+	packet, _ := doc.Flatten()
+
+	received := NewDocument()
+	received.Unflatten(packet)
+	if err := received.VerifyHMAC([]byte("wrong secret")); !errors.Is(err, ErrBadHMAC) {
+		t.Fatalf("Wrong key returned %v", err)
+	}
+	if err := received.VerifyHMAC(key); err != nil {
+		t.Fatalf("VerifyHMAC failed: %s", err.Error())
+	}
+	if code, err := messageCode(received); err != nil || code != "PING" {
+		t.Fatalf("Verified document isn't keyed anymore")
+	}
+	if err := received.VerifyHMAC(key); !errors.Is(err, ErrBadHMAC) {
+		t.Fatalf("Document without an HMAC passed")
+	}
+
+	// Changing a field's value is caught
+	tampered := NewDocument()
+	tampered.Unflatten(bytes.Replace(packet, []byte("PING"), []byte("PONG"), 1))
+	if err := tampered.VerifyHMAC(key); !errors.Is(err, ErrBadHMAC) {
+		t.Fatalf("Tampered document passed")
+	}
+}
@@ -1,7 +1,12 @@
 package oganesson
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"io"
+	"math"
+	"sync/atomic"
 
 	"github.com/darkwyrm/oganesson/membufio"
 )
@@ -17,14 +22,97 @@ type SegContainer interface {
 // session level. This is normally used for setting up encryption, but can als be used by
 // applications wanting greater control over the implementation.
 
+// lenientDocumentEnd is read by every goroutine decoding Documents, so it is only changed through
+// SetLenientDocumentEnd()
+var lenientDocumentEnd atomic.Bool
+
+// SetLenientDocumentEnd makes decoding accept Documents whose DocumentEnd segment is missing or
+// has the wrong segment count, logging a warning instead of returning an error. Some non-Go peers
+// have this bug, so this exists for interoperating with them and should otherwise be left off. It
+// is safe to call at any time.
+func SetLenientDocumentEnd(lenient bool) {
+	lenientDocumentEnd.Store(lenient)
+}
+
 // Document is a JBitPack document containing a string command name and optional associated data.
+//
+// A Document is not safe for use by more than one goroutine at a time unless all of them only read
+// it. Once a Document is finished, Freeze() makes it safe to share, such as for sending the same
+// Document from several goroutines or caching it.
 type Document struct {
 	Items []SegContainer
+
+	// MaxValueSize, if not zero, is the size of the largest string or binary value the Attach
+	// methods accept. Larger values make them return ErrSize instead of being stored as a
+	// HugeString or HugeBinary, so protocol limits are caught when a value is attached instead of
+	// by the peer.
+	MaxValueSize uint64
+
+	// MsgCode, Segments, and Attachments are the fields WireMsg had, kept so that old code still
+	// compiles. They are filled in from Items when a Document is created or read and kept up to
+	// date by the Document's methods, but changing Items directly leaves them as they were. The
+	// Segments of a message Document are its code and its attachment map. Attachments holds the
+	// attachments of a message or keyed Document, and the code is empty for other Documents.
+	//
+	// Deprecated: use MessageCode(), Items, and the named Get methods, which always match Items.
+	MsgCode     string
+	Segments    []MessageSegment
+	Attachments SegmentMap
+
+	sensitive map[string]bool
+	frozen    bool
+
+	// shared is set when the Document's items and sensitive field names may also be used by
+	// another Document created by View()
+	shared bool
+
+	// frozenSize is the value GetSize() returns once the Document is frozen, since its items
+	// can't change after that
+	frozenSize uint64
+
+	// budget is the MemoryBudget charged budgetUsed bytes when the Document was decoded
+	budget     *MemoryBudget
+	budgetUsed uint64
+}
+
+// NewDocument creates a new, empty Document. If a message code is given, the Document is a
+// message Document (see MessageCode()) with that code and no attachments. Only the first code
+// given is used.
+func NewDocument(msgCode ...string) *Document {
+
+	out := &Document{Items: make([]SegContainer, 0)}
+	if len(msgCode) == 0 {
+		return out
+	}
+
+	var code, index Segment
+	code.SetString(msgCode[0])
+	index.setContainerIndex(DFMapType, DFLargeMapType, 0)
+	out.appendItem(&code)
+	out.appendItem(&index)
+	out.updateWireMsgFields()
+	return out
+}
+
+// MarkSensitive flags the named fields as containing sensitive information, such as passwords or
+// tokens, so that their values are redacted in human-readable output like Dump() and String().
+// The data itself is not affected.
+func (doc *Document) MarkSensitive(names ...string) {
+	if doc.frozen {
+		return
+	}
+	doc.own()
+	if doc.sensitive == nil {
+		doc.sensitive = make(map[string]bool, len(names))
+	}
+	for _, name := range names {
+		doc.sensitive[name] = true
+	}
 }
 
-// NewDocument creates a new document with the specified command name
-func NewDocument() *Document {
-	return &Document{make([]SegContainer, 0)}
+// IsSensitive returns true if the named field has been marked as sensitive
+func (doc *Document) IsSensitive(name string) bool {
+	return doc.sensitive[name]
 }
 
 // AttachInt8 adds an attachment to the document of the specified type. If the attached data exists,
@@ -36,9 +124,7 @@ func (doc *Document) AttachInt8(name string, value int8) error {
 	if err != nil {
 		return err
 	}
-	doc.Items = append(doc.Items, seg)
-
-	return nil
+	return doc.attach(name, &seg)
 }
 
 // AttachUInt8 adds an attachment to the document of the specified type. If the attached data exists,
@@ -50,9 +136,7 @@ func (doc *Document) AttachUInt8(name string, value uint8) error {
 	if err != nil {
 		return err
 	}
-	doc.Items = append(doc.Items, seg)
-
-	return nil
+	return doc.attach(name, &seg)
 }
 
 // AttachInt16 adds an attachment to the document of the specified type. If the attached data exists,
@@ -64,9 +148,7 @@ func (doc *Document) AttachInt16(name string, value int16) error {
 	if err != nil {
 		return err
 	}
-	doc.Items = append(doc.Items, seg)
-
-	return nil
+	return doc.attach(name, &seg)
 }
 
 // AttachUInt16 adds an attachment to the document of the specified type. If the attached data exists,
@@ -78,9 +160,7 @@ func (doc *Document) AttachUInt16(name string, value uint16) error {
 	if err != nil {
 		return err
 	}
-	doc.Items = append(doc.Items, seg)
-
-	return nil
+	return doc.attach(name, &seg)
 }
 
 // AttachInt32 adds an attachment to the document of the specified type. If the attached data exists,
@@ -92,9 +172,7 @@ func (doc *Document) AttachInt32(name string, value int32) error {
 	if err != nil {
 		return err
 	}
-	doc.Items = append(doc.Items, seg)
-
-	return nil
+	return doc.attach(name, &seg)
 }
 
 // AttachUInt32 adds an attachment to the document of the specified type. If the attached data exists,
@@ -106,9 +184,7 @@ func (doc *Document) AttachUInt32(name string, value uint32) error {
 	if err != nil {
 		return err
 	}
-	doc.Items = append(doc.Items, seg)
-
-	return nil
+	return doc.attach(name, &seg)
 }
 
 // AttachInt64 adds an attachment to the document of the specified type. If the attached data exists,
@@ -120,9 +196,7 @@ func (doc *Document) AttachInt64(name string, value int64) error {
 	if err != nil {
 		return err
 	}
-	doc.Items = append(doc.Items, seg)
-
-	return nil
+	return doc.attach(name, &seg)
 }
 
 // AttachUInt64 adds an attachment to the document of the specified type. If the attached data exists,
@@ -134,9 +208,7 @@ func (doc *Document) AttachUInt64(name string, value uint64) error {
 	if err != nil {
 		return err
 	}
-	doc.Items = append(doc.Items, seg)
-
-	return nil
+	return doc.attach(name, &seg)
 }
 
 // AttachString adds an attachment to the document of the specified type. If the attached data
@@ -148,9 +220,7 @@ func (doc *Document) AttachString(name string, value string) error {
 	if err != nil {
 		return err
 	}
-	doc.Items = append(doc.Items, seg)
-
-	return nil
+	return doc.attach(name, &seg)
 }
 
 // AttachBinary adds an attachment to the document of the specified type. If the attached data
@@ -162,61 +232,268 @@ func (doc *Document) AttachBinary(name string, value []byte) error {
 	if err != nil {
 		return err
 	}
-	doc.Items = append(doc.Items, seg)
+	return doc.attach(name, &seg)
+}
 
+// appendItem adds an item to the Document
+func (doc *Document) appendItem(item SegContainer) error {
+	if doc.frozen {
+		return ErrFrozen
+	}
+	doc.own()
+	doc.Items = append(doc.Items, item)
 	return nil
 }
 
-// Flatten is a convenience method that turns a Document into a byte slice
+// Freeze makes the Document read-only so that it can be shared between goroutines. Afterward,
+// the Attach methods and anything which reads data into the Document return ErrFrozen, and
+// MarkSensitive() does nothing. Items must not be changed directly, either. A frozen Document
+// can't be thawed; use Clone() to get a copy which can be changed.
+func (doc *Document) Freeze() {
+	if doc.frozen {
+		return
+	}
+
+	doc.frozenSize = doc.GetSize()
+	doc.frozen = true
+}
+
+// IsFrozen returns true if Freeze() has been called for the Document
+func (doc *Document) IsFrozen() bool {
+	return doc.frozen
+}
+
+// Clone returns a deep copy of the Document, including which fields are sensitive. None of the
+// copy's items share memory with the original, so the two can be used by different goroutines.
+// The copy isn't frozen, even if the original is, and isn't charged to any MemoryBudget. Items
+// which aren't Segments are copied as the Segment they write, and ErrSize is returned for any
+// which don't write exactly one Segment.
+func (doc *Document) Clone() (*Document, error) {
+
+	out := &Document{Items: make([]SegContainer, 0, len(doc.Items)),
+		MaxValueSize: doc.MaxValueSize}
+	for i, item := range doc.Items {
+		if seg, ok := item.(*Segment); ok {
+			out.Items = append(out.Items, cloneItem(seg))
+			continue
+		}
+
+		// Other kinds of items are copied by way of their flattened form, which must be exactly
+		// one segment. Anything else would come out of the copy as something different.
+		var buf bytes.Buffer
+		if err := item.Write(&buf); err != nil {
+			return nil, err
+		}
+		var clone Segment
+		if err := clone.Read(&buf); err != nil {
+			return nil, err
+		}
+		if buf.Len() > 0 || clone.GetSize() != item.GetSize() {
+			return nil, fmt.Errorf("%w: item %d isn't a single segment", ErrSize, i)
+		}
+		out.Items = append(out.Items, &clone)
+	}
+
+	for name := range doc.sensitive {
+		out.MarkSensitive(name)
+	}
+	out.updateWireMsgFields()
+	return out, nil
+}
+
+// View returns a Document which shares the items of this one instead of copying them, which
+// makes it a cheap way to hand the same Document to several handlers. Either Document can still
+// be changed with its methods: the first change to a Document which shares its items gives it its
+// own copy of them, so the change isn't seen by the other. Items must not be changed directly
+// while they are shared, and nothing may change the Document while View() is being called. A
+// view of a frozen Document isn't frozen.
+func (doc *Document) View() *Document {
+
+	if !doc.frozen {
+		doc.shared = true
+	}
+	return &Document{
+		Items:        doc.Items[:len(doc.Items):len(doc.Items)],
+		MaxValueSize: doc.MaxValueSize,
+		MsgCode:      doc.MsgCode,
+		Segments:     doc.Segments,
+		Attachments:  doc.Attachments,
+		sensitive:    doc.sensitive,
+		shared:       true,
+	}
+}
+
+// own gives the Document its own copy of any data it shares with views before it is changed.
+// Only Segments are copied, since the methods which change a Document don't change other kinds
+// of items.
+func (doc *Document) own() {
+
+	if !doc.shared {
+		return
+	}
+
+	items := make([]SegContainer, len(doc.Items), len(doc.Items)+1)
+	for i, item := range doc.Items {
+		items[i] = item
+		if seg, ok := item.(*Segment); ok {
+			items[i] = cloneItem(seg)
+		}
+	}
+	doc.Items = items
+
+	// The attachment map may be shared with the views, too
+	doc.updateWireMsgFields()
+
+	if doc.sensitive != nil {
+		sensitive := make(map[string]bool, len(doc.sensitive))
+		for name := range doc.sensitive {
+			sensitive[name] = true
+		}
+		doc.sensitive = sensitive
+	}
+	doc.shared = false
+}
+
+// cloneItem returns a pointer to a deep copy of a Segment
+func cloneItem(seg *Segment) *Segment {
+	out := seg.Clone()
+	return &out
+}
+
+// Flatten is a convenience method that turns a Document into a byte slice. It returns the same
+// errors as FlattenTo() if an item doesn't write as many bytes as its GetSize() says it will.
 func (doc Document) Flatten() ([]byte, error) {
 
 	// We don't check to see if Size() is zero because Document objects have a minimum size even
 	// when empty.
-	bs := membufio.Make(doc.GetSize())
-
-	if err := WriteSegment(&bs, DFDocumentStart, []byte{1}); err != nil {
+	size := doc.GetSize()
+	if size > math.MaxInt {
+		return nil, ErrSize
+	}
+	out := make([]byte, size)
+	n, err := doc.FlattenTo(out)
+	if err != nil {
 		return nil, err
 	}
+	return out[:n], nil
+}
 
-	for _, seg := range doc.Items {
-		if err := seg.Write(&bs); err != nil {
-			return nil, err
+// FlattenTo is the same as Flatten(), but it writes the Document into a buffer provided by the
+// caller so that the buffer can be reused. The number of bytes written is returned.
+// ErrBufferTooSmall is returned if the buffer is smaller than GetSize() or if the Document turns
+// out not to fit, and ErrSize if the number of bytes written doesn't match GetSize(). The buffer's
+// contents are not a valid Document if an error is returned.
+func (doc Document) FlattenTo(buf []byte) (int, error) {
+
+	size := doc.GetSize()
+	if uint64(len(buf)) < size {
+		return 0, ErrBufferTooSmall
+	}
+
+	out := Segment{DFDocumentStart, []byte{1}}.AppendTo(buf[:0])
+	for _, item := range doc.Items {
+		if seg, ok := item.(*Segment); ok {
+			out = seg.AppendTo(out)
+			continue
+		}
+
+		var itemBuf bytes.Buffer
+		if err := item.Write(&itemBuf); err != nil {
+			return 0, err
 		}
+		out = append(out, itemBuf.Bytes()...)
 	}
 
 	var docEnd Segment
 	if err := docEnd.SetDocEnd(uint64(len(doc.Items))); err != nil {
-		return nil, err
+		return 0, err
 	}
-	if err := docEnd.Write(&bs); err != nil {
-		return nil, err
+	out = docEnd.AppendTo(out)
+
+	// If an item's size was reported wrong, append() will have moved the data elsewhere
+	if len(out) > len(buf) {
+		return 0, ErrBufferTooSmall
 	}
-	return bs.Buffer, nil
+	if uint64(len(out)) != size {
+		return 0, ErrSize
+	}
+	return len(out), nil
 }
 
 // Read attempts to read in a Document from the given Reader.
 func (doc *Document) Read(r io.Reader) error {
+	return doc.ReadContext(context.Background(), r)
+}
+
+// ReadContext is the same as Read(), but it checks the context between each segment so that
+// reading a very large Document can be abandoned partway through.
+func (doc *Document) ReadContext(ctx context.Context, r io.Reader) error {
+	return doc.ReadLimited(ctx, r, DecodeLimits{})
+}
+
+// ReadLimited is the same as ReadContext(), but the data read must also stay within the limits
+// given to it.
+func (doc *Document) ReadLimited(ctx context.Context, r io.Reader, limits DecodeLimits) error {
+	d := NewDecoder(r)
+	d.Limits = limits
+	return doc.Decode(ctx, d)
+}
+
+// Decode reads the Document from a Decoder. This makes all of the Decoder's options, such as its
+// limits and arena, available when reading a Document. If the limits have a MemoryBudget, the
+// bytes charged to it are held by the Document until ReleaseMemory() is called, or given back
+// right away if decoding fails.
+func (doc *Document) Decode(ctx context.Context, d *Decoder) error {
 
-	var s Segment
+	charged := d.charged
+	err := doc.decode(ctx, d)
+	charged = d.charged - charged
+	if err != nil {
+		d.Limits.Budget.Release(charged)
+		return err
+	}
+	doc.updateWireMsgFields()
+	if charged > 0 {
+		doc.ReleaseMemory()
+		doc.budget = d.Limits.Budget
+		doc.budgetUsed = charged
+	}
+	return nil
+}
 
-	if err := s.Read(r); err != nil {
+// decode does the work for Decode()
+func (doc *Document) decode(ctx context.Context, d *Decoder) error {
+
+	s, err := d.NextContext(ctx)
+	if err != nil {
 		return err
 	}
 	if s.GetType() != DFDocumentStart {
 		return ErrInvalidMsg
 	}
+	if doc.frozen {
+		return ErrFrozen
+	}
 
 	doc.Items = make([]SegContainer, 0)
 
-	if err := s.Read(r); err != nil {
-		return err
-	}
-	for s.GetType() != DFDocumentEnd {
-		doc.Items = append(doc.Items, s)
-
-		if err := s.Read(r); err != nil {
+	// Each item needs its own Segment because the Items slice holds pointers to them
+	for {
+		item, err := d.NextContext(ctx)
+		if err != nil {
+			// The decoder only returns io.EOF when the stream ends on a segment boundary. A
+			// segment which is cut short is an ErrSegmentSize error even in lenient mode.
+			if err == io.EOF && lenientDocumentEnd.Load() {
+				logWarning("document is missing its DocumentEnd segment")
+				return nil
+			}
 			return err
 		}
+		if item.GetType() == DFDocumentEnd {
+			s = item
+			break
+		}
+		doc.appendItem(&item)
 	}
 
 	segCount, err := s.GetDocEnd()
@@ -224,24 +501,35 @@ func (doc *Document) Read(r io.Reader) error {
 		return err
 	}
 
-	if segCount != uint64(len(doc.Items)) {
-		return ErrSize
+	return checkDocEndCount(segCount, uint64(len(doc.Items)))
+}
+
+// checkDocEndCount compares the segment count from a DocumentEnd segment with the actual number
+// of segments read, putting up with a mismatch if SetLenientDocumentEnd() has turned it on.
+func checkDocEndCount(segCount uint64, actual uint64) error {
+	if segCount == actual {
+		return nil
 	}
-	return nil
+	if lenientDocumentEnd.Load() {
+		logWarning("DocumentEnd segment count is %d, but document has %d segments", segCount,
+			actual)
+		return nil
+	}
+	return ErrSize
 }
 
-// GetSize returns the size of the document when flattened
+// GetSize returns the size of the document when flattened. The items' sizes are added up each
+// time, since they may have been changed in place, except for a frozen Document, whose size is
+// worked out once by Freeze().
 func (doc Document) GetSize() uint64 {
 
-	// Start with the DocStart segment size
-	out := uint64(2)
-
-	for _, s := range doc.Items {
-		out += s.GetSize()
+	if doc.frozen {
+		return doc.frozenSize
+	}
+	out := wireSize(DFDocumentStart, 0) + wireSize(DFDocumentEnd, 0)
+	for _, item := range doc.Items {
+		out += item.GetSize()
 	}
-
-	// DocEnd segment size
-	out += 9
 	return out
 }
 
@@ -252,26 +540,58 @@ func (doc *Document) Unflatten(data []byte) error {
 	return doc.Read(&bs)
 }
 
-// Write dumps the Document to the given Writer interface object.
+// UnflattenLimited is the same as Unflatten(), but the data must stay within the limits given
+// to it.
+func (doc *Document) UnflattenLimited(data []byte, limits DecodeLimits) error {
+
+	bs := membufio.New(data)
+	return doc.ReadLimited(context.Background(), &bs, limits)
+}
+
+// Write dumps the Document to the given Writer interface object. The whole Document is handed to
+// the Writer at once, so network connections can send it with a single writev call without
+// copying the payloads into a temporary buffer.
 func (doc *Document) Write(w io.Writer) error {
 
-	if err := WriteSegment(w, DFDocumentStart, []byte{1}); err != nil {
-		return err
+	// Segment headers are at most 9 bytes, so they all fit in one allocation
+	headers := make([]byte, 0, 9*(len(doc.Items)+2))
+	bufs := make([][]byte, 0, 2*len(doc.Items)+2)
+	var total int64
+	addSegment := func(fieldType uint8, payload []byte) {
+		start := len(headers)
+		headers = appendSegmentHeader(headers, fieldType, uint64(len(payload)))
+		bufs = append(bufs, headers[start:], payload)
+		total += int64(len(headers)-start) + int64(len(payload))
 	}
 
-	for _, s := range doc.Items {
-		if err := s.Write(w); err != nil {
+	addSegment(DFDocumentStart, []byte{1})
+	for _, item := range doc.Items {
+		if seg, ok := item.(*Segment); ok {
+			addSegment(seg.Type, seg.Value)
+			continue
+		}
+
+		var itemBuf bytes.Buffer
+		if err := item.Write(&itemBuf); err != nil {
 			return err
 		}
+		bufs = append(bufs, itemBuf.Bytes())
+		total += int64(itemBuf.Len())
 	}
 
 	var docEnd Segment
 	if err := docEnd.SetDocEnd(uint64(len(doc.Items))); err != nil {
 		return err
 	}
-	if err := docEnd.Write(w); err != nil {
+	addSegment(docEnd.Type, docEnd.Value)
+
+	bytesWritten, err := writeBuffers(w, bufs)
+	if err != nil {
 		return err
 	}
+	if bytesWritten != total {
+		return ErrIO
+	}
 
 	return nil
 }
@@ -1,7 +1,11 @@
+//go:build !nonet
+
 package oganesson
 
 import (
+	"errors"
 	"net"
+	"sync"
 	"testing"
 	"time"
 
@@ -9,7 +13,7 @@ import (
 )
 
 func TestDocumentFlattenUnflattenSize(t *testing.T) {
-	wm := NewDocument()
+	wm := NewDocument("TestMsg")
 	wm.AttachString("testString", "abcdef")
 	wm.AttachInt64("testInt", 42)
 
@@ -109,7 +113,7 @@ func DocumentReadWriteSetup(sync chan int, port string) {
 		return
 	}
 	s := NewPacketRequester(senderconn)
-	s.Timeout = time.Minute * 5
+	s.WriteTimeout = time.Minute * 5
 
 	wm := NewDocument("TestMsg")
 	wm.AttachString("testString", "abcdef")
@@ -121,7 +125,6 @@ func DocumentReadWriteSetup(sync chan int, port string) {
 		panic(err)
 	}
 
-	s.UpdateTimeout()
 	err = wm.Write(s.Connection)
 
 	if err != nil {
@@ -130,7 +133,6 @@ func DocumentReadWriteSetup(sync chan int, port string) {
 }
 
 func TestDocumentReadWrite(t *testing.T) {
-	MaxCommandLength = 300
 	sync := make(chan int)
 	go DocumentReadWriteSetup(sync, "3008")
 
@@ -147,11 +149,10 @@ func TestDocumentReadWrite(t *testing.T) {
 	}
 	defer conn.Close()
 
-	s := NewPacketResponder(conn, 32767)
-	s.Timeout = time.Minute * 5
+	s := NewPacketResponder(conn, 32767, WithMaxCommandLength(300))
+	s.ReadTimeout = time.Minute * 5
 
 	wm := NewDocument("")
-	s.UpdateTimeout()
 	err = wm.Read(s.Connection)
 	if err != nil {
 		t.Fatalf("Error receiving wire message: %s", err.Error())
@@ -300,3 +301,37 @@ func TestDocumentGetSetOther(t *testing.T) {
 	}
 
 }
+
+func TestDocumentFreeze(t *testing.T) {
+	doc := NewDocument()
+	doc.AttachString("name", "value")
+	doc.Freeze()
+
+	if !doc.IsFrozen() {
+		t.Fatalf("Document isn't frozen")
+	}
+	if err := doc.AttachInt8("more", 1); !errors.Is(err, ErrFrozen) {
+		t.Fatalf("Frozen document was changed: %v", err)
+	}
+	empty, _ := NewDocument().Flatten()
+	if err := doc.Unflatten(empty); !errors.Is(err, ErrFrozen) {
+		t.Fatalf("Frozen document was read into: %v", err)
+	}
+	if len(doc.Items) != 1 {
+		t.Fatalf("Frozen document has %d items", len(doc.Items))
+	}
+
+	// Readers can share a frozen Document
+	want := doc.GetSize()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if data, err := doc.Flatten(); err != nil || uint64(len(data)) != want {
+				t.Errorf("Flattening shared document failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
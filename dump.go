@@ -0,0 +1,168 @@
+package oganesson
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DumpOptions controls the output of Document.Dump()
+type DumpOptions struct {
+	// Indent is the string used for each level of indentation. Two spaces are used if it is empty.
+	Indent string
+
+	// MaxValueLength is the number of bytes of a string or binary value to show before it is
+	// truncated. 32 is used if it is zero and values are never truncated if it is negative.
+	MaxValueLength int
+
+	// ShowSizes adds the flattened size of each segment to the output
+	ShowSizes bool
+}
+
+// RedactedValue replaces the values of sensitive fields in human-readable output
+const RedactedValue = "***"
+
+// Dump writes an indented, human-readable description of the Document to the Writer given to it.
+// Map and list items are indented under the segment which starts their container. The values of
+// fields marked with MarkSensitive() are replaced with RedactedValue. It is intended for debugging
+// and logging; the output format is not stable and should not be parsed.
+func (doc *Document) Dump(w io.Writer, opts DumpOptions) error {
+
+	if opts.Indent == "" {
+		opts.Indent = "  "
+	}
+	if opts.MaxValueLength == 0 {
+		opts.MaxValueLength = 32
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Document (%d items", len(doc.Items)))
+	if opts.ShowSizes {
+		sb.WriteString(fmt.Sprintf(", %d bytes", doc.GetSize()))
+	}
+	sb.WriteString(")\n")
+
+	for i := 0; i < len(doc.Items); i++ {
+		seg, ok := doc.Items[i].(*Segment)
+		if !ok {
+			sb.WriteString(opts.Indent + dumpContainer(doc.Items[i], opts) + "\n")
+			continue
+		}
+		sb.WriteString(opts.Indent + dumpSegment(*seg, opts) + "\n")
+
+		// Container contents are grouped under the container's index segment, so figure out how
+		// many of the following items belong to it
+		var itemCount, perItem uint64
+		switch seg.Type {
+		case DFMapType, DFLargeMapType, DFKeyedMapType:
+			itemCount, _ = seg.GetMapIndex()
+			perItem = 2
+		case DFListType, DFLargeListType:
+			itemCount, _ = seg.GetListIndex()
+			perItem = 1
+		default:
+			continue
+		}
+
+		for j := uint64(0); j < itemCount && i+int(perItem) < len(doc.Items); j++ {
+			if perItem == 1 {
+				i++
+				sb.WriteString(opts.Indent + opts.Indent + dumpContainer(doc.Items[i], opts) + "\n")
+				continue
+			}
+
+			key, ok := doc.Items[i+1].(*Segment)
+			i += 2
+			if !ok || !isValidMapKey(seg.Type, key.Type) {
+				sb.WriteString(opts.Indent + opts.Indent + "<invalid key>: ")
+			} else if key.Type != DFStringType {
+				sb.WriteString(opts.Indent + opts.Indent + dumpSegment(*key,
+					DumpOptions{MaxValueLength: opts.MaxValueLength}) + ": ")
+			} else {
+				sb.WriteString(opts.Indent + opts.Indent + dumpString(key.Value, opts) + ": ")
+				if doc.IsSensitive(string(key.Value)) {
+					sb.WriteString(TypeName(doc.Items[i].GetType()) + " " + RedactedValue + "\n")
+					continue
+				}
+			}
+			sb.WriteString(dumpContainer(doc.Items[i], opts) + "\n")
+		}
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// String returns the output of Dump() with the default options
+func (doc *Document) String() string {
+	var sb strings.Builder
+	doc.Dump(&sb, DumpOptions{})
+	return sb.String()
+}
+
+// dumpContainer formats a Document item which may or may not be a Segment
+func dumpContainer(item SegContainer, opts DumpOptions) string {
+	if seg, ok := item.(*Segment); ok {
+		return dumpSegment(*seg, opts)
+	}
+
+	out := TypeName(item.GetType())
+	if opts.ShowSizes {
+		out += fmt.Sprintf(" (%d bytes)", item.GetSize())
+	}
+	return out
+}
+
+// dumpSegment formats a single segment for Dump()
+func dumpSegment(seg Segment, opts DumpOptions) string {
+
+	var value string
+	var err error
+	switch seg.Type {
+	case DFInt8Type, DFUInt8Type, DFInt16Type, DFUInt16Type, DFInt32Type, DFUInt32Type,
+		DFInt64Type, DFUInt64Type, DFBoolType, DFFloat32Type, DFFloat64Type:
+
+		// ToString() already handles all of the fixed-size types, so just borrow its output
+		value = strings.SplitN(seg.ToString(), "=", 2)[1]
+	case DFStringType, DFHugeStringType:
+		value = dumpString(seg.Value, opts)
+	case DFBinaryType, DFHugeBinaryType:
+		value = dumpBinary(seg.Value, opts)
+	case DFMapType, DFLargeMapType, DFKeyedMapType:
+		var count uint64
+		count, err = seg.GetMapIndex()
+		value = fmt.Sprintf("%d pairs", count)
+	case DFListType, DFLargeListType:
+		var count uint64
+		count, err = seg.GetListIndex()
+		value = fmt.Sprintf("%d items", count)
+	}
+	if err != nil {
+		value = "<" + err.Error() + ">"
+	}
+
+	out := TypeName(seg.Type)
+	if value != "" {
+		out += " " + value
+	}
+	if opts.ShowSizes {
+		out += fmt.Sprintf(" (%d bytes)", seg.GetSize())
+	}
+	return out
+}
+
+// dumpString quotes and, if needed, truncates a string value
+func dumpString(value []byte, opts DumpOptions) string {
+	if opts.MaxValueLength > 0 && len(value) > opts.MaxValueLength {
+		return fmt.Sprintf("%q...", value[:opts.MaxValueLength])
+	}
+	return fmt.Sprintf("%q", value)
+}
+
+// dumpBinary formats and, if needed, truncates a binary value as hex
+func dumpBinary(value []byte, opts DumpOptions) string {
+	if opts.MaxValueLength > 0 && len(value) > opts.MaxValueLength {
+		return fmt.Sprintf("[% x ...]", value[:opts.MaxValueLength])
+	}
+	return fmt.Sprintf("[% x]", value)
+}
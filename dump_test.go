@@ -0,0 +1,52 @@
+package oganesson
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDocumentDump(t *testing.T) {
+	doc := NewDocument()
+	doc.AttachString("testString", "abcdef")
+	doc.AttachInt64("testInt", 42)
+
+	var mapIndex Segment
+	mapIndex.SetMapIndex(SegmentMap{"key": Segment{}})
+	doc.Items = append(doc.Items,
+		&mapIndex,
+		&Segment{DFStringType, []byte("key")},
+		&Segment{DFBinaryType, []byte("\x01\x02\x03\x04\x05")})
+
+	var sb strings.Builder
+	if err := doc.Dump(&sb, DumpOptions{MaxValueLength: 4, ShowSizes: true}); err != nil {
+		t.Fatalf("Document.Dump failed: %s", err.Error())
+	}
+
+	expected := "Document (5 items, 46 bytes)\n" +
+		"  String \"abcd\"... (9 bytes)\n" +
+		"  Int64 42 (9 bytes)\n" +
+		"  Map 1 pairs (3 bytes)\n" +
+		"    \"key\": Binary [01 02 03 04 ...] (8 bytes)\n"
+	if sb.String() != expected {
+		t.Fatalf("Document.Dump output mismatch:\nexpected:\n%s\ngot:\n%s", expected, sb.String())
+	}
+}
+
+func TestDocumentRedaction(t *testing.T) {
+	b := NewDocumentBuilder(nil)
+	b.AddString("user", "admin")
+	b.AddString("password", "hunter2")
+	doc, err := b.Build()
+	if err != nil {
+		t.Fatalf("Error building document: %s", err.Error())
+	}
+	doc.MarkSensitive("password")
+
+	out := doc.String()
+	if strings.Contains(out, "hunter2") {
+		t.Fatalf("Document.String leaked a sensitive value:\n%s", out)
+	}
+	if !strings.Contains(out, `"password": String ***`) || !strings.Contains(out, `"admin"`) {
+		t.Fatalf("Document.String redaction mismatch:\n%s", out)
+	}
+}
@@ -0,0 +1,171 @@
+package oganesson
+
+import (
+	"bytes"
+	"io"
+)
+
+// EncodingProfile selects the byte order used for multi-byte values on the wire. JBitPack uses
+// network order (big-endian), but some embedded peers can only produce little-endian data. A
+// profile only affects the size fields of segments and the payloads of fixed-size numeric types,
+// such as integers, floats, and container counts. String, binary, and extension payloads are
+// passed through unchanged.
+type EncodingProfile int
+
+const (
+	// BigEndianProfile is the standard JBitPack byte order
+	BigEndianProfile EncodingProfile = iota
+
+	// LittleEndianProfile is for interoperating with little-endian firmware
+	LittleEndianProfile
+)
+
+func (p EncodingProfile) String() string {
+	switch p {
+	case BigEndianProfile:
+		return "big-endian"
+	case LittleEndianProfile:
+		return "little-endian"
+	}
+	return "unknown"
+}
+
+// toNetworkOrder converts a single value in the profile's byte order to network order in place.
+// Converting is just reversing the bytes, so this also converts from network order.
+func (p EncodingProfile) toNetworkOrder(value []byte) {
+	if p != LittleEndianProfile {
+		return
+	}
+	for i, j := 0, len(value)-1; i < j; i, j = i+1, j-1 {
+		value[i], value[j] = value[j], value[i]
+	}
+}
+
+// Encoder writes Segments to an io.Writer using a particular EncodingProfile. Writing with the
+// default profile is the same as calling Write() on the Segments themselves.
+type Encoder struct {
+	Profile EncodingProfile
+
+	// FieldOrder, if set, makes EncodeDocument() write the fields of keyed Documents in the
+	// schema's field order (see Schema.SetFieldOrder()), so that they can be read with
+	// Schema.DecodeOrdered()'s fast path. Other Documents are written as they are.
+	FieldOrder *Schema
+
+	// MaxValueSize, if not zero, makes Encode() return ErrSize for segments whose string, binary,
+	// or other variable-size value is larger than it
+	MaxValueSize uint64
+
+	// WideLargeContainers makes the Encoder write the item counts of LargeMap, LargeList, and
+	// KeyedMap segments as 64-bit values, as described in the JBitPack spec. This changes the wire
+	// format, so the data must be read by a Decoder with the same setting.
+	WideLargeContainers bool
+
+	w io.Writer
+}
+
+// NewEncoder creates a new Encoder which writes to the specified Writer
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes a Segment to the stream. The Segment itself isn't modified.
+func (e *Encoder) Encode(seg Segment) error {
+
+	if err := e.checkSize(seg); err != nil {
+		return err
+	}
+	if e.WideLargeContainers && hasWideIndex(seg.Type) &&
+		len(seg.Value) == int(fixedSegmentSize(seg.Type)) {
+		seg.Value = widenIndex(seg.Value)
+	}
+	if e.Profile == BigEndianProfile {
+		return seg.Write(e.w)
+	}
+
+	sizeSize := int(sizeSegmentSize(seg.Type))
+	out := seg.AppendTo(make([]byte, 0, 1+sizeSize+len(seg.Value)))
+	e.Profile.toNetworkOrder(out[1 : 1+sizeSize])
+	if fixedSegmentSize(seg.Type) > 1 {
+		e.Profile.toNetworkOrder(out[1:])
+	}
+
+	bytesWritten, err := e.w.Write(out)
+	if err != nil {
+		return err
+	}
+	if bytesWritten != len(out) {
+		return ErrIO
+	}
+	return nil
+}
+
+// checkSize returns ErrSize if the Segment's value is larger than MaxValueSize allows
+func (e *Encoder) checkSize(seg Segment) error {
+	if e.MaxValueSize > 0 && sizeSegmentSize(seg.Type) > 0 &&
+		uint64(len(seg.Value)) > e.MaxValueSize {
+		return ErrSize
+	}
+	return nil
+}
+
+// EncodeDocument writes a Document to the stream
+func (e *Encoder) EncodeDocument(doc *Document) error {
+
+	if e.FieldOrder != nil && len(doc.Items) > 0 && isMapType(doc.Items[0].GetType()) &&
+		doc.Items[0].GetType() != DFKeyedMapType {
+		if fields, err := doc.fieldMap(); err == nil {
+			items, err := orderedItems(fields, e.FieldOrder.orderedKeys(fields))
+			if err != nil {
+				return err
+			}
+			doc = &Document{Items: items}
+		}
+	}
+
+	if e.Profile == BigEndianProfile && !e.WideLargeContainers {
+		for _, item := range doc.Items {
+			if seg, ok := item.(*Segment); ok {
+				if err := e.checkSize(*seg); err != nil {
+					return err
+				}
+			}
+		}
+		return doc.Write(e.w)
+	}
+
+	if err := e.Encode(Segment{DFDocumentStart, []byte{1}}); err != nil {
+		return err
+	}
+
+	for _, item := range doc.Items {
+		if seg, ok := item.(*Segment); ok {
+			if err := e.Encode(*seg); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Other containers don't know about profiles, so their segments are written out and
+		// read back in to be converted
+		var buf bytes.Buffer
+		if err := item.Write(&buf); err != nil {
+			return err
+		}
+		d := NewDecoder(&buf)
+		for buf.Len() > 0 {
+			seg, err := d.Next()
+			if err != nil {
+				return err
+			}
+			if err := e.Encode(seg); err != nil {
+				return err
+			}
+		}
+	}
+
+	var docEnd Segment
+	if err := docEnd.SetDocEnd(uint64(len(doc.Items))); err != nil {
+		return err
+	}
+	return e.Encode(docEnd)
+}
@@ -0,0 +1,76 @@
+package oganesson
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestEncodingProfile(t *testing.T) {
+	doc := NewDocument()
+	doc.AttachInt32("testInt", 0x01020304)
+	doc.AttachString("testString", "abc")
+	var float Segment
+	float.SetFloat64(1.5)
+	doc.Items = append(doc.Items, &float)
+	doc.AttachUInt8("testByte", 9)
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.Profile = LittleEndianProfile
+	if err := e.EncodeDocument(doc); err != nil {
+		t.Fatalf("Error encoding little-endian document: %s", err.Error())
+	}
+
+	// DocumentStart is 2 bytes, followed by the Int32 segment and the String segment
+	le := buf.Bytes()
+	if !bytes.Equal(le[2:7], []byte{DFInt32Type, 4, 3, 2, 1}) {
+		t.Fatalf("Int32 wasn't encoded little-endian: %v", le[2:7])
+	}
+	if !bytes.Equal(le[7:13], []byte{DFStringType, 3, 0, 'a', 'b', 'c'}) {
+		t.Fatalf("String size wasn't encoded little-endian: %v", le[7:13])
+	}
+
+	d := NewDecoder(bytes.NewReader(le))
+	d.Profile = LittleEndianProfile
+	var decoded Document
+	if err := decoded.Decode(context.Background(), d); err != nil {
+		t.Fatalf("Error decoding little-endian document: %s", err.Error())
+	}
+
+	want, _ := doc.Flatten()
+	got, _ := decoded.Flatten()
+	if !bytes.Equal(want, got) {
+		t.Fatalf("Little-endian round trip mismatch")
+	}
+
+	// The default profile must match the normal encoding
+	buf.Reset()
+	if err := NewEncoder(&buf).EncodeDocument(doc); err != nil {
+		t.Fatalf("Error encoding big-endian document: %s", err.Error())
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("Big-endian profile doesn't match Flatten()")
+	}
+}
+
+func TestEncoderMaxValueSize(t *testing.T) {
+	doc := NewDocument()
+	doc.AttachString("small", "abc")
+	doc.AttachBinary("large", make([]byte, 5000))
+
+	for _, profile := range []EncodingProfile{BigEndianProfile, LittleEndianProfile} {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf)
+		e.Profile = profile
+		e.MaxValueSize = 4096
+		if err := e.EncodeDocument(doc); err != ErrSize {
+			t.Fatalf("Oversized value wasn't caught for %s: %v", profile, err)
+		}
+
+		e.MaxValueSize = 5000
+		if err := e.EncodeDocument(doc); err != nil {
+			t.Fatalf("Value at the limit was rejected for %s: %v", profile, err)
+		}
+	}
+}
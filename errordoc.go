@@ -0,0 +1,154 @@
+package oganesson
+
+import (
+	"errors"
+)
+
+var ErrNotErrorDocument = errors.New("not an error document")
+
+// Error documents are the standard reply for a request which failed. They are keyed Documents
+// with ErrorMsgCode as their message code and these fields:
+//
+//	ErrorCode     Int64   a numeric code, normally one of the ErrorCode constants
+//	Error         String  a message describing the problem
+//	ErrorDetails  Binary  an optional flattened Document with more information
+const ErrorMsgCode = "ERROR"
+const (
+	ErrorCodeField    = "ErrorCode"
+	ErrorField        = "Error"
+	ErrorDetailsField = "ErrorDetails"
+)
+
+// Standard error codes. They follow the HTTP status codes with the same meaning so that they are
+// easy to map to and from HTTP gateways. Applications may use other codes as well.
+const (
+	ErrorCodeBadRequest   = 400
+	ErrorCodeUnauthorized = 401
+	ErrorCodeNotFound     = 404
+	ErrorCodeRateLimited  = 429
+	ErrorCodeInternal     = 500
+)
+
+// ReplyError is an error which a Handler can return to control the error document sent back to
+// the client
+type ReplyError struct {
+	Code    int
+	Message string
+	Details *Document
+}
+
+func (e *ReplyError) Error() string {
+	return e.Message
+}
+
+// NewErrorDocument creates an error document. The details may be nil.
+func NewErrorDocument(code int, msg string, details *Document) (*Document, error) {
+
+	b := NewDocumentBuilder(nil)
+	if err := b.AddString(MsgCodeField, ErrorMsgCode); err != nil {
+		return nil, err
+	}
+	if err := b.AddInt64(ErrorCodeField, int64(code)); err != nil {
+		return nil, err
+	}
+	if err := b.AddString(ErrorField, msg); err != nil {
+		return nil, err
+	}
+	if details != nil {
+		flat, err := details.Flatten()
+		if err != nil {
+			return nil, err
+		}
+		if err := b.AddBinary(ErrorDetailsField, flat); err != nil {
+			return nil, err
+		}
+	}
+	return b.Build()
+}
+
+// errorDocumentFor creates the error document sent back by a Router when a Handler returns an
+// error
+func errorDocumentFor(err error) (*Document, error) {
+
+	var replyErr *ReplyError
+	if errors.As(err, &replyErr) {
+		return NewErrorDocument(replyErr.Code, replyErr.Message, replyErr.Details)
+	}
+
+	code := ErrorCodeInternal
+	switch {
+	case errors.Is(err, ErrUnknownMessage):
+		code = ErrorCodeNotFound
+	case errors.Is(err, ErrUnauthorized):
+		code = ErrorCodeUnauthorized
+	case errors.Is(err, ErrRateLimited):
+		code = ErrorCodeRateLimited
+	case errors.Is(err, ErrMissingField), errors.Is(err, ErrUnknownField),
+		errors.Is(err, ErrConstraint), errors.Is(err, ErrTypeError),
+		errors.Is(err, ErrInvalidContainer), errors.Is(err, ErrReservedKey):
+		code = ErrorCodeBadRequest
+	}
+	return NewErrorDocument(code, err.Error(), nil)
+}
+
+// IsError returns true if the Document is an error document
+func (doc *Document) IsError() bool {
+	code, err := messageCode(doc)
+	return err == nil && code == ErrorMsgCode
+}
+
+// ErrorCode returns the code of an error document
+func (doc *Document) ErrorCode() (int, error) {
+
+	fields, err := doc.errorFields()
+	if err != nil {
+		return 0, err
+	}
+	code, err := fields.GetInt64(ErrorCodeField)
+	return int(code), err
+}
+
+// ErrorMessage returns the message of an error document
+func (doc *Document) ErrorMessage() (string, error) {
+
+	fields, err := doc.errorFields()
+	if err != nil {
+		return "", err
+	}
+	return fields.GetString(ErrorField)
+}
+
+// ErrorDetails returns the details of an error document or nil if it doesn't have any
+func (doc *Document) ErrorDetails() (*Document, error) {
+
+	fields, err := doc.errorFields()
+	if err != nil {
+		return nil, err
+	}
+	if !fields.Has(ErrorDetailsField) {
+		return nil, nil
+	}
+
+	flat, err := fields.GetBinary(ErrorDetailsField)
+	if err != nil {
+		return nil, err
+	}
+	out := NewDocument()
+	if err := out.Unflatten(flat); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// errorFields returns the fields of an error document
+func (doc *Document) errorFields() (SegmentMap, error) {
+
+	fields, err := doc.fieldMap()
+	if err != nil {
+		return nil, err
+	}
+	if code, _ := fields.GetString(MsgCodeField); code != ErrorMsgCode {
+		return nil, ErrNotErrorDocument
+	}
+	return fields, nil
+}
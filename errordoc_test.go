@@ -0,0 +1,59 @@
+package oganesson
+
+import (
+	"context"
+	"testing"
+)
+
+func TestErrorDocument(t *testing.T) {
+	details := NewDocument()
+	details.AttachString("field", "user")
+
+	doc, err := NewErrorDocument(ErrorCodeBadRequest, "missing user", details)
+	if err != nil {
+		t.Fatalf("NewErrorDocument failed: %s", err.Error())
+	}
+	if !doc.IsError() {
+		t.Fatalf("Error document not recognized")
+	}
+	if code, err := doc.ErrorCode(); err != nil || code != ErrorCodeBadRequest {
+		t.Fatalf("Error code mismatch: %d", code)
+	}
+	if msg, err := doc.ErrorMessage(); err != nil || msg != "missing user" {
+		t.Fatalf("Error message mismatch: %s", msg)
+	}
+	gotDetails, err := doc.ErrorDetails()
+	if err != nil || gotDetails == nil || len(gotDetails.Items) != 1 {
+		t.Fatalf("Error details weren't preserved")
+	}
+
+	plain := testMessage(t, "HELLO")
+	if plain.IsError() {
+		t.Fatalf("Regular document recognized as an error document")
+	}
+	if _, err := plain.ErrorCode(); err != ErrNotErrorDocument {
+		t.Fatalf("ErrorCode didn't reject a regular document")
+	}
+}
+
+func TestRouterErrorCodes(t *testing.T) {
+	r := NewRouter()
+	r.Handle("CUSTOM", func(ctx context.Context, doc *Document) (*Document, error) {
+		return nil, &ReplyError{Code: 409, Message: "conflict"}
+	})
+	r.Handle("BROKEN", func(ctx context.Context, doc *Document) (*Document, error) {
+		return nil, ErrServerError
+	})
+
+	for code, want := range map[string]int{"CUSTOM": 409, "BROKEN": ErrorCodeInternal,
+		"BOGUS": ErrorCodeNotFound} {
+
+		reply, err := r.ServeDocument(context.Background(), testMessage(t, code))
+		if err != nil {
+			t.Fatalf("ServeDocument failed for %s: %s", code, err.Error())
+		}
+		if got, _ := reply.ErrorCode(); got != want {
+			t.Fatalf("Error code mismatch for %s: wanted %d, got %d", code, want, got)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package oganesson
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrExpired = errors.New("message expired")
+
+// This file contains support for message expiry. A sender gives a Document a time to live with
+// SetTTL(), which is stored in its header. Send() drops queued Documents which expire before
+// they are written, SendRequest() gives up on requests once they expire, and Routers don't pass
+// expired Documents to their Handlers.
+
+// SetTTL sets a keyed Document to expire the specified amount of time from now. A TTL of zero
+// or less removes the expiry time.
+func (doc *Document) SetTTL(ttl time.Duration) error {
+
+	h, err := doc.Header()
+	if err != nil {
+		return err
+	}
+	if ttl > 0 {
+		h.Expires = time.Now().Add(ttl)
+	} else {
+		h.Expires = time.Time{}
+	}
+	return doc.SetHeader(h)
+}
+
+// Expires returns the time at which a keyed Document expires or the zero time if it doesn't
+func (doc *Document) Expires() (time.Time, error) {
+	h, err := doc.Header()
+	return h.Expires, err
+}
+
+// IsExpired returns true if the Document has an expiry time which has passed
+func (doc *Document) IsExpired() bool {
+	expires := doc.expiry()
+	return !expires.IsZero() && time.Now().After(expires)
+}
+
+// expiry finds the expiry time of a keyed Document without decoding all of its fields, which
+// Header() does. It returns the zero time if the Document doesn't have one.
+func (doc *Document) expiry() time.Time {
+
+	if len(doc.Items) == 0 || doc.Items[0].GetType() != DFMapType &&
+		doc.Items[0].GetType() != DFLargeMapType {
+		return time.Time{}
+	}
+	for i := 1; i+1 < len(doc.Items); i += 2 {
+		key, ok := doc.Items[i].(*Segment)
+		if !ok || key.Type != DFStringType || string(key.Value) != HeaderExpiresField {
+			continue
+		}
+		value, ok := doc.Items[i+1].(*Segment)
+		if !ok {
+			break
+		}
+		ns, err := value.GetInt64()
+		if err != nil {
+			break
+		}
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
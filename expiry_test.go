@@ -0,0 +1,68 @@
+package oganesson
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDocumentTTL(t *testing.T) {
+	b := NewDocumentBuilder(nil)
+	b.AddString(MsgCodeField, "PING")
+	doc, _ := b.Build()
+	if doc.IsExpired() {
+		t.Fatalf("Document without a TTL is expired")
+	}
+
+	if err := doc.SetTTL(time.Hour); err != nil {
+		t.Fatalf("SetTTL failed: %s", err.Error())
+	}
+	expires, err := doc.Expires()
+	if err != nil || expires.Before(time.Now().Add(59*time.Minute)) {
+		t.Fatalf("Wrong expiry time %v", expires)
+	}
+	if !doc.expiry().Equal(expires) || doc.IsExpired() {
+		t.Fatalf("Expiry scan doesn't match the header")
+	}
+
+	doc.SetTTL(time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if !doc.IsExpired() {
+		t.Fatalf("Document didn't expire")
+	}
+
+	doc.SetTTL(0)
+	if expires, _ := doc.Expires(); !expires.IsZero() || doc.IsExpired() {
+		t.Fatalf("Expiry time wasn't removed")
+	}
+}
+
+func TestRouterExpired(t *testing.T) {
+	r := NewRouter()
+	handled := 0
+	r.Handle("PING", func(ctx context.Context, doc *Document) (*Document, error) {
+		handled++
+		return NewDocument(), nil
+	})
+
+	b := NewDocumentBuilder(nil)
+	b.AddString(MsgCodeField, "PING")
+	doc, _ := b.Build()
+	doc.SetTTL(time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	reply, err := r.ServeDocument(context.Background(), doc)
+	if err != nil || reply != nil || handled != 0 {
+		t.Fatalf("Expired document wasn't dropped")
+	}
+
+	expired := 0
+	r.OnExpired = func(ctx context.Context, doc *Document) (*Document, error) {
+		expired++
+		return nil, nil
+	}
+	r.ServeDocument(context.Background(), doc)
+	if expired != 1 || handled != 0 {
+		t.Fatalf("Expired document didn't go to OnExpired")
+	}
+}
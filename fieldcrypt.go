@@ -0,0 +1,184 @@
+package oganesson
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrDecryptFailed = errors.New("decryption failed")
+
+// This file contains field-level encryption for keyed Documents, for messages where only a few
+// fields, such as credentials, need to be protected. EncryptFields() replaces the value of each
+// field with a Binary segment holding the original segment sealed with AES-GCM and lists the
+// field in the header's Encrypted field, and DecryptFields() puts the original values back. The
+// field's name is used as additional data, so a sealed value can't be moved to another field.
+//
+// The Encrypted list is only a hint for the receiver and isn't authenticated. Someone who can
+// change the Document could replace a sealed value with plaintext and drop the field from the
+// list, so DecryptFields() has to be told which fields the receiver expects to be encrypted and
+// fails if any of them isn't. Binding the list to the sealed values instead would keep fields
+// from being encrypted and decrypted a few at a time, since each change to the list would break
+// the values sealed before it.
+//
+// A sealed value starts with a format version byte and the nonce, followed by the ciphertext:
+//
+//	[version (1)] [nonce (12)] [ciphertext and tag]
+
+// sealedFieldVersion is the format version of the sealed values made by EncryptFields()
+const sealedFieldVersion = uint8(1)
+
+// EncryptFields seals the values of the named fields of a keyed Document with the specified
+// AES-128, AES-192, or AES-256 key. Each field keeps its name, but its value becomes a Binary
+// segment. Fields which are already encrypted are left alone. The header fields can't be
+// encrypted, and nothing is changed if any of the fields can't be.
+func (doc *Document) EncryptFields(key []byte, names ...string) error {
+
+	if doc.frozen {
+		return ErrFrozen
+	}
+	aead, err := newFieldCipher(key)
+	if err != nil {
+		return err
+	}
+	fields, h, err := doc.fieldsAndHeader()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if IsReservedKey(name) {
+			return fmt.Errorf("%w '%s'", ErrReservedKey, name)
+		}
+		if strings.Contains(name, ",") {
+			return fmt.Errorf("%w: field name '%s' can't be encrypted", ErrInvalidKey, name)
+		}
+		if containsString(h.Encrypted, name) {
+			continue
+		}
+		seg, ok := fields[name]
+		if !ok {
+			return fmt.Errorf("%w: field '%s'", ErrNotFound, name)
+		}
+
+		sealed := make([]byte, 1+aead.NonceSize(), 1+aead.NonceSize()+int(seg.GetSize())+
+			aead.Overhead())
+		sealed[0] = sealedFieldVersion
+		if _, err := rand.Read(sealed[1:]); err != nil {
+			return err
+		}
+		sealed = aead.Seal(sealed, sealed[1:], seg.AppendTo(nil), []byte(name))
+		if err := fields.SetBinary(name, sealed); err != nil {
+			return err
+		}
+		h.Encrypted = append(h.Encrypted, name)
+	}
+	return doc.replaceFields(fields, h)
+}
+
+// DecryptFields restores the values of the named fields of a keyed Document which were sealed by
+// EncryptFields(). The names are those of the fields the receiver expects to be encrypted, not
+// ones taken from the header. ErrDecryptFailed is returned if no names are given, a field isn't
+// encrypted, the key is wrong, or the sealed value has been tampered with, in which case nothing
+// is changed.
+func (doc *Document) DecryptFields(key []byte, names ...string) error {
+
+	if doc.frozen {
+		return ErrFrozen
+	}
+	aead, err := newFieldCipher(key)
+	if err != nil {
+		return err
+	}
+	fields, h, err := doc.fieldsAndHeader()
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("%w: no fields were named", ErrDecryptFailed)
+	}
+
+	for _, name := range names {
+		if !containsString(h.Encrypted, name) {
+			return fmt.Errorf("%w: field '%s' isn't encrypted", ErrDecryptFailed, name)
+		}
+		sealed, err := fields.GetBinary(name)
+		if err != nil {
+			return err
+		}
+
+		headerSize := 1 + aead.NonceSize()
+		if len(sealed) < headerSize+aead.Overhead() || sealed[0] != sealedFieldVersion {
+			return fmt.Errorf("%w: field '%s' has a bad sealed value", ErrDecryptFailed, name)
+		}
+		plain, err := aead.Open(nil, sealed[1:headerSize], sealed[headerSize:], []byte(name))
+		if err != nil {
+			return fmt.Errorf("%w: field '%s'", ErrDecryptFailed, name)
+		}
+		seg, err := UnflattenSegment(plain)
+		if err != nil {
+			return fmt.Errorf("%w: field '%s': %s", ErrDecryptFailed, name, err.Error())
+		}
+		fields[name] = seg
+
+		for i, encrypted := range h.Encrypted {
+			if encrypted == name {
+				h.Encrypted = append(h.Encrypted[:i], h.Encrypted[i+1:]...)
+				break
+			}
+		}
+	}
+	return doc.replaceFields(fields, h)
+}
+
+// newFieldCipher creates the AEAD used to seal field values
+func newFieldCipher(key []byte) (cipher.AEAD, error) {
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// fieldsAndHeader returns the field map and header of a keyed Document
+func (doc *Document) fieldsAndHeader() (SegmentMap, DocumentHeader, error) {
+
+	var h DocumentHeader
+	fields, err := doc.fieldMap()
+	if err != nil {
+		return nil, h, err
+	}
+	if err := h.readFields(fields); err != nil {
+		return nil, h, err
+	}
+	return fields, h, nil
+}
+
+// replaceFields rebuilds the items of a keyed Document from its field map and header
+func (doc *Document) replaceFields(fields SegmentMap, h DocumentHeader) error {
+
+	if err := h.writeFields(fields); err != nil {
+		return err
+	}
+	items, err := keyedItems(fields)
+	if err != nil {
+		return err
+	}
+	doc.Items = items
+	doc.updateWireMsgFields()
+	return nil
+}
+
+// containsString returns true if the list holds the specified string
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package oganesson
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestEncryptFields(t *testing.T) {
+	b := NewDocumentBuilder(nil)
+	b.AddString(MsgCodeField, "LOGIN")
+	b.AddString("user", "alice")
+	b.AddString("password", "hunter2")
+	b.AddInt32("pin", 1234)
+	doc, _ := b.Build()
+	key := bytes.Repeat([]byte{7}, 32)
+
+	if err := doc.EncryptFields(key, "password", "pin"); err != nil {
+		t.Fatalf("EncryptFields failed: %s", err.Error())
+	}
+	fields, _ := doc.fieldMap()
+	if fields["password"].Type != DFBinaryType || bytes.Contains(fields["password"].Value,
+		[]byte("hunter2")) {
+		t.Fatalf("Field wasn't sealed")
+	}
+	if user, _ := fields.GetString("user"); user != "alice" {
+		t.Fatalf("Other field was changed")
+	}
+	if h, _ := doc.Header(); len(h.Encrypted) != 2 {
+		t.Fatalf("Encrypted fields weren't listed in the header: %v", h.Encrypted)
+	}
+
+	// The sealed values survive a round trip, and the wrong key changes nothing
+	packet, _ := doc.Flatten()
+	received := NewDocument()
+	if err := received.Unflatten(packet); err != nil {
+		t.Fatalf("Error unflattening: %s", err.Error())
+	}
+	wrongKey := bytes.Repeat([]byte{8}, 32)
+	if err := received.DecryptFields(wrongKey, "password", "pin"); !errors.Is(err,
+		ErrDecryptFailed) {
+		t.Fatalf("Wrong key returned %v", err)
+	}
+	if err := received.DecryptFields(key); !errors.Is(err, ErrDecryptFailed) {
+		t.Fatalf("DecryptFields without names returned %v", err)
+	}
+	if err := received.DecryptFields(key, "password", "pin"); err != nil {
+		t.Fatalf("DecryptFields failed: %s", err.Error())
+	}
+	fields, _ = received.fieldMap()
+	password, _ := fields.GetString("password")
+	pin, _ := fields.GetInt32("pin")
+	if password != "hunter2" || pin != 1234 {
+		t.Fatalf("Fields weren't restored: %s %d", password, pin)
+	}
+	if h, _ := received.Header(); len(h.Encrypted) != 0 {
+		t.Fatalf("Encrypted list wasn't cleared")
+	}
+
+	// A sealed value moved to another field doesn't open
+	doc.EncryptFields(key, "user")
+	fields, _ = doc.fieldMap()
+	fields["user"] = fields["password"]
+	doc.replaceFields(fields, DocumentHeader{Encrypted: []string{"user"}})
+	if err := doc.DecryptFields(key, "user"); !errors.Is(err, ErrDecryptFailed) {
+		t.Fatalf("Value moved between fields was decrypted")
+	}
+
+	// Nor does plaintext put in place of a sealed value and dropped from the Encrypted list
+	received.Unflatten(packet)
+	fields, _ = received.fieldMap()
+	fields.SetString("password", "attacker")
+	received.replaceFields(fields, DocumentHeader{Encrypted: []string{"pin"}})
+	if err := received.DecryptFields(key, "password", "pin"); !errors.Is(err, ErrDecryptFailed) {
+		t.Fatalf("Plaintext dropped from the Encrypted list was accepted")
+	}
+
+	if err := doc.EncryptFields(key, HeaderMessageIDField); !errors.Is(err, ErrReservedKey) {
+		t.Fatalf("Header field was encrypted")
+	}
+	if err := doc.EncryptFields(key, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Missing field returned %v", err)
+	}
+	if err := doc.EncryptFields([]byte{1, 2, 3}, "user"); err == nil {
+		t.Fatalf("Bad key size was accepted")
+	}
+}
@@ -0,0 +1,170 @@
+package oganesson
+
+import (
+	"fmt"
+	"sort"
+)
+
+// This file contains field ordering hints. A Schema can declare the order its fields are
+// expected to appear in, which DocumentBuilder and an Encoder with FieldOrder set will then
+// write them in. Schema.DecodeOrdered() reads a map written this way into a slice indexed by
+// position, comparing each key with the one expected next instead of hashing it. Maps in any
+// other order are still decoded correctly, just without the fast path for the keys which are out
+// of place.
+
+// SetFieldOrder declares the order in which the schema's fields are expected to appear. Fields
+// which aren't listed come after the listed ones in key order. ErrUnknownField is returned for
+// names which aren't in the schema and ErrInvalidKey for names which are listed more than once.
+func (s *Schema) SetFieldOrder(names ...string) error {
+
+	order := make([]string, 0, len(s.fields))
+	position := make(map[string]int, len(s.fields))
+	for _, name := range names {
+		if _, ok := s.fields[name]; !ok {
+			return fmt.Errorf("%w '%s'", ErrUnknownField, name)
+		}
+		if _, exists := position[name]; exists {
+			return fmt.Errorf("%w '%s'", ErrInvalidKey, name)
+		}
+		position[name] = len(order)
+		order = append(order, name)
+	}
+
+	rest := make([]string, 0, len(s.fields)-len(order))
+	for name := range s.fields {
+		if _, listed := position[name]; !listed {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+	for _, name := range rest {
+		position[name] = len(order)
+		order = append(order, name)
+	}
+
+	specs := make([]FieldSpec, len(order))
+	for i, name := range order {
+		specs[i] = s.fields[name]
+	}
+	s.order = order
+	s.specs = specs
+	s.position = position
+	return nil
+}
+
+// FieldOrder returns the names of all of the schema's fields in the order set by
+// SetFieldOrder(), or nil if no order has been set
+func (s *Schema) FieldOrder() []string {
+	if s.order == nil {
+		return nil
+	}
+	return append([]string(nil), s.order...)
+}
+
+// orderedKeys returns the keys of a SegmentMap in the schema's field order. Keys which aren't in
+// the schema, such as header fields, come last in key order. If the schema has no field order,
+// all of the keys are in key order.
+func (s *Schema) orderedKeys(fields SegmentMap) []string {
+
+	if s == nil || s.order == nil {
+		return fields.Keys()
+	}
+
+	out := make([]string, 0, len(fields))
+	for _, name := range s.order {
+		if _, ok := fields[name]; ok {
+			out = append(out, name)
+		}
+	}
+	rest := make([]string, 0, len(fields)-len(out))
+	for name := range fields {
+		if _, ok := s.position[name]; !ok {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+	return append(out, rest...)
+}
+
+// DecodeOrdered reads a map from a Decoder and checks it against the schema, returning the
+// values of its fields in the order given by FieldOrder(). Fields which aren't present have the
+// type DFUnknownType. Fields with names starting with ReservedKeyPrefix are skipped, and any
+// other field which isn't in the schema causes ErrUnknownField to be returned.
+//
+// SetFieldOrder() must have been called first. For maps written in the schema's order, each key
+// is only compared with the one expected next, so no keys are hashed or converted to
+// strings.
+func (s *Schema) DecodeOrdered(d *Decoder) ([]Segment, error) {
+
+	if s.order == nil {
+		return nil, ErrUnknownField
+	}
+
+	charged := d.charged
+	out, err := s.decodeOrdered(d)
+	if err != nil {
+		d.Limits.Budget.Release(d.charged - charged)
+		return nil, err
+	}
+	return out, nil
+}
+
+// decodeOrdered does the work for DecodeOrdered()
+func (s *Schema) decodeOrdered(d *Decoder) ([]Segment, error) {
+
+	countSegment, err := d.Next()
+	if err != nil {
+		return nil, err
+	}
+	pairCount, err := countSegment.GetMapIndex()
+	if err != nil {
+		return nil, err
+	}
+	if d.Limits.MaxSegmentCount > 0 && pairCount > d.Limits.MaxSegmentCount/2 {
+		return nil, ErrLimitExceeded
+	}
+
+	out := make([]Segment, len(s.order))
+	next := 0
+	for i := uint64(0); i < pairCount; i++ {
+		keySegment, err := d.Next()
+		if err != nil {
+			return nil, err
+		}
+		if keySegment.Type != DFStringType {
+			return nil, ErrInvalidKey
+		}
+		valueSegment, err := d.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		// The fast path: the key is the one expected next
+		pos := next
+		if pos >= len(s.order) || string(keySegment.Value) != s.order[pos] {
+			var ok bool
+			if pos, ok = s.position[string(keySegment.Value)]; !ok {
+				if IsReservedKey(string(keySegment.Value)) {
+					continue
+				}
+				return nil, fmt.Errorf("%w '%s'", ErrUnknownField, string(keySegment.Value))
+			}
+		}
+		next = pos + 1
+
+		if out[pos].Type != DFUnknownType {
+			return nil, ErrInvalidKey
+		}
+		if err := s.specs[pos].validate(s.order[pos], valueSegment); err != nil {
+			return nil, err
+		}
+		out[pos] = valueSegment
+	}
+
+	for i, spec := range s.specs {
+		if out[i].Type == DFUnknownType && spec.Required {
+			return nil, fmt.Errorf("%w: %s", ErrMissingField, spec.Name)
+		}
+	}
+	return out, nil
+}
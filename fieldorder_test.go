@@ -0,0 +1,136 @@
+package oganesson
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// orderedSchema returns a Schema with a field order for field ordering tests
+func orderedSchema(t *testing.T) *Schema {
+	schema := NewSchema(FieldSpec{Name: "id", Type: DFUInt32Type, Required: true},
+		FieldSpec{Name: "name", Type: DFStringType},
+		FieldSpec{Name: "email", Type: DFStringType},
+		FieldSpec{Name: "age", Type: DFInt8Type})
+	if err := schema.SetFieldOrder("id", "name", "email"); err != nil {
+		t.Fatalf("Error setting field order: %s", err.Error())
+	}
+	return schema
+}
+
+func TestSetFieldOrder(t *testing.T) {
+	schema := orderedSchema(t)
+	order := schema.FieldOrder()
+	if len(order) != 4 || order[0] != "id" || order[2] != "email" || order[3] != "age" {
+		t.Fatalf("Field order mismatch: %v", order)
+	}
+
+	if err := schema.SetFieldOrder("id", "missing"); !errors.Is(err, ErrUnknownField) {
+		t.Fatalf("Unknown field in order wasn't caught: %v", err)
+	}
+	if err := schema.SetFieldOrder("id", "id"); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("Repeated field in order wasn't caught: %v", err)
+	}
+	if NewSchema().FieldOrder() != nil {
+		t.Fatalf("Schema without an order returned one")
+	}
+}
+
+func TestDecodeOrdered(t *testing.T) {
+	schema := orderedSchema(t)
+
+	builder := NewDocumentBuilder(schema)
+	builder.AddUInt32("id", 7)
+	builder.AddString("email", "alice@example.com")
+	builder.AddInt8("age", 30)
+	builder.SetHeader(DocumentHeader{MessageID: "m1"})
+	doc, err := builder.Build()
+	if err != nil {
+		t.Fatalf("Error building document: %s", err.Error())
+	}
+
+	// The builder writes the fields in the schema's order, followed by the header
+	var buf bytes.Buffer
+	if err := doc.Write(&buf); err != nil {
+		t.Fatalf("Error writing document: %s", err.Error())
+	}
+	data := buf.Bytes()
+	if bytes.Index(data, []byte("id")) > bytes.Index(data, []byte("email")) ||
+		bytes.Index(data, []byte("email")) > bytes.Index(data, []byte("age")) {
+		t.Fatalf("Builder didn't follow the field order")
+	}
+
+	d := NewDecoder(bytes.NewReader(data))
+	if _, err := d.Next(); err != nil {
+		t.Fatalf("Error reading document start: %s", err.Error())
+	}
+	values, err := schema.DecodeOrdered(d)
+	if err != nil {
+		t.Fatalf("Error decoding ordered fields: %s", err.Error())
+	}
+	if id, _ := values[0].GetUInt32(); id != 7 || values[1].Type != DFUnknownType {
+		t.Fatalf("Ordered field mismatch: %v", values)
+	}
+	if email, _ := values[2].GetString(); email != "alice@example.com" {
+		t.Fatalf("Ordered field mismatch for email: %s", email)
+	}
+
+	// Fields out of order are still decoded, and missing required fields are caught
+	fields := make(SegmentMap)
+	fields.SetInt8("age", 30)
+	fields.SetString("name", "Alice")
+	buf.Reset()
+	fields.Write(&buf)
+	if _, err := schema.DecodeOrdered(NewDecoder(&buf)); !errors.Is(err, ErrMissingField) {
+		t.Fatalf("Missing required field wasn't caught: %v", err)
+	}
+	fields.SetUInt32("id", 9)
+	buf.Reset()
+	fields.Write(&buf)
+	values, err = schema.DecodeOrdered(NewDecoder(&buf))
+	if err != nil {
+		t.Fatalf("Error decoding fields out of order: %s", err.Error())
+	}
+	if name, _ := values[1].GetString(); name != "Alice" {
+		t.Fatalf("Out-of-order field mismatch: %s", name)
+	}
+
+	fields.SetString("extra", "x")
+	buf.Reset()
+	fields.Write(&buf)
+	if _, err := schema.DecodeOrdered(NewDecoder(&buf)); !errors.Is(err, ErrUnknownField) {
+		t.Fatalf("Unknown field wasn't caught: %v", err)
+	}
+}
+
+func TestEncoderFieldOrder(t *testing.T) {
+	schema := orderedSchema(t)
+
+	fields := make(SegmentMap)
+	fields.SetUInt32("id", 7)
+	fields.SetString("name", "Alice")
+	fields.SetInt8("age", 30)
+	items, err := keyedItems(fields)
+	if err != nil {
+		t.Fatalf("Error building keyed document: %s", err.Error())
+	}
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.FieldOrder = schema
+	if err := e.EncodeDocument(&Document{Items: items}); err != nil {
+		t.Fatalf("Error encoding document: %s", err.Error())
+	}
+	data := buf.Bytes()
+	if bytes.Index(data, []byte("age")) < bytes.Index(data, []byte("name")) {
+		t.Fatalf("Encoder didn't follow the field order")
+	}
+
+	doc := NewDocument()
+	if err := doc.Unflatten(data); err != nil {
+		t.Fatalf("Error unflattening ordered document: %s", err.Error())
+	}
+	if v, err := doc.Query("name"); err != nil || v != "Alice" {
+		t.Fatalf("Ordered document mismatch: %v, %v", v, err)
+	}
+}
@@ -0,0 +1,230 @@
+package oganesson
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// FileMessageStore is a MessageStore which keeps its packets in a file so that packets which
+// haven't been acknowledged survive restarts of the process. The file is a log of Documents
+// written with DocumentStreamWriter: each Put() adds a Document holding the message ID and the
+// packet, and each MarkAcked() adds one holding just the ID. The packets are also kept in memory.
+// When the log holds more acknowledged packets than pending ones, it is rewritten to hold just
+// the pending ones.
+type FileMessageStore struct {
+	lock    sync.Mutex
+	path    string
+	file    *os.File
+	pending *MemoryMessageStore
+	acked   int
+}
+
+// fileStoreCompactSize is the number of acknowledged packets a FileMessageStore's log has to
+// hold before it is rewritten
+const fileStoreCompactSize = 1024
+
+// OpenFileMessageStore opens the FileMessageStore at the specified path, creating it if it doesn't
+// exist. Packets left from an earlier run are loaded, and the log is rewritten without the
+// acknowledged ones. A Document cut off at the end of the log, such as by a crash partway through
+// a write, is ignored, as are corrupt Documents.
+func OpenFileMessageStore(path string) (*FileMessageStore, error) {
+
+	out := &FileMessageStore{path: path, pending: NewMemoryMessageStore()}
+	if err := out.load(); err != nil {
+		return nil, err
+	}
+	if err := out.compact(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// load reads the packets which haven't been acknowledged from the log
+func (f *FileMessageStore) load() error {
+
+	file, err := os.Open(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	sr := NewDocumentStreamReader(file)
+	for {
+		doc, err := sr.Read()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			var pathErr *os.PathError
+			if errors.As(err, &pathErr) {
+				return err
+			}
+			continue
+		}
+
+		id, packet, ok := parseStoreRecord(doc)
+		switch {
+		case !ok:
+			continue
+		case packet != nil:
+			f.pending.Put(id, packet)
+		default:
+			f.pending.MarkAcked(id)
+		}
+	}
+}
+
+// parseStoreRecord reads a Document from the log. The packet is nil for acknowledgements.
+func parseStoreRecord(doc *Document) (string, []byte, bool) {
+
+	if len(doc.Items) < 1 || len(doc.Items) > 2 {
+		return "", nil, false
+	}
+	idSeg, ok := doc.Items[0].(*Segment)
+	if !ok {
+		return "", nil, false
+	}
+	id, err := idSeg.GetString()
+	if err != nil || id == "" {
+		return "", nil, false
+	}
+	if len(doc.Items) == 1 {
+		return id, nil, true
+	}
+
+	packetSeg, ok := doc.Items[1].(*Segment)
+	if !ok {
+		return "", nil, false
+	}
+	packet, err := packetSeg.GetBinary()
+	if err != nil {
+		return "", nil, false
+	}
+	if packet == nil {
+		packet = []byte{}
+	}
+	return id, packet, true
+}
+
+// Put saves a packet, making sure that it has reached the disk before returning
+func (f *FileMessageStore) Put(id string, packet []byte) error {
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.file == nil {
+		return os.ErrClosed
+	}
+
+	doc := NewDocument()
+	doc.AttachString("id", id)
+	doc.AttachBinary("packet", packet)
+	if err := f.append(doc); err != nil {
+		return err
+	}
+	if err := f.file.Sync(); err != nil {
+		return err
+	}
+	return f.pending.Put(id, packet)
+}
+
+// MarkAcked drops the packet with the specified ID. The acknowledgement isn't synced to the disk
+// right away, since losing it only means that the packet is sent again.
+func (f *FileMessageStore) MarkAcked(id string) error {
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.file == nil {
+		return os.ErrClosed
+	}
+	if !f.pending.remove(id) {
+		return nil
+	}
+
+	doc := NewDocument()
+	doc.AttachString("id", id)
+	if err := f.append(doc); err != nil {
+		return err
+	}
+	f.acked++
+	if f.acked >= fileStoreCompactSize && f.acked > f.pending.Len() {
+		return f.compact()
+	}
+	return nil
+}
+
+// PendingIter calls fn for each packet waiting for acknowledgement, oldest first
+func (f *FileMessageStore) PendingIter(fn func(id string, packet []byte) error) error {
+	return f.pending.PendingIter(fn)
+}
+
+// Len returns the number of packets waiting for acknowledgement
+func (f *FileMessageStore) Len() int {
+	return f.pending.Len()
+}
+
+// Close closes the log file. The store can't be used afterward.
+func (f *FileMessageStore) Close() error {
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// append writes a Document to the end of the log. The caller must hold the lock.
+func (f *FileMessageStore) append(doc *Document) error {
+	return NewDocumentStreamWriter(f.file).Write(doc)
+}
+
+// compact rewrites the log so that it holds just the pending packets. The new log is written to a
+// temporary file which then replaces the old one, so a crash partway through leaves the old log
+// in place. The caller must hold the lock.
+func (f *FileMessageStore) compact() error {
+
+	tempPath := f.path + ".tmp"
+	temp, err := os.Create(tempPath)
+	if err != nil {
+		return err
+	}
+	sw := NewDocumentStreamWriter(temp)
+	err = f.pending.PendingIter(func(id string, packet []byte) error {
+		doc := NewDocument()
+		doc.AttachString("id", id)
+		doc.AttachBinary("packet", packet)
+		return sw.Write(doc)
+	})
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	// Windows can't replace a file which is open
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+	if err := os.Rename(tempPath, f.path); err != nil {
+		return err
+	}
+	f.file, err = os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	f.acked = 0
+	return nil
+}
@@ -0,0 +1,60 @@
+package oganesson
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileMessageStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.log")
+	f, err := OpenFileMessageStore(path)
+	if err != nil {
+		t.Fatalf("Error creating store: %s", err.Error())
+	}
+	f.Put("a", []byte("first"))
+	f.Put("b", []byte("second"))
+	f.Put("c", []byte{})
+	f.MarkAcked("a")
+	f.Close()
+	if err := f.Put("d", nil); err == nil {
+		t.Fatalf("Put succeeded on a closed store")
+	}
+
+	// A record cut off by a crash is ignored
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	doc := NewDocument()
+	doc.AttachString("id", "d")
+	doc.AttachBinary("packet", []byte("lost"))
+	packet, _ := doc.Flatten()
+	file.Write(packet[:len(packet)-3])
+	file.Close()
+
+	f, err = OpenFileMessageStore(path)
+	if err != nil {
+		t.Fatalf("Error reopening store: %s", err.Error())
+	}
+	defer f.Close()
+	ids := pendingIDs(t, f)
+	if len(ids) != 2 || ids[0] != "b" || ids[1] != "c" {
+		t.Fatalf("Wrong packets after reopening: %v", ids)
+	}
+	f.PendingIter(func(id string, packet []byte) error {
+		if id == "b" && string(packet) != "second" {
+			t.Fatalf("Packet changed after reopening")
+		}
+		return nil
+	})
+
+	// The log is rewritten once enough packets have been acknowledged
+	for i := 0; i < fileStoreCompactSize; i++ {
+		id := fmt.Sprintf("x%d", i)
+		f.Put(id, []byte(id))
+		f.MarkAcked(id)
+	}
+	info, _ := os.Stat(path)
+	if f.Len() != 2 || info.Size() > 1024 {
+		t.Fatalf("Log wasn't compacted: %d bytes", info.Size())
+	}
+}
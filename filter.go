@@ -0,0 +1,383 @@
+package oganesson
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var ErrInvalidFilter = errors.New("invalid filter expression")
+
+// This file contains Filter, a small expression format for picking out Documents by the values
+// of their fields. A filter is made of comparisons between a field and a literal, which can be
+// combined with AND, OR, and parentheses. AND binds more tightly than OR. For example:
+//
+//	priority >= 3 AND (region == "eu" OR region == "uk")
+//
+// Fields are named by the same paths used by Document.Query(). Literals are numbers, strings in
+// double quotes, true, or false. The operators are ==, !=, <, <=, >, and >=; bools can only be
+// compared for equality. Numbers of any type can be compared with each other. A comparison with a
+// field which is missing or has a different type of value is false.
+
+// Filter is a parsed filter expression. It is safe for concurrent use.
+type Filter struct {
+	expr string
+	root filterNode
+}
+
+// filterNode is a node of a parsed filter expression
+type filterNode interface {
+	match(doc *Document) bool
+}
+
+// ParseFilter parses a filter expression
+func ParseFilter(expr string) (*Filter, error) {
+
+	p := filterParser{tokens: make([]string, 0)}
+	if err := p.tokenize(expr); err != nil {
+		return nil, err
+	}
+	if len(p.tokens) == 0 {
+		return nil, ErrInvalidFilter
+	}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, ErrInvalidFilter
+	}
+	return &Filter{expr: expr, root: root}, nil
+}
+
+// Match returns true if the Document matches the filter
+func (f *Filter) Match(doc *Document) bool {
+	return f.root.match(doc)
+}
+
+// String returns the expression the filter was parsed from
+func (f *Filter) String() string {
+	return f.expr
+}
+
+// filterAnd matches if all of its terms do
+type filterAnd []filterNode
+
+func (n filterAnd) match(doc *Document) bool {
+	for _, term := range n {
+		if !term.match(doc) {
+			return false
+		}
+	}
+	return true
+}
+
+// filterOr matches if any of its terms do
+type filterOr []filterNode
+
+func (n filterOr) match(doc *Document) bool {
+	for _, term := range n {
+		if term.match(doc) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterComparison compares the value at a path with a literal
+type filterComparison struct {
+	path  string
+	op    string
+	value interface{}
+}
+
+func (n filterComparison) match(doc *Document) bool {
+
+	value, err := doc.Query(n.path)
+	if err != nil {
+		return false
+	}
+
+	var order int
+	switch literal := n.value.(type) {
+	case bool:
+		v, ok := value.(bool)
+		if !ok {
+			return false
+		}
+		return (n.op == "==") == (v == literal)
+	case string:
+		v, ok := value.(string)
+		if !ok {
+			return false
+		}
+		order = strings.Compare(v, literal)
+	default:
+		var ok bool
+		if order, ok = compareNumbers(value, n.value); !ok {
+			return false
+		}
+	}
+
+	switch n.op {
+	case "==":
+		return order == 0
+	case "!=":
+		return order != 0
+	case "<":
+		return order < 0
+	case "<=":
+		return order <= 0
+	case ">":
+		return order > 0
+	}
+	return order >= 0
+}
+
+// compareNumbers compares two numbers of any of the integer or float types, returning -1, 0, or
+// 1. It returns false if either of them isn't a number.
+func compareNumbers(a interface{}, b interface{}) (int, bool) {
+
+	ai, aSigned, aFloat, ok := numberParts(a)
+	if !ok {
+		return 0, false
+	}
+	bi, bSigned, bFloat, ok := numberParts(b)
+	if !ok {
+		return 0, false
+	}
+
+	// Integers are compared exactly unless one of them is too large for an int64
+	if aFloat == nil && bFloat == nil && aSigned && bSigned {
+		switch {
+		case ai < bi:
+			return -1, true
+		case ai > bi:
+			return 1, true
+		}
+		return 0, true
+	}
+
+	af, bf := numberFloat(ai, aSigned, aFloat), numberFloat(bi, bSigned, bFloat)
+	switch {
+	case af < bf:
+		return -1, true
+	case af > bf:
+		return 1, true
+	}
+	return 0, true
+}
+
+// numberParts splits a number into an int64 or a float64. signed is false for uint64 values
+// too large for an int64, in which case the bits are returned in the int64.
+func numberParts(v interface{}) (out int64, signed bool, f *float64, ok bool) {
+
+	switch n := v.(type) {
+	case int8:
+		return int64(n), true, nil, true
+	case int16:
+		return int64(n), true, nil, true
+	case int32:
+		return int64(n), true, nil, true
+	case int64:
+		return n, true, nil, true
+	case uint8:
+		return int64(n), true, nil, true
+	case uint16:
+		return int64(n), true, nil, true
+	case uint32:
+		return int64(n), true, nil, true
+	case uint64:
+		return int64(n), int64(n) >= 0, nil, true
+	case float32:
+		value := float64(n)
+		return 0, false, &value, true
+	case float64:
+		return 0, false, &n, true
+	}
+	return 0, false, nil, false
+}
+
+// numberFloat converts the parts returned by numberParts() to a float64
+func numberFloat(i int64, signed bool, f *float64) float64 {
+	switch {
+	case f != nil:
+		return *f
+	case signed:
+		return float64(i)
+	}
+	return float64(uint64(i))
+}
+
+// filterParser parses a filter expression by recursive descent
+type filterParser struct {
+	tokens []string
+	pos    int
+}
+
+// tokenize splits an expression into tokens. Strings keep their quotes so that they can be told
+// apart from paths.
+func (p *filterParser) tokenize(expr string) error {
+
+	rest := expr
+	for {
+		rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+		if rest == "" {
+			return nil
+		}
+
+		var size int
+		switch {
+		case rest[0] == '(' || rest[0] == ')':
+			size = 1
+		case rest[0] == '"':
+			end := 1
+			for end < len(rest) && rest[end] != '"' {
+				if rest[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(rest) {
+				return ErrInvalidFilter
+			}
+			size = end + 1
+		case strings.ContainsRune("=!<>", rune(rest[0])):
+			size = 1
+			if len(rest) > 1 && rest[1] == '=' {
+				size = 2
+			}
+		default:
+			size = strings.IndexFunc(rest, func(r rune) bool {
+				return unicode.IsSpace(r) || strings.ContainsRune("()\"=!<>", r)
+			})
+			if size < 0 {
+				size = len(rest)
+			}
+		}
+		p.tokens = append(p.tokens, rest[:size])
+		rest = rest[size:]
+	}
+}
+
+// next returns the next token without consuming it, or an empty string at the end
+func (p *filterParser) next() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+// parseOr parses terms joined by OR
+func (p *filterParser) parseOr() (filterNode, error) {
+
+	out := make(filterOr, 0, 1)
+	for {
+		term, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, term)
+		if p.next() != "OR" {
+			break
+		}
+		p.pos++
+	}
+	if len(out) == 1 {
+		return out[0], nil
+	}
+	return out, nil
+}
+
+// parseAnd parses terms joined by AND
+func (p *filterParser) parseAnd() (filterNode, error) {
+
+	out := make(filterAnd, 0, 1)
+	for {
+		term, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, term)
+		if p.next() != "AND" {
+			break
+		}
+		p.pos++
+	}
+	if len(out) == 1 {
+		return out[0], nil
+	}
+	return out, nil
+}
+
+// parseTerm parses a comparison or a parenthesized expression
+func (p *filterParser) parseTerm() (filterNode, error) {
+
+	if p.next() == "(" {
+		p.pos++
+		out, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, ErrInvalidFilter
+		}
+		p.pos++
+		return out, nil
+	}
+
+	if p.pos+3 > len(p.tokens) {
+		return nil, ErrInvalidFilter
+	}
+	path, op, literal := p.tokens[p.pos], p.tokens[p.pos+1], p.tokens[p.pos+2]
+	p.pos += 3
+
+	if _, err := parseQueryPath(path); err != nil {
+		return nil, ErrInvalidFilter
+	}
+	value, err := parseFilterLiteral(literal)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op {
+	case "==", "!=":
+	case "<", "<=", ">", ">=":
+		if _, isBool := value.(bool); isBool {
+			return nil, ErrInvalidFilter
+		}
+	default:
+		return nil, ErrInvalidFilter
+	}
+	return filterComparison{path: path, op: op, value: value}, nil
+}
+
+// parseFilterLiteral parses a literal into a bool, string, int64, uint64, or float64
+func parseFilterLiteral(literal string) (interface{}, error) {
+
+	switch {
+	case literal == "true":
+		return true, nil
+	case literal == "false":
+		return false, nil
+	case literal[0] == '"':
+		out, err := strconv.Unquote(literal)
+		if err != nil {
+			return nil, ErrInvalidFilter
+		}
+		return out, nil
+	}
+
+	if v, err := strconv.ParseInt(literal, 10, 64); err == nil {
+		return v, nil
+	}
+	if v, err := strconv.ParseUint(literal, 10, 64); err == nil {
+		return v, nil
+	}
+	if v, err := strconv.ParseFloat(literal, 64); err == nil {
+		return v, nil
+	}
+	return nil, ErrInvalidFilter
+}
@@ -0,0 +1,53 @@
+package oganesson
+
+import (
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	fields := make(SegmentMap)
+	fields.SetString("region", "eu")
+	fields.SetInt64("priority", 4)
+	fields.SetUInt8("level", 2)
+	fields.SetFloat64("load", 0.5)
+	fields.SetBool("urgent", true)
+	items, err := keyedItems(fields)
+	if err != nil {
+		t.Fatalf("Error building keyed document: %s", err.Error())
+	}
+	doc := &Document{Items: items}
+
+	tests := map[string]bool{
+		`region == "eu"`:                  true,
+		`region != "eu"`:                  false,
+		`region < "fr"`:                   true,
+		`priority >= 4`:                   true,
+		`priority > 4`:                    false,
+		`level == 2`:                      true,
+		`load < 1`:                        true,
+		`priority > 3.5`:                  true,
+		`urgent == true`:                  true,
+		`urgent != true`:                  false,
+		`missing == 1`:                    false,
+		`region == 1`:                     false,
+		`priority > 10 OR region == "eu"`: true,
+		`priority > 10 OR region == "eu" AND level > 5`:    false,
+		`(priority > 10 OR region == "eu") AND level == 2`: true,
+	}
+	for expr, want := range tests {
+		filter, err := ParseFilter(expr)
+		if err != nil {
+			t.Fatalf("ParseFilter failed for %q: %s", expr, err.Error())
+		}
+		if filter.Match(doc) != want {
+			t.Fatalf("Filter %q didn't return %v", filter.String(), want)
+		}
+	}
+
+	for _, expr := range []string{"", "region", `region == `, `region = "eu"`, `urgent < true`,
+		`(region == "eu"`, `region == "eu" AND`, `region == "eu`, `.x == 1`, `a == b`} {
+		if _, err := ParseFilter(expr); err != ErrInvalidFilter {
+			t.Fatalf("ParseFilter accepted %q", expr)
+		}
+	}
+}
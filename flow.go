@@ -0,0 +1,205 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// DefaultFlowWindow is the number of bytes a session lets the other side send before it must wait
+// for more credit when FlowControl is on and FlowWindow isn't set
+var DefaultFlowWindow = uint32(1 << 20)
+
+// This file contains the credit-based flow control used when PacketSession.FlowControl is set.
+// Each side grants the other a window of credit during setup, and every byte of frame payload
+// sent uses up some of it. The receiver grants more credit as it reads, once half of its window
+// has been used, so a sender which gets too far ahead of the receiver has to wait instead of
+// piling up data in the receiver's memory.
+//
+// A writer waiting for credit has to read the connection to find out that it has been granted
+// more. If no other goroutine is reading the session, the writer does the reading itself and
+// saves any packets it receives for the next call to Read().
+
+// errCreditReceived is returned by readPacket() when a credit frame arrives instead of a packet
+var errCreditReceived = errors.New("credit received")
+
+// creditSize is the size of the payload of a credit frame
+const creditSize = 8
+
+// queuedPacket is a packet read by a writer waiting for credit
+type queuedPacket struct {
+	packet []byte
+	topic  string
+
+	// credit is the amount of frame payload received for the packet, which is granted back to the
+	// other side once the packet is read
+	credit uint64
+}
+
+// flowWindow returns the number of bytes of credit the session grants the other side. The window
+// is always at least two frames so that the sender can't run out of credit while the receiver is
+// waiting to reach the halfway point.
+func (s *PacketSession) flowWindow() uint64 {
+
+	window := uint64(s.FlowWindow)
+	if window == 0 {
+		window = uint64(DefaultFlowWindow)
+	}
+	if window < 2*uint64(s.BufferSize) {
+		window = 2 * uint64(s.BufferSize)
+	}
+	return window
+}
+
+// startFlowControl exchanges the initial credit grants once setup has agreed on flow control. The
+// requester sends its grant first so that the exchange works over unbuffered connections.
+func (s *PacketSession) startFlowControl(isRequester bool) error {
+
+	if !s.FlowControl {
+		return nil
+	}
+	s.creditSignal = make(chan struct{}, 1)
+
+	if isRequester {
+		if err := s.grantCredit(s.flowWindow()); err != nil {
+			return err
+		}
+	}
+
+	// The other side sends its grant before anything else, so anything else is an error
+	_, err := s.readFrame(NewDataFrame(s.BufferSize))
+	if err != errCreditReceived {
+		if err == nil {
+			err = ErrSessionSetup
+		}
+		return err
+	}
+
+	if !isRequester {
+		return s.grantCredit(s.flowWindow())
+	}
+	return nil
+}
+
+// grantCredit sends a credit frame allowing the other side to send the specified number of bytes
+func (s *PacketSession) grantCredit(amount uint64) error {
+
+	payload := make([]byte, creditSize)
+	binary.BigEndian.PutUint64(payload, amount)
+
+	s.frameLock.Lock()
+	defer s.frameLock.Unlock()
+
+	if err := s.writeFrame(s.output(), CreditFrame, payload); err != nil {
+		return err
+	}
+	if s.writer != nil {
+		return s.writer.Flush()
+	}
+	return nil
+}
+
+// addCredit applies a credit frame received from the other side
+func (s *PacketSession) addCredit(payload []byte) error {
+
+	if len(payload) != creditSize {
+		return ErrInvalidFrame
+	}
+
+	s.flowLock.Lock()
+	s.sendCredit += binary.BigEndian.Uint64(payload)
+	s.flowLock.Unlock()
+
+	s.signalCredit()
+	return nil
+}
+
+// receivedData records frame payload received from the other side, granting it more credit once
+// half of the window has been used
+func (s *PacketSession) receivedData(size int) error {
+
+	s.flowLock.Lock()
+	s.recvUnacked += uint64(size)
+	amount := s.recvUnacked
+	if amount < s.flowWindow()/2 {
+		s.flowLock.Unlock()
+		return nil
+	}
+	s.recvUnacked = 0
+	s.flowLock.Unlock()
+
+	return s.grantCredit(amount)
+}
+
+// waitForCredit blocks until the other side has granted enough credit to send the specified
+// number of bytes and then uses it up
+func (s *PacketSession) waitForCredit(size uint64) error {
+
+	for {
+		s.flowLock.Lock()
+		if s.sendCredit >= size {
+			s.sendCredit -= size
+			s.flowLock.Unlock()
+			return nil
+		}
+		s.flowLock.Unlock()
+
+		// Frames still in the write buffer could be what the other side is waiting on before it
+		// reads any further
+		if err := s.flushFrames(); err != nil {
+			return err
+		}
+
+		if s.readLock.TryLock() {
+			err := s.readForCredit()
+			s.readLock.Unlock()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Someone else is reading. They signal when credit arrives or when they stop reading.
+		<-s.creditSignal
+	}
+}
+
+// readForCredit reads from the connection on behalf of a writer waiting for credit. Packets
+// received are saved for Read(), which grants the credit for them. The caller must hold readLock.
+func (s *PacketSession) readForCredit() error {
+
+	s.holdCredit = true
+	packet, topic, err := s.readPacket()
+	s.holdCredit = false
+	if err == errCreditReceived {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.queued = append(s.queued, queuedPacket{packet, topic, s.heldCredit})
+	s.heldCredit = 0
+	return nil
+}
+
+// creditData is called for frame payload which has been read. If a writer waiting for credit is
+// reading, the credit is held back until Read() returns the packet. Otherwise it is granted along
+// with any credit held back for the start of the packet. The caller must hold readLock.
+func (s *PacketSession) creditData(size uint64) error {
+	if s.holdCredit {
+		s.heldCredit += size
+		return nil
+	}
+	held := s.heldCredit
+	s.heldCredit = 0
+	return s.receivedData(int(held + size))
+}
+
+// signalCredit wakes up a writer waiting for credit, if there is one
+func (s *PacketSession) signalCredit() {
+	select {
+	case s.creditSignal <- struct{}{}:
+	default:
+	}
+}
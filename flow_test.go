@@ -0,0 +1,166 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flowControl sets up a pair of sessions for sessionPair() to use flow control with a small
+// window. The sessions are moved onto a TCP connection because an in-memory one has no buffer, so
+// it would hold up writers whether or not flow control did.
+func flowControl(t *testing.T) func(req, resp *PacketSession) {
+	return func(req, resp *PacketSession) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Error setting up listener: %s", err.Error())
+		}
+		defer listener.Close()
+		clientConn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Error connecting to listener: %s", err.Error())
+		}
+		serverConn, err := listener.Accept()
+		if err != nil {
+			t.Fatalf("Error accepting a connection: %s", err.Error())
+		}
+
+		req.Connection.Close()
+		resp.Connection.Close()
+		req.Connection = clientConn
+		resp.Connection = serverConn
+		resp.BufferSize = 1024
+		req.FlowControl = true
+		req.FlowWindow = 4096
+		resp.FlowControl = true
+		resp.FlowWindow = 4096
+	}
+}
+
+func TestFlowControl(t *testing.T) {
+	requester, responder := sessionPair(t, flowControl(t))
+	defer requester.Close()
+	defer responder.Close()
+
+	// The packet easily fits in the socket buffers, so only flow control can hold up the writer
+	// until the responder starts reading
+	packet := bytes.Repeat([]byte("0123456789"), 10000)
+	done := make(chan error)
+	go func() {
+		done <- requester.Write(packet)
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Write finished without waiting for credit: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	received, err := responder.Read()
+	if err != nil {
+		t.Fatalf("Read failed: %s", err.Error())
+	}
+	if !bytes.Equal(received, packet) {
+		t.Fatalf("Packet mismatch after flow-controlled transfer")
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Write failed: %s", err.Error())
+	}
+
+	// A reply needs the requester to read credit frames itself
+	go responder.Write([]byte("reply"))
+	if received, err = requester.Read(); err != nil || string(received) != "reply" {
+		t.Fatalf("Reply failed after flow-controlled transfer: %v", err)
+	}
+}
+
+func TestFlowControlBothWays(t *testing.T) {
+	requester, responder := sessionPair(t, flowControl(t))
+	defer requester.Close()
+	defer responder.Close()
+
+	// The responder's packet fits in its initial credit, but the requester's doesn't, so the
+	// requester has to read the responder's packet to find its credit and save it for Read()
+	small := bytes.Repeat([]byte("responder"), 300)
+	large := bytes.Repeat([]byte("requester"), 5000)
+	if err := responder.Write(small); err != nil {
+		t.Fatalf("Write failed: %s", err.Error())
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- requester.Write(large)
+	}()
+
+	received, err := responder.Read()
+	if err != nil || !bytes.Equal(received, large) {
+		t.Fatalf("Packet mismatch after writing both ways: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Write failed: %s", err.Error())
+	}
+	received, err = requester.Read()
+	if err != nil || !bytes.Equal(received, small) {
+		t.Fatalf("Saved packet mismatch after writing both ways: %v", err)
+	}
+}
+
+func TestFlowControlNoReaders(t *testing.T) {
+	requester, responder := sessionPair(t, flowControl(t))
+
+	// Neither side reads, so the packets each writer saves while looking for credit mustn't
+	// earn the other side any more credit
+	packet := bytes.Repeat([]byte("0123456789"), 100)
+	sent := map[*PacketSession]*int64{requester: new(int64), responder: new(int64)}
+	var wg sync.WaitGroup
+	for s, count := range sent {
+		wg.Add(1)
+		go func(s *PacketSession, count *int64) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				if s.Write(packet) != nil {
+					return
+				}
+				atomic.AddInt64(count, 1)
+			}
+		}(s, count)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	requester.Close()
+	responder.Close()
+	wg.Wait()
+
+	// Each side can send no more than its initial window of 4096 bytes
+	for s, count := range sent {
+		if *count > 4 || len(s.queued) > 4 {
+			t.Fatalf("Sessions kept granting credit without reading: %d sent, %d saved", *count,
+				len(s.queued))
+		}
+	}
+}
+
+func TestFlowControlSetup(t *testing.T) {
+	for _, settings := range [][]bool{{true, false}, {false, true}} {
+		clientConn, serverConn := net.Pipe()
+		requester := NewPacketRequester(clientConn)
+		requester.FlowControl = settings[0]
+		responder := NewPacketResponder(serverConn, 1024)
+		responder.FlowControl = settings[1]
+
+		errChan := make(chan error)
+		go func() {
+			errChan <- requester.InitRequester()
+		}()
+		respErr := responder.InitResponder()
+		reqErr := <-errChan
+		if !errors.Is(reqErr, ErrSessionSetup) && !errors.Is(respErr, ErrSessionSetup) {
+			t.Fatalf("Mismatched flow control settings weren't caught: %v", settings)
+		}
+	}
+}
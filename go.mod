@@ -0,0 +1,3 @@
+module github.com/darkwyrm/oganesson
+
+go 1.21
@@ -0,0 +1,174 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+var ErrPeerRejected = errors.New("peer identity rejected")
+
+// HandshakeInfo contains the details of a PacketSession's setup, such as the parameters
+// negotiated by each side, for applications which need to log or audit them. PacketSession does
+// not perform encryption by itself, so there is no cipher information and peer identity is only
+// available for TLS connections.
+type HandshakeInfo struct {
+	// SessionID is the local ID of the session. See PacketSession.ID().
+	SessionID string
+
+	// IsRequester is true if this side of the session initiated the setup
+	IsRequester bool
+
+	// RemoteAddr is the network address of the peer
+	RemoteAddr net.Addr
+
+	// RequestedBufferSize is the buffer size sent by the requester and ResponseBufferSize is the
+	// one sent back by the responder. BufferSize is the buffer size the session ended up with.
+	RequestedBufferSize uint16
+	ResponseBufferSize  uint16
+	BufferSize          uint16
+
+	// Version is the protocol version chosen for the session
+	Version uint8
+
+	// TranscriptHash is the SHA-256 hash of the setup request, the setup response, and the
+	// version offer and answer, if versions were negotiated. Both sides of a session calculate
+	// the same value.
+	TranscriptHash []byte
+
+	// Completed is the time that setup finished
+	Completed time.Time
+
+	// Peer is the identity of the other side of the session. It is nil unless the session runs
+	// over a TLS connection.
+	Peer *PeerIdentity
+}
+
+// PeerIdentity describes the other side of an encrypted session so that applications can pin
+// its certificate or key and record who they are talking to
+type PeerIdentity struct {
+	// Certificates is the certificate chain presented by the peer, leaf first. It is empty if the
+	// peer didn't present one, such as a TLS client when the server doesn't ask for certificates.
+	Certificates []*x509.Certificate
+
+	// Fingerprint is the SHA-256 hash of the peer's leaf certificate, suitable for pinning. It is
+	// nil if there are no certificates.
+	Fingerprint []byte
+
+	// ServerName is the server name requested by the TLS client
+	ServerName string
+}
+
+// PublicKey returns the public key from the peer's leaf certificate or nil if there isn't one
+func (p PeerIdentity) PublicKey() crypto.PublicKey {
+	if len(p.Certificates) == 0 {
+		return nil
+	}
+	return p.Certificates[0].PublicKey
+}
+
+// peerIdentity returns the identity of the peer on a TLS connection or nil for other connections
+func peerIdentity(conn net.Conn) *PeerIdentity {
+
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	state := tlsConn.ConnectionState()
+	if !state.HandshakeComplete {
+		return nil
+	}
+
+	out := PeerIdentity{Certificates: state.PeerCertificates, ServerName: state.ServerName}
+	if len(state.PeerCertificates) > 0 {
+		fingerprint := sha256.Sum256(state.PeerCertificates[0].Raw)
+		out.Fingerprint = fingerprint[:]
+	}
+	return &out
+}
+
+// verifyPeer passes the peer's identity to the session's VerifyPeer callback, if it has one. If
+// the peer is rejected, the connection is closed.
+func (s *PacketSession) verifyPeer() error {
+
+	if s.VerifyPeer == nil {
+		return nil
+	}
+
+	var err error
+	if s.handshake == nil || s.handshake.Peer == nil {
+		err = ErrEncryptionRequired
+	} else if verifyErr := s.VerifyPeer(*s.handshake.Peer); verifyErr != nil {
+		err = fmt.Errorf("%w: %s", ErrPeerRejected, verifyErr.Error())
+	}
+
+	if err != nil {
+		s.isInit = false
+		s.setClosed(CloseAuthFailed, err)
+		s.Connection.Close()
+	}
+	return err
+}
+
+// HandshakeInfo returns the details of the session's setup. ErrNoInit is returned if the session
+// hasn't been set up yet.
+func (s *PacketSession) HandshakeInfo() (HandshakeInfo, error) {
+	if !s.isInit || s.handshake == nil {
+		return HandshakeInfo{}, ErrNoInit
+	}
+
+	out := *s.handshake
+	out.TranscriptHash = append([]byte(nil), s.handshake.TranscriptHash...)
+	return out, nil
+}
+
+// ChannelBinding returns a token which is unique to the session's encrypted channel, so that
+// application-level authentication can be tied to the channel and can't be replayed over a
+// different one. PacketSession doesn't encrypt data by itself, so this requires a TLS connection,
+// in which case the tls-exporter binding from RFC 9266 is used. ErrEncryptionRequired is returned
+// for all other connections.
+func (s *PacketSession) ChannelBinding() ([]byte, error) {
+	if !s.isInit {
+		return nil, ErrNoInit
+	}
+
+	tlsConn, ok := s.Connection.(*tls.Conn)
+	if !ok {
+		return nil, ErrEncryptionRequired
+	}
+
+	state := tlsConn.ConnectionState()
+	if !state.HandshakeComplete {
+		return nil, ErrEncryptionRequired
+	}
+	return state.ExportKeyingMaterial("EXPORTER-Channel-Binding", nil, 32)
+}
+
+// recordHandshake saves the details of a completed session setup
+func (s *PacketSession) recordHandshake(isRequester bool, request []byte, response []byte) {
+
+	transcript := sha256.New()
+	transcript.Write(request)
+	transcript.Write(response)
+	transcript.Write(s.versionExchange)
+
+	s.handshake = &HandshakeInfo{
+		SessionID:           s.id,
+		IsRequester:         isRequester,
+		RemoteAddr:          s.Connection.RemoteAddr(),
+		RequestedBufferSize: uint16(request[1])<<8 + uint16(request[2]),
+		ResponseBufferSize:  uint16(response[1])<<8 + uint16(response[2]),
+		BufferSize:          s.BufferSize,
+		Version:             s.version,
+		TranscriptHash:      transcript.Sum(nil),
+		Completed:           time.Now(),
+		Peer:                peerIdentity(s.Connection),
+	}
+}
@@ -0,0 +1,140 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// testTLSConfig creates a throwaway self-signed certificate for testing TLS connections
+func testTLSConfig(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating test key: %s", err.Error())
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Error creating test certificate: %s", err.Error())
+	}
+
+	return &tls.Config{
+		Certificates:       []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		InsecureSkipVerify: true,
+	}
+}
+
+func TestChannelBinding(t *testing.T) {
+	config := testTLSConfig(t)
+	clientConn, serverConn := net.Pipe()
+
+	requester := NewPacketRequester(tls.Client(clientConn, config))
+	responder := NewPacketResponder(tls.Server(serverConn, config), 32767)
+
+	if _, err := responder.ChannelBinding(); err != ErrNoInit {
+		t.Fatalf("ChannelBinding didn't require session setup")
+	}
+
+	errChan := make(chan error)
+	go func() {
+		errChan <- requester.InitRequester()
+	}()
+	if err := responder.InitResponder(); err != nil {
+		t.Fatalf("Responder init failed: %s", err.Error())
+	}
+	if err := <-errChan; err != nil {
+		t.Fatalf("Requester init failed: %s", err.Error())
+	}
+
+	requesterBinding, err := requester.ChannelBinding()
+	if err != nil {
+		t.Fatalf("Requester ChannelBinding failed: %s", err.Error())
+	}
+	responderBinding, err := responder.ChannelBinding()
+	if err != nil {
+		t.Fatalf("Responder ChannelBinding failed: %s", err.Error())
+	}
+	if len(requesterBinding) != 32 || !bytes.Equal(requesterBinding, responderBinding) {
+		t.Fatalf("Channel binding mismatch between requester and responder")
+	}
+
+	requesterInfo, _ := requester.HandshakeInfo()
+	responderInfo, _ := responder.HandshakeInfo()
+	if !bytes.Equal(requesterInfo.TranscriptHash, responderInfo.TranscriptHash) {
+		t.Fatalf("Handshake transcript hash mismatch between requester and responder")
+	}
+
+	plain := PacketSession{isInit: true, Connection: clientConn}
+	if _, err := plain.ChannelBinding(); err != ErrEncryptionRequired {
+		t.Fatalf("ChannelBinding didn't require an encrypted connection")
+	}
+}
+
+func TestVerifyPeer(t *testing.T) {
+	config := testTLSConfig(t)
+	pin := sha256.Sum256(config.Certificates[0].Certificate[0])
+
+	setup := func(verify func(PeerIdentity) error) (*PacketSession, error) {
+		clientConn, serverConn := net.Pipe()
+		requester := NewPacketRequester(tls.Client(clientConn, config))
+		requester.VerifyPeer = verify
+		responder := NewPacketResponder(tls.Server(serverConn, config), 32767)
+
+		// The responder keeps reading so that closing a rejected connection doesn't block
+		go func() {
+			responder.InitResponder()
+			responder.Read()
+		}()
+		return requester, requester.InitRequester()
+	}
+
+	requester, err := setup(func(identity PeerIdentity) error {
+		if !bytes.Equal(identity.Fingerprint, pin[:]) {
+			return errors.New("certificate doesn't match pin")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Pinned peer was rejected: %s", err.Error())
+	}
+	info, _ := requester.HandshakeInfo()
+	if info.Peer == nil || !bytes.Equal(info.Peer.Fingerprint, pin[:]) ||
+		info.Peer.PublicKey() == nil {
+		t.Fatalf("Peer identity wasn't recorded")
+	}
+
+	requester, err = setup(func(identity PeerIdentity) error {
+		return errors.New("certificate doesn't match pin")
+	})
+	if !errors.Is(err, ErrPeerRejected) {
+		t.Fatalf("VerifyPeer error didn't reject the peer: %v", err)
+	}
+	if requester.CloseReason() != CloseAuthFailed {
+		t.Fatalf("Close reason mismatch for rejected peer: %s", requester.CloseReason())
+	}
+
+	clientConn, serverConn := net.Pipe()
+	plain := NewPacketRequester(clientConn)
+	plain.VerifyPeer = func(identity PeerIdentity) error { return nil }
+	go NewPacketResponder(serverConn, 32767).InitResponder()
+	if err := plain.InitRequester(); !errors.Is(err, ErrEncryptionRequired) {
+		t.Fatalf("VerifyPeer didn't require an encrypted connection: %v", err)
+	}
+}
@@ -0,0 +1,286 @@
+package oganesson
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrReservedKey = errors.New("reserved key")
+
+// ReservedKeyPrefix begins the names of fields which are managed by this package, such as those
+// of the header section. DocumentBuilder refuses to add fields whose names start with it, so
+// application fields can't collide with them.
+const ReservedKeyPrefix = "_og."
+
+// The fields of the header section of a keyed Document. Fields which aren't set are left out.
+const (
+	HeaderMessageIDField      = ReservedKeyPrefix + "msgid"
+	HeaderTimestampField      = ReservedKeyPrefix + "timestamp"
+	HeaderCompressedField     = ReservedKeyPrefix + "compressed"
+	HeaderSignatureField      = ReservedKeyPrefix + "signature"
+	HeaderIdempotencyKeyField = ReservedKeyPrefix + "idempotency"
+	HeaderReplyToField        = ReservedKeyPrefix + "replyto"
+	HeaderExpiresField        = ReservedKeyPrefix + "expires"
+	HeaderEncryptedField      = ReservedKeyPrefix + "encrypted"
+
+	// HeaderKeyDictionaryField holds the ID of the KeyDictionary used by CompactKeys(). It is
+	// only present while a Document's keys are compacted, so DocumentHeader has no field for it.
+	HeaderKeyDictionaryField = ReservedKeyPrefix + "keydict"
+)
+
+// headerFieldTypes holds the type of each header field
+var headerFieldTypes = map[string]uint8{
+	HeaderMessageIDField:      DFStringType,
+	HeaderTimestampField:      DFInt64Type,
+	HeaderCompressedField:     DFBoolType,
+	HeaderSignatureField:      DFBinaryType,
+	HeaderIdempotencyKeyField: DFStringType,
+	HeaderReplyToField:        DFStringType,
+	HeaderExpiresField:        DFInt64Type,
+	HeaderEncryptedField:      DFStringType,
+	HeaderKeyDictionaryField:  DFBinaryType,
+}
+
+// DocumentHeader holds the metadata which this package keeps in the header section of a keyed
+// Document. It is stored in the Document's map alongside the application's fields.
+type DocumentHeader struct {
+	// MessageID identifies the message, such as for matching replies to requests
+	MessageID string
+
+	// Timestamp is the time the message was created. It is stored with nanosecond precision.
+	Timestamp time.Time
+
+	// Compressed is set if the application payload of the message has been compressed
+	Compressed bool
+
+	// Signature holds a signature of the message made by the sender
+	Signature []byte
+
+	// IdempotencyKey is set by clients on requests which are safe to retry. Servers using
+	// IdempotencyMiddleware handle each key only once and send the saved reply to retries.
+	IdempotencyKey string
+
+	// ReplyTo holds the message ID of the request which a reply answers
+	ReplyTo string
+
+	// Expires is the time after which the message is no longer worth delivering or handling. It
+	// is usually set with SetTTL().
+	Expires time.Time
+
+	// Encrypted lists the fields whose values have been sealed with EncryptFields(). It is
+	// stored as a comma-separated list.
+	Encrypted []string
+}
+
+// IsReservedKey returns true if a field name starts with ReservedKeyPrefix
+func IsReservedKey(name string) bool {
+	return strings.HasPrefix(name, ReservedKeyPrefix)
+}
+
+// checkHeaderField makes sure that a reserved field is one of the header fields and has the
+// right type
+func checkHeaderField(name string, seg Segment) error {
+
+	typeCode, ok := headerFieldTypes[name]
+	if !ok {
+		return fmt.Errorf("%w '%s'", ErrReservedKey, name)
+	}
+	if baseTypeCode(seg.Type) != typeCode {
+		return &TypeError{Expected: typeCode, Actual: seg.Type, Key: name}
+	}
+	return nil
+}
+
+// Header returns the header section of a keyed Document. Fields which aren't set have their zero
+// values, as do all of them for an empty Document.
+func (doc *Document) Header() (DocumentHeader, error) {
+
+	var out DocumentHeader
+	if len(doc.Items) == 0 {
+		return out, nil
+	}
+	fields, err := doc.fieldMap()
+	if err != nil {
+		return out, err
+	}
+	if err := out.readFields(fields); err != nil {
+		return DocumentHeader{}, err
+	}
+	return out, nil
+}
+
+// SetHeader replaces the header section of a keyed Document, leaving its other fields alone. An
+// empty Document becomes a keyed Document which only holds the header.
+func (doc *Document) SetHeader(h DocumentHeader) error {
+
+	if doc.frozen {
+		return ErrFrozen
+	}
+
+	fields := make(SegmentMap)
+	if len(doc.Items) > 0 {
+		var err error
+		if fields, err = doc.fieldMap(); err != nil {
+			return err
+		}
+	}
+	return doc.replaceFields(fields, h)
+}
+
+// readFields fills in the header from the reserved fields of a keyed Document
+func (h *DocumentHeader) readFields(fields SegmentMap) error {
+
+	for name, seg := range fields {
+		if !IsReservedKey(name) {
+			continue
+		}
+		if err := checkHeaderField(name, seg); err != nil {
+			return err
+		}
+
+		var err error
+		switch name {
+		case HeaderMessageIDField:
+			h.MessageID, err = seg.GetString()
+		case HeaderTimestampField:
+			var ns int64
+			ns, err = seg.GetInt64()
+			h.Timestamp = time.Unix(0, ns)
+		case HeaderCompressedField:
+			h.Compressed, err = seg.GetBool()
+		case HeaderSignatureField:
+			h.Signature, err = seg.GetBinary()
+		case HeaderIdempotencyKeyField:
+			h.IdempotencyKey, err = seg.GetString()
+		case HeaderReplyToField:
+			h.ReplyTo, err = seg.GetString()
+		case HeaderExpiresField:
+			var ns int64
+			ns, err = seg.GetInt64()
+			h.Expires = time.Unix(0, ns)
+		case HeaderEncryptedField:
+			var names string
+			names, err = seg.GetString()
+			if names != "" {
+				h.Encrypted = strings.Split(names, ",")
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFields replaces the header fields of a keyed Document's field map
+func (h DocumentHeader) writeFields(fields SegmentMap) error {
+
+	for name := range headerFieldTypes {
+		delete(fields, name)
+	}
+
+	if h.MessageID != "" {
+		if err := fields.SetString(HeaderMessageIDField, h.MessageID); err != nil {
+			return err
+		}
+	}
+	if !h.Timestamp.IsZero() {
+		if err := fields.SetInt64(HeaderTimestampField, h.Timestamp.UnixNano()); err != nil {
+			return err
+		}
+	}
+	if h.Compressed {
+		if err := fields.SetBool(HeaderCompressedField, true); err != nil {
+			return err
+		}
+	}
+	if len(h.Signature) > 0 {
+		if err := fields.SetBinary(HeaderSignatureField, h.Signature); err != nil {
+			return err
+		}
+	}
+	if h.IdempotencyKey != "" {
+		if err := fields.SetString(HeaderIdempotencyKeyField, h.IdempotencyKey); err != nil {
+			return err
+		}
+	}
+	if h.ReplyTo != "" {
+		if err := fields.SetString(HeaderReplyToField, h.ReplyTo); err != nil {
+			return err
+		}
+	}
+	if !h.Expires.IsZero() {
+		if err := fields.SetInt64(HeaderExpiresField, h.Expires.UnixNano()); err != nil {
+			return err
+		}
+	}
+	if len(h.Encrypted) > 0 {
+		names := strings.Join(h.Encrypted, ",")
+		if err := fields.SetString(HeaderEncryptedField, names); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stamp fills in the message ID and timestamp of a keyed Document's header with a new UUIDv7 and
+// the current time. Fields which are already set are left alone, so a Document which is sent
+// again, such as when a request is retried, keeps its original ID.
+func (doc *Document) Stamp() error {
+
+	h, err := doc.Header()
+	if err != nil {
+		return err
+	}
+	if h.MessageID != "" && !h.Timestamp.IsZero() {
+		return nil
+	}
+
+	now := time.Now()
+	if h.MessageID == "" {
+		if h.MessageID, err = newMessageID(now); err != nil {
+			return err
+		}
+	}
+	if h.Timestamp.IsZero() {
+		h.Timestamp = now
+	}
+	return doc.SetHeader(h)
+}
+
+// MessageID returns the message ID from a keyed Document's header or an empty string if it
+// doesn't have one
+func (doc *Document) MessageID() (string, error) {
+	h, err := doc.Header()
+	return h.MessageID, err
+}
+
+// Timestamp returns the creation time from a keyed Document's header or the zero time if it
+// doesn't have one
+func (doc *Document) Timestamp() (time.Time, error) {
+	h, err := doc.Header()
+	return h.Timestamp, err
+}
+
+// newMessageID generates a UUIDv7 as described in RFC 9562. The IDs sort in the order in which
+// they were created, give or take a millisecond.
+func newMessageID(now time.Time) (string, error) {
+
+	var id [16]byte
+	if _, err := rand.Read(id[6:]); err != nil {
+		return "", err
+	}
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(now.UnixMilli()))
+	copy(id[:6], ms[2:])
+	id[6] = id[6]&0x0f | 0x70
+	id[8] = id[8]&0x3f | 0x80
+
+	out := hex.EncodeToString(id[:])
+	return out[:8] + "-" + out[8:12] + "-" + out[12:16] + "-" + out[16:20] + "-" + out[20:], nil
+}
@@ -0,0 +1,124 @@
+package oganesson
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDocumentHeader(t *testing.T) {
+	schema := NewSchema(FieldSpec{Name: MsgCodeField, Type: DFStringType, Required: true})
+	b := NewDocumentBuilder(schema)
+	if err := b.AddString(HeaderMessageIDField, "abc"); !errors.Is(err, ErrReservedKey) {
+		t.Fatalf("DocumentBuilder accepted a reserved key")
+	}
+	b.AddString(MsgCodeField, "PING")
+
+	header := DocumentHeader{
+		MessageID: "abc",
+		Timestamp: time.Unix(1700000000, 123),
+		Signature: []byte{1, 2, 3},
+	}
+	if err := b.SetHeader(header); err != nil {
+		t.Fatalf("Error setting header: %s", err.Error())
+	}
+
+	// Header fields don't count against the schema
+	doc, err := b.Build()
+	if err != nil {
+		t.Fatalf("Error building document with header: %s", err.Error())
+	}
+
+	out, err := doc.Header()
+	if err != nil {
+		t.Fatalf("Error reading header: %s", err.Error())
+	}
+	if out.MessageID != "abc" || !out.Timestamp.Equal(header.Timestamp) || out.Compressed ||
+		!bytes.Equal(out.Signature, header.Signature) {
+		t.Fatalf("Header wasn't read back correctly: %+v", out)
+	}
+	if code, _ := messageCode(doc); code != "PING" {
+		t.Fatalf("Setting the header changed the message code")
+	}
+
+	// Replacing the header drops the fields which are no longer set
+	if err := doc.SetHeader(DocumentHeader{Compressed: true}); err != nil {
+		t.Fatalf("Error replacing header: %s", err.Error())
+	}
+	out, _ = doc.Header()
+	if out.MessageID != "" || out.Signature != nil || !out.Compressed {
+		t.Fatalf("Header wasn't replaced: %+v", out)
+	}
+	if code, _ := messageCode(doc); code != "PING" {
+		t.Fatalf("Replacing the header changed the message code")
+	}
+	if flat, _ := doc.Flatten(); uint64(len(flat)) != doc.GetSize() {
+		t.Fatalf("Document size is stale after replacing the header")
+	}
+
+	doc.Freeze()
+	if err := doc.SetHeader(header); err != ErrFrozen {
+		t.Fatalf("Header of a frozen document was changed")
+	}
+}
+
+func TestDocumentHeaderText(t *testing.T) {
+	doc := NewDocument()
+	if err := doc.SetHeader(DocumentHeader{MessageID: "42"}); err != nil {
+		t.Fatalf("Error setting header on empty document: %s", err.Error())
+	}
+
+	text, err := FormatDocumentText(doc)
+	if err != nil {
+		t.Fatalf("Error formatting document with header: %s", err.Error())
+	}
+	parsed, err := ParseDocumentText(text)
+	if err != nil {
+		t.Fatalf("Error parsing document with header: %s", err.Error())
+	}
+	if h, _ := parsed.Header(); h.MessageID != "42" {
+		t.Fatalf("Header didn't survive the text format")
+	}
+
+	if _, err := ParseDocumentText([]byte("_og.other: x")); !errors.Is(err, ErrReservedKey) {
+		t.Fatalf("Unknown reserved key was accepted")
+	}
+	if _, err := ParseDocumentText([]byte("_og.msgid: !Int8 1")); !errors.Is(err, ErrTypeError) {
+		t.Fatalf("Header field of the wrong type was accepted")
+	}
+}
+
+func TestDocumentStamp(t *testing.T) {
+	doc := NewDocument()
+	before := time.Now()
+	if err := doc.Stamp(); err != nil {
+		t.Fatalf("Error stamping document: %s", err.Error())
+	}
+
+	id, err := doc.MessageID()
+	if err != nil {
+		t.Fatalf("Error getting message ID: %s", err.Error())
+	}
+	if len(id) != 36 || id[14] != '7' || !strings.ContainsRune("89ab", rune(id[19])) {
+		t.Fatalf("Message ID %s isn't a UUIDv7", id)
+	}
+	if ts, _ := doc.Timestamp(); ts.Before(before) || ts.After(time.Now()) {
+		t.Fatalf("Wrong timestamp %v", ts)
+	}
+
+	// Stamping again keeps the original ID
+	doc.Stamp()
+	if again, _ := doc.MessageID(); again != id {
+		t.Fatalf("Stamp replaced an existing message ID")
+	}
+
+	// IDs sort by creation time
+	other := NewDocument()
+	time.Sleep(2 * time.Millisecond)
+	other.Stamp()
+	if otherID, _ := other.MessageID(); otherID <= id {
+		t.Fatalf("Message ID %s doesn't sort after %s", otherID, id)
+	}
+}
@@ -0,0 +1,99 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+var ErrContentType = errors.New("unsupported content type")
+
+// DocumentContentType is the MIME type used for Documents sent over HTTP
+const DocumentContentType = "application/x-jbitpack"
+
+// WriteDocumentResponse sends a Document as the body of an HTTP response with a 200 status. Any
+// other headers need to be set before calling it.
+func WriteDocumentResponse(w http.ResponseWriter, doc *Document) error {
+
+	if doc == nil {
+		return ErrEmptyData
+	}
+	data, err := doc.Flatten()
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", DocumentContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(data)
+	return err
+}
+
+// ReadDocumentRequest decodes a Document from the body of an HTTP request. ErrContentType is
+// returned if the request doesn't have DocumentContentType as its content type. Decoding stops if
+// the request's context is canceled and any limits passed to the call are applied.
+func ReadDocumentRequest(r *http.Request, limits ...DecodeLimits) (*Document, error) {
+	return readDocumentBody(r.Context(), r.Header, r.Body, limits)
+}
+
+// NewDocumentRequest creates an HTTP request with a Document as its body
+func NewDocumentRequest(ctx context.Context, method string, url string,
+	doc *Document) (*http.Request, error) {
+
+	if doc == nil {
+		return nil, ErrEmptyData
+	}
+	data, err := doc.Flatten()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", DocumentContentType)
+	return req, nil
+}
+
+// ReadDocumentResponse decodes a Document from the body of an HTTP response. It is the
+// client-side counterpart to ReadDocumentRequest(). The response's body is not closed.
+func ReadDocumentResponse(resp *http.Response, limits ...DecodeLimits) (*Document, error) {
+
+	ctx := context.Background()
+	if resp.Request != nil {
+		ctx = resp.Request.Context()
+	}
+	return readDocumentBody(ctx, resp.Header, resp.Body, limits)
+}
+
+// readDocumentBody does the work for ReadDocumentRequest() and ReadDocumentResponse()
+func readDocumentBody(ctx context.Context, header http.Header, body io.Reader,
+	limits []DecodeLimits) (*Document, error) {
+
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || mediaType != DocumentContentType {
+		return nil, ErrContentType
+	}
+	if body == nil {
+		return nil, ErrEmptyData
+	}
+
+	var callLimits DecodeLimits
+	for _, l := range limits {
+		callLimits = callLimits.Restrict(l)
+	}
+
+	out := NewDocument()
+	if err := out.ReadLimited(ctx, body, callLimits); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
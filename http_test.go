@@ -0,0 +1,54 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDocumentHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		doc, err := ReadDocumentRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		doc.AttachString("reply", "pong")
+		WriteDocumentResponse(w, doc)
+	}))
+	defer server.Close()
+
+	doc := NewDocument()
+	doc.AttachString("request", "ping")
+	req, err := NewDocumentRequest(context.Background(), http.MethodPost, server.URL, doc)
+	if err != nil {
+		t.Fatalf("NewDocumentRequest failed: %s", err.Error())
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error sending request: %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	reply, err := ReadDocumentResponse(resp)
+	if err != nil {
+		t.Fatalf("ReadDocumentResponse failed: %s", err.Error())
+	}
+	if len(reply.Items) != 2 {
+		t.Fatalf("Reply item count mismatch: %d", len(reply.Items))
+	}
+
+	// Wrong content type
+	resp, err = http.Post(server.URL, "text/plain", nil)
+	if err != nil {
+		t.Fatalf("Error sending request: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Server accepted the wrong content type")
+	}
+}
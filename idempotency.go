@@ -0,0 +1,181 @@
+package oganesson
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// This file contains support for idempotency keys. A client which may retry a request, such as
+// after a timeout, puts a unique key into the request's header with SetIdempotencyKey(). A server
+// using IdempotencyMiddleware runs the Handler for the first request with a key and sends the
+// saved reply to any retries instead of handling them again.
+
+// SetIdempotencyKey sets the idempotency key in a keyed Document's header. A new UUIDv7 is
+// generated if the key is empty. The key is returned so that it can be reused for retries.
+func (doc *Document) SetIdempotencyKey(key string) (string, error) {
+
+	h, err := doc.Header()
+	if err != nil {
+		return "", err
+	}
+	if key == "" {
+		if key, err = newMessageID(time.Now()); err != nil {
+			return "", err
+		}
+	}
+	h.IdempotencyKey = key
+	return key, doc.SetHeader(h)
+}
+
+// IdempotencyKey returns the idempotency key from a keyed Document's header or an empty string
+// if it doesn't have one
+func (doc *Document) IdempotencyKey() (string, error) {
+	h, err := doc.Header()
+	return h.IdempotencyKey, err
+}
+
+// DedupCache holds the replies to requests which had idempotency keys. Implementations must be
+// safe for concurrent use.
+type DedupCache interface {
+	// Get returns the reply saved for a key. The reply is nil for requests which had no reply.
+	Get(key string) (reply *Document, ok bool)
+
+	// Put saves the reply for a key
+	Put(key string, reply *Document)
+}
+
+// IdempotencyMiddleware handles each request with an idempotency key only once, saving the reply
+// in the cache and returning it for later requests with the same key and message code. A request
+// which arrives while another with the same key is still being handled waits for it to finish.
+// Requests which fail aren't saved, so they can be retried. Saved replies are frozen because they
+// may be sent more than once. Requests without a key are passed through.
+func IdempotencyMiddleware(cache DedupCache) Middleware {
+
+	var lock sync.Mutex
+	inFlight := make(map[string]chan struct{})
+
+	return func(next Handler) Handler {
+		return func(ctx context.Context, doc *Document) (*Document, error) {
+			key, err := doc.IdempotencyKey()
+			if err != nil || key == "" {
+				return next(ctx, doc)
+			}
+			code, _ := messageCode(doc)
+			key = code + "\x00" + key
+
+			// Wait for any other request with the same key to finish
+			var done chan struct{}
+			for done == nil {
+				if reply, ok := cache.Get(key); ok {
+					return reply, nil
+				}
+
+				lock.Lock()
+				wait, busy := inFlight[key]
+				if !busy {
+					done = make(chan struct{})
+					inFlight[key] = done
+				}
+				lock.Unlock()
+
+				if busy {
+					select {
+					case <-wait:
+					case <-ctx.Done():
+						return nil, ctx.Err()
+					}
+				}
+			}
+			defer func() {
+				lock.Lock()
+				delete(inFlight, key)
+				lock.Unlock()
+				close(done)
+			}()
+
+			// The request ahead of this one may have finished between the cache check and
+			// claiming the key
+			if reply, ok := cache.Get(key); ok {
+				return reply, nil
+			}
+
+			reply, err := next(ctx, doc)
+			if err != nil {
+				return nil, err
+			}
+			if reply != nil {
+				reply.Freeze()
+			}
+			cache.Put(key, reply)
+			return reply, nil
+		}
+	}
+}
+
+// MemoryDedupCache is a DedupCache which keeps replies in memory for a set amount of time
+type MemoryDedupCache struct {
+	lock       sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[string]dedupEntry
+
+	// order holds the keys in the order they were added, which is also the order in which they
+	// expire
+	order []string
+}
+
+type dedupEntry struct {
+	reply   *Document
+	expires time.Time
+}
+
+// NewMemoryDedupCache creates a MemoryDedupCache which keeps replies for the specified amount of
+// time. Once it holds maxEntries replies, the oldest are dropped to make room. Zero means no
+// limit.
+func NewMemoryDedupCache(ttl time.Duration, maxEntries int) *MemoryDedupCache {
+	return &MemoryDedupCache{ttl: ttl, maxEntries: maxEntries,
+		entries: make(map[string]dedupEntry)}
+}
+
+// Get returns the reply saved for a key if it hasn't expired
+func (c *MemoryDedupCache) Get(key string) (*Document, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.reply, true
+}
+
+// Put saves the reply for a key
+func (c *MemoryDedupCache) Put(key string, reply *Document) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	for len(c.order) > 0 {
+		oldest := c.order[0]
+		if now.Before(c.entries[oldest].expires) &&
+			(c.maxEntries == 0 || len(c.entries) < c.maxEntries) {
+			break
+		}
+		delete(c.entries, oldest)
+		c.order = c.order[1:]
+	}
+
+	if _, exists := c.entries[key]; !exists {
+		c.order = append(c.order, key)
+	}
+	c.entries[key] = dedupEntry{reply: reply, expires: now.Add(c.ttl)}
+}
+
+// Len returns the number of replies in the cache, including any which have expired but haven't
+// been dropped yet
+func (c *MemoryDedupCache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.entries)
+}
@@ -0,0 +1,107 @@
+package oganesson
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	var calls int32
+	failNext := false
+	handler := func(ctx context.Context, doc *Document) (*Document, error) {
+		atomic.AddInt32(&calls, 1)
+		if failNext {
+			failNext = false
+			return nil, errors.New("temporary failure")
+		}
+		time.Sleep(20 * time.Millisecond)
+		b := NewDocumentBuilder(nil)
+		b.AddInt32("call", atomic.LoadInt32(&calls))
+		return b.Build()
+	}
+	h := IdempotencyMiddleware(NewMemoryDedupCache(time.Minute, 0))(handler)
+
+	newRequest := func(code string, key string) *Document {
+		b := NewDocumentBuilder(nil)
+		b.AddString(MsgCodeField, code)
+		doc, _ := b.Build()
+		if key != "" {
+			doc.SetIdempotencyKey(key)
+		}
+		return doc
+	}
+
+	// Retries which arrive while the first request is still running wait for its reply
+	var wg sync.WaitGroup
+	replies := make([]*Document, 5)
+	for i := range replies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			replies[i], _ = h(context.Background(), newRequest("PAY", "key1"))
+		}(i)
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Fatalf("Handler ran %d times for the same key", calls)
+	}
+	for _, reply := range replies {
+		if reply != replies[0] || !reply.IsFrozen() {
+			t.Fatalf("Retry didn't get the saved reply")
+		}
+	}
+
+	// Keys are separate for each message code, and requests without keys always run
+	h(context.Background(), newRequest("REFUND", "key1"))
+	h(context.Background(), newRequest("PAY", ""))
+	h(context.Background(), newRequest("PAY", ""))
+	if calls != 4 {
+		t.Fatalf("Handler ran %d times instead of 4", calls)
+	}
+
+	// Failures aren't saved
+	failNext = true
+	if _, err := h(context.Background(), newRequest("PAY", "key2")); err == nil {
+		t.Fatalf("Handler error was lost")
+	}
+	if _, err := h(context.Background(), newRequest("PAY", "key2")); err != nil || calls != 6 {
+		t.Fatalf("Failed request wasn't run again")
+	}
+}
+
+func TestMemoryDedupCache(t *testing.T) {
+	c := NewMemoryDedupCache(time.Minute, 2)
+	c.Put("a", nil)
+	c.Put("b", NewDocument())
+	c.Put("c", NewDocument())
+	if _, ok := c.Get("a"); ok || c.Len() != 2 {
+		t.Fatalf("Oldest entry wasn't dropped")
+	}
+	if reply, ok := c.Get("b"); !ok || reply == nil {
+		t.Fatalf("Entry wasn't saved")
+	}
+
+	c = NewMemoryDedupCache(time.Millisecond, 0)
+	c.Put("a", nil)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := c.Get("a"); ok {
+		t.Fatalf("Expired entry was returned")
+	}
+	c.Put("b", nil)
+	if c.Len() != 1 {
+		t.Fatalf("Expired entry wasn't dropped")
+	}
+
+	doc := NewDocument()
+	key, err := doc.SetIdempotencyKey("")
+	if err != nil || len(key) != 36 {
+		t.Fatalf("Idempotency key wasn't generated: %v", err)
+	}
+	if got, _ := doc.IdempotencyKey(); got != key {
+		t.Fatalf("Wrong idempotency key %s", got)
+	}
+}
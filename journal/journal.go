@@ -0,0 +1,48 @@
+// Package journal works with journals of recorded traffic. A journal is a file of Documents
+// written back-to-back with oganesson.DocumentStreamWriter, so recording one needs nothing more
+// than a file and a DocumentStreamWriter.
+package journal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/darkwyrm/oganesson"
+)
+
+// ExportJSONL streams each Document in the journal at the specified path to the Writer as one
+// line of JSON, using the lossless JSON mapping of the oganesson package. This makes recorded
+// traffic easy to work with in tools like jq. Export stops at the first corrupt Document.
+func ExportJSONL(w io.Writer, path string) error {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return WriteJSONL(w, f)
+}
+
+// WriteJSONL does the same thing as ExportJSONL(), but reads the journal from a Reader
+func WriteJSONL(w io.Writer, r io.Reader) error {
+
+	sr := oganesson.NewDocumentStreamReader(r)
+	enc := json.NewEncoder(w)
+	for index := 0; ; index++ {
+		doc, err := sr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("journal document %d: %w", index, err)
+		}
+
+		// Encode() ends each value with a newline, which is all JSON Lines needs
+		if err := enc.Encode(doc); err != nil {
+			return fmt.Errorf("journal document %d: %w", index, err)
+		}
+	}
+}
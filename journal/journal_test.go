@@ -0,0 +1,46 @@
+package journal
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/darkwyrm/oganesson"
+)
+
+func TestExportJSONL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.journal")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Error creating journal: %s", err.Error())
+	}
+
+	sw := oganesson.NewDocumentStreamWriter(f)
+	for _, value := range []string{"one", "two"} {
+		doc := oganesson.NewDocument()
+		doc.AttachString("value", value)
+		if err := sw.Write(doc); err != nil {
+			t.Fatalf("Error writing journal: %s", err.Error())
+		}
+	}
+	f.Close()
+
+	var out bytes.Buffer
+	if err := ExportJSONL(&out, path); err != nil {
+		t.Fatalf("ExportJSONL failed: %s", err.Error())
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("ExportJSONL line count mismatch: %d", len(lines))
+	}
+	if lines[1] != `{"items":[{"type":"String","value":"two"}]}` {
+		t.Fatalf("ExportJSONL output mismatch: %s", lines[1])
+	}
+
+	if err := ExportJSONL(&out, path+".missing"); err == nil {
+		t.Fatalf("ExportJSONL didn't fail for a missing file")
+	}
+}
@@ -0,0 +1,306 @@
+package oganesson
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"strconv"
+	"unicode/utf8"
+)
+
+// This file contains the JSON mapping for Segments and Documents. It is lossless: every segment
+// converts to JSON and back without any change to its type or value. Each segment becomes an
+// object with the type's name and its value, such as {"type":"Int8","value":-3}. Values use the
+// natural JSON type where it can hold them exactly:
+//
+//   - 8-, 16- and 32-bit integers are numbers, but 64-bit integers are decimal strings because
+//     most JSON tools can't represent them exactly
+//   - Floats are numbers, except for NaN and the infinities, which are the strings "NaN",
+//     "+Inf", and "-Inf". The bits of a NaN's payload are not kept.
+//   - Strings are strings unless they aren't valid UTF-8, in which case the value is the base64
+//     encoding of the payload and the object has "encoding":"base64"
+//   - Binary values and any registered extension types are base64 strings
+//   - DocumentStart, DocumentEnd, and container index segments are numbers
+
+// jsonSegment is the JSON representation of a Segment
+type jsonSegment struct {
+	Type     string          `json:"type"`
+	Value    json.RawMessage `json:"value"`
+	Encoding string          `json:"encoding,omitempty"`
+}
+
+// jsonDocument is the JSON representation of a Document
+type jsonDocument struct {
+	Items []Segment `json:"items"`
+}
+
+// MarshalJSON implements json.Marshaler
+func (seg Segment) MarshalJSON() ([]byte, error) {
+
+	out := jsonSegment{Type: TypeName(seg.Type)}
+	if !isTypeCodeValid(seg.Type) {
+		return nil, ErrInvalidSegment
+	}
+
+	var value interface{}
+	var err error
+	switch seg.Type {
+	case DFDocumentStart:
+		value, err = seg.GetDocStart()
+	case DFDocumentEnd:
+		value, err = seg.GetDocEnd()
+	case DFInt8Type:
+		value, err = seg.GetInt8()
+	case DFUInt8Type:
+		value, err = seg.GetUInt8()
+	case DFInt16Type:
+		value, err = seg.GetInt16()
+	case DFUInt16Type:
+		value, err = seg.GetUInt16()
+	case DFInt32Type:
+		value, err = seg.GetInt32()
+	case DFUInt32Type:
+		value, err = seg.GetUInt32()
+	case DFInt64Type:
+		var v int64
+		v, err = seg.GetInt64()
+		value = strconv.FormatInt(v, 10)
+	case DFUInt64Type:
+		var v uint64
+		v, err = seg.GetUInt64()
+		value = strconv.FormatUint(v, 10)
+	case DFBoolType:
+		value, err = seg.GetBool()
+	case DFFloat32Type:
+		var v float32
+		v, err = seg.GetFloat32()
+		value = jsonFloat(float64(v), 32)
+	case DFFloat64Type:
+		var v float64
+		v, err = seg.GetFloat64()
+		value = jsonFloat(v, 64)
+	case DFStringType, DFHugeStringType:
+		if utf8.Valid(seg.Value) {
+			value = string(seg.Value)
+		} else {
+			value = base64.StdEncoding.EncodeToString(seg.Value)
+			out.Encoding = "base64"
+		}
+	case DFMapType, DFLargeMapType, DFKeyedMapType:
+		value, err = seg.GetMapIndex()
+	case DFListType, DFLargeListType:
+		value, err = seg.GetListIndex()
+	default:
+		value = base64.StdEncoding.EncodeToString(seg.Value)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if out.Value, err = json.Marshal(value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (seg *Segment) UnmarshalJSON(data []byte) error {
+
+	var in jsonSegment
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+
+	typeCode, ok := typeCodeForName(in.Type)
+	if !ok {
+		return ErrInvalidSegment
+	}
+
+	var out Segment
+	var err error
+	switch typeCode {
+	case DFDocumentStart:
+		var v uint8
+		if err = json.Unmarshal(in.Value, &v); err == nil {
+			err = out.SetDocStart(v)
+		}
+	case DFDocumentEnd:
+		var v uint64
+		if err = json.Unmarshal(in.Value, &v); err == nil {
+			err = out.SetDocEnd(v)
+		}
+	case DFInt8Type:
+		var v int8
+		if err = json.Unmarshal(in.Value, &v); err == nil {
+			err = out.SetInt8(v)
+		}
+	case DFUInt8Type:
+		var v uint8
+		if err = json.Unmarshal(in.Value, &v); err == nil {
+			err = out.SetUInt8(v)
+		}
+	case DFInt16Type:
+		var v int16
+		if err = json.Unmarshal(in.Value, &v); err == nil {
+			err = out.SetInt16(v)
+		}
+	case DFUInt16Type:
+		var v uint16
+		if err = json.Unmarshal(in.Value, &v); err == nil {
+			err = out.SetUInt16(v)
+		}
+	case DFInt32Type:
+		var v int32
+		if err = json.Unmarshal(in.Value, &v); err == nil {
+			err = out.SetInt32(v)
+		}
+	case DFUInt32Type:
+		var v uint32
+		if err = json.Unmarshal(in.Value, &v); err == nil {
+			err = out.SetUInt32(v)
+		}
+	case DFInt64Type:
+		var v string
+		if err = json.Unmarshal(in.Value, &v); err == nil {
+			var n int64
+			if n, err = strconv.ParseInt(v, 10, 64); err == nil {
+				err = out.SetInt64(n)
+			}
+		}
+	case DFUInt64Type:
+		var v string
+		if err = json.Unmarshal(in.Value, &v); err == nil {
+			var n uint64
+			if n, err = strconv.ParseUint(v, 10, 64); err == nil {
+				err = out.SetUInt64(n)
+			}
+		}
+	case DFBoolType:
+		var v bool
+		if err = json.Unmarshal(in.Value, &v); err == nil {
+			err = out.SetBool(v)
+		}
+	case DFFloat32Type:
+		var v float64
+		if v, err = parseJSONFloat(in.Value, 32); err == nil {
+			err = out.SetFloat32(float32(v))
+		}
+	case DFFloat64Type:
+		var v float64
+		if v, err = parseJSONFloat(in.Value, 64); err == nil {
+			err = out.SetFloat64(v)
+		}
+	case DFMapType, DFLargeMapType, DFKeyedMapType, DFListType, DFLargeListType:
+		var v uint64
+		if err = json.Unmarshal(in.Value, &v); err == nil {
+			out.Type = typeCode
+			out.Value = make([]byte, fixedSegmentSize(typeCode))
+			for i := len(out.Value) - 1; i >= 0; i-- {
+				out.Value[i] = uint8(v)
+				v >>= 8
+			}
+			if v != 0 {
+				err = ErrSize
+			}
+		}
+	default:
+		var v string
+		if err = json.Unmarshal(in.Value, &v); err == nil {
+			out.Type = typeCode
+			if in.Encoding == "" && (typeCode == DFStringType || typeCode == DFHugeStringType) {
+				out.Value = []byte(v)
+			} else {
+				out.Value, err = base64.StdEncoding.DecodeString(v)
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	*seg = out
+	return validateSegment(out)
+}
+
+// MarshalJSON implements json.Marshaler for Documents. The Document is an object with its
+// segments in the "items" field.
+func (doc Document) MarshalJSON() ([]byte, error) {
+
+	out := jsonDocument{Items: make([]Segment, len(doc.Items))}
+	for i, item := range doc.Items {
+		seg, ok := item.(*Segment)
+		if !ok {
+			return nil, ErrInvalidSegment
+		}
+		out.Items[i] = *seg
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON implements json.Unmarshaler for Documents
+func (doc *Document) UnmarshalJSON(data []byte) error {
+
+	if doc.frozen {
+		return ErrFrozen
+	}
+
+	var in jsonDocument
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+
+	doc.Items = make([]SegContainer, len(in.Items))
+	for i := range in.Items {
+		doc.Items[i] = &in.Items[i]
+	}
+	doc.updateWireMsgFields()
+	return nil
+}
+
+// typeCodeForName is the reverse of TypeName()
+func typeCodeForName(name string) (uint8, bool) {
+	for code := 1; code < 255; code++ {
+		if isTypeCodeValid(uint8(code)) && TypeName(uint8(code)) == name {
+			return uint8(code), true
+		}
+	}
+	return 0, false
+}
+
+// jsonFloat returns a float in a form that can be stored in JSON without any loss
+func jsonFloat(v float64, bitSize int) interface{} {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return json.Number(strconv.FormatFloat(v, 'g', -1, bitSize))
+}
+
+// parseJSONFloat is the counterpart to jsonFloat()
+func parseJSONFloat(data json.RawMessage, bitSize int) (float64, error) {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		return strconv.ParseFloat(s, bitSize)
+	}
+
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(n), bitSize)
+}
+
+// MarshalKeyedJSON returns the JSON for a keyed Document as an object which maps each field's name
+// to its segment object, such as {"count":{"type":"UInt16","value":3}}. This is the form that
+// schemas exported by ExportJSONSchema() describe. Documents which aren't keyed return an error.
+func (doc *Document) MarshalKeyedJSON() ([]byte, error) {
+	fields, err := doc.fieldMap()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
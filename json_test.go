@@ -0,0 +1,80 @@
+package oganesson
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestSegmentJSON(t *testing.T) {
+
+	var mapIndex Segment
+	mapIndex.SetMapIndex(SegmentMap{"a": Segment{}, "b": Segment{}})
+
+	segments := []Segment{
+		{DFInt8Type, []byte{0xfd}},
+		{DFUInt32Type, []byte{0, 1, 0, 0}},
+		{DFInt64Type, []byte{0x80, 0, 0, 0, 0, 0, 0, 1}},
+		{DFUInt64Type, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{DFBoolType, []byte{1}},
+		{DFStringType, []byte("abc")},
+		{DFStringType, []byte{0xff, 0xfe}},
+		{DFBinaryType, []byte{0, 1, 2}},
+		mapIndex,
+	}
+
+	var f32, f64, nan Segment
+	f32.SetFloat32(0.1)
+	f64.SetFloat64(-1.5e300)
+	nan.SetFloat64(math.NaN())
+	segments = append(segments, f32, f64, nan)
+
+	for _, seg := range segments {
+		data, err := json.Marshal(seg)
+		if err != nil {
+			t.Fatalf("Error marshaling %s: %s", seg.ToString(), err.Error())
+		}
+
+		var out Segment
+		if err := json.Unmarshal(data, &out); err != nil {
+			t.Fatalf("Error unmarshaling %s: %s", string(data), err.Error())
+		}
+		if out.Type != seg.Type || !bytes.Equal(out.Value, seg.Value) {
+			t.Fatalf("JSON round trip mismatch for %s", string(data))
+		}
+	}
+
+	data, _ := json.Marshal(Segment{DFInt64Type, []byte{0, 0, 0, 0, 0, 0, 0, 42}})
+	if string(data) != `{"type":"Int64","value":"42"}` {
+		t.Fatalf("Int64 JSON mismatch: %s", string(data))
+	}
+
+	var out Segment
+	if err := json.Unmarshal([]byte(`{"type":"Bogus","value":1}`), &out); err != ErrInvalidSegment {
+		t.Fatalf("UnmarshalJSON accepted a bad type name")
+	}
+}
+
+func TestDocumentJSON(t *testing.T) {
+	doc := NewDocument()
+	doc.AttachString("testString", "abcdef")
+	doc.AttachInt64("testInt", 42)
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("Error marshaling document: %s", err.Error())
+	}
+	if string(data) != `{"items":[{"type":"String","value":"abcdef"},`+
+		`{"type":"Int64","value":"42"}]}` {
+		t.Fatalf("Document JSON mismatch: %s", string(data))
+	}
+
+	var out Document
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatalf("Error unmarshaling document: %s", err.Error())
+	}
+	if len(out.Items) != 2 || out.Items[1].(*Segment).Value[7] != 42 {
+		t.Fatalf("Document JSON round trip mismatch")
+	}
+}
@@ -0,0 +1,144 @@
+package oganesson
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+)
+
+// This file contains the export of Schemas as JSON Schema documents so that implementations in
+// other languages can check their messages against the same rules. The exported schema describes
+// the JSON written by Document.MarshalKeyedJSON(), an object which maps each field's name to the
+// segment object written by Segment.MarshalJSON(), such as {"type":"Int8","value":-3}. It doesn't
+// apply to the list of segments written by Document.MarshalJSON(). The Huge and Large variants of
+// a type are accepted wherever the regular one is, the same as Schema.Validate().
+//
+// Check functions can't be exported, so the constraints they impose are left out. Fields with
+// names starting with ReservedKeyPrefix are always allowed. Like Schema.Validate(), the
+// MsgCodeField field is only allowed if the schema has it.
+
+// jsonSchemaDraft is the version of the JSON Schema spec that exported schemas follow
+const jsonSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// ExportJSONSchema returns a JSON Schema document describing the JSON written by
+// Document.MarshalKeyedJSON() for the messages accepted by the schema.
+// ErrTypeError is returned if one of its fields has an invalid type code.
+func ExportJSONSchema(schema *Schema) ([]byte, error) {
+
+	// Required fields are listed in the schema's field order if it has one so that the output is
+	// stable for anyone diffing it
+	names := schema.FieldOrder()
+	if names == nil {
+		names = make([]string, 0, len(schema.fields))
+		for name := range schema.fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	properties := make(map[string]interface{}, len(names))
+	required := make([]string, 0)
+	for _, name := range names {
+		spec := schema.fields[name]
+		field, err := jsonSchemaField(spec.Type)
+		if err != nil {
+			return nil, fmt.Errorf("%w: field '%s'", err, name)
+		}
+		properties[name] = field
+		if spec.Required {
+			required = append(required, name)
+		}
+	}
+
+	out := map[string]interface{}{
+		"$schema":    jsonSchemaDraft,
+		"type":       "object",
+		"properties": properties,
+		"patternProperties": map[string]interface{}{
+			"^" + regexp.QuoteMeta(ReservedKeyPrefix): map[string]interface{}{},
+		},
+		"additionalProperties": false,
+	}
+
+	if len(required) > 0 {
+		out["required"] = required
+	}
+	return json.MarshalIndent(out, "", "  ")
+}
+
+// jsonSchemaField returns the JSON Schema for the segment object of a field of the specified type
+func jsonSchemaField(typeCode uint8) (map[string]interface{}, error) {
+
+	if !isTypeCodeValid(typeCode) || typeCode == DFDocumentStart || typeCode == DFDocumentEnd {
+		return nil, ErrTypeError
+	}
+
+	names := []string{TypeName(typeCode)}
+	switch typeCode {
+	case DFStringType, DFHugeStringType:
+		names = []string{TypeName(DFStringType), TypeName(DFHugeStringType)}
+	case DFBinaryType, DFHugeBinaryType:
+		names = []string{TypeName(DFBinaryType), TypeName(DFHugeBinaryType)}
+	case DFMapType, DFLargeMapType:
+		names = []string{TypeName(DFMapType), TypeName(DFLargeMapType)}
+	case DFListType, DFLargeListType:
+		names = []string{TypeName(DFListType), TypeName(DFLargeListType)}
+	}
+
+	properties := map[string]interface{}{
+		"type":  map[string]interface{}{"enum": names},
+		"value": jsonSchemaValue(typeCode),
+	}
+	if typeCode == DFStringType || typeCode == DFHugeStringType {
+		properties["encoding"] = map[string]interface{}{"const": "base64"}
+	}
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             []string{"type", "value"},
+		"additionalProperties": false,
+	}, nil
+}
+
+// jsonSchemaValue returns the JSON Schema for the value of a segment object of the specified type
+func jsonSchemaValue(typeCode uint8) map[string]interface{} {
+
+	integer := func(min int64, max uint64) map[string]interface{} {
+		return map[string]interface{}{"type": "integer", "minimum": min, "maximum": max}
+	}
+	nonFinite := map[string]interface{}{"enum": []string{"NaN", "+Inf", "-Inf"}}
+
+	switch typeCode {
+	case DFInt8Type:
+		return integer(math.MinInt8, math.MaxInt8)
+	case DFUInt8Type:
+		return integer(0, math.MaxUint8)
+	case DFInt16Type:
+		return integer(math.MinInt16, math.MaxInt16)
+	case DFUInt16Type:
+		return integer(0, math.MaxUint16)
+	case DFInt32Type:
+		return integer(math.MinInt32, math.MaxInt32)
+	case DFUInt32Type:
+		return integer(0, math.MaxUint32)
+	case DFInt64Type:
+		return map[string]interface{}{"type": "string", "pattern": "^-?[0-9]+$"}
+	case DFUInt64Type:
+		return map[string]interface{}{"type": "string", "pattern": "^[0-9]+$"}
+	case DFBoolType:
+		return map[string]interface{}{"type": "boolean"}
+	case DFFloat32Type, DFFloat64Type:
+		return map[string]interface{}{
+			"anyOf": []interface{}{map[string]interface{}{"type": "number"}, nonFinite},
+		}
+	case DFStringType, DFHugeStringType:
+		return map[string]interface{}{"type": "string"}
+	case DFMapType, DFLargeMapType, DFKeyedMapType, DFListType, DFLargeListType:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	}
+
+	// Binary values and extension types are base64 strings
+	return map[string]interface{}{"type": "string", "contentEncoding": "base64"}
+}
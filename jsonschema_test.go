@@ -0,0 +1,239 @@
+package oganesson
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+// checkJSONSchema validates a decoded JSON value against a decoded JSON Schema. It only handles
+// the keywords used by ExportJSONSchema().
+func checkJSONSchema(schema map[string]interface{}, value interface{}) error {
+
+	if options, ok := schema["anyOf"].([]interface{}); ok {
+		for _, option := range options {
+			if checkJSONSchema(option.(map[string]interface{}), value) == nil {
+				return nil
+			}
+		}
+		return fmt.Errorf("%v matches none of the options", value)
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, v := range enum {
+			found = found || v == value
+		}
+		if !found {
+			return fmt.Errorf("%v isn't one of %v", value, enum)
+		}
+	}
+	if c, ok := schema["const"]; ok && c != value {
+		return fmt.Errorf("%v isn't %v", value, c)
+	}
+
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%v isn't an object", value)
+		}
+		for _, name := range schema["required"].([]interface{}) {
+			if _, ok := obj[name.(string)]; !ok {
+				return fmt.Errorf("required property %s is missing", name)
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		patterns, _ := schema["patternProperties"].(map[string]interface{})
+		for name, v := range obj {
+			if sub, ok := properties[name]; ok {
+				if err := checkJSONSchema(sub.(map[string]interface{}), v); err != nil {
+					return fmt.Errorf("%s: %w", name, err)
+				}
+				continue
+			}
+			matched := false
+			for pattern := range patterns {
+				matched = matched || regexp.MustCompile(pattern).MatchString(name)
+			}
+			if !matched && schema["additionalProperties"] == false {
+				return fmt.Errorf("property %s isn't allowed", name)
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%v isn't a string", value)
+		}
+		pattern, ok := schema["pattern"].(string)
+		if ok && !regexp.MustCompile(pattern).MatchString(str) {
+			return fmt.Errorf("%s doesn't match %s", str, pattern)
+		}
+	case "integer", "number":
+		num, ok := value.(float64)
+		if !ok || (schema["type"] == "integer" && num != float64(int64(num))) {
+			return fmt.Errorf("%v isn't an %s", value, schema["type"])
+		}
+		if min, ok := schema["minimum"].(float64); ok && num < min {
+			return fmt.Errorf("%v is less than %v", num, min)
+		}
+		if max, ok := schema["maximum"].(float64); ok && num > max {
+			return fmt.Errorf("%v is more than %v", num, max)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%v isn't a boolean", value)
+		}
+	}
+	return nil
+}
+
+func TestExportJSONSchema(t *testing.T) {
+
+	schema := NewSchema(
+		FieldSpec{Name: "name", Type: DFStringType, Required: true},
+		FieldSpec{Name: "count", Type: DFUInt16Type},
+		FieldSpec{Name: "id", Type: DFInt64Type, Required: true},
+		FieldSpec{Name: "data", Type: DFHugeBinaryType},
+	)
+	if err := schema.SetFieldOrder("name", "id"); err != nil {
+		t.Fatalf("SetFieldOrder error: %s", err.Error())
+	}
+
+	data, err := ExportJSONSchema(schema)
+	if err != nil {
+		t.Fatalf("ExportJSONSchema error: %s", err.Error())
+	}
+	var out struct {
+		Type       string                     `json:"type"`
+		Required   []string                   `json:"required"`
+		Properties map[string]json.RawMessage `json:"properties"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatalf("Exported schema isn't valid JSON: %s", err.Error())
+	}
+
+	if out.Type != "object" || !reflect.DeepEqual(out.Required, []string{"name", "id"}) {
+		t.Fatalf("Wrong object schema: %s", string(data))
+	}
+	for _, name := range []string{"name", "count", "id", "data"} {
+		if _, ok := out.Properties[name]; !ok {
+			t.Fatalf("Property %s missing from %s", name, string(data))
+		}
+	}
+	if _, ok := out.Properties[MsgCodeField]; ok {
+		t.Fatalf("Undeclared %s field is allowed by %s", MsgCodeField, string(data))
+	}
+
+	var field struct {
+		Properties struct {
+			Type  struct{ Enum []string }
+			Value map[string]interface{}
+		}
+	}
+	json.Unmarshal(out.Properties["data"], &field)
+	if !reflect.DeepEqual(field.Properties.Type.Enum, []string{"Binary", "HugeBinary"}) ||
+		field.Properties.Value["contentEncoding"] != "base64" {
+		t.Fatalf("Wrong schema for Binary field: %s", string(out.Properties["data"]))
+	}
+	json.Unmarshal(out.Properties["count"], &field)
+	if field.Properties.Value["type"] != "integer" || field.Properties.Value["maximum"] != 65535.0 {
+		t.Fatalf("Wrong schema for UInt16 field: %s", string(out.Properties["count"]))
+	}
+
+	// The JSON for a segment of a field's type has to use the names in the schema
+	var seg Segment
+	seg.SetInt64(-5)
+	segData, _ := json.Marshal(seg)
+	var segOut struct{ Type, Value string }
+	json.Unmarshal(segData, &segOut)
+	json.Unmarshal(out.Properties["id"], &field)
+	if field.Properties.Type.Enum[0] != segOut.Type || field.Properties.Value["type"] != "string" {
+		t.Fatalf("Schema for Int64 field doesn't match segment JSON %s", string(segData))
+	}
+
+	bad := NewSchema(FieldSpec{Name: "bad", Type: DFUpperBound})
+	if _, err := ExportJSONSchema(bad); !errors.Is(err, ErrTypeError) {
+		t.Fatalf("Invalid type code wasn't caught: %v", err)
+	}
+}
+
+func TestJSONSchemaMatchesDocuments(t *testing.T) {
+
+	schema := NewSchema(
+		FieldSpec{Name: "name", Type: DFStringType, Required: true},
+		FieldSpec{Name: "count", Type: DFUInt16Type},
+		FieldSpec{Name: "id", Type: DFInt64Type, Required: true},
+		FieldSpec{Name: "enabled", Type: DFBoolType},
+		FieldSpec{Name: "ratio", Type: DFFloat64Type},
+		FieldSpec{Name: "data", Type: DFBinaryType},
+	)
+	data, err := ExportJSONSchema(schema)
+	if err != nil {
+		t.Fatalf("ExportJSONSchema error: %s", err.Error())
+	}
+	var exported map[string]interface{}
+	if err := json.Unmarshal(data, &exported); err != nil {
+		t.Fatalf("Exported schema isn't valid JSON: %s", err.Error())
+	}
+
+	// check builds a document with the builder, which runs Schema.Validate(), and checks its JSON
+	// against the exported schema
+	check := func(build func(b *DocumentBuilder)) (error, error) {
+		b := NewDocumentBuilder(nil)
+		build(b)
+		doc, err := b.Build()
+		if err != nil {
+			t.Fatalf("Error building document: %s", err.Error())
+		}
+		fields, _ := doc.fieldMap()
+		docJSON, err := doc.MarshalKeyedJSON()
+		if err != nil {
+			t.Fatalf("MarshalKeyedJSON error: %s", err.Error())
+		}
+		var value interface{}
+		json.Unmarshal(docJSON, &value)
+		return schema.Validate(fields), checkJSONSchema(exported, value)
+	}
+
+	validateErr, jsonErr := check(func(b *DocumentBuilder) {
+		b.AddString("name", "widget")
+		b.AddUInt16("count", 3)
+		b.AddInt64("id", -9000000000)
+		b.AddBool("enabled", true)
+		b.AddFloat64("ratio", 0.25)
+		b.AddBinary("data", []byte{1, 2, 3})
+		b.Add(ReservedKeyPrefix+"trace", Segment{Type: DFUInt8Type, Value: []byte{1}})
+	})
+	if validateErr != nil || jsonErr != nil {
+		t.Fatalf("Valid document was rejected: %v, %v", validateErr, jsonErr)
+	}
+
+	// Both the schema and its export reject the same mistakes
+	bad := map[string]func(b *DocumentBuilder){
+		"missing field": func(b *DocumentBuilder) {
+			b.AddString("name", "widget")
+		},
+		"wrong type": func(b *DocumentBuilder) {
+			b.AddString("name", "widget")
+			b.AddInt32("id", 5)
+		},
+		"unknown field": func(b *DocumentBuilder) {
+			b.AddString("name", "widget")
+			b.AddInt64("id", 5)
+			b.AddString("color", "red")
+		},
+		"undeclared message code": func(b *DocumentBuilder) {
+			b.AddString(MsgCodeField, "ADD")
+			b.AddString("name", "widget")
+			b.AddInt64("id", 5)
+		},
+	}
+	for name, build := range bad {
+		if validateErr, jsonErr := check(build); validateErr == nil || jsonErr == nil {
+			t.Fatalf("Document with %s wasn't rejected: %v, %v", name, validateErr, jsonErr)
+		}
+	}
+}
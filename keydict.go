@@ -0,0 +1,222 @@
+package oganesson
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"math"
+	"sort"
+)
+
+var ErrKeyDictionary = errors.New("key dictionary mismatch")
+
+// This file contains key dictionaries, which shrink keyed Documents whose size is mostly made up
+// of field names. Each name in the dictionary is given a small integer ID, and CompactKeys()
+// writes the Document's map as a KeyedMap with the IDs in place of the names. Names which aren't
+// in the dictionary are left as they are. The ID of the dictionary itself is stored in the
+// HeaderKeyDictionaryField so that the reader can make sure it has the same one before calling
+// ExpandKeys() to put the names back.
+//
+// A PacketSession with a KeyDictionary does this by itself for the keyed Documents it sends and
+// receives, so both sides just have to set up the same dictionary.
+
+// keyDictionaryIDSize is the number of bytes of a dictionary's hash used as its ID
+const keyDictionaryIDSize = 8
+
+// KeyDictionary assigns IDs to a set of field names. It can't be changed once created, so it is
+// safe for concurrent use.
+type KeyDictionary struct {
+	keys []string
+	ids  map[string]uint16
+	id   []byte
+}
+
+// NewKeyDictionary creates a dictionary for the specified field names. Each name's ID is its
+// position in the list, so both sides must create the dictionary from the same names in the same
+// order. Up to 65536 names can be given, and they must not repeat.
+func NewKeyDictionary(keys ...string) (*KeyDictionary, error) {
+
+	if len(keys) > math.MaxUint16+1 {
+		return nil, ErrSize
+	}
+
+	out := KeyDictionary{keys: append([]string(nil), keys...), ids: make(map[string]uint16)}
+	var hashData bytes.Buffer
+	for i, key := range keys {
+		if _, exists := out.ids[key]; exists || key == "" || len(key) > 65535 {
+			return nil, ErrInvalidKey
+		}
+		out.ids[key] = uint16(i)
+		hashData.WriteString(key)
+		hashData.WriteByte(0)
+	}
+
+	sum := sha256.Sum256(hashData.Bytes())
+	out.id = sum[:keyDictionaryIDSize]
+	return &out, nil
+}
+
+// BuildKeyDictionary creates a dictionary from the field names of a set of schemas. The names of
+// each schema are sorted, so both sides only need to use the same schemas in the same order.
+func BuildKeyDictionary(schemas ...*Schema) (*KeyDictionary, error) {
+
+	keys := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, schema := range schemas {
+		names := make([]string, 0, len(schema.fields))
+		for name := range schema.fields {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		keys = append(keys, names...)
+	}
+	return NewKeyDictionary(keys...)
+}
+
+// ID returns the ID of the dictionary, which is a hash of its names
+func (kd *KeyDictionary) ID() []byte {
+	return append([]byte(nil), kd.id...)
+}
+
+// Keys returns the field names in the dictionary in the order of their IDs
+func (kd *KeyDictionary) Keys() []string {
+	return append([]string(nil), kd.keys...)
+}
+
+// CompactKeys replaces the names of a keyed Document's fields which are in the dictionary with
+// their IDs. The Document can't be used as a keyed Document again until ExpandKeys() has been
+// called.
+func (doc *Document) CompactKeys(kd *KeyDictionary) error {
+
+	if doc.frozen {
+		return ErrFrozen
+	}
+	fields, err := doc.fieldMap()
+	if err != nil {
+		return err
+	}
+	if err := fields.SetBinary(HeaderKeyDictionaryField, kd.id); err != nil {
+		return err
+	}
+
+	var index Segment
+	err = index.setContainerIndex(DFKeyedMapType, DFKeyedMapType, uint64(len(fields)))
+	if err != nil {
+		return err
+	}
+
+	items := make([]SegContainer, 0, len(fields)*2+1)
+	items = append(items, &index)
+	for _, name := range fields.Keys() {
+		var key Segment
+		id, ok := kd.ids[name]
+		switch {
+		case !ok || name == HeaderKeyDictionaryField:
+			err = key.SetString(name)
+		case id <= math.MaxUint8:
+			err = key.SetUInt8(uint8(id))
+		default:
+			err = key.SetUInt16(id)
+		}
+		if err != nil {
+			return err
+		}
+		value := fields[name]
+		items = append(items, &key, &value)
+	}
+
+	doc.Items = items
+	doc.updateWireMsgFields()
+	return nil
+}
+
+// ExpandKeys puts back the field names of a Document compacted by CompactKeys(). It returns
+// ErrKeyDictionary if the Document was compacted with a different dictionary.
+func (doc *Document) ExpandKeys(kd *KeyDictionary) error {
+
+	if doc.frozen {
+		return ErrFrozen
+	}
+	if !doc.hasCompactKeys() {
+		return ErrKeyDictionary
+	}
+
+	count, err := doc.Items[0].(*Segment).GetMapIndex()
+	if err != nil {
+		return err
+	}
+	if count > uint64(len(doc.Items)-1)/2 {
+		return ErrInvalidContainer
+	}
+
+	fields := make(SegmentMap, count)
+	for i := 1; i < 1+2*int(count); i += 2 {
+		key, keyOK := doc.Items[i].(*Segment)
+		value, valueOK := doc.Items[i+1].(*Segment)
+		if !keyOK || !valueOK {
+			return ErrInvalidContainer
+		}
+
+		var name string
+		switch key.Type {
+		case DFStringType:
+			name = string(key.Value)
+		case DFUInt8Type, DFUInt16Type:
+			var id uint16
+			if key.Type == DFUInt8Type {
+				var small uint8
+				small, err = key.GetUInt8()
+				id = uint16(small)
+			} else {
+				id, err = key.GetUInt16()
+			}
+			if err != nil {
+				return err
+			}
+			if int(id) >= len(kd.keys) {
+				return ErrKeyDictionary
+			}
+			name = kd.keys[id]
+		default:
+			return ErrInvalidKey
+		}
+
+		if _, exists := fields[name]; exists {
+			return ErrInvalidKey
+		}
+		fields[name] = *value
+	}
+
+	if id, err := fields.GetBinary(HeaderKeyDictionaryField); err != nil ||
+		!bytes.Equal(id, kd.id) {
+		return ErrKeyDictionary
+	}
+	delete(fields, HeaderKeyDictionaryField)
+
+	items, err := keyedItems(fields)
+	if err != nil {
+		return err
+	}
+	doc.Items = items
+	doc.updateWireMsgFields()
+	return nil
+}
+
+// hasCompactKeys returns true if the Document has been compacted by CompactKeys(). Only the
+// keys are checked, so this is cheap.
+func (doc *Document) hasCompactKeys() bool {
+
+	if len(doc.Items) == 0 || doc.Items[0].GetType() != DFKeyedMapType {
+		return false
+	}
+	for i := 1; i < len(doc.Items); i += 2 {
+		key, ok := doc.Items[i].(*Segment)
+		if ok && key.Type == DFStringType && string(key.Value) == HeaderKeyDictionaryField {
+			return true
+		}
+	}
+	return false
+}
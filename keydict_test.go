@@ -0,0 +1,96 @@
+package oganesson
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// keyDictionaryDocument returns a keyed Document for key dictionary tests
+func keyDictionaryDocument(t *testing.T) *Document {
+	fields := make(SegmentMap)
+	fields.SetString("RecipientAddress", "bob@example.com")
+	fields.SetInt64("DeliveryTimestamp", 1700000000)
+	fields.SetString("Note", "not in the dictionary")
+	items, err := keyedItems(fields)
+	if err != nil {
+		t.Fatalf("Error building keyed document: %s", err.Error())
+	}
+	return &Document{Items: items}
+}
+
+func TestNewKeyDictionary(t *testing.T) {
+	kd, err := NewKeyDictionary("RecipientAddress", "DeliveryTimestamp")
+	if err != nil {
+		t.Fatalf("Error creating key dictionary: %s", err.Error())
+	}
+	same, _ := NewKeyDictionary("RecipientAddress", "DeliveryTimestamp")
+	swapped, _ := NewKeyDictionary("DeliveryTimestamp", "RecipientAddress")
+	if !bytes.Equal(kd.ID(), same.ID()) || bytes.Equal(kd.ID(), swapped.ID()) {
+		t.Fatalf("Key dictionary IDs don't follow the keys and their order")
+	}
+
+	if _, err := NewKeyDictionary("a", "b", "a"); err != ErrInvalidKey {
+		t.Fatalf("Duplicate key wasn't caught: %v", err)
+	}
+	if _, err := NewKeyDictionary("a", ""); err != ErrInvalidKey {
+		t.Fatalf("Empty key wasn't caught: %v", err)
+	}
+
+	schema := NewSchema(FieldSpec{Name: "b", Type: DFStringType},
+		FieldSpec{Name: "a", Type: DFStringType})
+	other := NewSchema(FieldSpec{Name: "a", Type: DFStringType},
+		FieldSpec{Name: "c", Type: DFInt32Type})
+	built, err := BuildKeyDictionary(schema, other)
+	if err != nil {
+		t.Fatalf("Error building key dictionary: %s", err.Error())
+	}
+	if keys := built.Keys(); len(keys) != 3 || keys[0] != "a" || keys[1] != "b" ||
+		keys[2] != "c" {
+		t.Fatalf("Built key dictionary mismatch: %v", keys)
+	}
+}
+
+func TestCompactKeys(t *testing.T) {
+	kd, err := NewKeyDictionary("RecipientAddress", "DeliveryTimestamp")
+	if err != nil {
+		t.Fatalf("Error creating key dictionary: %s", err.Error())
+	}
+
+	doc := keyDictionaryDocument(t)
+	original, _ := doc.Flatten()
+	if err := doc.CompactKeys(kd); err != nil {
+		t.Fatalf("Error compacting keys: %s", err.Error())
+	}
+	compacted, _ := doc.Flatten()
+	if len(compacted) >= len(original) {
+		t.Fatalf("Compacted document isn't smaller: %d vs %d", len(compacted), len(original))
+	}
+	if bytes.Contains(compacted, []byte("RecipientAddress")) ||
+		!bytes.Contains(compacted, []byte("Note")) {
+		t.Fatalf("Compacted document has the wrong keys")
+	}
+
+	received := NewDocument()
+	if err := received.Unflatten(compacted); err != nil {
+		t.Fatalf("Error unflattening compacted document: %s", err.Error())
+	}
+	other, _ := NewKeyDictionary("DeliveryTimestamp", "RecipientAddress")
+	if err := received.ExpandKeys(other); !errors.Is(err, ErrKeyDictionary) {
+		t.Fatalf("Mismatched dictionary wasn't caught: %v", err)
+	}
+	if err := received.ExpandKeys(kd); err != nil {
+		t.Fatalf("Error expanding keys: %s", err.Error())
+	}
+	expanded, _ := received.Flatten()
+	if !bytes.Equal(expanded, original) {
+		t.Fatalf("Expanded document doesn't match the original")
+	}
+	if err := received.ExpandKeys(kd); !errors.Is(err, ErrKeyDictionary) {
+		t.Fatalf("Expanding a document without compacted keys wasn't caught: %v", err)
+	}
+
+	if err := NewDocument().CompactKeys(kd); err == nil {
+		t.Fatalf("Compacted a document which isn't keyed")
+	}
+}
@@ -0,0 +1,223 @@
+package oganesson
+
+import (
+	"io"
+)
+
+// This file contains SegmentMapOf, a map whose keys can be integers or binary data instead of only
+// strings. These maps are written with a KeyedMap index segment so that readers expecting string
+// keys reject them instead of misreading them.
+
+// BinaryKey is a map key which is written as a Binary segment instead of a String one. Go doesn't
+// allow byte slices as map keys, so the bytes are held in a string.
+type BinaryKey string
+
+// MapKey is the set of types which can be used as the keys of a SegmentMapOf
+type MapKey interface {
+	int8 | int16 | int32 | int64 | uint8 | uint16 | uint32 | uint64 | string | BinaryKey
+}
+
+// SegmentMapOf is a map of Segments keyed by any of the MapKey types. All of the keys in a map
+// have the same type, and reading a map whose keys are of a different type returns ErrInvalidKey.
+type SegmentMapOf[K MapKey] map[K]Segment
+
+// SegmentMapI64 is a map of Segments keyed by signed 64-bit integers
+type SegmentMapI64 = SegmentMapOf[int64]
+
+// Read attempts to read a keyed map from a byte buffer. Regular maps can also be read into a
+// SegmentMapOf[string]. Note that this call will overwrite existing keys with new data.
+func (sm SegmentMapOf[K]) Read(r io.Reader) error {
+	return sm.ReadLimited(r, DecodeLimits{})
+}
+
+// ReadLimited is the same as Read(), but the data read must stay within the limits given to it.
+// Bytes charged to the limits' MemoryBudget stay charged unless reading fails.
+func (sm SegmentMapOf[K]) ReadLimited(r io.Reader, limits DecodeLimits) error {
+
+	d := NewDecoder(r)
+	d.Limits = limits
+	return sm.Decode(d)
+}
+
+// Decode reads the map from a Decoder. This makes all of the Decoder's options, such as its
+// limits and key interning, available when reading a map.
+func (sm SegmentMapOf[K]) Decode(d *Decoder) error {
+	charged := d.charged
+	if err := sm.decode(d); err != nil {
+		d.Limits.Budget.Release(d.charged - charged)
+		return err
+	}
+	return nil
+}
+
+// decode does the work for Decode()
+func (sm SegmentMapOf[K]) decode(d *Decoder) error {
+
+	countSegment, err := d.Next()
+	if err != nil {
+		return err
+	}
+
+	pairCount, err := countSegment.GetMapIndex()
+	if err != nil {
+		return err
+	}
+	if d.Limits.MaxSegmentCount > 0 && pairCount > d.Limits.MaxSegmentCount/2 {
+		return ErrLimitExceeded
+	}
+
+	for i := uint64(0); i < pairCount; i++ {
+		keySegment, err := d.Next()
+		if err != nil {
+			return err
+		}
+		if !isValidMapKey(countSegment.Type, keySegment.Type) {
+			return ErrInvalidKey
+		}
+		key, err := getMapKey[K](keySegment, d)
+		if err != nil {
+			return err
+		}
+
+		valueSegment, err := d.Next()
+		if err != nil {
+			return err
+		}
+
+		sm[key] = valueSegment
+	}
+
+	return nil
+}
+
+// Write flattens a SegmentMapOf to an io.Writer. ErrInvalidKey is returned for string and binary
+// keys longer than 65535 bytes.
+func (sm SegmentMapOf[K]) Write(w io.Writer) error {
+
+	var countSegment Segment
+	err := countSegment.setContainerIndex(DFKeyedMapType, DFKeyedMapType, uint64(len(sm)))
+	if err != nil {
+		return err
+	}
+	if err := countSegment.Write(w); err != nil {
+		return err
+	}
+
+	var keySegment Segment
+	for k, v := range sm {
+		if err := setMapKey(&keySegment, k); err != nil {
+			return err
+		}
+		if err := keySegment.Write(w); err != nil {
+			return err
+		}
+
+		if err := v.Write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetSize returns the size of the buffer needed to contain all flattened elements
+func (sm SegmentMapOf[K]) GetSize() uint64 {
+
+	out := wireSize(DFKeyedMapType, 0)
+	var keySegment Segment
+	for k, v := range sm {
+		setMapKey(&keySegment, k)
+		out += keySegment.GetSize() + v.GetSize()
+	}
+	return out
+}
+
+// isValidMapKey returns true if a segment with the specified type code can be used as a key in a
+// map with the specified index type
+func isValidMapKey(indexType uint8, keyType uint8) bool {
+
+	if keyType == DFStringType {
+		return true
+	}
+	if indexType != DFKeyedMapType {
+		return false
+	}
+	switch keyType {
+	case DFInt8Type, DFUInt8Type, DFInt16Type, DFUInt16Type, DFInt32Type, DFUInt32Type,
+		DFInt64Type, DFUInt64Type, DFBinaryType:
+		return true
+	}
+	return false
+}
+
+// setMapKey encodes a map key into a segment
+func setMapKey[K MapKey](seg *Segment, key K) error {
+	switch k := interface{}(key).(type) {
+	case int8:
+		return seg.SetInt8(k)
+	case int16:
+		return seg.SetInt16(k)
+	case int32:
+		return seg.SetInt32(k)
+	case int64:
+		return seg.SetInt64(k)
+	case uint8:
+		return seg.SetUInt8(k)
+	case uint16:
+		return seg.SetUInt16(k)
+	case uint32:
+		return seg.SetUInt32(k)
+	case uint64:
+		return seg.SetUInt64(k)
+	case string:
+		if len(k) > 65535 {
+			return ErrInvalidKey
+		}
+		return seg.SetString(k)
+	case BinaryKey:
+		if len(k) > 65535 {
+			return ErrInvalidKey
+		}
+		return seg.SetBinary([]byte(k))
+	}
+	return ErrInvalidKey
+}
+
+// getMapKey decodes a map key from a segment. The segment's type must match the key type exactly.
+// String and binary keys are converted by the Decoder so that they can be interned.
+func getMapKey[K MapKey](seg Segment, d *Decoder) (K, error) {
+
+	var out K
+	var err error
+	switch p := interface{}(&out).(type) {
+	case *int8:
+		*p, err = seg.GetInt8()
+	case *int16:
+		*p, err = seg.GetInt16()
+	case *int32:
+		*p, err = seg.GetInt32()
+	case *int64:
+		*p, err = seg.GetInt64()
+	case *uint8:
+		*p, err = seg.GetUInt8()
+	case *uint16:
+		*p, err = seg.GetUInt16()
+	case *uint32:
+		*p, err = seg.GetUInt32()
+	case *uint64:
+		*p, err = seg.GetUInt64()
+	case *string:
+		if seg.Type != DFStringType {
+			return out, ErrInvalidKey
+		}
+		*p = d.key(seg.Value)
+	case *BinaryKey:
+		if seg.Type != DFBinaryType {
+			return out, ErrInvalidKey
+		}
+		*p = BinaryKey(d.key(seg.Value))
+	}
+	if err != nil {
+		return out, ErrInvalidKey
+	}
+	return out, nil
+}
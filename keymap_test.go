@@ -0,0 +1,92 @@
+package oganesson
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSegmentMapI64(t *testing.T) {
+	sm := make(SegmentMapI64)
+	for _, k := range []int64{-1, 0, 1 << 40} {
+		var value Segment
+		value.SetInt64(k * 2)
+		sm[k] = value
+	}
+
+	var buffer bytes.Buffer
+	if err := sm.Write(&buffer); err != nil {
+		t.Fatalf("Error writing keyed map: %s", err.Error())
+	}
+	if uint64(buffer.Len()) != sm.GetSize() {
+		t.Fatalf("Keyed map is %d bytes instead of %d", buffer.Len(), sm.GetSize())
+	}
+	if buffer.Bytes()[0] != DFKeyedMapType {
+		t.Fatalf("Keyed map was written with type code %d", buffer.Bytes()[0])
+	}
+
+	out := make(SegmentMapI64)
+	if err := out.Read(bytes.NewReader(buffer.Bytes())); err != nil {
+		t.Fatalf("Error reading keyed map: %s", err.Error())
+	}
+	if v, _ := out[1<<40].GetInt64(); len(out) != 3 || v != 1<<41 {
+		t.Fatalf("Keyed map wasn't read back correctly")
+	}
+
+	// The keys have to match the map's key type exactly
+	keyed := bytes.NewReader(buffer.Bytes())
+	if err := make(SegmentMapOf[int32]).Read(keyed); err != ErrInvalidKey {
+		t.Fatalf("Keys of the wrong type were accepted")
+	}
+	if err := make(SegmentMap).Read(bytes.NewReader(buffer.Bytes())); err != ErrInvalidKey {
+		t.Fatalf("SegmentMap accepted integer keys")
+	}
+
+	var items []Segment
+	d := NewDecoder(&buffer)
+	for seg, err := d.Next(); err == nil; seg, err = d.Next() {
+		items = append(items, seg)
+	}
+	if err := validateItems(items); err != nil {
+		t.Fatalf("Keyed map failed validation: %s", err.Error())
+	}
+}
+
+func TestSegmentMapOfKeys(t *testing.T) {
+	var value Segment
+	value.SetBool(true)
+
+	bm := SegmentMapOf[BinaryKey]{BinaryKey([]byte{0, 1, 2}): value}
+	var buffer bytes.Buffer
+	if err := bm.Write(&buffer); err != nil {
+		t.Fatalf("Error writing binary-keyed map: %s", err.Error())
+	}
+	out := make(SegmentMapOf[BinaryKey])
+	if err := out.Read(&buffer); err != nil {
+		t.Fatalf("Error reading binary-keyed map: %s", err.Error())
+	}
+	if _, ok := out["\x00\x01\x02"]; !ok {
+		t.Fatalf("Binary key wasn't read back")
+	}
+
+	// Regular maps can be read into string-keyed ones
+	buffer.Reset()
+	SegmentMap{"a": value}.Write(&buffer)
+	sm := make(SegmentMapOf[string])
+	if err := sm.Read(&buffer); err != nil || len(sm) != 1 {
+		t.Fatalf("Regular map wasn't read into SegmentMapOf[string]: %v", err)
+	}
+
+	long := SegmentMapOf[string]{string(make([]byte, 70000)): value}
+	if err := long.Write(&buffer); err != ErrInvalidKey {
+		t.Fatalf("Oversized key was accepted")
+	}
+
+	// Integer keys aren't allowed in regular maps
+	items := make([]Segment, 3)
+	items[0].SetMapIndex(SegmentMap{"": value})
+	items[1].SetInt32(5)
+	items[2] = value
+	if err := validateItems(items); err == nil {
+		t.Fatalf("Integer key in a regular map passed validation")
+	}
+}
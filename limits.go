@@ -0,0 +1,60 @@
+package oganesson
+
+import "errors"
+
+var ErrLimitExceeded = errors.New("decode limit exceeded")
+
+// MaxDecodedSize is the largest payload which will be accepted for a single segment while
+// decoding, whether or not any DecodeLimits are in use. It also caps the size of an inflated
+// packet. It defaults to 64MiB; applications which exchange larger HugeString or HugeBinary
+// values need to raise it. Values above the largest slice the platform can hold are still
+// rejected with ErrSize instead of being truncated.
+var MaxDecodedSize = uint64(DefaultMaxDecodedSize)
+
+// DefaultMaxDecodedSize is the default value of MaxDecodedSize
+const DefaultMaxDecodedSize = 64 * 1024 * 1024
+
+// DecodeLimits places upper bounds on the data accepted while decoding so that a malicious or
+// broken peer can't make the decoder allocate arbitrary amounts of memory. A field with a value of
+// zero is not limited.
+type DecodeLimits struct {
+	// MaxSize is the largest total number of bytes which may be decoded
+	MaxSize uint64
+
+	// MaxSegmentSize is the largest payload, in bytes, which a single segment may have
+	MaxSegmentSize uint64
+
+	// MaxSegmentCount is the largest number of segments which may be decoded
+	MaxSegmentCount uint64
+
+	// Budget, if set, is charged for the payload of each segment before it is allocated
+	Budget *MemoryBudget
+}
+
+// Restrict returns a copy of the limits which has been further restricted by another set of
+// limits. For each field the stricter of the two values is used, so Restrict() can never loosen
+// an existing limit.
+func (l DecodeLimits) Restrict(other DecodeLimits) DecodeLimits {
+	return DecodeLimits{
+		MaxSize:         stricterLimit(l.MaxSize, other.MaxSize),
+		MaxSegmentSize:  stricterLimit(l.MaxSegmentSize, other.MaxSegmentSize),
+		MaxSegmentCount: stricterLimit(l.MaxSegmentCount, other.MaxSegmentCount),
+		Budget:          stricterBudget(l.Budget, other.Budget),
+	}
+}
+
+// IsZero returns true if none of the limits have been set
+func (l DecodeLimits) IsZero() bool {
+	return l.MaxSize == 0 && l.MaxSegmentSize == 0 && l.MaxSegmentCount == 0 && l.Budget == nil
+}
+
+// stricterLimit returns the smaller of two limits, keeping in mind that zero means no limit
+func stricterLimit(a uint64, b uint64) uint64 {
+	if a == 0 {
+		return b
+	}
+	if b == 0 || a < b {
+		return a
+	}
+	return b
+}
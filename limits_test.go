@@ -0,0 +1,123 @@
+package oganesson
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"math"
+	"testing"
+)
+
+func TestDecodeLimitsRestrict(t *testing.T) {
+	sessionLimits := DecodeLimits{MaxSize: 1000, MaxSegmentSize: 100}
+	callLimits := DecodeLimits{MaxSize: 5000, MaxSegmentCount: 10}
+
+	out := sessionLimits.Restrict(callLimits)
+	if out.MaxSize != 1000 || out.MaxSegmentSize != 100 || out.MaxSegmentCount != 10 {
+		t.Fatalf("DecodeLimits.Restrict mismatch: got %+v", out)
+	}
+
+	if !(DecodeLimits{}).IsZero() || out.IsZero() {
+		t.Fatalf("DecodeLimits.IsZero failure")
+	}
+}
+
+func TestDocumentReadLimited(t *testing.T) {
+	doc := NewDocument()
+	doc.AttachString("testString", "abcdefghijklmnopqrstuvwxyz")
+	doc.AttachInt64("testInt", 42)
+
+	data, err := doc.Flatten()
+	if err != nil {
+		t.Fatalf("Error flattening document: %s", err.Error())
+	}
+
+	var readDoc Document
+	if err := readDoc.UnflattenLimited(data, DecodeLimits{MaxSegmentSize: 26}); err != nil {
+		t.Fatalf("UnflattenLimited failed within limits: %s", err.Error())
+	}
+
+	if err := readDoc.UnflattenLimited(data, DecodeLimits{MaxSegmentSize: 25}); err !=
+		ErrLimitExceeded {
+		t.Fatalf("UnflattenLimited didn't enforce the segment size limit")
+	}
+	if err := readDoc.UnflattenLimited(data, DecodeLimits{MaxSegmentCount: 3}); err !=
+		ErrLimitExceeded {
+		t.Fatalf("UnflattenLimited didn't enforce the segment count limit")
+	}
+	err = readDoc.ReadLimited(context.Background(), bytes.NewReader(data),
+		DecodeLimits{MaxSize: uint64(len(data) - 1)})
+	if err != ErrLimitExceeded {
+		t.Fatalf("ReadLimited didn't enforce the total size limit")
+	}
+}
+
+func TestSegmentMapReadLimited(t *testing.T) {
+	sm := make(SegmentMap)
+	sm.SetString("test1", "ABCDEF")
+	sm.SetString("test2", "GHIJKL")
+
+	var buffer bytes.Buffer
+	if err := sm.Write(&buffer); err != nil {
+		t.Fatalf("Error writing segment map: %s", err.Error())
+	}
+	data := buffer.Bytes()
+
+	readMap := make(SegmentMap)
+	if err := readMap.ReadLimited(bytes.NewReader(data), DecodeLimits{MaxSegmentCount: 5}); err !=
+		nil {
+		t.Fatalf("SegmentMap.ReadLimited failed within limits: %s", err.Error())
+	}
+	if err := readMap.ReadLimited(bytes.NewReader(data), DecodeLimits{MaxSegmentCount: 4}); err !=
+		ErrLimitExceeded {
+		t.Fatalf("SegmentMap.ReadLimited didn't enforce the segment count limit")
+	}
+}
+
+func TestMaxDecodedSize(t *testing.T) {
+
+	// A size field which can't be allocated on any platform must be rejected, not truncated
+	var seg Segment
+	err := seg.Read(bytes.NewReader([]byte{DFHugeBinaryType, 255, 255, 255, 255, 255, 255, 255,
+		255, 1, 2, 3}))
+	if err != ErrSize {
+		t.Fatalf("Oversized payload wasn't rejected: %v", err)
+	}
+
+	oldMax := MaxDecodedSize
+	defer func() { MaxDecodedSize = oldMax }()
+	MaxDecodedSize = 4
+
+	flat := []byte{DFBinaryType, 0, 5, 1, 2, 3, 4, 5}
+	if err := seg.Read(bytes.NewReader(flat)); err != ErrSize {
+		t.Fatalf("MaxDecodedSize wasn't applied: %v", err)
+	}
+	if err := seg.Read(bytes.NewReader([]byte{DFBinaryType, 0, 4, 1, 2, 3, 4})); err != nil {
+		t.Fatalf("Payload at MaxDecodedSize was rejected: %s", err.Error())
+	}
+}
+
+func TestOversizedPayloadClaim(t *testing.T) {
+
+	// A HugeBinary segment whose size field claims far more data than the input holds
+	data, _ := hex.DecodeString("1101010e00790179020000000000c50001")
+
+	var doc Document
+	if err := doc.Read(bytes.NewReader(data)); err == nil {
+		t.Fatalf("Document.Read accepted a truncated oversized payload")
+	}
+	readMap := make(SegmentMap)
+	if err := readMap.Read(bytes.NewReader(data)); err == nil {
+		t.Fatalf("SegmentMap.Read accepted a truncated oversized payload")
+	}
+
+	// Within MaxDecodedSize, the claimed size still mustn't be allocated before it arrives
+	oldMax := MaxDecodedSize
+	defer func() { MaxDecodedSize = oldMax }()
+	MaxDecodedSize = math.MaxUint64
+	var seg Segment
+	claim := []byte{DFHugeBinaryType, 0, 0, 0, 0x10, 0, 0, 0, 0, 1, 2, 3}
+	if err := seg.Read(bytes.NewReader(claim)); err != ErrSegmentSize {
+		t.Fatalf("Truncated 64GiB payload wasn't rejected: %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package oganesson
+
+import (
+	"context"
+	"io"
+)
+
+// This file contains ListWriter and ListReader, which write and read a list one item at a time
+// so that very large lists don't have to be held in memory as a SegmentList. The list's item
+// count comes first on the wire, so it has to be known before writing starts.
+
+// ListWriter writes a list to an io.Writer one item at a time
+type ListWriter struct {
+	enc      *Encoder
+	expected uint64
+	written  uint64
+	started  bool
+}
+
+// NewListWriter creates a ListWriter for a list which will hold the specified number of items.
+// Nothing is written until the first call to Add() or Close().
+func NewListWriter(w io.Writer, expectedCount uint64) *ListWriter {
+	return &ListWriter{enc: NewEncoder(w), expected: expectedCount}
+}
+
+// Add writes the next item of the list. ErrSize is returned if the list already holds the number
+// of items passed to NewListWriter(). Lists can't hold other containers, so ErrInvalidContainer is
+// returned for map and list index segments and Document delimiters.
+func (lw *ListWriter) Add(seg Segment) error {
+
+	switch seg.Type {
+	case DFMapType, DFLargeMapType, DFKeyedMapType, DFListType, DFLargeListType, DFDocumentStart,
+		DFDocumentEnd:
+		return ErrInvalidContainer
+	}
+	if lw.written >= lw.expected {
+		return ErrSize
+	}
+	if err := lw.start(); err != nil {
+		return err
+	}
+	if err := lw.enc.Encode(seg); err != nil {
+		return err
+	}
+	lw.written++
+	return nil
+}
+
+// Close finishes the list. ErrSize is returned if fewer items were added than were expected,
+// because the data written so far can't be read back as a valid list. It doesn't close the
+// underlying Writer.
+func (lw *ListWriter) Close() error {
+	if err := lw.start(); err != nil {
+		return err
+	}
+	if lw.written != lw.expected {
+		return ErrSize
+	}
+	return nil
+}
+
+// start writes the list's index segment if it hasn't been written yet
+func (lw *ListWriter) start() error {
+
+	if lw.started {
+		return nil
+	}
+
+	var index Segment
+	if err := index.setContainerIndex(DFListType, DFLargeListType, lw.expected); err != nil {
+		return err
+	}
+	if err := lw.enc.Encode(index); err != nil {
+		return err
+	}
+	lw.started = true
+	return nil
+}
+
+// ListReader reads a list one item at a time. The Decoder it reads from can have limits set so
+// that a hostile list can't run on forever.
+type ListReader struct {
+	d         *Decoder
+	count     uint64
+	remaining uint64
+}
+
+// NewListReader reads the index segment of a list from a Decoder and returns a ListReader for its
+// items. Use NewDecoder() to read from an io.Reader.
+func NewListReader(d *Decoder) (*ListReader, error) {
+
+	index, err := d.Next()
+	if err != nil {
+		return nil, err
+	}
+	count, err := index.GetListIndex()
+	if err != nil {
+		return nil, err
+	}
+	return &ListReader{d: d, count: count, remaining: count}, nil
+}
+
+// Len returns the number of items in the list
+func (lr *ListReader) Len() uint64 {
+	return lr.count
+}
+
+// Remaining returns the number of items which haven't been read yet
+func (lr *ListReader) Remaining() uint64 {
+	return lr.remaining
+}
+
+// Next returns the next item in the list, or io.EOF once all of them have been read
+func (lr *ListReader) Next() (Segment, error) {
+	return lr.NextContext(context.Background())
+}
+
+// NextContext is the same as Next(), but it returns the context's error if it has been canceled
+func (lr *ListReader) NextContext(ctx context.Context) (Segment, error) {
+
+	if lr.remaining == 0 {
+		return Segment{}, io.EOF
+	}
+	seg, err := lr.d.NextContext(ctx)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return seg, err
+	}
+
+	switch seg.Type {
+	case DFMapType, DFLargeMapType, DFKeyedMapType, DFListType, DFLargeListType, DFDocumentStart,
+		DFDocumentEnd:
+		return seg, ErrInvalidContainer
+	}
+	lr.remaining--
+	return seg, nil
+}
@@ -0,0 +1,102 @@
+package oganesson
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestListWriterReader(t *testing.T) {
+
+	// More than 65535 items needs the large list index
+	const count = 100000
+	var buffer bytes.Buffer
+	lw := NewListWriter(&buffer, count)
+	for i := 0; i < count; i++ {
+		var seg Segment
+		seg.SetInt32(int32(i))
+		if err := lw.Add(seg); err != nil {
+			t.Fatalf("Error adding list item %d: %s", i, err.Error())
+		}
+	}
+	if err := lw.Close(); err != nil {
+		t.Fatalf("Error closing list: %s", err.Error())
+	}
+
+	lr, err := NewListReader(NewDecoder(&buffer))
+	if err != nil {
+		t.Fatalf("Error starting list read: %s", err.Error())
+	}
+	if lr.Len() != count {
+		t.Fatalf("Wrong list length %d", lr.Len())
+	}
+	for i := 0; i < count; i++ {
+		seg, err := lr.Next()
+		if err != nil {
+			t.Fatalf("Error reading list item %d: %s", i, err.Error())
+		}
+		if v, _ := seg.GetInt32(); v != int32(i) {
+			t.Fatalf("List item %d has the wrong value %d", i, v)
+		}
+	}
+	if _, err := lr.Next(); err != io.EOF || lr.Remaining() != 0 {
+		t.Fatalf("List didn't end after its last item: %v", err)
+	}
+}
+
+func TestListWriterMatchesSegmentList(t *testing.T) {
+	list := make(SegmentList, 3)
+	list[0].SetString("a")
+	list[1].SetInt64(-1)
+	list[2].SetBool(true)
+
+	var expected, actual bytes.Buffer
+	list.Write(&expected)
+	lw := NewListWriter(&actual, uint64(len(list)))
+	for _, seg := range list {
+		lw.Add(seg)
+	}
+	lw.Close()
+	if !bytes.Equal(expected.Bytes(), actual.Bytes()) {
+		t.Fatalf("ListWriter output doesn't match SegmentList.Write()")
+	}
+
+	var empty bytes.Buffer
+	if err := NewListWriter(&empty, 0).Close(); err != nil || empty.Len() != 3 {
+		t.Fatalf("Empty list wasn't written correctly")
+	}
+}
+
+func TestListWriterErrors(t *testing.T) {
+	var buffer bytes.Buffer
+	var seg Segment
+	seg.SetInt8(1)
+
+	lw := NewListWriter(&buffer, 1)
+	if err := lw.Add(seg); err != nil {
+		t.Fatalf("Error adding list item: %s", err.Error())
+	}
+	if err := lw.Add(seg); err != ErrSize {
+		t.Fatalf("Extra list item was accepted")
+	}
+
+	if err := NewListWriter(&buffer, 2).Close(); err != ErrSize {
+		t.Fatalf("Short list was accepted")
+	}
+
+	var index Segment
+	index.SetListIndex(SegmentList{})
+	if err := NewListWriter(&buffer, 1).Add(index); err != ErrInvalidContainer {
+		t.Fatalf("Nested list was accepted")
+	}
+
+	// A list cut off partway through is reported
+	buffer.Reset()
+	lw = NewListWriter(&buffer, 2)
+	lw.Add(seg)
+	lr, _ := NewListReader(NewDecoder(&buffer))
+	lr.Next()
+	if _, err := lr.Next(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("Truncated list wasn't caught: %v", err)
+	}
+}
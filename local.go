@@ -0,0 +1,65 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+)
+
+// This file contains helpers for using sessions for IPC between processes on the same machine
+// through Unix domain sockets. Windows 10 and later support these as well, so they work the same
+// way on all platforms. Windows named pipes can also be dialed with DialPacketRequesterPipe().
+
+// DialPacketRequesterUnix connects to a Unix domain socket and sets up a requester session
+func DialPacketRequesterUnix(path string) (*PacketSession, error) {
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	session := NewPacketRequester(conn)
+	if err := session.InitRequester(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return session, nil
+}
+
+// ListenUnix listens on a Unix domain socket for AcceptPacketResponder() or SessionServer.Serve().
+// A socket file left behind by a process which didn't shut down cleanly is removed first, but
+// any other kind of file at the path is left alone.
+func ListenUnix(path string) (net.Listener, error) {
+
+	if info, err := os.Lstat(path); err == nil && info.Mode().Type() == fs.ModeSocket {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, &net.OpError{Op: "listen", Net: "unix",
+				Addr: &net.UnixAddr{Name: path, Net: "unix"}, Err: errors.New("socket in use")}
+		}
+		os.Remove(path)
+	}
+	return net.Listen("unix", path)
+}
+
+// AcceptPacketResponder waits for a connection on any kind of listener and sets up a responder
+// session for it
+func AcceptPacketResponder(l net.Listener, bufferSize uint16) (*PacketSession, error) {
+
+	conn, err := l.Accept()
+	if err != nil {
+		return nil, err
+	}
+	session := NewPacketResponder(conn, bufferSize)
+	if session == nil {
+		conn.Close()
+		return nil, ErrSize
+	}
+	if err := session.InitResponder(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return session, nil
+}
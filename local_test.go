@@ -0,0 +1,55 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnixSessions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.sock")
+
+	// A socket file left behind by a dead process doesn't get in the way
+	stale, err := ListenUnix(path)
+	if err != nil {
+		t.Fatalf("Error listening on Unix socket: %s", err.Error())
+	}
+	stale.(interface{ SetUnlinkOnClose(bool) }).SetUnlinkOnClose(false)
+	stale.Close()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("Stale socket file wasn't left behind: %s", err.Error())
+	}
+
+	listener, err := ListenUnix(path)
+	if err != nil {
+		t.Fatalf("Error replacing stale Unix socket: %s", err.Error())
+	}
+	defer listener.Close()
+
+	errChan := make(chan error)
+	go func() {
+		responder, err := AcceptPacketResponder(listener, 4096)
+		if err == nil {
+			err = responder.Write([]byte("local"))
+		}
+		errChan <- err
+	}()
+
+	requester, err := DialPacketRequesterUnix(path)
+	if err != nil {
+		t.Fatalf("Error dialing Unix socket: %s", err.Error())
+	}
+	defer requester.Close()
+	if packet, err := requester.Read(); err != nil || string(packet) != "local" {
+		t.Fatalf("Unix socket session doesn't work: %v", err)
+	}
+	if err := <-errChan; err != nil {
+		t.Fatalf("Responder failed: %s", err.Error())
+	}
+
+	if _, err := ListenUnix(path); err == nil {
+		t.Fatalf("Socket in use was replaced")
+	}
+}
@@ -0,0 +1,28 @@
+package oganesson
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// logger receives warnings about recoverable problems. It is read by session and decoding
+// goroutines, so it is only changed through SetLogger().
+var logger atomic.Pointer[func(msg string)]
+
+// SetLogger sets the function which receives warnings about recoverable problems, such as
+// malformed data from a peer which was accepted anyway because of a lenient decoding option.
+// Warnings are discarded if it is nil, which is the default. It is safe to call at any time.
+func SetLogger(fn func(msg string)) {
+	if fn == nil {
+		logger.Store(nil)
+		return
+	}
+	logger.Store(&fn)
+}
+
+// logWarning formats a warning and passes it to the logger if one has been set
+func logWarning(format string, args ...interface{}) {
+	if fn := logger.Load(); fn != nil {
+		(*fn)(fmt.Sprintf(format, args...))
+	}
+}
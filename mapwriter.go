@@ -0,0 +1,151 @@
+package oganesson
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// MapWriter writes a map to an io.Writer one pair at a time so that very large maps don't have to
+// be held in memory as a SegmentMap. Because the pair count comes first on the wire, either the
+// Writer has to be able to seek back and fill it in once the map is finished, or the count has to
+// be known ahead of time, such as from a first pass over the data. Keys are checked for
+// duplicates as they are added, which means that every key is kept in memory until Close().
+type MapWriter struct {
+	enc      *Encoder
+	seeker   io.WriteSeeker
+	indexPos int64
+	expected uint64
+	written  uint64
+	keys     map[string]struct{}
+	started  bool
+}
+
+// NewMapWriter creates a MapWriter which fills in the pair count when Close() is called. The
+// map starts at the Writer's current position. The large map index is always used so that there
+// is room for any count.
+func NewMapWriter(w io.WriteSeeker) *MapWriter {
+	return &MapWriter{enc: NewEncoder(w), seeker: w, keys: make(map[string]struct{})}
+}
+
+// NewCountedMapWriter creates a MapWriter for a Writer which can't seek, such as a network
+// connection. The map must end up with exactly the specified number of pairs.
+func NewCountedMapWriter(w io.Writer, expectedCount uint64) *MapWriter {
+	return &MapWriter{enc: NewEncoder(w), expected: expectedCount,
+		keys: make(map[string]struct{})}
+}
+
+// Add writes a pair to the map. ErrInvalidKey is returned for a key which has already been added
+// or which is too long for a String segment, and ErrInvalidContainer is returned for values which
+// are map or list index segments. For counted maps, ErrSize is returned once the map already has
+// the expected number of pairs.
+func (mw *MapWriter) Add(key string, value Segment) error {
+
+	if len(key) > 65535 {
+		return ErrInvalidKey
+	}
+	if _, exists := mw.keys[key]; exists {
+		return fmt.Errorf("%w: duplicate key '%s'", ErrInvalidKey, key)
+	}
+	switch value.Type {
+	case DFMapType, DFLargeMapType, DFKeyedMapType, DFListType, DFLargeListType, DFDocumentStart,
+		DFDocumentEnd:
+		return ErrInvalidContainer
+	}
+	if mw.seeker == nil && mw.written >= mw.expected {
+		return ErrSize
+	}
+	if err := mw.start(); err != nil {
+		return err
+	}
+
+	var keySegment Segment
+	keySegment.SetString(key)
+	if err := mw.enc.Encode(keySegment); err != nil {
+		return err
+	}
+	if err := mw.enc.Encode(value); err != nil {
+		return err
+	}
+	mw.keys[key] = struct{}{}
+	mw.written++
+	return nil
+}
+
+// Close finishes the map. For seekable Writers, the pair count is written and the Writer is left
+// positioned after the end of the map. For counted maps, ErrSize is returned if fewer pairs were
+// added than were expected. It doesn't close the underlying Writer.
+func (mw *MapWriter) Close() error {
+
+	if err := mw.start(); err != nil {
+		return err
+	}
+	mw.keys = make(map[string]struct{})
+	if mw.seeker == nil {
+		if mw.written != mw.expected {
+			return ErrSize
+		}
+		return nil
+	}
+
+	end, err := mw.seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := mw.seeker.Seek(mw.indexPos, io.SeekStart); err != nil {
+		return err
+	}
+	if err := mw.writeLargeIndex(mw.written); err != nil {
+		return err
+	}
+	_, err = mw.seeker.Seek(end, io.SeekStart)
+	return err
+}
+
+// start writes the map's index segment if it hasn't been written yet. Seekable maps get a
+// placeholder which Close() fills in.
+func (mw *MapWriter) start() error {
+
+	if mw.started {
+		return nil
+	}
+
+	if mw.seeker == nil {
+		var index Segment
+		if err := index.setContainerIndex(DFMapType, DFLargeMapType, mw.expected); err != nil {
+			return err
+		}
+		if err := mw.enc.Encode(index); err != nil {
+			return err
+		}
+		mw.started = true
+		return nil
+	}
+
+	pos, err := mw.seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	mw.indexPos = pos
+	if err := mw.writeLargeIndex(0); err != nil {
+		return err
+	}
+	mw.started = true
+	return nil
+}
+
+// writeLargeIndex writes a large map index segment holding the specified count. It is the same
+// size whatever the count, so the placeholder can be overwritten.
+func (mw *MapWriter) writeLargeIndex(count uint64) error {
+
+	index := Segment{Type: DFLargeMapType, Value: make([]byte, fixedSegmentSize(DFLargeMapType))}
+	if len(index.Value) == 8 {
+		binary.BigEndian.PutUint64(index.Value, count)
+	} else {
+		if count > 0xFFFFFFFF {
+			return ErrSize
+		}
+		binary.BigEndian.PutUint32(index.Value, uint32(count))
+	}
+	return mw.enc.Encode(index)
+}
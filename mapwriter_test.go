@@ -0,0 +1,91 @@
+package oganesson
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMapWriterSeekable(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "map.bin"))
+	if err != nil {
+		t.Fatalf("Error creating map file: %s", err.Error())
+	}
+	defer f.Close()
+
+	// Something before the map makes sure the count goes in the right place
+	f.Write([]byte("prefix"))
+
+	const count = 70000
+	mw := NewMapWriter(f)
+	for i := 0; i < count; i++ {
+		var value Segment
+		value.SetInt64(int64(i))
+		if err := mw.Add(fmt.Sprintf("key%d", i), value); err != nil {
+			t.Fatalf("Error adding pair %d: %s", i, err.Error())
+		}
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatalf("Error closing map: %s", err.Error())
+	}
+	f.Write([]byte("suffix"))
+
+	f.Seek(int64(len("prefix")), io.SeekStart)
+	sm := make(SegmentMap)
+	if err := sm.Read(f); err != nil {
+		t.Fatalf("Error reading map back: %s", err.Error())
+	}
+	if len(sm) != count {
+		t.Fatalf("Map has %d pairs instead of %d", len(sm), count)
+	}
+	if v, _ := sm["key69999"].GetInt64(); v != 69999 {
+		t.Fatalf("Wrong value read back from map")
+	}
+
+	rest, _ := io.ReadAll(f)
+	if string(rest) != "suffix" {
+		t.Fatalf("Writer wasn't left at the end of the map")
+	}
+}
+
+func TestMapWriterCounted(t *testing.T) {
+	var buffer bytes.Buffer
+	var value Segment
+	value.SetString("value")
+
+	mw := NewCountedMapWriter(&buffer, 2)
+	mw.Add("a", value)
+	if err := mw.Add("a", value); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("Duplicate key was accepted: %v", err)
+	}
+	mw.Add("b", value)
+	if err := mw.Add("c", value); err != ErrSize {
+		t.Fatalf("Extra pair was accepted")
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatalf("Error closing map: %s", err.Error())
+	}
+
+	// A counted map is written the same way as a SegmentMap
+	expected := SegmentMap{"a": value, "b": value}
+	if uint64(buffer.Len()) != expected.GetSize() {
+		t.Fatalf("Counted map is %d bytes instead of %d", buffer.Len(), expected.GetSize())
+	}
+	sm := make(SegmentMap)
+	if err := sm.Read(&buffer); err != nil || len(sm) != 2 {
+		t.Fatalf("Error reading counted map back: %v", err)
+	}
+
+	if err := NewCountedMapWriter(&buffer, 1).Close(); err != ErrSize {
+		t.Fatalf("Short map was accepted")
+	}
+	var index Segment
+	index.SetListIndex(SegmentList{})
+	if err := NewCountedMapWriter(&buffer, 1).Add("list", index); err != ErrInvalidContainer {
+		t.Fatalf("Nested container was accepted")
+	}
+}
@@ -3,6 +3,7 @@ package membufio
 import (
 	"errors"
 	"io"
+	"math"
 )
 
 var ErrEmptyData = errors.New("empty data")
@@ -66,7 +67,7 @@ func (bs *ByteSliceIO) Read(p []byte) (int, error) {
 
 		if bs.Index > bs.BufferLength {
 			bs.Index = bs.BufferLength
-			return bytesRead, errors.New("EOF")
+			return bytesRead, io.EOF
 		}
 	}
 
@@ -81,10 +82,13 @@ func (bs *ByteSliceIO) ReadAt(p []byte, offset int64) (int, error) {
 	if targetLength == 0 {
 		return 0, ErrEmptyData
 	}
+	if offset < 0 {
+		return 0, ErrRange
+	}
 
 	bytesToRead := bs.BufferLength - offset
 	if bytesToRead <= 0 {
-		return 0, errors.New("EOF")
+		return 0, io.EOF
 	}
 	if bytesToRead > targetLength {
 		bytesToRead = targetLength
@@ -129,6 +133,9 @@ func (bs *ByteSliceIO) WriteAt(p []byte, offset int64) (int, error) {
 	if len(p) == 0 {
 		return 0, ErrEmptyData
 	}
+	if offset < 0 {
+		return 0, ErrRange
+	}
 
 	bytesToWrite := bs.BufferLength - offset
 	if bytesToWrite <= 0 {
@@ -171,7 +178,8 @@ func (bs *ByteSliceIO) Seek(offset int64, whence int) (int64, error) {
 		return 0, errors.New("invalid whence value")
 	}
 
-	if start+offset < 0 {
+	// Offsets near the limits of int64 could otherwise wrap around to a valid-looking position
+	if (offset > 0 && start > math.MaxInt64-offset) || start+offset < 0 {
 		return 0, ErrRange
 	}
 
@@ -2,6 +2,7 @@ package membufio
 
 import (
 	"io"
+	"math"
 	"testing"
 )
 
@@ -127,3 +128,23 @@ func TestByteSliceIO2(t *testing.T) {
 		t.Fatalf("readbyte didn't update the index properly. At %d, should be 4", bs.Index)
 	}
 }
+
+func TestByteSliceIOOffsets(t *testing.T) {
+
+	bs := Make(20)
+	buffer := make([]byte, 4)
+	if _, err := bs.ReadAt(buffer, -1); err != ErrRange {
+		t.Fatalf("readat() accepted a negative offset")
+	}
+	if _, err := bs.WriteAt(buffer, -1); err != ErrRange {
+		t.Fatalf("writeat() accepted a negative offset")
+	}
+
+	bs.Index = 10
+	if _, err := bs.Seek(math.MaxInt64, io.SeekCurrent); err != ErrRange {
+		t.Fatalf("seek() didn't catch an overflowing offset")
+	}
+	if bs.Index != 10 {
+		t.Fatalf("seek() changed the index after failing")
+	}
+}
@@ -0,0 +1,518 @@
+package oganesson
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// This file contains message Documents, which are laid out the way WireMsg used to be: a String
+// holding the message code followed by a map of named attachments. NewDocument() creates one
+// when it is given a message code. The Attach methods set attachments in the map of a message
+// Document or of a keyed Document, the named Get methods and Has() read them, and Detach(),
+// Rename(), and ReplaceType() change them. AttachIfAbsent() and CompareAndAttach() make a change
+// only if the Document is in the expected state, such as for middleware which may see the same
+// Document more than once. Documents of other shapes still have their attachments appended as
+// loose values, with the names ignored.
+
+// WireMsg is the old name for Document. The MsgCode, Segments, and Attachments fields it had are
+// kept on Document for old code, but are deprecated.
+//
+// Deprecated: use Document.
+type WireMsg = Document
+
+// NewWireMsg creates a message Document with the specified code.
+//
+// Deprecated: use NewDocument() with a message code.
+func NewWireMsg(msgCode string) *Document {
+	return NewDocument(msgCode)
+}
+
+// MessageCode returns the code of a message Document, or an empty string for other Documents.
+// Keyed Documents keep their code in the MsgCodeField field instead.
+func (doc *Document) MessageCode() string {
+	if !doc.isMessage() {
+		return ""
+	}
+	code, _ := doc.Items[0].(*Segment).GetString()
+	return code
+}
+
+// MessageSegment is an element of the deprecated Document.Segments field, which lays out a
+// message Document the way WireMsg did: its code, then the map of its attachments.
+//
+// Deprecated: use Document.Items and the Document's named Get methods.
+type MessageSegment struct {
+	Segment
+
+	// Attachments holds the attachments of the map, keyed by name. It is nil for the code.
+	Attachments SegmentMap
+}
+
+// Has returns true if the element is the attachment map and holds the named attachment
+func (ms MessageSegment) Has(name string) bool {
+	return ms.Attachments.Has(name)
+}
+
+// updateWireMsgFields fills in the deprecated MsgCode, Segments, and Attachments fields from the
+// Document's items
+func (doc *Document) updateWireMsgFields() {
+
+	doc.MsgCode = doc.MessageCode()
+	doc.Segments = nil
+	doc.Attachments = nil
+
+	index, ok := doc.attachmentMap()
+	if !ok {
+		return
+	}
+	indexSeg, ok := doc.Items[index].(*Segment)
+	if !ok {
+		return
+	}
+	count, err := indexSeg.GetMapIndex()
+	if err != nil {
+		return
+	}
+
+	// Attachments which are containers have no place in a SegmentMap, so they are left out
+	doc.Attachments = make(SegmentMap)
+	pos := index + 1
+	for i := uint64(0); i < count && pos+1 < len(doc.Items); i++ {
+		end, err := doc.skipValue(pos + 1)
+		if err != nil {
+			break
+		}
+		key, keyOK := doc.Items[pos].(*Segment)
+		value, valueOK := doc.Items[pos+1].(*Segment)
+		if keyOK && valueOK && key.Type == DFStringType && end == pos+2 {
+			doc.Attachments[string(key.Value)] = *value
+		}
+		pos = end
+	}
+
+	if doc.isMessage() {
+		doc.Segments = []MessageSegment{{Segment: *doc.Items[0].(*Segment)},
+			{Segment: *indexSeg, Attachments: doc.Attachments}}
+	}
+}
+
+// isMessage returns true if the Document is laid out as a message code followed by a map of
+// attachments
+func (doc *Document) isMessage() bool {
+
+	if len(doc.Items) < 2 || doc.Items[0].GetType() != DFStringType {
+		return false
+	}
+	index, ok := doc.Items[1].(*Segment)
+	if !ok || (index.Type != DFMapType && index.Type != DFLargeMapType) {
+		return false
+	}
+	count, err := index.GetMapIndex()
+	return err == nil && count == uint64(len(doc.Items)-2)/2 && len(doc.Items)%2 == 0
+}
+
+// attachmentMap returns the position of the index segment of the map holding the Document's
+// attachments: the one after the code of a message Document or the only item of a keyed one. It
+// returns false for Documents of other shapes.
+func (doc *Document) attachmentMap() (int, bool) {
+
+	if doc.isMessage() {
+		return 1, true
+	}
+	if len(doc.Items) == 0 || !isMapType(doc.Items[0].GetType()) {
+		return 0, false
+	}
+	end, err := doc.skipValue(0)
+	return 0, err == nil && end == len(doc.Items)
+}
+
+// attach adds an attachment to the Document after checking it against MaxValueSize. In message
+// and keyed Documents, the value of an attachment with the same name is replaced.
+func (doc *Document) attach(name string, seg *Segment) error {
+
+	if doc.MaxValueSize > 0 && sizeSegmentSize(seg.Type) > 0 &&
+		uint64(len(seg.Value)) > doc.MaxValueSize {
+		return fmt.Errorf("%w: attachment '%s' is %d bytes, more than the limit of %d", ErrSize,
+			name, len(seg.Value), doc.MaxValueSize)
+	}
+
+	index, ok := doc.attachmentMap()
+	if !ok {
+		return doc.appendItem(seg)
+	}
+	if doc.frozen {
+		return ErrFrozen
+	}
+	if _, key, err := doc.findAttachment(name); err == nil {
+		return doc.replaceValue(key, seg)
+	}
+
+	newIndex, err := doc.resizeMap(index, 1)
+	if err != nil {
+		return err
+	}
+	var key Segment
+	if err := key.SetString(name); err != nil {
+		return err
+	}
+
+	// The map runs to the end of the Document, so new pairs go at the end
+	doc.own()
+	doc.Items[index] = newIndex
+	doc.Items = append(doc.Items, &key, seg)
+
+	// Rebuilding the deprecated fields would make building a Document quadratic
+	if doc.Attachments != nil {
+		doc.Attachments[name] = *seg
+		if len(doc.Segments) == 2 {
+			doc.Segments[1].Segment = *newIndex
+		}
+	} else {
+		doc.updateWireMsgFields()
+	}
+	return nil
+}
+
+// resizeMap returns a new index segment for the map at the specified position with its pair
+// count changed by delta. The map keeps its type, except that Maps grow into LargeMaps.
+func (doc *Document) resizeMap(index int, delta int64) (*Segment, error) {
+
+	indexSeg := doc.Items[index].(*Segment)
+	count, err := indexSeg.GetMapIndex()
+	if err != nil {
+		return nil, err
+	}
+	largeType := uint8(DFLargeMapType)
+	if indexSeg.Type == DFKeyedMapType {
+		largeType = DFKeyedMapType
+	}
+
+	var out Segment
+	err = out.setContainerIndex(indexSeg.Type, largeType, uint64(int64(count)+delta))
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// replaceValue replaces the value following the key at the specified position, including all of
+// the items of a container
+func (doc *Document) replaceValue(key int, seg *Segment) error {
+
+	end, err := doc.skipValue(key + 1)
+	if err != nil {
+		return err
+	}
+
+	doc.own()
+	items := make([]SegContainer, 0, len(doc.Items)-(end-key-2))
+	items = append(items, doc.Items[:key+1]...)
+	items = append(items, seg)
+	items = append(items, doc.Items[end:]...)
+	doc.Items = items
+	doc.updateWireMsgFields()
+	return nil
+}
+
+// findAttachment returns the positions of the map index segment and of the key of the named
+// attachment of a message or keyed Document
+func (doc *Document) findAttachment(name string) (int, int, error) {
+
+	index, ok := doc.attachmentMap()
+	if !ok {
+		return 0, 0, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	pos, err := doc.queryStep(index, queryStep{key: name})
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: %s", err, name)
+	}
+	return index, pos - 1, nil
+}
+
+// attachment returns the value of the named attachment of a message Document or field of a
+// keyed Document
+func (doc *Document) attachment(name string) (Segment, error) {
+
+	_, key, err := doc.findAttachment(name)
+	if err != nil {
+		return Segment{}, err
+	}
+	seg, ok := doc.Items[key+1].(*Segment)
+	if !ok {
+		return Segment{}, ErrTypeError
+	}
+	return *seg, nil
+}
+
+// Detach removes the named attachment from the Document. ErrNotFound is returned if it doesn't
+// exist.
+func (doc *Document) Detach(name string) error {
+
+	if doc.frozen {
+		return ErrFrozen
+	}
+	index, key, err := doc.findAttachment(name)
+	if err != nil {
+		return err
+	}
+	end, err := doc.skipValue(key + 1)
+	if err != nil {
+		return err
+	}
+
+	newIndex, err := doc.resizeMap(index, -1)
+	if err != nil {
+		return err
+	}
+
+	doc.own()
+	items := make([]SegContainer, 0, len(doc.Items)-(end-key))
+	items = append(items, doc.Items[:key]...)
+	items = append(items, doc.Items[end:]...)
+	items[index] = newIndex
+	doc.Items = items
+	doc.updateWireMsgFields()
+	return nil
+}
+
+// Rename changes the name of an attachment. ErrNotFound is returned if it doesn't exist and
+// ErrInvalidKey if an attachment with the new name already does.
+func (doc *Document) Rename(oldName string, newName string) error {
+
+	if doc.frozen {
+		return ErrFrozen
+	}
+	_, key, err := doc.findAttachment(oldName)
+	if err != nil {
+		return err
+	}
+	if oldName == newName {
+		return nil
+	}
+	if _, _, err := doc.findAttachment(newName); err == nil {
+		return fmt.Errorf("%w '%s'", ErrInvalidKey, newName)
+	}
+
+	var newKey Segment
+	if err := newKey.SetString(newName); err != nil {
+		return err
+	}
+	doc.own()
+	doc.Items[key] = &newKey
+	doc.updateWireMsgFields()
+	return nil
+}
+
+// ReplaceType replaces the value of an attachment with one which may be of a different type.
+// ErrNotFound is returned if the attachment doesn't exist.
+func (doc *Document) ReplaceType(name string, value Segment) error {
+
+	if doc.frozen {
+		return ErrFrozen
+	}
+	_, key, err := doc.findAttachment(name)
+	if err != nil {
+		return err
+	}
+	if value.Type == DFUnknownType || isMapType(value.Type) || isListType(value.Type) {
+		return ErrTypeError
+	}
+	return doc.replaceValue(key, cloneItem(&value))
+}
+
+// AttachIfAbsent adds an attachment unless the Document already has one with the same name. It
+// returns true if the attachment was added. Documents other than message and keyed Documents
+// have no names to check, so the value is always added to them.
+func (doc *Document) AttachIfAbsent(name string, value Segment) (bool, error) {
+
+	if doc.frozen {
+		return false, ErrFrozen
+	}
+	if doc.Has(name) {
+		return false, nil
+	}
+	if err := doc.attach(name, cloneItem(&value)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// AttachStringIfAbsent is the same as AttachIfAbsent() for a String value
+func (doc *Document) AttachStringIfAbsent(name string, value string) (bool, error) {
+	var seg Segment
+	if err := seg.SetString(value); err != nil {
+		return false, err
+	}
+	return doc.AttachIfAbsent(name, seg)
+}
+
+// CompareAndAttach replaces the value of an attachment, but only if its current value, including
+// its type, is the expected one. It returns true if the value was replaced. ErrNotFound is
+// returned if the attachment doesn't exist.
+func (doc *Document) CompareAndAttach(name string, expected Segment, value Segment) (bool, error) {
+
+	if doc.frozen {
+		return false, ErrFrozen
+	}
+	current, err := doc.attachment(name)
+	if err != nil {
+		return false, err
+	}
+	if current.Type != expected.Type || !bytes.Equal(current.Value, expected.Value) {
+		return false, nil
+	}
+	if err := doc.attach(name, cloneItem(&value)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CompareAndAttachString is the same as CompareAndAttach() for String values
+func (doc *Document) CompareAndAttachString(name string, expected string, value string) (bool,
+	error) {
+
+	var expectedSeg, seg Segment
+	if err := expectedSeg.SetString(expected); err != nil {
+		return false, err
+	}
+	if err := seg.SetString(value); err != nil {
+		return false, err
+	}
+	return doc.CompareAndAttach(name, expectedSeg, seg)
+}
+
+// Has returns true if the Document has an attachment with the specified name
+func (doc *Document) Has(name string) bool {
+	_, err := doc.attachment(name)
+	return err == nil
+}
+
+// GetInt8 returns the value of an attachment of the specified type
+func (doc *Document) GetInt8(name string) (int8, error) {
+	seg, err := doc.attachment(name)
+	if err != nil {
+		return 0, err
+	}
+	v, err := seg.GetInt8()
+	return v, withKey(err, name)
+}
+
+// GetUInt8 returns the value of an attachment of the specified type
+func (doc *Document) GetUInt8(name string) (uint8, error) {
+	seg, err := doc.attachment(name)
+	if err != nil {
+		return 0, err
+	}
+	v, err := seg.GetUInt8()
+	return v, withKey(err, name)
+}
+
+// GetInt16 returns the value of an attachment of the specified type
+func (doc *Document) GetInt16(name string) (int16, error) {
+	seg, err := doc.attachment(name)
+	if err != nil {
+		return 0, err
+	}
+	v, err := seg.GetInt16()
+	return v, withKey(err, name)
+}
+
+// GetUInt16 returns the value of an attachment of the specified type
+func (doc *Document) GetUInt16(name string) (uint16, error) {
+	seg, err := doc.attachment(name)
+	if err != nil {
+		return 0, err
+	}
+	v, err := seg.GetUInt16()
+	return v, withKey(err, name)
+}
+
+// GetInt32 returns the value of an attachment of the specified type
+func (doc *Document) GetInt32(name string) (int32, error) {
+	seg, err := doc.attachment(name)
+	if err != nil {
+		return 0, err
+	}
+	v, err := seg.GetInt32()
+	return v, withKey(err, name)
+}
+
+// GetUInt32 returns the value of an attachment of the specified type
+func (doc *Document) GetUInt32(name string) (uint32, error) {
+	seg, err := doc.attachment(name)
+	if err != nil {
+		return 0, err
+	}
+	v, err := seg.GetUInt32()
+	return v, withKey(err, name)
+}
+
+// GetInt64 returns the value of an attachment of the specified type
+func (doc *Document) GetInt64(name string) (int64, error) {
+	seg, err := doc.attachment(name)
+	if err != nil {
+		return 0, err
+	}
+	v, err := seg.GetInt64()
+	return v, withKey(err, name)
+}
+
+// GetUInt64 returns the value of an attachment of the specified type
+func (doc *Document) GetUInt64(name string) (uint64, error) {
+	seg, err := doc.attachment(name)
+	if err != nil {
+		return 0, err
+	}
+	v, err := seg.GetUInt64()
+	return v, withKey(err, name)
+}
+
+// GetBool returns the value of an attachment of the specified type
+func (doc *Document) GetBool(name string) (bool, error) {
+	seg, err := doc.attachment(name)
+	if err != nil {
+		return false, err
+	}
+	v, err := seg.GetBool()
+	return v, withKey(err, name)
+}
+
+// GetFloat32 returns the value of an attachment of the specified type
+func (doc *Document) GetFloat32(name string) (float32, error) {
+	seg, err := doc.attachment(name)
+	if err != nil {
+		return 0, err
+	}
+	v, err := seg.GetFloat32()
+	return v, withKey(err, name)
+}
+
+// GetFloat64 returns the value of an attachment of the specified type
+func (doc *Document) GetFloat64(name string) (float64, error) {
+	seg, err := doc.attachment(name)
+	if err != nil {
+		return 0, err
+	}
+	v, err := seg.GetFloat64()
+	return v, withKey(err, name)
+}
+
+// GetString returns the value of an attachment of the specified type
+func (doc *Document) GetString(name string) (string, error) {
+	seg, err := doc.attachment(name)
+	if err != nil {
+		return "", err
+	}
+	v, err := seg.GetString()
+	return v, withKey(err, name)
+}
+
+// GetBinary returns the value of an attachment of the specified type
+func (doc *Document) GetBinary(name string) ([]byte, error) {
+	seg, err := doc.attachment(name)
+	if err != nil {
+		return nil, err
+	}
+	v, err := seg.GetBinary()
+	return v, withKey(err, name)
+}
@@ -0,0 +1,255 @@
+package oganesson
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMessageDocument(t *testing.T) {
+	var wm *WireMsg = NewWireMsg("PING")
+	wm.AttachString("name", "Alice")
+	wm.AttachString("name", "Bob")
+	wm.AttachInt8("count", 2)
+
+	if wm.MessageCode() != "PING" || len(wm.Items) != 6 {
+		t.Fatalf("Message document mismatch: %q, %d items", wm.MessageCode(), len(wm.Items))
+	}
+	if name, err := wm.GetString("name"); err != nil || name != "Bob" {
+		t.Fatalf("Attachment wasn't replaced: %v, %v", name, err)
+	}
+	if code, err := messageCode(wm); err != nil || code != "PING" {
+		t.Fatalf("messageCode mismatch for a message document: %v, %v", code, err)
+	}
+	if _, err := wm.GetInt8("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Missing attachment wasn't caught: %v", err)
+	}
+
+	// The named getters also read keyed Documents, which have no message code
+	b := NewDocumentBuilder(nil)
+	b.AddString(MsgCodeField, "LOGIN")
+	b.AddUInt32("id", 7)
+	doc, err := b.Build()
+	if err != nil {
+		t.Fatalf("Error building document: %s", err.Error())
+	}
+	if id, err := doc.GetUInt32("id"); err != nil || id != 7 || doc.MessageCode() != "" {
+		t.Fatalf("Keyed document getter mismatch: %v, %v", id, err)
+	}
+
+	flat := NewDocument()
+	flat.AttachString("name", "value")
+	if flat.Has("name") || len(flat.Items) != 1 {
+		t.Fatalf("Unnamed document gained a named attachment")
+	}
+}
+
+func TestDocumentGetters(t *testing.T) {
+	b := NewDocumentBuilder(nil)
+	b.AddBool("enabled", true)
+	b.AddFloat32("ratio", 0.5)
+	b.AddFloat64("total", 12.25)
+	doc, err := b.Build()
+	if err != nil {
+		t.Fatalf("Error building document: %s", err.Error())
+	}
+
+	if v, err := doc.GetBool("enabled"); err != nil || !v {
+		t.Fatalf("GetBool mismatch: %v, %v", v, err)
+	}
+	if v, err := doc.GetFloat32("ratio"); err != nil || v != 0.5 {
+		t.Fatalf("GetFloat32 mismatch: %v, %v", v, err)
+	}
+	if v, err := doc.GetFloat64("total"); err != nil || v != 12.25 {
+		t.Fatalf("GetFloat64 mismatch: %v, %v", v, err)
+	}
+
+	// Type errors name the attachment which had the wrong type
+	_, err = doc.GetInt64("ratio")
+	var typeErr *TypeError
+	if !errors.As(err, &typeErr) || typeErr.Key != "ratio" || typeErr.Expected != DFInt64Type {
+		t.Fatalf("Type error doesn't name the attachment: %v", err)
+	}
+	if _, err := doc.GetFloat64("enabled"); !errors.Is(err, ErrTypeError) ||
+		!strings.Contains(err.Error(), "'enabled'") {
+		t.Fatalf("Type error message doesn't name the attachment: %v", err)
+	}
+}
+
+func TestDetachRenameReplace(t *testing.T) {
+	wm := NewDocument("PING")
+	wm.AttachString("name", "Alice")
+	wm.AttachInt8("count", 2)
+	wm.AttachString("note", "hello")
+
+	if err := wm.Detach("count"); err != nil {
+		t.Fatalf("Error detaching attachment: %s", err.Error())
+	}
+	if wm.Has("count") || !wm.Has("note") || wm.MessageCode() != "PING" {
+		t.Fatalf("Detach removed the wrong attachment")
+	}
+	if err := wm.Detach("count"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Detaching a missing attachment wasn't caught: %v", err)
+	}
+
+	if err := wm.Rename("note", "greeting"); err != nil {
+		t.Fatalf("Error renaming attachment: %s", err.Error())
+	}
+	if v, err := wm.GetString("greeting"); err != nil || v != "hello" || wm.Has("note") {
+		t.Fatalf("Rename mismatch: %v, %v", v, err)
+	}
+	if err := wm.Rename("greeting", "name"); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("Renaming over an existing attachment wasn't caught: %v", err)
+	}
+	if err := wm.Rename("missing", "other"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Renaming a missing attachment wasn't caught: %v", err)
+	}
+
+	var value Segment
+	value.SetUInt64(42)
+	if err := wm.ReplaceType("name", value); err != nil {
+		t.Fatalf("Error replacing attachment: %s", err.Error())
+	}
+	if v, err := wm.GetUInt64("name"); err != nil || v != 42 {
+		t.Fatalf("ReplaceType mismatch: %v, %v", v, err)
+	}
+	if err := wm.ReplaceType("missing", value); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Replacing a missing attachment wasn't caught: %v", err)
+	}
+
+	// The changes have to survive a round trip
+	data, err := wm.Flatten()
+	if err != nil {
+		t.Fatalf("Error flattening document: %s", err.Error())
+	}
+	received := NewDocument()
+	if err := received.Unflatten(data); err != nil {
+		t.Fatalf("Error unflattening document: %s", err.Error())
+	}
+	if v, err := received.GetString("greeting"); err != nil || v != "hello" ||
+		received.MessageCode() != "PING" {
+		t.Fatalf("Round trip mismatch: %v, %v", v, err)
+	}
+
+	wm.Freeze()
+	if err := wm.Detach("name"); !errors.Is(err, ErrFrozen) {
+		t.Fatalf("Frozen document was changed: %v", err)
+	}
+}
+
+func TestConditionalAttach(t *testing.T) {
+	b := NewDocumentBuilder(nil)
+	b.AddString(MsgCodeField, "LOGIN")
+	b.AddString("user", "alice")
+	doc, err := b.Build()
+	if err != nil {
+		t.Fatalf("Error building document: %s", err.Error())
+	}
+
+	// Enriching the same keyed Document twice only adds the field once
+	for i, want := range []bool{true, false} {
+		added, err := doc.AttachStringIfAbsent("region", "eu")
+		if err != nil || added != want {
+			t.Fatalf("AttachStringIfAbsent pass %d mismatch: %v, %v", i, added, err)
+		}
+	}
+	if _, err := doc.fieldMap(); err != nil {
+		t.Fatalf("Attaching broke the keyed document: %s", err.Error())
+	}
+	if v, err := doc.GetString("region"); err != nil || v != "eu" {
+		t.Fatalf("Attached field mismatch: %v, %v", v, err)
+	}
+
+	if ok, err := doc.CompareAndAttachString("user", "bob", "carol"); err != nil || ok {
+		t.Fatalf("CompareAndAttach replaced a value which didn't match: %v, %v", ok, err)
+	}
+	if ok, err := doc.CompareAndAttachString("user", "alice", "carol"); err != nil || !ok {
+		t.Fatalf("CompareAndAttach didn't replace a matching value: %v, %v", ok, err)
+	}
+	if v, _ := doc.GetString("user"); v != "carol" {
+		t.Fatalf("CompareAndAttach value mismatch: %s", v)
+	}
+	var expected, value Segment
+	expected.SetInt8(1)
+	value.SetInt8(2)
+	if ok, _ := doc.CompareAndAttach("user", expected, value); ok {
+		t.Fatalf("CompareAndAttach ignored the type of the expected value")
+	}
+	if _, err := doc.CompareAndAttach("missing", expected, value); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("CompareAndAttach didn't catch a missing attachment: %v", err)
+	}
+}
+
+func TestMaxValueSize(t *testing.T) {
+	doc := NewDocument("UPLOAD")
+	doc.MaxValueSize = 4096
+
+	if err := doc.AttachString("name", strings.Repeat("a", 4096)); err != nil {
+		t.Fatalf("Value at the limit was rejected: %s", err.Error())
+	}
+	if err := doc.AttachString("name", strings.Repeat("a", 4097)); !errors.Is(err, ErrSize) {
+		t.Fatalf("Oversized string wasn't caught: %v", err)
+	}
+	if err := doc.AttachBinary("data", make([]byte, 70000)); !errors.Is(err, ErrSize) {
+		t.Fatalf("Oversized binary value wasn't caught: %v", err)
+	}
+	if err := doc.AttachInt64("count", 1); err != nil {
+		t.Fatalf("Fixed-size value was rejected: %s", err.Error())
+	}
+	if v, _ := doc.GetString("name"); len(v) != 4096 || doc.Has("data") {
+		t.Fatalf("Rejected values changed the document")
+	}
+
+	view := doc.View()
+	if _, err := view.AttachStringIfAbsent("note", strings.Repeat("a", 5000)); !errors.Is(err,
+		ErrSize) {
+		t.Fatalf("View didn't keep the size limit: %v", err)
+	}
+}
+
+func TestWireMsgFields(t *testing.T) {
+	wm := NewWireMsg("PING")
+	wm.AttachString("name", "Alice")
+	wm.AttachInt8("count", 2)
+
+	if wm.MsgCode != "PING" || len(wm.Segments) != 2 {
+		t.Fatalf("WireMsg fields weren't filled in: %q, %d segments", wm.MsgCode,
+			len(wm.Segments))
+	}
+	if code, err := wm.Segments[0].GetString(); err != nil || code != "PING" {
+		t.Fatalf("Code segment mismatch: %q, %v", code, err)
+	}
+	if !wm.Segments[1].Has("name") || wm.Segments[0].Has("name") {
+		t.Fatalf("Attachment map segment mismatch")
+	}
+	if count, _ := wm.Segments[1].GetMapIndex(); count != 2 {
+		t.Fatalf("Attachment map segment has the wrong count: %d", count)
+	}
+
+	// The fields follow changes made with the Document's methods
+	wm.AttachString("name", "Bob")
+	wm.Rename("count", "total")
+	wm.Detach("name")
+	if name := wm.Attachments["name"]; name.Value != nil || !wm.Attachments.Has("total") {
+		t.Fatalf("Attachments weren't kept up to date: %v", wm.Attachments)
+	}
+	if total, err := wm.Attachments.GetInt8("total"); err != nil || total != 2 {
+		t.Fatalf("Attachment value mismatch: %v, %v", total, err)
+	}
+
+	// Changes to a view don't reach the original's fields
+	view := wm.View()
+	view.AttachString("extra", "value")
+	if wm.Attachments.Has("extra") || !view.Attachments.Has("extra") {
+		t.Fatalf("View shared its attachments with the original")
+	}
+
+	var decoded Document
+	flat, _ := wm.Flatten()
+	if err := decoded.Unflatten(flat); err != nil {
+		t.Fatalf("Error unflattening message: %s", err.Error())
+	}
+	if decoded.MsgCode != "PING" || !decoded.Segments[1].Has("total") {
+		t.Fatalf("WireMsg fields weren't filled in when reading")
+	}
+}
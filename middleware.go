@@ -0,0 +1,187 @@
+package oganesson
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var ErrHandlerPanic = errors.New("handler panicked")
+var ErrUnauthorized = errors.New("unauthorized")
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// Middleware wraps a Handler to add behavior which applies to every message, such as logging,
+// metrics, or authentication. Because it wraps the whole call, it sees both the inbound Document
+// and the outbound reply.
+type Middleware func(Handler) Handler
+
+// Chain combines several Middleware into one. The first one given sees each message first.
+func Chain(mw ...Middleware) Middleware {
+	return func(next Handler) Handler {
+		for i := len(mw) - 1; i >= 0; i-- {
+			next = mw[i](next)
+		}
+		return next
+	}
+}
+
+// Interceptor inspects a Document passing through a PacketSession. It may modify the Document.
+// Returning an error stops the Document from being sent or received.
+type Interceptor func(doc *Document) error
+
+// runInterceptors passes a Document through a list of Interceptors in order
+func runInterceptors(interceptors []Interceptor, doc *Document) error {
+	for _, intercept := range interceptors {
+		if err := intercept(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sessionContextKey is the context key a SessionServer stores the handling session under
+type sessionContextKey struct{}
+
+// sessionIDFromContext returns the ID of the session handling a Document, or an empty string if
+// the context didn't come from a SessionServer
+func sessionIDFromContext(ctx context.Context) string {
+	if s, ok := ctx.Value(sessionContextKey{}).(interface{ ID() string }); ok {
+		return s.ID()
+	}
+	return ""
+}
+
+// RecoverMiddleware turns a panic in a Handler into an ErrHandlerPanic error so that one bad
+// message can't bring down a server
+func RecoverMiddleware(next Handler) Handler {
+	return func(ctx context.Context, doc *Document) (reply *Document, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				if id := sessionIDFromContext(ctx); id != "" {
+					logWarning("session %s recovered from handler panic: %v", id, p)
+				} else {
+					logWarning("recovered from handler panic: %v", p)
+				}
+				reply, err = nil, ErrHandlerPanic
+			}
+		}()
+		return next(ctx, doc)
+	}
+}
+
+// LoggingMiddleware sends a line to log for each message handled, giving its code, how long it
+// took, and the error, if any
+func LoggingMiddleware(log func(msg string)) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, doc *Document) (*Document, error) {
+			start := time.Now()
+			reply, err := next(ctx, doc)
+
+			code, _ := messageCode(doc)
+			msg := fmt.Sprintf("%s handled in %s", code, time.Since(start))
+			if err != nil {
+				msg += ": " + err.Error()
+			}
+			log(msg)
+			return reply, err
+		}
+	}
+}
+
+// AuthMiddleware calls check for each message and rejects the message with ErrUnauthorized if it
+// returns an error. Messages with one of the exempt codes, such as a login message, skip the
+// check.
+func AuthMiddleware(check func(ctx context.Context, doc *Document) error,
+	exempt ...string) Middleware {
+
+	skip := make(map[string]bool, len(exempt))
+	for _, code := range exempt {
+		skip[code] = true
+	}
+
+	return func(next Handler) Handler {
+		return func(ctx context.Context, doc *Document) (*Document, error) {
+			if code, _ := messageCode(doc); !skip[code] {
+				if err := check(ctx, doc); err != nil {
+					return nil, fmt.Errorf("%w: %s", ErrUnauthorized, err.Error())
+				}
+			}
+			return next(ctx, doc)
+		}
+	}
+}
+
+// MetricsMiddleware calls record for each message handled with its code, how long it took, and
+// the error, if any. It is meant for feeding a metrics or tracing system.
+func MetricsMiddleware(record func(code string, elapsed time.Duration, err error)) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, doc *Document) (*Document, error) {
+			start := time.Now()
+			reply, err := next(ctx, doc)
+
+			code, _ := messageCode(doc)
+			record(code, time.Since(start), err)
+			return reply, err
+		}
+	}
+}
+
+// ValidateMiddleware checks messages against the Schema registered for their message code before
+// they reach their Handler. The message code field itself doesn't need to be in the Schema.
+// Messages with codes which have no Schema are passed through unchecked.
+func ValidateMiddleware(schemas map[string]*Schema) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, doc *Document) (*Document, error) {
+			code, err := messageCode(doc)
+			if err != nil {
+				return nil, err
+			}
+			if schema, ok := schemas[code]; ok {
+				fields, err := doc.fieldMap()
+				if err != nil {
+					return nil, err
+				}
+				delete(fields, MsgCodeField)
+				if err := schema.Validate(fields); err != nil {
+					return nil, err
+				}
+			}
+			return next(ctx, doc)
+		}
+	}
+}
+
+// RateLimitMiddleware limits the number of messages handled to perSecond, allowing bursts of up
+// to burst messages. Messages over the limit are rejected with ErrRateLimited. The limit applies
+// to all messages passing through the middleware, so a SessionServer's limit is shared by all of
+// its sessions.
+func RateLimitMiddleware(perSecond float64, burst int) Middleware {
+
+	var lock sync.Mutex
+	tokens := float64(burst)
+	last := time.Now()
+
+	return func(next Handler) Handler {
+		return func(ctx context.Context, doc *Document) (*Document, error) {
+			lock.Lock()
+			now := time.Now()
+			tokens += now.Sub(last).Seconds() * perSecond
+			if tokens > float64(burst) {
+				tokens = float64(burst)
+			}
+			last = now
+			allowed := tokens >= 1
+			if allowed {
+				tokens--
+			}
+			lock.Unlock()
+
+			if !allowed {
+				return nil, ErrRateLimited
+			}
+			return next(ctx, doc)
+		}
+	}
+}
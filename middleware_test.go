@@ -0,0 +1,77 @@
+package oganesson
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestChain(t *testing.T) {
+	var order []string
+	mark := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, doc *Document) (*Document, error) {
+				order = append(order, name)
+				return next(ctx, doc)
+			}
+		}
+	}
+
+	h := Chain(mark("first"), mark("second"))(func(ctx context.Context,
+		doc *Document) (*Document, error) {
+		order = append(order, "handler")
+		return nil, nil
+	})
+	h(context.Background(), testMessage(t, "TEST"))
+	if len(order) != 3 || order[0] != "first" || order[1] != "second" {
+		t.Fatalf("Chain order mismatch: %v", order)
+	}
+}
+
+func TestValidateMiddleware(t *testing.T) {
+	r := NewRouter()
+	r.Use(ValidateMiddleware(map[string]*Schema{
+		"LOGIN": NewSchema(FieldSpec{Name: "user", Type: DFStringType, Required: true}),
+	}))
+	r.Handle("LOGIN", func(ctx context.Context, doc *Document) (*Document, error) {
+		return testMessage(t, "WELCOME"), nil
+	})
+
+	reply, _ := r.ServeDocument(context.Background(), testMessage(t, "LOGIN"))
+	if replyField(t, reply, MsgCodeField) != ErrorMsgCode {
+		t.Fatalf("Message missing a required field passed validation")
+	}
+
+	b := NewDocumentBuilder(nil)
+	b.AddString(MsgCodeField, "LOGIN")
+	b.AddString("user", "admin")
+	doc, _ := b.Build()
+	reply, _ = r.ServeDocument(context.Background(), doc)
+	if replyField(t, reply, MsgCodeField) != "WELCOME" {
+		t.Fatalf("Valid message failed validation: %s", replyField(t, reply, ErrorField))
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	var recorded []string
+	h := Chain(MetricsMiddleware(func(code string, elapsed time.Duration, err error) {
+		recorded = append(recorded, code)
+	}), RateLimitMiddleware(0.001, 2))(func(ctx context.Context,
+		doc *Document) (*Document, error) {
+		return nil, nil
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, err := h(context.Background(), testMessage(t, "TEST")); err != nil {
+			t.Fatalf("Message within the burst was rejected: %s", err.Error())
+		}
+	}
+	if _, err := h(context.Background(), testMessage(t, "TEST")); !errors.Is(err,
+		ErrRateLimited) {
+		t.Fatalf("Message over the rate limit wasn't rejected")
+	}
+	if len(recorded) != 3 || recorded[0] != "TEST" {
+		t.Fatalf("Metrics middleware didn't record every message: %v", recorded)
+	}
+}
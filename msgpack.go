@@ -0,0 +1,444 @@
+package oganesson
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// This file converts Documents to and from MessagePack. A Document becomes a MessagePack array of
+// its items, with each map or list turned into a nested MessagePack map or array. Segment types
+// map to MessagePack formats as follows:
+//
+//	JBitPack type         MessagePack format
+//	-------------         ------------------
+//	Int8 ... Int64        int 8 ... int 64
+//	UInt8 ... UInt64      uint 8 ... uint 64
+//	Bool                  true / false
+//	Float32, Float64      float 32, float 64
+//	String, HugeString    str
+//	Binary, HugeBinary    bin
+//	Map, LargeMap         map (keys are str)
+//	List, LargeList       array
+//	Extension types       ext, with the ext type being the type code minus 128
+//
+// ToMsgPack() always uses the sized integer formats, so integers keep their exact type when
+// converted back. Data from other MessagePack encoders will often use the compact fixint formats,
+// which FromMsgPack() reads as Int64. MessagePack nil has no JBitPack equivalent and is rejected,
+// as are containers nested more deeply than JBitPack allows.
+
+// ToMsgPack converts a Document to MessagePack
+func ToMsgPack(doc *Document) ([]byte, error) {
+
+	if doc == nil {
+		return nil, ErrEmptyData
+	}
+
+	items := make([]Segment, len(doc.Items))
+	for i, item := range doc.Items {
+		seg, ok := item.(*Segment)
+		if !ok {
+			return nil, ErrInvalidSegment
+		}
+		items[i] = *seg
+	}
+
+	var body bytes.Buffer
+	var valueCount uint64
+	for i := 0; i < len(items); i++ {
+		var err error
+		switch items[i].Type {
+		case DFMapType, DFLargeMapType, DFKeyedMapType:
+			var pairCount uint64
+			if pairCount, err = items[i].GetMapIndex(); err != nil {
+				return nil, err
+			}
+			if pairCount > uint64(len(items)-i-1)/2 {
+				return nil, ErrInvalidContainer
+			}
+			writeMsgPackHeader(&body, 0x80, 0xde, 0xdf, pairCount)
+			indexType := items[i].Type
+			for j := uint64(0); j < pairCount*2 && err == nil; j++ {
+				i++
+				if j%2 == 0 && !isValidMapKey(indexType, items[i].Type) {
+					return nil, ErrInvalidKey
+				}
+				err = writeMsgPackScalar(&body, items[i])
+			}
+		case DFListType, DFLargeListType:
+			var itemCount uint64
+			if itemCount, err = items[i].GetListIndex(); err != nil {
+				return nil, err
+			}
+			if itemCount > uint64(len(items)-i-1) {
+				return nil, ErrInvalidContainer
+			}
+			writeMsgPackHeader(&body, 0x90, 0xdc, 0xdd, itemCount)
+			for j := uint64(0); j < itemCount && err == nil; j++ {
+				i++
+				err = writeMsgPackScalar(&body, items[i])
+			}
+		default:
+			err = writeMsgPackScalar(&body, items[i])
+		}
+		if err != nil {
+			return nil, err
+		}
+		valueCount++
+	}
+
+	var out bytes.Buffer
+	writeMsgPackHeader(&out, 0x90, 0xdc, 0xdd, valueCount)
+	out.Write(body.Bytes())
+	return out.Bytes(), nil
+}
+
+// writeMsgPackHeader writes the header of a map or array, using the fixed-size format if the
+// count is small enough
+func writeMsgPackHeader(buf *bytes.Buffer, fixCode uint8, code16 uint8, code32 uint8,
+	count uint64) {
+
+	switch {
+	case count < 16:
+		buf.WriteByte(fixCode | uint8(count))
+	case count <= 0xffff:
+		buf.WriteByte(code16)
+		binary.Write(buf, binary.BigEndian, uint16(count))
+	default:
+		buf.WriteByte(code32)
+		binary.Write(buf, binary.BigEndian, uint32(count))
+	}
+}
+
+// writeMsgPackSized writes a str, bin, or ext header using the smallest size field which fits
+func writeMsgPackSized(buf *bytes.Buffer, codes [3]uint8, size int) error {
+
+	switch {
+	case size <= 0xff:
+		buf.WriteByte(codes[0])
+		buf.WriteByte(uint8(size))
+	case size <= 0xffff:
+		buf.WriteByte(codes[1])
+		binary.Write(buf, binary.BigEndian, uint16(size))
+	case uint64(size) <= 0xffffffff:
+		buf.WriteByte(codes[2])
+		binary.Write(buf, binary.BigEndian, uint32(size))
+	default:
+		return ErrSize
+	}
+	return nil
+}
+
+// writeMsgPackScalar writes a segment which isn't a container
+func writeMsgPackScalar(buf *bytes.Buffer, seg Segment) error {
+
+	if err := validateSegment(seg); err != nil {
+		return err
+	}
+
+	// The sized integer and float formats use the same big-endian layout as JBitPack, so their
+	// payloads can be copied as-is
+	switch seg.Type {
+	case DFInt8Type:
+		buf.WriteByte(0xd0)
+	case DFInt16Type:
+		buf.WriteByte(0xd1)
+	case DFInt32Type:
+		buf.WriteByte(0xd2)
+	case DFInt64Type:
+		buf.WriteByte(0xd3)
+	case DFUInt8Type:
+		buf.WriteByte(0xcc)
+	case DFUInt16Type:
+		buf.WriteByte(0xcd)
+	case DFUInt32Type:
+		buf.WriteByte(0xce)
+	case DFUInt64Type:
+		buf.WriteByte(0xcf)
+	case DFFloat32Type:
+		buf.WriteByte(0xca)
+	case DFFloat64Type:
+		buf.WriteByte(0xcb)
+	case DFBoolType:
+		if seg.Value[0] != 0 {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+		return nil
+	case DFStringType, DFHugeStringType:
+		if len(seg.Value) < 32 {
+			buf.WriteByte(0xa0 | uint8(len(seg.Value)))
+		} else if err := writeMsgPackSized(buf, [3]uint8{0xd9, 0xda, 0xdb},
+			len(seg.Value)); err != nil {
+			return err
+		}
+	case DFBinaryType, DFHugeBinaryType:
+		if err := writeMsgPackSized(buf, [3]uint8{0xc4, 0xc5, 0xc6}, len(seg.Value)); err != nil {
+			return err
+		}
+	case DFMapType, DFLargeMapType, DFKeyedMapType, DFListType, DFLargeListType:
+		return ErrInvalidContainer
+	default:
+		if !IsExtensionTypeCode(seg.Type) && !IsExperimentalTypeCode(seg.Type) {
+			return ErrInvalidSegment
+		}
+		if err := writeMsgPackSized(buf, [3]uint8{0xc7, 0xc8, 0xc9}, len(seg.Value)); err != nil {
+			return err
+		}
+		buf.WriteByte(seg.Type - ExtensionTypeCodeMin)
+	}
+
+	buf.Write(seg.Value)
+	return nil
+}
+
+// FromMsgPack converts MessagePack data created by ToMsgPack() back into a Document. Data from
+// other encoders can also be converted as long as it is an array whose values are scalars or
+// maps and arrays of scalars.
+func FromMsgPack(data []byte) (*Document, error) {
+
+	mr := msgPackReader{data: data}
+	count, isContainer, err := mr.readContainerHeader(false)
+	if err != nil {
+		return nil, err
+	}
+	if !isContainer {
+		return nil, ErrInvalidMsg
+	}
+
+	out := NewDocument()
+	for i := uint64(0); i < count; i++ {
+		if err := mr.readValue(out); err != nil {
+			return nil, err
+		}
+	}
+	if mr.index != len(data) {
+		return nil, ErrSize
+	}
+	out.updateWireMsgFields()
+	return out, nil
+}
+
+// msgPackReader keeps track of the position in MessagePack data while it is being decoded
+type msgPackReader struct {
+	data  []byte
+	index int
+}
+
+// next returns the next size bytes of the data
+func (mr *msgPackReader) next(size uint64) ([]byte, error) {
+	if size > uint64(len(mr.data)-mr.index) {
+		return nil, ErrSize
+	}
+	out := mr.data[mr.index : mr.index+int(size)]
+	mr.index += int(size)
+	return out, nil
+}
+
+// nextSize reads a big-endian size field of the specified number of bytes
+func (mr *msgPackReader) nextSize(fieldSize uint64) (uint64, error) {
+	field, err := mr.next(fieldSize)
+	if err != nil {
+		return 0, err
+	}
+	var out uint64
+	for _, b := range field {
+		out = (out << 8) + uint64(b)
+	}
+	return out, nil
+}
+
+// readContainerHeader reads a map or array header. If isMap is true, a map is expected. Otherwise
+// an array is expected. The returned flag is false, and the reader's position is unchanged, if
+// the next value isn't the expected kind of container.
+func (mr *msgPackReader) readContainerHeader(isMap bool) (uint64, bool, error) {
+
+	if mr.index >= len(mr.data) {
+		return 0, false, ErrSize
+	}
+
+	fixCode, code16, code32 := uint8(0x90), uint8(0xdc), uint8(0xdd)
+	if isMap {
+		fixCode, code16, code32 = 0x80, 0xde, 0xdf
+	}
+
+	code := mr.data[mr.index]
+	switch {
+	case code&0xf0 == fixCode:
+		mr.index++
+		return uint64(code & 0x0f), true, nil
+	case code == code16:
+		mr.index++
+		count, err := mr.nextSize(2)
+		return count, true, err
+	case code == code32:
+		mr.index++
+		count, err := mr.nextSize(4)
+		return count, true, err
+	}
+	return 0, false, nil
+}
+
+// readValue reads the next top-level value and attaches it to the Document
+func (mr *msgPackReader) readValue(doc *Document) error {
+
+	if count, isMap, err := mr.readContainerHeader(true); err != nil || isMap {
+		if err != nil {
+			return err
+		}
+		return mr.readMap(doc, count)
+	}
+	if count, isArray, err := mr.readContainerHeader(false); err != nil || isArray {
+		if err != nil {
+			return err
+		}
+		return mr.readList(doc, count)
+	}
+
+	seg, err := mr.readScalar()
+	if err != nil {
+		return err
+	}
+	doc.Items = append(doc.Items, &seg)
+	return nil
+}
+
+// readMap reads the pairs of a map and attaches them along with the map's index segment
+func (mr *msgPackReader) readMap(doc *Document, count uint64) error {
+
+	var index Segment
+	if err := index.setContainerIndex(DFMapType, DFLargeMapType, count); err != nil {
+		return err
+	}
+	doc.Items = append(doc.Items, &index)
+
+	for i := uint64(0); i < count*2; i++ {
+		seg, err := mr.readScalar()
+		if err != nil {
+			return err
+		}
+		if i%2 == 0 && seg.Type != DFStringType {
+			return ErrInvalidKey
+		}
+		doc.Items = append(doc.Items, &seg)
+	}
+	return nil
+}
+
+// readList reads the items of an array and attaches them along with the list's index segment
+func (mr *msgPackReader) readList(doc *Document, count uint64) error {
+
+	var index Segment
+	if err := index.setContainerIndex(DFListType, DFLargeListType, count); err != nil {
+		return err
+	}
+	doc.Items = append(doc.Items, &index)
+
+	for i := uint64(0); i < count; i++ {
+		seg, err := mr.readScalar()
+		if err != nil {
+			return err
+		}
+		doc.Items = append(doc.Items, &seg)
+	}
+	return nil
+}
+
+// readScalar reads a value which isn't a container. Containers are only permitted at the top
+// level because JBitPack doesn't allow them to be nested.
+func (mr *msgPackReader) readScalar() (Segment, error) {
+
+	var out Segment
+	codeBytes, err := mr.next(1)
+	if err != nil {
+		return out, err
+	}
+	code := codeBytes[0]
+
+	var payload []byte
+	switch {
+	case code <= 0x7f:
+		return out, out.SetInt64(int64(code))
+	case code >= 0xe0:
+		return out, out.SetInt64(int64(int8(code)))
+	case code&0xe0 == 0x80, code >= 0xdc && code <= 0xdf:
+		return out, ErrInvalidContainer
+	case code&0xe0 == 0xa0:
+		payload, err = mr.next(uint64(code & 0x1f))
+		if err != nil {
+			return out, err
+		}
+		return out, out.SetString(string(payload))
+	}
+
+	fixedTypes := map[uint8]uint8{
+		0xd0: DFInt8Type, 0xd1: DFInt16Type, 0xd2: DFInt32Type, 0xd3: DFInt64Type,
+		0xcc: DFUInt8Type, 0xcd: DFUInt16Type, 0xce: DFUInt32Type, 0xcf: DFUInt64Type,
+		0xca: DFFloat32Type, 0xcb: DFFloat64Type,
+	}
+	if typeCode, ok := fixedTypes[code]; ok {
+		if payload, err = mr.next(uint64(fixedSegmentSize(typeCode))); err != nil {
+			return out, err
+		}
+		out.Type = typeCode
+		out.Value = append([]byte(nil), payload...)
+		return out, nil
+	}
+
+	switch code {
+	case 0xc2, 0xc3:
+		return out, out.SetBool(code == 0xc3)
+	case 0xd9, 0xda, 0xdb, 0xc4, 0xc5, 0xc6:
+		isString := code >= 0xd9
+		fieldSize := uint64(1) << (code - 0xc4)
+		if isString {
+			fieldSize = uint64(1) << (code - 0xd9)
+		}
+		size, err := mr.nextSize(fieldSize)
+		if err == nil {
+			payload, err = mr.next(size)
+		}
+		if err != nil {
+			return out, err
+		}
+		if isString {
+			return out, out.SetString(string(payload))
+		}
+		return out, out.SetBinary(payload)
+	case 0xc7, 0xc8, 0xc9, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return mr.readExt(code)
+	}
+	return out, ErrInvalidSegment
+}
+
+// readExt reads an ext value as an extension or experimental type
+func (mr *msgPackReader) readExt(code uint8) (Segment, error) {
+
+	var out Segment
+	var size uint64
+	var err error
+	if code >= 0xd4 {
+		size = 1 << (code - 0xd4)
+	} else {
+		size, err = mr.nextSize(1 << (code - 0xc7))
+		if err != nil {
+			return out, err
+		}
+	}
+
+	extType, err := mr.next(1)
+	if err != nil {
+		return out, err
+	}
+	if extType[0] > ExperimentalTypeCodeMax-ExtensionTypeCodeMin {
+		return out, ErrInvalidSegment
+	}
+	payload, err := mr.next(size)
+	if err != nil {
+		return out, err
+	}
+
+	out.Type = extType[0] + ExtensionTypeCodeMin
+	out.Value = append([]byte(nil), payload...)
+	return out, validateSegment(out)
+}
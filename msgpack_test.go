@@ -0,0 +1,71 @@
+package oganesson
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMsgPack(t *testing.T) {
+	doc := NewDocument()
+	doc.AttachInt8("a", -3)
+	doc.AttachUInt64("b", 1<<40)
+	doc.AttachString("c", strings.Repeat("x", 300))
+	doc.AttachBinary("d", []byte{1, 2, 3})
+
+	var mapIndex, listIndex, f32 Segment
+	mapIndex.SetMapIndex(SegmentMap{"key": Segment{}})
+	listIndex.SetListIndex(make(SegmentList, 2))
+	f32.SetFloat32(1.5)
+	doc.Items = append(doc.Items,
+		&mapIndex,
+		&Segment{DFStringType, []byte("key")},
+		&Segment{DFBoolType, []byte{1}},
+		&listIndex,
+		&f32,
+		&Segment{DFInt32Type, []byte{0, 0, 1, 0}})
+
+	data, err := ToMsgPack(doc)
+	if err != nil {
+		t.Fatalf("ToMsgPack failed: %s", err.Error())
+	}
+	if data[0] != 0x96 {
+		t.Fatalf("ToMsgPack top-level array header mismatch: %x", data[0])
+	}
+
+	out, err := FromMsgPack(data)
+	if err != nil {
+		t.Fatalf("FromMsgPack failed: %s", err.Error())
+	}
+	if len(out.Items) != len(doc.Items) {
+		t.Fatalf("MsgPack round trip item count mismatch: %d", len(out.Items))
+	}
+	for i := range doc.Items {
+		want := doc.Items[i].(*Segment)
+		got := out.Items[i].(*Segment)
+		if want.Type != got.Type || !bytes.Equal(want.Value, got.Value) {
+			t.Fatalf("MsgPack round trip mismatch at index %d: %s vs %s", i, want.ToString(),
+				got.ToString())
+		}
+	}
+
+	// Compact values from other encoders: [5, -1, "hi", {"k": 7}]
+	out, err = FromMsgPack([]byte{0x94, 0x05, 0xff, 0xa2, 'h', 'i', 0x81, 0xa1, 'k', 0x07})
+	if err != nil {
+		t.Fatalf("FromMsgPack failed on compact data: %s", err.Error())
+	}
+	if len(out.Items) != 6 {
+		t.Fatalf("FromMsgPack compact item count mismatch: %d", len(out.Items))
+	}
+	if v, _ := out.Items[1].(*Segment).GetInt64(); v != -1 {
+		t.Fatalf("FromMsgPack negative fixint mismatch: %d", v)
+	}
+
+	// Nested containers and nil aren't supported
+	if _, err := FromMsgPack([]byte{0x91, 0x91, 0x91, 0x01}); err != ErrInvalidContainer {
+		t.Fatalf("FromMsgPack accepted nested containers")
+	}
+	if _, err := FromMsgPack([]byte{0x91, 0xc0}); err != ErrInvalidSegment {
+		t.Fatalf("FromMsgPack accepted nil")
+	}
+}
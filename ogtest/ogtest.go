@@ -0,0 +1,180 @@
+// Package ogtest contains helpers for testing code which uses oganesson Documents: comparing
+// Documents, checking them against golden files, and generating random Documents for property
+// tests.
+package ogtest
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/darkwyrm/oganesson"
+)
+
+// UpdateGolden makes AssertGolden() write golden files instead of comparing against them. It is
+// set when the OGTEST_UPDATE environment variable is not empty, e.g. OGTEST_UPDATE=1 go test.
+var UpdateGolden = os.Getenv("OGTEST_UPDATE") != ""
+
+// AssertDocumentEqual fails the test if two Documents don't have the same segments. The output
+// includes both Documents to make the difference easy to find.
+func AssertDocumentEqual(t testing.TB, want *oganesson.Document, got *oganesson.Document) {
+	t.Helper()
+
+	if index := firstDifference(want, got); index >= 0 {
+		t.Fatalf("documents differ at item %d\nwant: %s\ngot: %s", index, want.String(),
+			got.String())
+	}
+}
+
+// firstDifference returns the index of the first item which differs between two Documents or -1
+// if they are the same
+func firstDifference(want *oganesson.Document, got *oganesson.Document) int {
+
+	for i := 0; i < len(want.Items) && i < len(got.Items); i++ {
+		var wantData, gotData bytes.Buffer
+		wantErr := want.Items[i].Write(&wantData)
+		gotErr := got.Items[i].Write(&gotData)
+		if wantErr != nil || gotErr != nil || !bytes.Equal(wantData.Bytes(), gotData.Bytes()) {
+			return i
+		}
+	}
+
+	if len(want.Items) != len(got.Items) {
+		if len(want.Items) < len(got.Items) {
+			return len(want.Items)
+		}
+		return len(got.Items)
+	}
+	return -1
+}
+
+// AssertGolden compares the flattened form of a Document with the golden file
+// testdata/<name>.golden and fails the test if they differ. When UpdateGolden is set, the golden
+// file is written instead.
+func AssertGolden(t testing.TB, name string, doc *oganesson.Document) {
+	t.Helper()
+
+	data, err := doc.Flatten()
+	if err != nil {
+		t.Fatalf("error flattening document: %s", err.Error())
+	}
+
+	path := filepath.Join("testdata", name+".golden")
+	if UpdateGolden {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("error creating golden file directory: %s", err.Error())
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("error writing golden file: %s", err.Error())
+		}
+		return
+	}
+
+	golden, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("error reading golden file (set OGTEST_UPDATE=1 to create it): %s",
+			err.Error())
+	}
+	if bytes.Equal(golden, data) {
+		return
+	}
+
+	want := oganesson.NewDocument()
+	if err := want.Unflatten(golden); err != nil {
+		t.Fatalf("document doesn't match golden file %s, which is corrupt: %s", path,
+			err.Error())
+	}
+	AssertDocumentEqual(t, want, doc)
+
+	// The items match, but something else in the framing doesn't
+	t.Fatalf("document doesn't match golden file %s", path)
+}
+
+// GenerateDocument creates a random but valid Document with the specified number of top-level
+// values. The same seed always produces the same Document, so failures in property tests can be
+// reproduced. Values may be any of the scalar types, a map, or a list.
+func GenerateDocument(seed int64, valueCount int) *oganesson.Document {
+
+	r := rand.New(rand.NewSource(seed))
+	out := oganesson.NewDocument()
+	for i := 0; i < valueCount; i++ {
+		switch r.Intn(10) {
+		case 0:
+			pairs := make(oganesson.SegmentMap)
+			for j := r.Intn(5); j > 0; j-- {
+				pairs[randomString(r, 1+r.Intn(8))] = randomScalar(r)
+			}
+			var index oganesson.Segment
+			index.SetMapIndex(pairs)
+			out.Items = append(out.Items, &index)
+			for _, key := range pairs.Keys() {
+				var keySeg oganesson.Segment
+				keySeg.SetString(key)
+				value := pairs[key]
+				out.Items = append(out.Items, &keySeg, &value)
+			}
+		case 1:
+			items := make(oganesson.SegmentList, r.Intn(5))
+			var index oganesson.Segment
+			index.SetListIndex(items)
+			out.Items = append(out.Items, &index)
+			for range items {
+				value := randomScalar(r)
+				out.Items = append(out.Items, &value)
+			}
+		default:
+			value := randomScalar(r)
+			out.Items = append(out.Items, &value)
+		}
+	}
+	return out
+}
+
+// randomScalar creates a segment of a random non-container type with a random value
+func randomScalar(r *rand.Rand) oganesson.Segment {
+
+	var out oganesson.Segment
+	switch r.Intn(13) {
+	case 0:
+		out.SetInt8(int8(r.Uint32()))
+	case 1:
+		out.SetUInt8(uint8(r.Uint32()))
+	case 2:
+		out.SetInt16(int16(r.Uint32()))
+	case 3:
+		out.SetUInt16(uint16(r.Uint32()))
+	case 4:
+		out.SetInt32(int32(r.Uint32()))
+	case 5:
+		out.SetUInt32(r.Uint32())
+	case 6:
+		out.SetInt64(int64(r.Uint64()))
+	case 7:
+		out.SetUInt64(r.Uint64())
+	case 8:
+		out.SetBool(r.Intn(2) == 1)
+	case 9:
+		out.SetFloat32(r.Float32())
+	case 10:
+		out.SetFloat64(r.NormFloat64())
+	case 11:
+		out.SetString(randomString(r, r.Intn(32)))
+	default:
+		data := make([]byte, r.Intn(32))
+		r.Read(data)
+		out.SetBinary(data)
+	}
+	return out
+}
+
+// randomString creates a random alphanumeric string of the specified length
+func randomString(r *rand.Rand, length int) string {
+	const chars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	out := make([]byte, length)
+	for i := range out {
+		out[i] = chars[r.Intn(len(chars))]
+	}
+	return string(out)
+}
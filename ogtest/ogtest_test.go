@@ -0,0 +1,42 @@
+package ogtest
+
+import (
+	"os"
+	"testing"
+)
+
+func TestGenerateDocument(t *testing.T) {
+	for seed := int64(0); seed < 20; seed++ {
+		doc := GenerateDocument(seed, 20)
+		if err := doc.Validate(); err != nil {
+			t.Fatalf("GenerateDocument created an invalid document for seed %d: %s", seed,
+				err.Error())
+		}
+		AssertDocumentEqual(t, doc, GenerateDocument(seed, 20))
+	}
+
+	if firstDifference(GenerateDocument(1, 20), GenerateDocument(2, 20)) < 0 {
+		t.Fatalf("GenerateDocument created the same document for different seeds")
+	}
+	short := GenerateDocument(1, 20)
+	short.Items = short.Items[:len(short.Items)-1]
+	if firstDifference(GenerateDocument(1, 20), short) != len(short.Items) {
+		t.Fatalf("firstDifference didn't catch a missing item")
+	}
+}
+
+func TestAssertGolden(t *testing.T) {
+	dir := t.TempDir()
+	oldDir, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Error changing directory: %s", err.Error())
+	}
+	defer os.Chdir(oldDir)
+
+	doc := GenerateDocument(42, 10)
+
+	UpdateGolden = true
+	AssertGolden(t, "test", doc)
+	UpdateGolden = false
+	AssertGolden(t, "test", doc)
+}
@@ -1,14 +1,31 @@
+//go:build !nonet
+
 package oganesson
 
 import (
+	"bufio"
 	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"strconv"
+	"sync"
 	"time"
 )
 
+// Default timeouts for new PacketSession instances
+var (
+	DefaultReadTimeout      = 30 * time.Second
+	DefaultWriteTimeout     = 30 * time.Second
+	DefaultHandshakeTimeout = 10 * time.Second
+)
+
 // DataFrame type codes
 const (
 	SingleFrame = uint8(50) + iota
@@ -21,6 +38,32 @@ const (
 	SessionSetupRequest
 	SessionSetupResponse
 
+	// TopicFrame holds the topic of the packet which follows it. See PacketSession.Publish().
+	TopicFrame
+
+	// CreditFrame grants the other side permission to send more data. See
+	// PacketSession.FlowControl.
+	CreditFrame
+
+	// CancelFrame ends a multipart packet early. See PacketSession.CancelTransfer().
+	CancelFrame
+
+	// DictionaryFrame identifies the compression dictionary each side has during setup. See
+	// PacketSession.Compression.
+	DictionaryFrame
+
+	// AckFrame acknowledges a Document sent with acknowledged delivery. See
+	// PacketSession.Acknowledged.
+	AckFrame
+
+	// RejectFrame tells the sender of a multipart packet that it won't be accepted. See
+	// PacketSession.AssemblyBudget.
+	RejectFrame
+
+	// FilterFrame tells the other side which filters its subscribers to a topic use. See
+	// PacketSession.SubscribeFiltered().
+	FilterFrame
+
 	// This code isn't used for any frames; instead it marks the upper boundary for valid frame
 	// codes. This entry should ALWAYS be last.
 	FrameUpperBound
@@ -64,11 +107,11 @@ func (df *DataFrame) GetType() uint8 {
 // GetType returns the size of the payload or 0 if the frame is invalid/
 func (df *DataFrame) GetSize() uint16 {
 
-	if len(df.buffer) < 4 {
+	if df.index < 4 {
 		return 0
 	}
 
-	return uint16(len(df.buffer) - 3)
+	return uint16(df.index - 3)
 }
 
 // GetType returns the data held by the frame or nil if the frame is invalid
@@ -81,19 +124,23 @@ func (df *DataFrame) GetPayload() []byte {
 	return df.buffer[3:df.index]
 }
 
-// Read() reads in a chunk of data from the network socket and ensures the frame structure is valid
+// Read() reads in a chunk of data from the network socket and ensures the frame structure is valid.
+// The header is read first so that frames which arrive together, such as those sent from a
+// session's write buffer, are split apart correctly.
 func (df *DataFrame) Read(r io.Reader) error {
 
 	// Invalidate the index in case we error out
 	df.index = 0
 
-	bytesRead, err := r.Read(df.buffer)
-	if err != nil {
-		return err
+	if len(df.buffer) < 4 {
+		return ErrSize
 	}
 
-	if bytesRead < 4 {
-		return ErrIO
+	if _, err := io.ReadFull(r, df.buffer[:3]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return ErrIO
+		}
+		return err
 	}
 
 	if df.buffer[0] < SingleFrame || df.buffer[0] >= FrameUpperBound {
@@ -102,12 +149,22 @@ func (df *DataFrame) Read(r io.Reader) error {
 
 	// The size bytes are in network order (MSB), so this makes dealing with CPU architecture much
 	// less of a headache regardless of what archictecture this is compiled for.
-	payloadSize := (uint16(df.buffer[1]) << 8) + uint16(df.buffer[2])
-	if bytesRead != int(payloadSize)+3 {
+	payloadSize := int(df.buffer[1])<<8 + int(df.buffer[2])
+	if payloadSize == 0 {
+		return ErrIO
+	}
+	if payloadSize+3 > len(df.buffer) {
 		return ErrSize
 	}
 
-	df.index = bytesRead
+	if _, err := io.ReadFull(r, df.buffer[3:payloadSize+3]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrSize
+		}
+		return err
+	}
+
+	df.index = payloadSize + 3
 	return nil
 }
 
@@ -115,7 +172,7 @@ func WriteFrame(w io.Writer, fieldType uint8, payload []byte) error {
 	payloadLen := len(payload)
 
 	buffer := make([]byte, payloadLen+3)
-	buffer[0] = SingleFrame
+	buffer[0] = fieldType
 	buffer[1] = uint8((payloadLen >> 8) & 255)
 	buffer[2] = uint8(payloadLen & 255)
 	copy(buffer[3:], payload)
@@ -124,163 +181,1099 @@ func WriteFrame(w io.Writer, fieldType uint8, payload []byte) error {
 	return err
 }
 
+// SessionError wraps an error returned by a PacketSession with the ID of the session which
+// returned it so that errors can be matched up with a specific session after the fact. The
+// original error is still available via errors.Is() and errors.As().
+type SessionError struct {
+	SessionID string
+	Err       error
+}
+
+func (e *SessionError) Error() string {
+	return "session " + e.SessionID + ": " + e.Err.Error()
+}
+
+func (e *SessionError) Unwrap() error {
+	return e.Err
+}
+
+// Flags sent in the last byte of the session setup frames
+const (
+	setupSequenceNumbers = uint8(1)
+	setupFlowControl     = uint8(2)
+	setupFrameChecksums  = uint8(4)
+	setupCompression     = uint8(8)
+	setupAcknowledged    = uint8(16)
+
+	// setupKnownFlags holds all of the flags understood by this version
+	setupKnownFlags = setupSequenceNumbers | setupFlowControl | setupFrameChecksums |
+		setupCompression | setupAcknowledged
+
+	// setupFlagsAck is set by responders which understand the setup flags. Older responders just
+	// send back whatever the requester sent, so this keeps them from appearing to agree to
+	// options they don't support.
+	setupFlagsAck = uint8(0x80)
+
+	// setupVersions is set by sessions which exchange protocol versions after the setup frames.
+	// It is separate from the feature flags because it doesn't have to match on both sides.
+	setupVersions = uint8(0x40)
+)
+
+// sequenceSize is the size of the sequence number at the start of each frame's payload when
+// sequence numbers are in use
+const sequenceSize = 8
+
 // PacketSession works at the lowest layer of the framework. Its job is to break arbitrary-sized
 // chunks of data into segments that fit into the network buffer on both sides of the channel.
 // It performs no encryption.
+//
+// Once setup has finished, one goroutine may read from a session while others write to it. Each
+// packet is sent whole even when several goroutines write at once, and concurrent readers each
+// get whole packets, although which reader gets which packet isn't defined. The exported fields
+// must be set before setup and not changed afterward.
 type PacketSession struct {
 	Connection net.Conn
-	Timeout    time.Duration
 	BufferSize uint16
-	isInit     bool
+
+	// MaxCommandLength is the maximum number of bytes a command sent over the session is
+	// permitted to be. ReadDocument() and ReadSegmentMap() return ErrSize for longer packets, but
+	// bulk transfers read with Read() are not subject to this restriction. It starts out as the
+	// package's MaxCommandLength, and 0 means there is no limit.
+	MaxCommandLength int
+
+	// ReadTimeout limits how long the session waits for each frame it reads, including the first
+	// frame of a packet, so it also limits how long Read() waits for a packet to start arriving.
+	// WriteTimeout limits how long each frame takes to send. HandshakeTimeout limits the whole of
+	// InitRequester() or InitResponder(), during which the other two aren't used. A timeout of
+	// zero means no limit.
+	ReadTimeout      time.Duration
+	WriteTimeout     time.Duration
+	HandshakeTimeout time.Duration
+
+	// Limits is applied to all data received by the session, including data decoded by
+	// ReadDocument() and ReadSegmentMap().
+	Limits DecodeLimits
+
+	// OnAnomaly, if set, is called when the session receives malformed frames or messages which
+	// come close to or exceed Limits.MaxSize
+	OnAnomaly AnomalyHook
+
+	// VerifyPeer, if set, is called during setup with the identity of the peer so that the
+	// application can pin its certificate or key. Returning an error rejects the peer and closes
+	// the connection. It requires a TLS connection; setup fails with ErrEncryptionRequired for
+	// any other kind. The identity is also recorded in HandshakeInfo().
+	VerifyPeer func(identity PeerIdentity) error
+
+	// Authenticator, if set, is run at the end of session setup to check the requester's
+	// identity. See Authenticator for details.
+	Authenticator Authenticator
+
+	// Inbound and Outbound are run on every Document received by ReadDocument() and sent by
+	// WriteDocument(), respectively, for things like metrics and validation which should apply to
+	// all of a session's messages
+	Inbound  []Interceptor
+	Outbound []Interceptor
+
+	// SequenceNumbers makes every frame carry a sequence number so that frames which are
+	// accidentally dropped, duplicated, or reordered on the way are caught with ErrReplayDetected.
+	// The numbers aren't authenticated, so an attacker can simply rewrite them; protection against
+	// deliberate replay or reordering needs TLS. It must be set on both sides before setup, and
+	// setup fails with ErrSessionSetup if only one side has it.
+	SequenceNumbers bool
+
+	// FlowControl makes the receiving side of the session grant the sender credit for the number
+	// of bytes it may send, and makes the sender wait when it runs out. This keeps a fast sender
+	// from piling up data on a slow receiver. FlowWindow is the number of bytes the receiver lets
+	// the sender have in flight; DefaultFlowWindow is used if it is zero. Like SequenceNumbers,
+	// it must be set on both sides before setup.
+	FlowControl bool
+	FlowWindow  uint32
+
+	// FrameChecksums adds a checksum to each frame's header so that a corrupted header is caught
+	// instead of leaving the session reading frames from the wrong place in the stream. Read()
+	// returns ErrFrameChecksum for the packet which was corrupted, and the next read skips ahead
+	// to the next good frame. Like SequenceNumbers, it must be set on both sides before setup.
+	FrameChecksums bool
+
+	// Compression, if set, makes the session deflate the packets it sends which the policy says
+	// are worth it. Each packet is marked as compressed or not, so both sides need to set it, but
+	// their policies may differ. CompressionDictionary is a preset dictionary, such as one from
+	// BuildCompressionDictionary(), for getting small packets to compress well. It is only used if
+	// the other side has the same one, which UsingSharedDictionary() reports after setup.
+	Compression           *CompressionPolicy
+	CompressionDictionary []byte
+
+	// KeyDictionary, if set, makes the session send keyed Documents with their field names
+	// compacted by the dictionary (see CompactKeys()) and expand the ones it receives. Both sides
+	// must use the same dictionary; a compacted Document read without it fails with
+	// ErrKeyDictionary.
+	KeyDictionary *KeyDictionary
+
+	// WideLargeContainers makes the session send and receive Documents with the 64-bit container
+	// counts described for Encoder.WideLargeContainers. Like KeyDictionary, it isn't negotiated,
+	// so both sides must use the same setting.
+	WideLargeContainers bool
+
+	// SupportedVersions lists the protocol versions the session will agree to. During setup the
+	// newest version both sides support is chosen, which NegotiatedVersion() returns afterward,
+	// and setup fails with ErrVersionMismatch if there isn't one. If it is empty, only
+	// ProtocolVersion is supported.
+	SupportedVersions []uint8
+
+	// SendQueueSize is the number of packets Send() can queue before it blocks.
+	// DefaultSendQueueSize is used if it is zero.
+	SendQueueSize int
+
+	// RequestTimeout limits how long SendRequest() waits for each reply. It starts out as
+	// DefaultRequestTimeout, and zero means no limit. RequestRetry controls whether requests
+	// which time out are sent again.
+	RequestTimeout time.Duration
+	RequestRetry   RetryPolicy
+
+	// Acknowledged makes the session acknowledge each Document it receives which has a message
+	// ID, so that Documents sent with Deliver() are delivered at least once. Outbox holds the
+	// Documents sent with Deliver() until they are acknowledged; it should be shared by the
+	// sessions made by each reconnect so that ResendPending() can send them again. A
+	// FileMessageStore also keeps them across restarts, and a MemoryMessageStore which only lasts
+	// as long as the session is used if it is nil. Duplicates holds the message IDs already
+	// received so that Documents sent again are dropped; it should also be shared, and if it is
+	// nil, the IDs are kept by the session for DefaultDuplicateWindow. Like SequenceNumbers,
+	// Acknowledged must be set on both sides before setup.
+	Acknowledged bool
+	Outbox       MessageStore
+	Duplicates   DedupCache
+
+	// MaxAssemblySize is the largest multipart packet the session will put back together, and
+	// AssemblyBudget limits the memory used for putting them back together by all of the sessions
+	// which share it. DefaultAssemblyBudget is used if it is nil. Packets which don't fit are
+	// rejected without closing the session, unlike those over Limits.MaxSize. Zero means no
+	// limit.
+	MaxAssemblySize uint64
+	AssemblyBudget  *AssemblyBudget
+
+	isInit    bool
+	id        string
+	version   uint8
+	handshake *HandshakeInfo
+
+	// versionExchange holds the bytes of the version offer and answer sent during setup so that
+	// they can be included in the handshake transcript
+	versionExchange []byte
+
+	// writer holds outgoing frames so that several can be sent at once. It is nil unless
+	// SetWriteBuffer() has been called.
+	writer *bufio.Writer
+
+	badFrames int
+
+	// badHeader holds the header of the last frame which failed its checksum, which is where the
+	// next read starts looking for a good frame. skipContinued is set after a bad frame to skip
+	// the rest of the packet it belonged to. Once a good frame is found, resynced is set until
+	// its sequence number has been checked, and skippedPayload holds the size of the payload of
+	// the bad frame for flow control.
+	badHeader      []byte
+	skipContinued  bool
+	resynced       bool
+	skippedPayload uint64
+
+	sendSequence uint64
+	recvSequence uint64
+
+	// recvTransfers counts the multipart packets which have started to arrive
+	recvTransfers uint64
+
+	// sendLock keeps packets from different goroutines from being mixed together, and frameLock
+	// does the same for individual frames, such as credit frames sent while reading
+	sendLock  sync.Mutex
+	frameLock sync.Mutex
+
+	// readLock is held by whichever goroutine is reading from the connection. When a writer has
+	// to read credit frames itself, packets it reads are saved in queued for Read(). incoming
+	// holds the packet being received between reads.
+	readLock sync.Mutex
+	queued   []queuedPacket
+	incoming incomingPacket
+
+	transferLock    sync.Mutex
+	lastTransfer    uint64
+	activeTransfer  uint64
+	cancelRequested bool
+
+	sharedDictionary bool
+	deflater         *flate.Writer
+	inflater         io.ReadCloser
+
+	// capture is the writer passed to RecordTo(). captureStarted is set once the capture file's
+	// header has been written.
+	captureLock    sync.Mutex
+	capture        io.Writer
+	captureStarted bool
+
+	// sendQueue holds the packets queued by Send() for the writer goroutine. sendStop is closed
+	// to make the writer finish, and it closes senderDone when it has.
+	senderOnce    sync.Once
+	senderLock    sync.Mutex
+	sendQueue     chan queuedSend
+	sendStop      chan struct{}
+	senderDone    chan struct{}
+	senderStopped bool
+	sendErr       error
+
+	// requests holds a channel for each request waiting for its reply, keyed by message ID. The
+	// reader goroutine started by the first request delivers the replies and passes other
+	// Documents to unsolicited, which is created by Incoming(). readerErr is the error which
+	// stopped the reader.
+	readerOnce  sync.Once
+	requestLock sync.Mutex
+	requests    map[string]chan *Document
+	unsolicited chan *Document
+	readerErr   error
+
+	ackLock         sync.Mutex
+	localOutbox     MessageStore
+	localDuplicates DedupCache
+
+	flowLock     sync.Mutex
+	sendCredit   uint64
+	recvUnacked  uint64
+	creditSignal chan struct{}
+
+	// holdCredit is set while a writer waiting for credit is reading, so that the credit for what
+	// it reads is held in heldCredit until Read() returns the packet. Both are guarded by
+	// readLock.
+	holdCredit bool
+	heldCredit uint64
+
+	subscriptionLock sync.Mutex
+	subscriptions    map[string][]subscription
+	announcedTopics  map[string]bool
+	peerFilters      map[string][]*Filter
+
+	closeLock   sync.Mutex
+	closeReason CloseReason
+	lastError   error
+
+	stateLock    sync.Mutex
+	state        SessionState
+	stateChanges chan StateEvent
 }
 
-func NewPacketRequester(conn net.Conn) *PacketSession {
-	out := PacketSession{conn, PacketSessionTimeout, DefaultBufferSize, false}
-	return &out
+// SessionOption changes one of a PacketSession's settings when it is created, so that callers
+// don't have to change the package-level defaults
+type SessionOption func(s *PacketSession)
+
+// WithBufferSize sets the buffer size offered during setup in place of DefaultBufferSize or the
+// size passed to NewPacketResponder()
+func WithBufferSize(size uint16) SessionOption {
+	return func(s *PacketSession) {
+		s.BufferSize = size
+	}
 }
 
-func NewPacketResponder(conn net.Conn, bufferSize uint16) *PacketSession {
+// WithMaxCommandLength sets the session's MaxCommandLength
+func WithMaxCommandLength(length int) SessionOption {
+	return func(s *PacketSession) {
+		s.MaxCommandLength = length
+	}
+}
 
-	if bufferSize < 1024 {
+// WithTimeouts sets the session's ReadTimeout, WriteTimeout, and HandshakeTimeout
+func WithTimeouts(read time.Duration, write time.Duration, handshake time.Duration) SessionOption {
+	return func(s *PacketSession) {
+		s.ReadTimeout = read
+		s.WriteTimeout = write
+		s.HandshakeTimeout = handshake
+	}
+}
+
+func NewPacketRequester(conn net.Conn, opts ...SessionOption) *PacketSession {
+	out := PacketSession{Connection: conn, BufferSize: DefaultBufferSize}
+	out.setDefaults(opts)
+	if out.BufferSize < 1024 {
 		return nil
 	}
+	return &out
+}
+
+func NewPacketResponder(conn net.Conn, bufferSize uint16, opts ...SessionOption) *PacketSession {
 
-	out := PacketSession{conn, PacketSessionTimeout, bufferSize, false}
+	out := PacketSession{Connection: conn, BufferSize: bufferSize}
+	out.setDefaults(opts)
+	if out.BufferSize < 1024 {
+		return nil
+	}
 	return &out
 }
 
+// setDefaults fills in the settings of a new session from the package defaults and then applies
+// any options passed to its constructor
+func (s *PacketSession) setDefaults(opts []SessionOption) {
+	s.MaxCommandLength = MaxCommandLength
+	s.setDefaultTimeouts()
+	for _, opt := range opts {
+		opt(s)
+	}
+}
+
+// ID returns the short identifier generated for the session during setup. It is empty until
+// InitRequester() or InitResponder() has been called.
+func (s *PacketSession) ID() string {
+	return s.id
+}
+
+// newSessionID generates a random 8-character hex string for identifying a session
+func newSessionID() string {
+	idBytes := make([]byte, 4)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "00000000"
+	}
+	return hex.EncodeToString(idBytes)
+}
+
+// wrapError attaches the session's ID to an error returned by one of its methods
+func (s *PacketSession) wrapError(err error) error {
+	if err == nil || s.id == "" {
+		return err
+	}
+
+	// Errors from the session's own Read() and Write() calls, such as those made by an
+	// Authenticator, are already wrapped
+	var sessionErr *SessionError
+	if errors.As(err, &sessionErr) && sessionErr.SessionID == s.id {
+		return err
+	}
+	return &SessionError{s.id, err}
+}
+
+// InitRequester performs session setup from the requesting side of the connection
 func (s *PacketSession) InitRequester() error {
 
-	setupBuffer := []byte{SessionSetupRequest, 255, 255, 0}
-	s.UpdateTimeout()
-	byteCount, err := s.Connection.Write(setupBuffer)
-	if byteCount != 4 {
-		return ErrSize
+	s.id = newSessionID()
+	s.setState(StateHandshaking, nil)
+	s.Connection.SetDeadline(deadlineFor(s.HandshakeTimeout))
+	err := s.initRequester()
+	if err == nil {
+		err = s.startFlowControl(true)
+	}
+	if err == nil {
+		err = s.startCompression(true)
+	}
+	if err == nil {
+		err = s.verifyPeer()
+	}
+	if err == nil {
+		err = s.authenticate(true)
 	}
+	s.finishSetup(err)
+	return s.wrapError(err)
+}
+
+func (s *PacketSession) initRequester() error {
+
+	setupBuffer := []byte{SessionSetupRequest, uint8((s.BufferSize >> 8) & 255),
+		uint8(s.BufferSize & 255), s.setupFlags() | setupVersions}
+	byteCount, err := s.Connection.Write(setupBuffer)
 	if err != nil {
 		return err
 	}
-
-	s.UpdateTimeout()
-	byteCount, err = s.Connection.Read(setupBuffer)
 	if byteCount != 4 {
 		return ErrSize
 	}
+	request := append([]byte(nil), setupBuffer...)
+
+	byteCount, err = s.Connection.Read(setupBuffer)
 	if err != nil {
 		return err
 	}
+	if byteCount != 4 {
+		return ErrSize
+	}
 
 	listenerSize := uint16(setupBuffer[1])<<8 + uint16(setupBuffer[2])
 	if listenerSize < s.BufferSize {
 		s.BufferSize = listenerSize
 	}
+	if s.BufferSize < 1024 {
+		return ErrSessionSetup
+	}
+
+	responderFlags := setupBuffer[3] &^ (setupFlagsAck | setupVersions)
+	if setupBuffer[3]&setupFlagsAck == 0 {
+		responderFlags = 0
+	}
+	if responderFlags != s.setupFlags() {
+		return ErrSessionSetup
+	}
 
+	peerNegotiates := setupBuffer[3]&(setupFlagsAck|setupVersions) == setupFlagsAck|setupVersions
+	if err := s.negotiateVersion(true, peerNegotiates); err != nil {
+		return err
+	}
+
+	s.recordHandshake(true, request, setupBuffer)
 	s.isInit = true
 	return nil
 }
 
+// InitResponder performs session setup from the responding side of the connection
 func (s *PacketSession) InitResponder() error {
 
+	s.id = newSessionID()
+	s.setState(StateHandshaking, nil)
+	s.Connection.SetDeadline(deadlineFor(s.HandshakeTimeout))
+	err := s.initResponder()
+	if err == nil {
+		err = s.startFlowControl(false)
+	}
+	if err == nil {
+		err = s.startCompression(false)
+	}
+	if err == nil {
+		err = s.verifyPeer()
+	}
+	if err == nil {
+		err = s.authenticate(false)
+	}
+	s.finishSetup(err)
+	return s.wrapError(err)
+}
+
+func (s *PacketSession) initResponder() error {
+
 	setupBuffer := []byte{0, 0, 0, 0}
-	s.UpdateTimeout()
 	byteCount, err := s.Connection.Read(setupBuffer)
-	if byteCount != 4 {
-		return ErrSize
-	}
 	if err != nil {
 		return err
 	}
+	if byteCount != 4 {
+		return ErrSize
+	}
 
+	request := append([]byte(nil), setupBuffer...)
 	bufferSize := uint16(setupBuffer[1])<<8 + uint16(setupBuffer[2])
 	if bufferSize < s.BufferSize {
 		s.BufferSize = bufferSize
 	}
 
+	requesterFlags := setupBuffer[3] & setupKnownFlags
+	peerNegotiates := setupBuffer[3]&setupVersions != 0
+
 	setupBuffer[0] = SessionSetupResponse
 	setupBuffer[1] = uint8((s.BufferSize >> 8) & 255)
 	setupBuffer[2] = uint8(s.BufferSize & 255)
-	// the fourth byte is ignored and exists just for compliance -- a DataFrame must be at least
-	// 4 bytes
-	s.UpdateTimeout()
+	// the fourth byte holds flags for optional features, which are only turned on if both sides
+	// asked for them. It also makes the frame the minimum DataFrame size of 4 bytes.
+	setupBuffer[3] = setupFlagsAck | (s.setupFlags() & requesterFlags)
+	if peerNegotiates {
+		setupBuffer[3] |= setupVersions
+	}
 	byteCount, err = s.Connection.Write(setupBuffer)
+	if err != nil {
+		return err
+	}
 	if byteCount != 4 {
 		return ErrSize
 	}
 
+	// The response is sent even if the options don't match so that the requester finds out
+	// instead of waiting for a response which never comes
+	if s.setupFlags() != requesterFlags || s.BufferSize < 1024 {
+		s.Connection.Close()
+		return ErrSessionSetup
+	}
+
+	if err := s.negotiateVersion(false, peerNegotiates); err != nil {
+		return err
+	}
+
+	s.recordHandshake(false, request, setupBuffer)
 	s.isInit = true
-	return err
+	return nil
 }
 
-func (s *PacketSession) UpdateTimeout() {
-	s.Connection.SetReadDeadline(time.Now().Add(s.Timeout))
-	s.Connection.SetWriteDeadline(time.Now().Add(s.Timeout))
+// NegotiatedChunkSize returns the largest number of bytes of a packet which are sent in each
+// frame. It is based on the smaller of the two buffer sizes exchanged during setup, so the other
+// side can always hold a whole frame. It returns 0 until setup has finished.
+func (s *PacketSession) NegotiatedChunkSize() int {
+
+	if !s.isInit {
+		return 0
+	}
+	out := int(s.BufferSize) - 3
+	if s.SequenceNumbers {
+		out -= sequenceSize
+	}
+	if s.FrameChecksums {
+		out--
+	}
+	return out
 }
 
-// Read() reads packets from a socket and hides away the chunking logic
+// setupFlags returns the flags sent during setup for the optional features the session uses
+func (s *PacketSession) setupFlags() uint8 {
+
+	var out uint8
+	if s.SequenceNumbers {
+		out |= setupSequenceNumbers
+	}
+	if s.FlowControl {
+		out |= setupFlowControl
+	}
+	if s.FrameChecksums {
+		out |= setupFrameChecksums
+	}
+	if s.Compression != nil {
+		out |= setupCompression
+	}
+	if s.Acknowledged {
+		out |= setupAcknowledged
+	}
+	return out
+}
+
+func (s *PacketSession) setDefaultTimeouts() {
+	s.ReadTimeout = DefaultReadTimeout
+	s.WriteTimeout = DefaultWriteTimeout
+	s.HandshakeTimeout = DefaultHandshakeTimeout
+	s.RequestTimeout = DefaultRequestTimeout
+}
+
+// setReadDeadline starts the read timeout for the next frame. The handshake timeout is used
+// instead while setup is running.
+func (s *PacketSession) setReadDeadline() {
+	if s.State() != StateHandshaking {
+		s.Connection.SetReadDeadline(deadlineFor(s.ReadTimeout))
+	}
+}
+
+// setWriteDeadline is the same as setReadDeadline() for writing frames
+func (s *PacketSession) setWriteDeadline() {
+	if s.State() != StateHandshaking {
+		s.Connection.SetWriteDeadline(deadlineFor(s.WriteTimeout))
+	}
+}
+
+// deadlineFor returns the deadline for a timeout starting now. A zero timeout means no deadline.
+func deadlineFor(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
+
+// Read() reads packets from a socket and hides away the chunking logic. Anything in the write
+// buffer is sent first so that a request can't sit in the buffer while waiting for its response,
+// unless another goroutine is busy writing, in which case it is up to the writers to call Flush().
+// Packets published to a topic are handed to the topic's subscribers while reading.
 func (s *PacketSession) Read() ([]byte, error) {
+	if err := s.flushIdle(); err != nil {
+		s.noteError(err)
+		return nil, s.wrapError(err)
+	}
+
+	s.readLock.Lock()
+	defer s.signalCredit()
+	defer s.readLock.Unlock()
+
+	for {
+		out, topic, err := s.nextPacket()
+		if err == errCreditReceived {
+			continue
+		}
+		if err == nil && topic != "" {
+			// Published packets go to the topic's subscribers instead of the caller
+			if err = s.deliver(topic, out); err == nil {
+				continue
+			}
+		}
+		s.noteError(err)
+		s.checkFrameError(err)
+		return out, s.wrapError(err)
+	}
+}
+
+// nextPacket returns the next packet saved by a writer waiting for credit or, if there aren't any,
+// reads one from the connection
+func (s *PacketSession) nextPacket() ([]byte, string, error) {
+	if len(s.queued) > 0 {
+		next := s.queued[0]
+		s.queued = s.queued[1:]
+		if err := s.receivedData(int(next.credit)); err != nil {
+			return nil, "", err
+		}
+		return next.packet, next.topic, nil
+	}
+	return s.readPacket()
+}
+
+// checkFrameError keeps track of malformed frames received in a row and reports them
+func (s *PacketSession) checkFrameError(err error) {
+
+	switch err {
+	case nil:
+		s.badFrames = 0
+	case ErrInvalidFrame, ErrInvalidMultipartFrame, ErrMultipartSession, ErrSize, ErrIO,
+		ErrReplayDetected, ErrFrameChecksum:
+		s.badFrames++
+		s.OnAnomaly.report(Anomaly{Kind: AnomalyMalformedFrame, Peer: s.peer(),
+			SessionID: s.ID(), Count: s.badFrames, Err: err})
+	}
+}
+
+// peer returns the address of the other side of the session for anomaly reports
+func (s *PacketSession) peer() string {
+	if s.Connection == nil || s.Connection.RemoteAddr() == nil {
+		return ""
+	}
+	return s.Connection.RemoteAddr().String()
+}
+
+// readPacket reads the next packet from the connection. If the packet was published to a topic,
+// the topic is returned along with it. If a credit frame arrives, errCreditReceived is returned so
+// that writers waiting for credit can carry on, and the packet is picked up where it left off by
+// the next call. Ack and reject frames are handled without returning.
+func (s *PacketSession) readPacket() ([]byte, string, error) {
 
 	if !s.isInit {
-		return nil, ErrNoInit
+		return nil, "", ErrNoInit
 	}
 
 	chunk := NewDataFrame(s.BufferSize)
-	err := chunk.Read(s.Connection)
+	for {
+		payload, err := s.readFrame(chunk)
+		if err == nil {
+			var done bool
+			var out []byte
+			out, done, err = s.addFrame(chunk.GetType(), payload)
+			if done {
+				topic := s.incoming.topic
+				s.resetIncoming()
+				out, err = s.decompressPacket(out)
+				return out, topic, err
+			}
+		}
+
+		if err == errCreditReceived {
+			return nil, "", err
+		}
+		if err == errAckReceived || err == errRejectReceived || err == errFilterReceived {
+			continue
+		}
+		if err != nil {
+			s.resetIncoming()
+			return nil, "", err
+		}
+	}
+}
+
+// incomingPacket holds the state of a packet which is partway through being received
+type incomingPacket struct {
+	topic     string
+	multipart bool
+	totalSize uint64
+	sizeRead  uint64
+	parts     [][]byte
+
+	// reserved is the memory reserved from the session's AssemblyBudget for the packet
+	reserved uint64
+}
+
+// addFrame adds a frame to the packet being received. The packet is returned once it is
+// complete.
+func (s *PacketSession) addFrame(frameType uint8, payload []byte) ([]byte, bool, error) {
+
+	p := &s.incoming
+	if !p.multipart {
+		// A published packet is sent right after a frame holding its topic
+		if frameType == TopicFrame && p.topic == "" {
+			p.topic = string(payload)
+			s.skipContinued = false
+			return nil, false, nil
+		}
+
+		// The rest of a packet with a corrupted frame is thrown away
+		if s.skipContinued {
+			switch frameType {
+			case MultipartFrame, MultipartFrameFinal, CancelFrame:
+				return nil, false, nil
+			}
+			s.skipContinued = false
+		}
+
+		switch frameType {
+		case SingleFrame:
+			return payload, true, nil
+		case MultipartFrameFinal, MultipartFrame:
+			return nil, false, ErrMultipartSession
+		case MultipartFrameStart:
+			// Keep calm and carry on 👑
+		default:
+			return nil, false, ErrInvalidFrame
+		}
+
+		// We got this far, so we have a multipart message which we need to reassemble.
+
+		// No validity checking is performed on the actual data in a DataFrame, so we need to
+		// validate the total payload size.
+		totalSize, err := strconv.ParseUint(string(payload), 10, 64)
+		if err != nil {
+			return nil, false, err
+		}
+		if nearLimit(totalSize, s.Limits.MaxSize) {
+			a := Anomaly{Kind: AnomalyLargeSize, Peer: s.peer(), SessionID: s.ID(),
+				Size: totalSize, Limit: s.Limits.MaxSize}
+			if totalSize > s.Limits.MaxSize {
+				a.Err = ErrLimitExceeded
+			}
+			s.OnAnomaly.report(a)
+			if a.Err != nil {
+				return nil, false, a.Err
+			}
+		}
+
+		if err := s.reserveAssembly(totalSize); err != nil {
+			return nil, false, err
+		}
+		p.multipart = true
+		p.totalSize = totalSize
+		p.parts = make([][]byte, 1)
+		if totalSize > 0 {
+			return nil, false, nil
+		}
+	} else {
+		if frameType == CancelFrame {
+			return nil, false, ErrTransferCanceled
+		}
+
+		// The frame's buffer is reused for the next read, so the payload has to be copied
+		p.parts = append(p.parts, append([]byte(nil), payload...))
+		p.sizeRead += uint64(len(payload))
+
+		if frameType != MultipartFrameFinal && p.sizeRead < p.totalSize {
+			return nil, false, nil
+		}
+	}
+
+	if p.sizeRead != p.totalSize {
+		return nil, false, ErrSize
+	}
+
+	out := bytes.Join(p.parts, nil)
+	if uint64(len(out)) != p.totalSize {
+		return nil, false, ErrSize
+	}
+
+	return out, true, nil
+}
+
+// readFrame reads a frame and returns its payload. Credit, ack, reject, and filter frames are
+// handled here, and errCreditReceived, errAckReceived, errRejectReceived, or errFilterReceived is
+// returned after one instead of a payload.
+func (s *PacketSession) readFrame(chunk *DataFrame) ([]byte, error) {
+
+	s.setReadDeadline()
+	if s.FrameChecksums {
+		if err := s.readCheckedFrame(chunk); err != nil {
+			return nil, err
+		}
+	} else if err := chunk.Read(s.Connection); err != nil {
+		return nil, err
+	}
+	payload, err := s.checkSequence(chunk.GetPayload())
 	if err != nil {
 		return nil, err
 	}
+	s.recordFrame(captureInbound, chunk.GetType(), payload)
+
+	// The sender has already used up credit for the payload of a frame skipped after a checksum
+	// failure, so it is counted as received
+	if s.FlowControl && s.skippedPayload > 0 {
+		skipped := s.skippedPayload
+		s.skippedPayload = 0
+		if err := s.creditData(skipped); err != nil {
+			return nil, err
+		}
+	}
 
+	// Ack, reject, and filter frames are sent outside of flow control, like credit frames
 	switch chunk.GetType() {
-	case SingleFrame:
-		return chunk.GetPayload(), nil
-	case MultipartFrameFinal, MultipartFrame:
-		return nil, ErrMultipartSession
-	case MultipartFrameStart:
-		// Keep calm and carry on 👑
-	default:
-		return nil, ErrInvalidFrame
-	}
-
-	// We got this far, so we have a multipart message which we need to reassemble.
-
-	// No validity checking is performed on the actual data in a DataFrame, so we need to validate
-	// the total payload size.
-	var totalSize uint64
-	totalSize, err = strconv.ParseUint(string(chunk.GetPayload()), 10, 64)
-	if err != nil {
+	case AckFrame:
+		return nil, s.ackReceived(payload)
+	case RejectFrame:
+		return nil, s.rejectReceived(payload)
+	case FilterFrame:
+		return nil, s.filterReceived(payload)
+	}
+	if !s.FlowControl {
+		return payload, nil
+	}
+	if chunk.GetType() != CreditFrame {
+		return payload, s.creditData(uint64(len(payload)))
+	}
+
+	if err := s.addCredit(payload); err != nil {
 		return nil, err
 	}
+	return nil, errCreditReceived
+}
+
+// checkSequence makes sure that a frame's sequence number is the next one expected and returns
+// the rest of the frame's payload. Frames are delivered in order by TCP, so any other number
+// means that frames have been replayed, dropped, or tampered with.
+func (s *PacketSession) checkSequence(payload []byte) ([]byte, error) {
+
+	if !s.SequenceNumbers {
+		return payload, nil
+	}
+	if len(payload) <= sequenceSize {
+		return nil, ErrSize
+	}
+
+	// The frames skipped to get past a bad checksum used up sequence numbers, so the first good
+	// frame after them is taken as the new starting point as long as it doesn't go backward
+	sequence := binary.BigEndian.Uint64(payload)
+	if s.resynced && sequence >= s.recvSequence {
+		s.recvSequence = sequence
+	}
+	s.resynced = false
+	if sequence != s.recvSequence {
+		return nil, ErrReplayDetected
+	}
+	s.recvSequence++
+	return payload[sequenceSize:], nil
+}
 
-	msgparts := make([][]byte, 1)
-	var sizeRead uint64
+// ReadDocument reads a packet from the session and decodes it as a Document. The session's Limits
+// are always applied to the decoding, and any limits passed to the call restrict them further.
+// If the session is Acknowledged, Documents which have already been received are skipped.
+func (s *PacketSession) ReadDocument(limits ...DecodeLimits) (*Document, error) {
 
-	for sizeRead < totalSize {
-		err := chunk.Read(s.Connection)
+	for {
+		packet, err := s.Read()
 		if err != nil {
 			return nil, err
 		}
+		out, err := s.decodeDocument(packet, limits)
+		if err != errDuplicate {
+			return out, err
+		}
+	}
+}
 
-		msgparts = append(msgparts, chunk.GetPayload())
-		sizeRead += uint64(chunk.GetSize())
+// decodeDocument unflattens a packet read by the session and runs the Inbound interceptors on it.
+// If the session is Acknowledged, the Document is acknowledged first, and errDuplicate is
+// returned if it has already been received.
+func (s *PacketSession) decodeDocument(packet []byte, limits []DecodeLimits) (*Document, error) {
 
-		if chunk.GetType() == MultipartFrameFinal {
-			break
+	if err := s.checkCommandLength(packet); err != nil {
+		return nil, s.wrapError(err)
+	}
+	out := NewDocument()
+	if err := s.unflattenDocument(out, packet, s.callLimits(limits)); err != nil {
+		return nil, s.wrapError(err)
+	}
+	if err := s.expandKeys(out); err != nil {
+		return nil, s.wrapError(err)
+	}
+	if s.Acknowledged {
+		duplicate, err := s.acknowledge(out)
+		if err != nil {
+			s.noteError(err)
+			return nil, s.wrapError(err)
 		}
+		if duplicate {
+			return nil, errDuplicate
+		}
+	}
+	if err := runInterceptors(s.Inbound, out); err != nil {
+		return nil, s.wrapError(err)
 	}
+	return out, nil
+}
 
-	if sizeRead != totalSize {
-		return nil, ErrSize
+// WriteDocument flattens a Document and sends it as a packet. It is the counterpart to
+// ReadDocument().
+func (s *PacketSession) WriteDocument(doc *Document) error {
+
+	if err := runInterceptors(s.Outbound, doc); err != nil {
+		return s.wrapError(err)
+	}
+	packet, err := s.flattenDocument(doc)
+	if err != nil {
+		return s.wrapError(err)
 	}
+	return s.Write(packet)
+}
 
-	out := bytes.Join(msgparts, nil)
-	if uint64(len(out)) != totalSize {
-		return nil, ErrSize
+// flattenDocument flattens a Document to be sent, compacting its keys if the session has a
+// KeyDictionary and widening its container counts if WideLargeContainers is set. The Document
+// itself isn't changed, and ones which aren't keyed are sent without compaction.
+func (s *PacketSession) flattenDocument(doc *Document) ([]byte, error) {
+
+	if s.KeyDictionary != nil && len(doc.Items) > 0 && isMapType(doc.Items[0].GetType()) &&
+		doc.Items[0].GetType() != DFKeyedMapType {
+		compact := &Document{Items: doc.Items}
+		if err := compact.CompactKeys(s.KeyDictionary); err == nil {
+			doc = compact
+		}
+	}
+	if !s.WideLargeContainers {
+		return doc.Flatten()
 	}
 
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.WideLargeContainers = true
+	if err := e.EncodeDocument(doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// unflattenDocument is the counterpart to flattenDocument() for everything but the keys, which
+// expandKeys() handles
+func (s *PacketSession) unflattenDocument(doc *Document, packet []byte,
+	limits DecodeLimits) error {
+
+	d := NewDecoder(bytes.NewReader(packet))
+	d.Limits = limits
+	d.WideLargeContainers = s.WideLargeContainers
+	return doc.Decode(context.Background(), d)
+}
+
+// expandKeys puts back the field names of a received Document whose keys were compacted
+func (s *PacketSession) expandKeys(doc *Document) error {
+
+	if !doc.hasCompactKeys() {
+		return nil
+	}
+	if s.KeyDictionary == nil {
+		return ErrKeyDictionary
+	}
+	return doc.ExpandKeys(s.KeyDictionary)
+}
+
+// ReadSegmentMap reads a packet from the session and decodes it as a SegmentMap. Limits are
+// handled the same way as ReadDocument().
+func (s *PacketSession) ReadSegmentMap(limits ...DecodeLimits) (SegmentMap, error) {
+
+	packet, err := s.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkCommandLength(packet); err != nil {
+		return nil, s.wrapError(err)
+	}
+	out := make(SegmentMap)
+	bs := bytes.NewReader(packet)
+	if err := out.ReadLimited(bs, s.callLimits(limits)); err != nil {
+		return nil, s.wrapError(err)
+	}
 	return out, nil
 }
 
-// Write() is the sending counterpart to Read().
+// checkCommandLength returns ErrSize if a packet holding a command is longer than the session's
+// MaxCommandLength
+func (s *PacketSession) checkCommandLength(packet []byte) error {
+	if s.MaxCommandLength > 0 && len(packet) > s.MaxCommandLength {
+		return ErrSize
+	}
+	return nil
+}
+
+// callLimits combines the session's limits with those passed to an individual call
+func (s *PacketSession) callLimits(limits []DecodeLimits) DecodeLimits {
+	out := s.Limits
+	for _, l := range limits {
+		out = out.Restrict(l)
+	}
+	return out
+}
+
+// Write() is the sending counterpart to Read(). If the session has a write buffer, the packet
+// isn't necessarily sent until Flush() is called.
 func (s *PacketSession) Write(packet []byte) error {
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+
+	err := s.writePacket(packet)
+	s.noteError(err)
+	return s.wrapError(err)
+}
+
+// SetWriteBuffer makes the session collect outgoing frames in a buffer of the specified size
+// instead of writing each one to the connection separately. This cuts down on the number of
+// small TCP packets sent when writing many small packets in a row. Frames are sent when the
+// buffer fills or when Flush(), Read(), or Close() is called. A size of 0 turns buffering off.
+// Any frames already in the buffer are sent before the buffer is changed.
+func (s *PacketSession) SetWriteBuffer(size int) error {
+
+	// Writers pick the buffer once per packet, so it can't be swapped out partway through one
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+
+	if err := s.Flush(); err != nil {
+		return err
+	}
+
+	s.frameLock.Lock()
+	defer s.frameLock.Unlock()
+	if size <= 0 {
+		s.writer = nil
+	} else {
+		s.writer = bufio.NewWriterSize(s.Connection, size)
+	}
+	return nil
+}
+
+// Flush sends any frames waiting in the session's write buffer
+func (s *PacketSession) Flush() error {
+
+	err := s.flushFrames()
+	s.noteError(err)
+	return s.wrapError(err)
+}
+
+// flushFrames sends any frames waiting in the write buffer
+func (s *PacketSession) flushFrames() error {
+
+	s.frameLock.Lock()
+	defer s.frameLock.Unlock()
+	return s.flushLocked()
+}
+
+// flushIdle is the same as flushFrames(), but it does nothing if another goroutine is writing a
+// frame. Read() uses it so that it doesn't wait on a writer which is itself waiting for the other
+// side to read.
+func (s *PacketSession) flushIdle() error {
+
+	if !s.frameLock.TryLock() {
+		return nil
+	}
+	defer s.frameLock.Unlock()
+	return s.flushLocked()
+}
+
+// flushLocked sends the frames in the write buffer. The caller must hold frameLock.
+func (s *PacketSession) flushLocked() error {
+
+	if s.writer == nil || s.writer.Buffered() == 0 {
+		return nil
+	}
+	s.setWriteDeadline()
+	return s.writer.Flush()
+}
+
+// output returns where frames are written to
+func (s *PacketSession) output() io.Writer {
+	if s.writer != nil {
+		return s.writer
+	}
+	return s.Connection
+}
+
+func (s *PacketSession) writePacket(packet []byte) error {
 
 	if !s.isInit {
 		return ErrNoInit
@@ -288,17 +1281,21 @@ func (s *PacketSession) Write(packet []byte) error {
 	if packet == nil {
 		return ErrEmptyData
 	}
+	packet, err := s.compressPacket(packet)
+	if err != nil {
+		return err
+	}
 
+	w := s.output()
 	packetLen := len(packet)
 
+	ValueSize := s.NegotiatedChunkSize()
+
 	// If the packet is small enough to fit into a single frame, just send it and be done.
-	if packetLen < int(s.BufferSize)-3 {
-		s.UpdateTimeout()
-		return WriteFrame(s.Connection, SingleFrame, packet)
+	if packetLen < ValueSize {
+		return s.writeDataFrame(w, SingleFrame, packet)
 	}
 
-	ValueSize := int(s.BufferSize) - 3
-
 	// If the message is bigger than the max command length, then we will send the Value as
 	// a multipart message. This takes more work internally, but the benefits at the application
 	// level are worth it. Fortunately, by using a binary wire format, we don't have to flatten
@@ -308,14 +1305,20 @@ func (s *PacketSession) Write(packet []byte) error {
 	// total message size in the Value. All messages that follow contain the actual message data.
 	// The size Value is actually a decimal string of the total message size
 
-	if err := WriteFrame(s.Connection, MultipartFrameStart,
+	id := s.startTransfer()
+	defer s.endTransfer()
+
+	if err := s.writeDataFrame(w, MultipartFrameStart,
 		[]byte(fmt.Sprintf("%d", packetLen))); err != nil {
 		return err
 	}
 
 	var index int
 	for index+ValueSize < packetLen {
-		if err := WriteFrame(s.Connection, MultipartFrame,
+		if s.transferCanceled() {
+			return s.writeCancelFrame(w, id)
+		}
+		if err := s.writeDataFrame(w, MultipartFrame,
 			packet[index:index+ValueSize]); err != nil {
 			return err
 		}
@@ -323,5 +1326,43 @@ func (s *PacketSession) Write(packet []byte) error {
 		index += ValueSize
 	}
 
-	return WriteFrame(s.Connection, MultipartFrameFinal, packet[index:])
+	if s.transferCanceled() {
+		return s.writeCancelFrame(w, id)
+	}
+	return s.writeDataFrame(w, MultipartFrameFinal, packet[index:])
+}
+
+// writeDataFrame writes a frame holding part of a packet, waiting for credit first if flow
+// control is in use
+func (s *PacketSession) writeDataFrame(w io.Writer, frameType uint8, payload []byte) error {
+
+	if s.FlowControl {
+		if err := s.waitForCredit(uint64(len(payload))); err != nil {
+			return err
+		}
+	}
+
+	s.frameLock.Lock()
+	defer s.frameLock.Unlock()
+	return s.writeFrame(w, frameType, payload)
+}
+
+// writeFrame writes a frame for the session, adding a sequence number if they are in use. The
+// caller must hold frameLock.
+func (s *PacketSession) writeFrame(w io.Writer, frameType uint8, payload []byte) error {
+
+	s.setWriteDeadline()
+	s.recordFrame(captureOutbound, frameType, payload)
+	if s.SequenceNumbers {
+		sequenced := make([]byte, sequenceSize+len(payload))
+		binary.BigEndian.PutUint64(sequenced, s.sendSequence)
+		copy(sequenced[sequenceSize:], payload)
+		s.sendSequence++
+		payload = sequenced
+	}
+
+	if s.FrameChecksums {
+		return writeCheckedFrame(w, frameType, payload)
+	}
+	return WriteFrame(w, frameType, payload)
 }
@@ -1,10 +1,16 @@
+//go:build !nonet
+
 package oganesson
 
 import (
+	"bytes"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -20,7 +26,7 @@ func FrameSessionTestSetup(sync chan int, port string) {
 	}
 
 	s := NewPacketRequester(senderconn)
-	s.Timeout = time.Minute * 5
+	s.ReadTimeout = time.Minute * 5
 
 	err = s.InitRequester()
 	if err != nil {
@@ -53,12 +59,24 @@ func TestFrameSession(t *testing.T) {
 	defer conn.Close()
 
 	s := NewPacketResponder(conn, 32767)
-	s.Timeout = time.Minute * 5
+	s.ReadTimeout = time.Minute * 5
 
 	err = s.InitResponder()
 	if err != nil {
 		panic(fmt.Sprintf("Responder init failed: %s", err.Error()))
 	}
+	if len(s.ID()) != 8 {
+		t.Fatalf("Bad session ID after setup: '%s'", s.ID())
+	}
+
+	info, err := s.HandshakeInfo()
+	if err != nil {
+		t.Fatalf("Error getting handshake info: %s", err.Error())
+	}
+	if info.IsRequester || info.SessionID != s.ID() || info.RequestedBufferSize != 65535 ||
+		info.BufferSize != 32767 || len(info.TranscriptHash) != 32 {
+		t.Fatalf("Handshake info mismatch: %+v", info)
+	}
 
 	data, err := s.Read()
 	if err != nil {
@@ -81,7 +99,7 @@ func WriteMultipartMessageSetup(sync chan int, port string) {
 	}
 
 	s := NewPacketRequester(senderconn)
-	s.Timeout = time.Minute * 5
+	s.ReadTimeout = time.Minute * 5
 
 	err = s.InitRequester()
 	if err != nil {
@@ -120,7 +138,7 @@ func TestWriteMultipartMessage(t *testing.T) {
 	defer conn.Close()
 
 	s := NewPacketResponder(conn, 1024)
-	s.Timeout = time.Minute * 5
+	s.ReadTimeout = time.Minute * 5
 	err = s.InitResponder()
 	if err != nil {
 		t.Fatalf("Responder init failure: %s", err.Error())
@@ -178,7 +196,6 @@ func TestWriteMultipartMessage(t *testing.T) {
 // TestReadMultipartMessage uses the same setup function as TestWriteMultipartMessage to test
 // both multipart sending and receiving code in the Packet class
 func TestReadMultipartMessage1(t *testing.T) {
-	MaxCommandLength = 300
 	sync := make(chan int)
 	go WriteMultipartMessageSetup(sync, "3001")
 
@@ -195,8 +212,8 @@ func TestReadMultipartMessage1(t *testing.T) {
 	}
 	defer conn.Close()
 
-	s := NewPacketResponder(conn, 32767)
-	s.Timeout = time.Minute * 5
+	s := NewPacketResponder(conn, 32767, WithMaxCommandLength(300))
+	s.ReadTimeout = time.Minute * 5
 	err = s.InitResponder()
 	if err != nil {
 		t.Fatalf("Responder init failure: %s", err.Error())
@@ -207,3 +224,472 @@ func TestReadMultipartMessage1(t *testing.T) {
 		t.Fatalf("Failure to read WirePacket: %s", err.Error())
 	}
 }
+
+func TestSessionError(t *testing.T) {
+	s := PacketSession{id: "0123abcd"}
+
+	err := s.wrapError(ErrInvalidFrame)
+	if !errors.Is(err, ErrInvalidFrame) {
+		t.Fatalf("Wrapped session error doesn't match the original error")
+	}
+	if err.Error() != "session 0123abcd: invalid data frame" {
+		t.Fatalf("Wrapped session error message mismatch: %s", err.Error())
+	}
+	if s.wrapError(nil) != nil {
+		t.Fatalf("wrapError() wrapped a nil error")
+	}
+}
+
+// countingConn counts the Write calls made on a connection
+type countingConn struct {
+	net.Conn
+	writes int
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	c.writes++
+	return c.Conn.Write(b)
+}
+
+func TestWriteBuffer(t *testing.T) {
+	requester, responder := sessionPair(t, nil)
+	conn := &countingConn{Conn: requester.Connection}
+	requester.Connection = conn
+
+	if err := requester.SetWriteBuffer(8192); err != nil {
+		t.Fatalf("SetWriteBuffer failed: %s", err.Error())
+	}
+
+	// The last packet needs more than one frame to make sure multipart messages are buffered, too
+	packets := [][]byte{[]byte("first"), []byte("second"), []byte("third"),
+		[]byte(strings.Repeat("ABCDEFGHIJ", 500))}
+	for _, packet := range packets {
+		if err := requester.Write(packet); err != nil {
+			t.Fatalf("Buffered write failed: %s", err.Error())
+		}
+	}
+	if conn.writes != 0 {
+		t.Fatalf("Buffered frames were written before Flush()")
+	}
+
+	errChan := make(chan error)
+	go func() {
+		errChan <- requester.Flush()
+	}()
+	for _, packet := range packets {
+		received, err := responder.Read()
+		if err != nil {
+			t.Fatalf("Failed to read buffered packet: %s", err.Error())
+		}
+		if string(received) != string(packet) {
+			t.Fatalf("Buffered packet mismatch: %s", string(received))
+		}
+	}
+	if err := <-errChan; err != nil {
+		t.Fatalf("Flush failed: %s", err.Error())
+	}
+	if conn.writes != 1 {
+		t.Fatalf("Buffered frames took %d writes instead of 1", conn.writes)
+	}
+
+	if err := requester.SetWriteBuffer(0); err != nil {
+		t.Fatalf("Failed to turn off write buffer: %s", err.Error())
+	}
+	go requester.Write([]byte("unbuffered"))
+	if received, err := responder.Read(); err != nil || string(received) != "unbuffered" {
+		t.Fatalf("Unbuffered write failed after turning off the write buffer")
+	}
+}
+
+// setupSessionPair runs setup for a requester and a responder session over an in-memory
+// connection and returns both sides' errors. configure, if not nil, is called with both sessions
+// before setup so that a test can change their settings, including their connections.
+func setupSessionPair(configure func(req, resp *PacketSession)) (*PacketSession, *PacketSession,
+	error, error) {
+
+	clientConn, serverConn := net.Pipe()
+	requester := NewPacketRequester(clientConn)
+	responder := NewPacketResponder(serverConn, 4096)
+	if configure != nil {
+		configure(requester, responder)
+	}
+
+	errChan := make(chan error)
+	go func() {
+		errChan <- requester.InitRequester()
+	}()
+	responderErr := responder.InitResponder()
+	return requester, responder, <-errChan, responderErr
+}
+
+// sessionPair is the same as setupSessionPair(), but fails the test if setup fails
+func sessionPair(t *testing.T, configure func(req, resp *PacketSession)) (*PacketSession,
+	*PacketSession) {
+
+	requester, responder, reqErr, respErr := setupSessionPair(configure)
+	if respErr != nil {
+		t.Fatalf("Responder init failed: %s", respErr.Error())
+	}
+	if reqErr != nil {
+		t.Fatalf("Requester init failed: %s", reqErr.Error())
+	}
+	return requester, responder
+}
+
+func TestSequenceNumbers(t *testing.T) {
+	sequenced := func(requesterSeq, responderSeq bool) func(req, resp *PacketSession) {
+		return func(req, resp *PacketSession) {
+			req.SequenceNumbers = requesterSeq
+			resp.SequenceNumbers = responderSeq
+			resp.BufferSize = 1024
+		}
+	}
+	requester, responder, reqErr, respErr := setupSessionPair(sequenced(true, true))
+	if reqErr != nil || respErr != nil {
+		t.Fatalf("Sequenced session setup failed: %v / %v", reqErr, respErr)
+	}
+
+	// The second packet is big enough to be sent as a multipart message
+	packets := [][]byte{[]byte("first"), []byte(strings.Repeat("ABCDEFGHIJ", 300)),
+		[]byte("third")}
+	go func() {
+		for _, packet := range packets {
+			requester.Write(packet)
+		}
+	}()
+	for _, packet := range packets {
+		received, err := responder.Read()
+		if err != nil {
+			t.Fatalf("Failed to read sequenced packet: %s", err.Error())
+		}
+		if string(received) != string(packet) {
+			t.Fatalf("Sequenced packet mismatch: %s", string(received))
+		}
+	}
+
+	// Replaying the first frame must be caught
+	replay := append(make([]byte, sequenceSize), []byte("first")...)
+	go WriteFrame(requester.Connection, SingleFrame, replay)
+	if _, err := responder.Read(); !errors.Is(err, ErrReplayDetected) {
+		t.Fatalf("Replayed frame wasn't detected: %v", err)
+	}
+
+	for _, settings := range [][]bool{{true, false}, {false, true}} {
+		_, _, reqErr, respErr = setupSessionPair(sequenced(settings[0], settings[1]))
+		if !errors.Is(reqErr, ErrSessionSetup) && !errors.Is(respErr, ErrSessionSetup) {
+			t.Fatalf("Mismatched sequence number settings weren't caught: %v", settings)
+		}
+	}
+}
+
+func TestSessionInterceptors(t *testing.T) {
+	requester, responder := sessionPair(t, nil)
+
+	var sent, received int
+	requester.Outbound = []Interceptor{func(doc *Document) error {
+		sent++
+		return doc.AttachString("extra", "value")
+	}}
+	responder.Inbound = []Interceptor{func(doc *Document) error {
+		received++
+		if len(doc.Items) != 2 {
+			return ErrInvalidMsg
+		}
+		return nil
+	}}
+
+	doc := NewDocument()
+	doc.AttachInt8("value", 1)
+	go requester.WriteDocument(doc)
+	if _, err := responder.ReadDocument(); err != nil {
+		t.Fatalf("Intercepted document was rejected: %s", err.Error())
+	}
+	if sent != 1 || received != 1 {
+		t.Fatalf("Interceptors weren't called: %d sent, %d received", sent, received)
+	}
+
+	requester.Outbound = nil
+	doc = NewDocument()
+	go requester.WriteDocument(doc)
+	if _, err := responder.ReadDocument(); !errors.Is(err, ErrInvalidMsg) {
+		t.Fatalf("Inbound interceptor error wasn't returned")
+	}
+}
+
+func TestSessionKeyDictionary(t *testing.T) {
+	kd, err := NewKeyDictionary("RecipientAddress", "DeliveryTimestamp")
+	if err != nil {
+		t.Fatalf("Error creating key dictionary: %s", err.Error())
+	}
+	requester, responder := sessionPair(t, nil)
+	requester.KeyDictionary = kd
+	responder.KeyDictionary = kd
+
+	fields := make(SegmentMap)
+	fields.SetString("RecipientAddress", "bob@example.com")
+	fields.SetInt64("DeliveryTimestamp", 1700000000)
+	items, err := keyedItems(fields)
+	if err != nil {
+		t.Fatalf("Error building keyed document: %s", err.Error())
+	}
+	doc := &Document{Items: items}
+	original, _ := doc.Flatten()
+
+	go requester.WriteDocument(doc)
+	received, err := responder.ReadDocument()
+	if err != nil {
+		t.Fatalf("Error reading compacted document: %s", err.Error())
+	}
+	if data, _ := received.Flatten(); !bytes.Equal(data, original) {
+		t.Fatalf("Received document doesn't match the one sent")
+	}
+	if data, _ := doc.Flatten(); !bytes.Equal(data, original) {
+		t.Fatalf("Sending changed the document")
+	}
+
+	responder.KeyDictionary = nil
+	go requester.WriteDocument(doc)
+	if _, err := responder.ReadDocument(); !errors.Is(err, ErrKeyDictionary) {
+		t.Fatalf("Compacted document without a dictionary wasn't caught: %v", err)
+	}
+}
+
+func TestSessionWideLargeContainers(t *testing.T) {
+	requester, responder := sessionPair(t, nil)
+	requester.WideLargeContainers = true
+	responder.WideLargeContainers = true
+	responder.MaxCommandLength = 0
+
+	fields := make(SegmentMap, 70000)
+	for i := 0; i < 70000; i++ {
+		fields.SetUInt8(strconv.Itoa(i), uint8(i))
+	}
+	items, err := keyedItems(fields)
+	if err != nil {
+		t.Fatalf("Error building keyed document: %s", err.Error())
+	}
+	doc := &Document{Items: items}
+	original, _ := doc.Flatten()
+
+	go requester.WriteDocument(doc)
+	received, err := responder.ReadDocument()
+	if err != nil {
+		t.Fatalf("Error reading wide document: %s", err.Error())
+	}
+	if data, _ := received.Flatten(); !bytes.Equal(data, original) {
+		t.Fatalf("Received document doesn't match the one sent")
+	}
+}
+
+func TestChunkSizeNegotiation(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	requester := NewPacketRequester(clientConn)
+	requester.BufferSize = 2048
+	responder := NewPacketResponder(serverConn, 4096)
+	if requester.NegotiatedChunkSize() != 0 {
+		t.Fatalf("Chunk size reported before setup")
+	}
+
+	errChan := make(chan error)
+	go func() {
+		errChan <- requester.InitRequester()
+	}()
+	if err := responder.InitResponder(); err != nil {
+		t.Fatalf("Responder init failed: %s", err.Error())
+	}
+	if err := <-errChan; err != nil {
+		t.Fatalf("Requester init failed: %s", err.Error())
+	}
+
+	// The smaller buffer wins no matter which side has it
+	for _, s := range []*PacketSession{requester, responder} {
+		if s.BufferSize != 2048 || s.NegotiatedChunkSize() != 2045 {
+			t.Fatalf("Chunk size mismatch: %d", s.NegotiatedChunkSize())
+		}
+	}
+
+	packet := []byte(strings.Repeat("ABCDEFGHIJ", 1000))
+	go responder.Write(packet)
+	received, err := requester.Read()
+	if err != nil {
+		t.Fatalf("Failed to read packet from larger side: %s", err.Error())
+	}
+	if string(received) != string(packet) {
+		t.Fatalf("Packet mismatch after chunk size negotiation")
+	}
+
+	// Buffers too small to hold a frame are refused
+	clientConn, serverConn = net.Pipe()
+	requester = NewPacketRequester(clientConn)
+	requester.BufferSize = 512
+	responder = NewPacketResponder(serverConn, 4096)
+	go func() {
+		errChan <- requester.InitRequester()
+	}()
+	respErr := responder.InitResponder()
+	reqErr := <-errChan
+	if !errors.Is(respErr, ErrSessionSetup) || !errors.Is(reqErr, ErrSessionSetup) {
+		t.Fatalf("Undersized buffer wasn't refused: %v / %v", reqErr, respErr)
+	}
+}
+
+func TestSessionTimeouts(t *testing.T) {
+	// Nothing answers the setup request, so the handshake timeout has to end it
+	clientConn, _ := net.Pipe()
+	requester := NewPacketRequester(clientConn)
+	requester.HandshakeTimeout = 50 * time.Millisecond
+	var netErr net.Error
+	if err := requester.InitRequester(); !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("Handshake timeout didn't apply: %v", err)
+	}
+
+	clientConn, serverConn := net.Pipe()
+	requester = NewPacketRequester(clientConn)
+	requester.HandshakeTimeout = 50 * time.Millisecond
+	responder := NewPacketResponder(serverConn, 4096)
+	responder.HandshakeTimeout = 50 * time.Millisecond
+	responder.ReadTimeout = 100 * time.Millisecond
+	errChan := make(chan error)
+	go func() {
+		errChan <- requester.InitRequester()
+	}()
+	if err := responder.InitResponder(); err != nil {
+		t.Fatalf("Responder init failed: %s", err.Error())
+	}
+	if err := <-errChan; err != nil {
+		t.Fatalf("Requester init failed: %s", err.Error())
+	}
+
+	// The handshake deadline must not outlive setup
+	time.Sleep(75 * time.Millisecond)
+	go requester.Write([]byte("late"))
+	if packet, err := responder.Read(); err != nil || string(packet) != "late" {
+		t.Fatalf("Read after handshake timeout failed: %v", err)
+	}
+
+	start := time.Now()
+	if _, err := responder.Read(); !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("Read timeout didn't apply: %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("Read timeout took too long")
+	}
+}
+
+func TestSessionOptions(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+
+	requester := NewPacketRequester(clientConn)
+	if requester.BufferSize != DefaultBufferSize || requester.MaxCommandLength != MaxCommandLength ||
+		requester.ReadTimeout != DefaultReadTimeout {
+		t.Fatalf("Package defaults weren't applied")
+	}
+
+	requester = NewPacketRequester(clientConn, WithBufferSize(2048), WithMaxCommandLength(300),
+		WithTimeouts(time.Second, 2*time.Second, 3*time.Second))
+	if requester.BufferSize != 2048 || requester.MaxCommandLength != 300 ||
+		requester.ReadTimeout != time.Second || requester.WriteTimeout != 2*time.Second ||
+		requester.HandshakeTimeout != 3*time.Second {
+		t.Fatalf("Options weren't applied")
+	}
+
+	if NewPacketResponder(serverConn, 4096, WithBufferSize(100)) != nil {
+		t.Fatalf("Option with a buffer size which is too small was accepted")
+	}
+	if responder := NewPacketResponder(serverConn, 100, WithBufferSize(4096)); responder == nil {
+		t.Fatalf("Buffer size option didn't replace the constructor's size")
+	}
+}
+
+func TestMaxCommandLength(t *testing.T) {
+	requester, responder := sessionPair(t, nil)
+	responder.MaxCommandLength = 300
+
+	small := NewDocument("Small")
+	large := NewDocument("Large")
+	large.AttachString("data", strings.Repeat("x", 400))
+	go func() {
+		requester.WriteDocument(small)
+		requester.WriteDocument(large)
+		requester.WriteDocument(large)
+		requester.Write([]byte(strings.Repeat("x", 400)))
+	}()
+
+	if doc, err := responder.ReadDocument(); err != nil || doc.MessageCode() != "Small" {
+		t.Fatalf("Document within the limit wasn't read: %v", err)
+	}
+	if _, err := responder.ReadDocument(); !errors.Is(err, ErrSize) {
+		t.Fatalf("Document longer than MaxCommandLength wasn't rejected: %v", err)
+	}
+	if _, err := responder.ReadSegmentMap(); !errors.Is(err, ErrSize) {
+		t.Fatalf("SegmentMap longer than MaxCommandLength wasn't rejected: %v", err)
+	}
+	if packet, err := responder.Read(); err != nil || len(packet) != 400 {
+		t.Fatalf("Bulk data was limited by MaxCommandLength: %v", err)
+	}
+}
+
+func TestConcurrentReadWrite(t *testing.T) {
+	requester, responder := sessionPair(t, nil)
+
+	const writers, perWriter = 4, 50
+	doc := NewDocument()
+	doc.AttachString("shared", strings.Repeat("data", 2000))
+	doc.Freeze()
+
+	// The responder echoes everything back while the requester reads and writes at the same time
+	go func() {
+		for i := 0; i < writers*perWriter; i++ {
+			packet, err := responder.Read()
+			if err != nil {
+				return
+			}
+			responder.Write(packet)
+		}
+	}()
+
+	done := make(chan error)
+	go func() {
+		for i := 0; i < writers*perWriter; i++ {
+			if _, err := requester.ReadDocument(); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				if i == perWriter/2 {
+					requester.SetWriteBuffer(4096 * (w % 2))
+				}
+				if err := requester.WriteDocument(doc); err != nil {
+					t.Errorf("Error writing shared document: %s", err.Error())
+					return
+				}
+			}
+			requester.Flush()
+		}(w)
+	}
+	wg.Wait()
+	requester.Flush()
+	if err := <-done; err != nil {
+		t.Fatalf("Error reading echoed document: %s", err.Error())
+	}
+}
+
+func TestReadDocumentOversizedPayload(t *testing.T) {
+	requester, responder := sessionPair(t, nil)
+
+	// A HugeBinary segment whose size field claims far more data than the packet holds
+	data, _ := hex.DecodeString("1101010e00790179020000000000c50001")
+	go WriteFrame(requester.Connection, SingleFrame, data)
+	if _, err := responder.ReadDocument(); err == nil {
+		t.Fatalf("Session accepted a truncated oversized payload")
+	}
+}
@@ -0,0 +1,52 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// pipePrefix is the namespace which holds the names of local named pipes
+const pipePrefix = `\\.\pipe\`
+
+// DialPacketRequesterPipe connects to a Windows named pipe and sets up a requester session. The
+// name may be given with or without the \\.\pipe\ prefix. Timeouts depend on the pipe having
+// been opened for overlapped I/O by its server, so they may not apply.
+func DialPacketRequesterPipe(name string) (*PacketSession, error) {
+
+	if !strings.HasPrefix(name, `\\`) {
+		name = pipePrefix + name
+	}
+	f, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	session := NewPacketRequester(&pipeConn{f})
+	if err := session.InitRequester(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return session, nil
+}
+
+// pipeConn makes an open named pipe usable as a net.Conn
+type pipeConn struct {
+	*os.File
+}
+
+// pipeAddr is the address of a named pipe
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+func (c *pipeConn) LocalAddr() net.Addr  { return pipeAddr(c.Name()) }
+func (c *pipeConn) RemoteAddr() net.Addr { return pipeAddr(c.Name()) }
+
+func (c *pipeConn) SetDeadline(t time.Time) error {
+	return c.File.SetDeadline(t)
+}
@@ -0,0 +1,10 @@
+module github.com/darkwyrm/oganesson/protoconv
+
+go 1.23
+
+require (
+	github.com/darkwyrm/oganesson v0.0.0
+	google.golang.org/protobuf v1.36.12
+)
+
+replace github.com/darkwyrm/oganesson => ../
@@ -0,0 +1,199 @@
+// Package protoconv converts protocol buffer messages to and from Documents using the message's
+// descriptor, which makes it possible to move an existing protobuf service over to JBitPack one
+// message at a time. It is a separate module with its own go.mod so that programs which
+// only use the oganesson package don't pull in protobuf.
+//
+// A message becomes a keyed Document: a single map whose keys are the proto field names. Only
+// populated fields are included. Field kinds map to segment types as follows:
+//
+//	bool                          Bool
+//	int32, sint32, sfixed32       Int32
+//	uint32, fixed32               UInt32
+//	int64, sint64, sfixed64       Int64
+//	uint64, fixed64               UInt64
+//	float                         Float32
+//	double                        Float64
+//	string                        String
+//	bytes                         Binary
+//	enum                          Int32 holding the enum value's number
+//	message                       Binary holding the flattened Document for the message
+//
+// JBitPack containers can't be nested, so repeated and map fields are not supported.
+package protoconv
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/darkwyrm/oganesson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+var ErrUnsupportedField = errors.New("unsupported protobuf field")
+
+// ToDocument converts a protobuf message to a Document
+func ToDocument(msg proto.Message) (*oganesson.Document, error) {
+
+	b := oganesson.NewDocumentBuilder(nil)
+	var err error
+	msg.ProtoReflect().Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		var seg oganesson.Segment
+		if seg, err = fieldToSegment(fd, v); err == nil {
+			err = b.Add(string(fd.Name()), seg)
+		}
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b.Build()
+}
+
+// FromDocument fills in a protobuf message from a Document created by ToDocument(). Fields in the
+// Document which aren't in the message's descriptor are ignored.
+func FromDocument(doc *oganesson.Document, msg proto.Message) error {
+
+	fields, err := documentFields(doc)
+	if err != nil {
+		return err
+	}
+
+	m := msg.ProtoReflect()
+	descFields := m.Descriptor().Fields()
+	for i := 0; i < descFields.Len(); i++ {
+		fd := descFields.Get(i)
+		seg, ok := fields[string(fd.Name())]
+		if !ok {
+			continue
+		}
+
+		v, err := segmentToField(fd, m, seg)
+		if err != nil {
+			return err
+		}
+		m.Set(fd, v)
+	}
+	return nil
+}
+
+// fieldToSegment converts the value of a single field
+func fieldToSegment(fd protoreflect.FieldDescriptor, v protoreflect.Value) (oganesson.Segment,
+	error) {
+
+	var out oganesson.Segment
+	if fd.IsList() || fd.IsMap() {
+		return out, ErrUnsupportedField
+	}
+
+	var err error
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		err = out.SetBool(v.Bool())
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		err = out.SetInt32(int32(v.Int()))
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		err = out.SetUInt32(uint32(v.Uint()))
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		err = out.SetInt64(v.Int())
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		err = out.SetUInt64(v.Uint())
+	case protoreflect.FloatKind:
+		err = out.SetFloat32(float32(v.Float()))
+	case protoreflect.DoubleKind:
+		err = out.SetFloat64(v.Float())
+	case protoreflect.StringKind:
+		err = out.SetString(v.String())
+	case protoreflect.BytesKind:
+		err = out.SetBinary(v.Bytes())
+	case protoreflect.EnumKind:
+		err = out.SetInt32(int32(v.Enum()))
+	case protoreflect.MessageKind:
+		var doc *oganesson.Document
+		if doc, err = ToDocument(v.Message().Interface()); err != nil {
+			return out, err
+		}
+		var data []byte
+		if data, err = doc.Flatten(); err == nil {
+			err = out.SetBinary(data)
+		}
+	default:
+		err = ErrUnsupportedField
+	}
+	return out, err
+}
+
+// segmentToField is the counterpart to fieldToSegment(). The message is needed to create nested
+// messages.
+func segmentToField(fd protoreflect.FieldDescriptor, m protoreflect.Message,
+	seg oganesson.Segment) (protoreflect.Value, error) {
+
+	if fd.IsList() || fd.IsMap() {
+		return protoreflect.Value{}, ErrUnsupportedField
+	}
+
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		v, err := seg.GetBool()
+		return protoreflect.ValueOfBool(v), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		v, err := seg.GetInt32()
+		return protoreflect.ValueOfInt32(v), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		v, err := seg.GetUInt32()
+		return protoreflect.ValueOfUint32(v), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		v, err := seg.GetInt64()
+		return protoreflect.ValueOfInt64(v), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		v, err := seg.GetUInt64()
+		return protoreflect.ValueOfUint64(v), err
+	case protoreflect.FloatKind:
+		v, err := seg.GetFloat32()
+		return protoreflect.ValueOfFloat32(v), err
+	case protoreflect.DoubleKind:
+		v, err := seg.GetFloat64()
+		return protoreflect.ValueOfFloat64(v), err
+	case protoreflect.StringKind:
+		v, err := seg.GetString()
+		return protoreflect.ValueOfString(v), err
+	case protoreflect.BytesKind:
+		v, err := seg.GetBinary()
+		return protoreflect.ValueOfBytes(append([]byte(nil), v...)), err
+	case protoreflect.EnumKind:
+		v, err := seg.GetInt32()
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(v)), err
+	case protoreflect.MessageKind:
+		data, err := seg.GetBinary()
+		if err != nil {
+			return protoreflect.Value{}, err
+		}
+		var doc oganesson.Document
+		if err := doc.Unflatten(data); err != nil {
+			return protoreflect.Value{}, err
+		}
+		nested := m.NewField(fd)
+		if err := FromDocument(&doc, nested.Message().Interface()); err != nil {
+			return protoreflect.Value{}, err
+		}
+		return nested, nil
+	}
+	return protoreflect.Value{}, ErrUnsupportedField
+}
+
+// documentFields reads the map from a keyed Document
+func documentFields(doc *oganesson.Document) (oganesson.SegmentMap, error) {
+
+	var buf bytes.Buffer
+	for _, item := range doc.Items {
+		if err := item.Write(&buf); err != nil {
+			return nil, err
+		}
+	}
+
+	out := make(oganesson.SegmentMap)
+	if err := out.Read(&buf); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
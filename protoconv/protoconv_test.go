@@ -0,0 +1,45 @@
+package protoconv
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestProtoConversion(t *testing.T) {
+	msg := &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String("testField"),
+		Number:   proto.Int32(7),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+		JsonName: proto.String("test_field"),
+		Options:  &descriptorpb.FieldOptions{Deprecated: proto.Bool(true)},
+	}
+
+	doc, err := ToDocument(msg)
+	if err != nil {
+		t.Fatalf("ToDocument failed: %s", err.Error())
+	}
+
+	// A map index segment followed by 6 key-value pairs
+	if len(doc.Items) != 13 {
+		t.Fatalf("ToDocument item count mismatch: %d", len(doc.Items))
+	}
+
+	var out descriptorpb.FieldDescriptorProto
+	if err := FromDocument(doc, &out); err != nil {
+		t.Fatalf("FromDocument failed: %s", err.Error())
+	}
+	if !proto.Equal(msg, &out) {
+		t.Fatalf("Protobuf round trip mismatch: %v", &out)
+	}
+
+	// Repeated fields aren't supported
+	repeated := &descriptorpb.EnumDescriptorProto{
+		Value: []*descriptorpb.EnumValueDescriptorProto{{Name: proto.String("A")}},
+	}
+	if _, err := ToDocument(repeated); err != ErrUnsupportedField {
+		t.Fatalf("ToDocument accepted a repeated field")
+	}
+}
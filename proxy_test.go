@@ -0,0 +1,164 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// proxyTarget starts a listener which answers each session with a packet
+func proxyTarget(t *testing.T) net.Listener {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error setting up listener: %s", err.Error())
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			responder := NewPacketResponder(conn, 4096)
+			if responder.InitResponder() == nil {
+				responder.Write([]byte("proxied"))
+			}
+		}
+	}()
+	return listener
+}
+
+// runProxy accepts connections and hands each one to a function which returns the address to
+// relay it to, or an empty string to drop it
+func runProxy(t *testing.T, handshake func(conn net.Conn, br *bufio.Reader) string) net.Listener {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error setting up proxy: %s", err.Error())
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				target := handshake(conn, br)
+				if target == "" {
+					return
+				}
+				upstream, err := net.Dial("tcp", target)
+				if err != nil {
+					return
+				}
+				defer upstream.Close()
+				go io.Copy(upstream, br)
+				io.Copy(conn, upstream)
+			}()
+		}
+	}()
+	return listener
+}
+
+// socks5Handshake is a minimal SOCKS5 server which requires the user name "user" and password
+// "secret"
+func socks5Handshake(conn net.Conn, br *bufio.Reader) string {
+
+	head := make([]byte, 2)
+	io.ReadFull(br, head)
+	io.ReadFull(br, make([]byte, head[1]))
+	conn.Write([]byte{5, socksPasswordAuth})
+
+	io.ReadFull(br, head)
+	user := make([]byte, head[1])
+	io.ReadFull(br, user)
+	length, _ := br.ReadByte()
+	password := make([]byte, length)
+	io.ReadFull(br, password)
+	if string(user) != "user" || string(password) != "secret" {
+		conn.Write([]byte{1, 1})
+		return ""
+	}
+	conn.Write([]byte{1, 0})
+
+	request := make([]byte, 4)
+	io.ReadFull(br, request)
+	var host string
+	switch request[3] {
+	case socksIPv4:
+		ip := make([]byte, 4)
+		io.ReadFull(br, ip)
+		host = net.IP(ip).String()
+	case socksDomain:
+		length, _ := br.ReadByte()
+		name := make([]byte, length)
+		io.ReadFull(br, name)
+		host = string(name)
+	}
+	port := make([]byte, 2)
+	io.ReadFull(br, port)
+	conn.Write([]byte{5, 0, 0, socksIPv4, 0, 0, 0, 0, 0, 0})
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+}
+
+// connectHandshake is a minimal HTTP CONNECT proxy which requires Basic authentication
+func connectHandshake(conn net.Conn, br *bufio.Reader) string {
+
+	req, err := http.ReadRequest(br)
+	if err != nil || req.Method != http.MethodConnect {
+		return ""
+	}
+	if req.Header.Get("Proxy-Authorization") != "Basic dXNlcjpzZWNyZXQ=" {
+		conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+		return ""
+	}
+	conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	return req.Host
+}
+
+func TestProxyDialer(t *testing.T) {
+	target := proxyTarget(t)
+	defer target.Close()
+
+	proxies := map[string]func(net.Conn, *bufio.Reader) string{
+		"socks5": socks5Handshake,
+		"http":   connectHandshake,
+	}
+	for scheme, handshake := range proxies {
+		proxy := runProxy(t, handshake)
+		defer proxy.Close()
+
+		dialer, err := NewProxyDialer(scheme + "://user:secret@" + proxy.Addr().String())
+		if err != nil {
+			t.Fatalf("Error creating %s proxy dialer: %s", scheme, err.Error())
+		}
+		session, err := DialRequester(context.Background(), dialer, target.Addr().String())
+		if err != nil {
+			t.Fatalf("Error connecting through %s proxy: %s", scheme, err.Error())
+		}
+		if packet, err := session.Read(); err != nil || string(packet) != "proxied" {
+			t.Fatalf("Session through %s proxy doesn't work: %v", scheme, err)
+		}
+		session.Close()
+
+		dialer, _ = NewProxyDialer(scheme + "://user:wrong@" + proxy.Addr().String())
+		_, err = DialRequester(context.Background(), dialer, target.Addr().String())
+		if !errors.Is(err, ErrProxyAuth) {
+			t.Fatalf("Bad %s proxy credentials weren't caught: %v", scheme, err)
+		}
+	}
+
+	if _, err := NewProxyDialer("ftp://proxy:21"); !errors.Is(err, ErrProxyFailed) {
+		t.Fatalf("Unsupported proxy scheme was accepted")
+	}
+}
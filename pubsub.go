@@ -0,0 +1,278 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// SubscriptionBufferSize is the number of published Documents which can wait in a subscription's
+// channel. Documents published while the channel is full are dropped.
+var SubscriptionBufferSize = 64
+
+// This file contains a simple publish/subscribe mechanism which works directly between the two
+// sides of a session without a broker. Publish() sends a Document tagged with a topic, and the
+// other side hands it to its subscribers for that topic, if it has any. Documents for topics
+// without subscribers are discarded. Published Documents are delivered while the receiving
+// session is being read, so a session which only receives published Documents still needs a
+// goroutine calling Read().
+//
+// Subscriptions made with SubscribeFiltered() only receive the Documents which match their
+// Filter. The filters are also sent to the other side in a filter frame, so that the publisher
+// can skip sending Documents which none of the subscribers to a topic want. Once a topic's
+// filters have been sent, they are sent again whenever the topic's subscriptions change. The
+// publisher finds out about them when it reads the filter frame, so this only happens if
+// something is reading the publishing side, such as the reader started by Incoming(). Until then,
+// everything is sent and the filters are applied when the Documents arrive.
+
+// errFilterReceived is returned by readFrame() when a filter frame arrives instead of packet data
+var errFilterReceived = errors.New("filter received")
+
+// subscription is a channel receiving the Documents published to a topic
+type subscription struct {
+	ch     chan *Document
+	filter *Filter
+}
+
+// Subscribe returns a channel which receives the Documents published to a topic by the other
+// side of the session. The channel is closed by Unsubscribe() or Close().
+func (s *PacketSession) Subscribe(topic string) (<-chan *Document, error) {
+	return s.subscribe(topic, nil)
+}
+
+// SubscribeFiltered is the same as Subscribe(), but the channel only receives the Documents which
+// match the filter. The filter is sent to the other side, so the session must have been set up.
+func (s *PacketSession) SubscribeFiltered(topic string, filter *Filter) (<-chan *Document,
+	error) {
+
+	if filter == nil {
+		return nil, ErrInvalidFilter
+	}
+	if !s.isInit {
+		return nil, s.wrapError(ErrNoInit)
+	}
+	return s.subscribe(topic, filter)
+}
+
+// subscribe does the work for Subscribe() and SubscribeFiltered()
+func (s *PacketSession) subscribe(topic string, filter *Filter) (<-chan *Document, error) {
+
+	if topic == "" || len(topic) > 255 {
+		return nil, ErrInvalidKey
+	}
+
+	s.subscriptionLock.Lock()
+	defer s.subscriptionLock.Unlock()
+
+	if s.subscriptions == nil {
+		s.subscriptions = make(map[string][]subscription)
+	}
+	out := make(chan *Document, SubscriptionBufferSize)
+	s.subscriptions[topic] = append(s.subscriptions[topic], subscription{ch: out, filter: filter})
+
+	if filter != nil || s.announcedTopics[topic] {
+		if err := s.sendFilters(topic); err != nil {
+			s.subscriptions[topic] = s.subscriptions[topic][:len(s.subscriptions[topic])-1]
+			return nil, s.wrapError(err)
+		}
+	}
+	return out, nil
+}
+
+// Unsubscribe closes all of the subscriptions to a topic
+func (s *PacketSession) Unsubscribe(topic string) {
+
+	s.subscriptionLock.Lock()
+	defer s.subscriptionLock.Unlock()
+
+	for _, sub := range s.subscriptions[topic] {
+		close(sub.ch)
+	}
+	delete(s.subscriptions, topic)
+
+	if s.announcedTopics[topic] {
+		if err := s.sendFilters(topic); err != nil {
+			logWarning("session %s couldn't send filters for %s: %s", s.ID(), topic,
+				err.Error())
+		}
+	}
+}
+
+// Publish sends a Document to the other side's subscribers for a topic. If the other side has
+// sent filters for the topic and none of them match the Document, it isn't sent.
+func (s *PacketSession) Publish(topic string, doc *Document) error {
+
+	if topic == "" || len(topic) > 255 {
+		return ErrInvalidKey
+	}
+	if !s.isInit {
+		return s.wrapError(ErrNoInit)
+	}
+
+	if err := runInterceptors(s.Outbound, doc); err != nil {
+		return s.wrapError(err)
+	}
+	if !s.peerWants(topic, doc) {
+		return nil
+	}
+	packet, err := s.flattenDocument(doc)
+	if err != nil {
+		return s.wrapError(err)
+	}
+
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+
+	err = s.writeDataFrame(s.output(), TopicFrame, []byte(topic))
+	if err == nil {
+		err = s.writePacket(packet)
+	}
+	s.noteError(err)
+	return s.wrapError(err)
+}
+
+// deliver decodes a published packet and passes it to the topic's subscribers
+func (s *PacketSession) deliver(topic string, packet []byte) error {
+
+	doc := NewDocument()
+	if err := s.unflattenDocument(doc, packet, s.Limits); err != nil {
+		return err
+	}
+	if err := s.expandKeys(doc); err != nil {
+		return err
+	}
+	if err := runInterceptors(s.Inbound, doc); err != nil {
+		return err
+	}
+
+	s.subscriptionLock.Lock()
+	defer s.subscriptionLock.Unlock()
+
+	for _, sub := range s.subscriptions[topic] {
+		if sub.filter != nil && !sub.filter.Match(doc) {
+			continue
+		}
+		select {
+		case sub.ch <- doc:
+		default:
+			logWarning("session %s: subscription to %s is full, dropping published document",
+				s.ID(), topic)
+		}
+	}
+	return nil
+}
+
+// closeSubscriptions closes all of the session's subscriptions
+func (s *PacketSession) closeSubscriptions() {
+
+	s.subscriptionLock.Lock()
+	defer s.subscriptionLock.Unlock()
+
+	for _, subs := range s.subscriptions {
+		for _, sub := range subs {
+			close(sub.ch)
+		}
+	}
+	s.subscriptions = nil
+}
+
+// sendFilters sends a filter frame with the filters of a topic's subscriptions. An empty
+// expression stands for a subscription without a filter. The caller must hold
+// subscriptionLock.
+func (s *PacketSession) sendFilters(topic string) error {
+
+	payload := append([]byte{uint8(len(topic))}, topic...)
+	for _, sub := range s.subscriptions[topic] {
+		var expr string
+		if sub.filter != nil {
+			expr = sub.filter.String()
+		}
+		payload = binary.BigEndian.AppendUint16(payload, uint16(len(expr)))
+		payload = append(payload, expr...)
+	}
+	if len(payload) > s.NegotiatedChunkSize() {
+		return ErrSize
+	}
+
+	if s.announcedTopics == nil {
+		s.announcedTopics = make(map[string]bool)
+	}
+	s.announcedTopics[topic] = true
+
+	s.frameLock.Lock()
+	defer s.frameLock.Unlock()
+
+	if err := s.writeFrame(s.output(), FilterFrame, payload); err != nil {
+		return err
+	}
+	if s.writer != nil {
+		return s.writer.Flush()
+	}
+	return nil
+}
+
+// filterReceived records the filters sent by the other side for one of its topics
+func (s *PacketSession) filterReceived(payload []byte) error {
+
+	topic, filters, err := parseFilterFrame(payload)
+	if err != nil {
+		return err
+	}
+
+	s.subscriptionLock.Lock()
+	defer s.subscriptionLock.Unlock()
+
+	if s.peerFilters == nil {
+		s.peerFilters = make(map[string][]*Filter)
+	}
+	s.peerFilters[topic] = filters
+	return errFilterReceived
+}
+
+// parseFilterFrame splits the payload of a filter frame into its topic and filters. Filters which
+// can't be parsed are treated as matching everything, since the subscriber filters the Documents
+// it receives anyway.
+func parseFilterFrame(payload []byte) (string, []*Filter, error) {
+
+	if len(payload) == 0 || len(payload) < 1+int(payload[0]) {
+		return "", nil, ErrInvalidFrame
+	}
+	topic := string(payload[1 : 1+payload[0]])
+	rest := payload[1+payload[0]:]
+
+	filters := make([]*Filter, 0)
+	for len(rest) > 0 {
+		if len(rest) < 2 || len(rest) < 2+int(binary.BigEndian.Uint16(rest)) {
+			return "", nil, ErrInvalidFrame
+		}
+		expr := string(rest[2 : 2+binary.BigEndian.Uint16(rest)])
+		rest = rest[2+len(expr):]
+
+		var filter *Filter
+		if expr != "" {
+			filter, _ = ParseFilter(expr)
+		}
+		filters = append(filters, filter)
+	}
+	return topic, filters, nil
+}
+
+// peerWants returns true if the other side has a subscriber which wants a Document published to
+// a topic. It is assumed to have one unless it has sent filters for the topic.
+func (s *PacketSession) peerWants(topic string, doc *Document) bool {
+
+	s.subscriptionLock.Lock()
+	defer s.subscriptionLock.Unlock()
+
+	filters, known := s.peerFilters[topic]
+	if !known {
+		return true
+	}
+	for _, filter := range filters {
+		if filter == nil || filter.Match(doc) {
+			return true
+		}
+	}
+	return false
+}
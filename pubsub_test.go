@@ -0,0 +1,134 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPublishSubscribe(t *testing.T) {
+	requester, responder := sessionPair(t, nil)
+
+	events, err := responder.Subscribe("events")
+	if err != nil {
+		t.Fatalf("Subscribe failed: %s", err.Error())
+	}
+	if _, err := responder.Subscribe(""); err != ErrInvalidKey {
+		t.Fatalf("Subscribe accepted an empty topic")
+	}
+
+	// The large event needs a multipart message
+	small := NewDocument()
+	small.AttachString("event", "small")
+	large := NewDocument()
+	large.AttachString("event", strings.Repeat("large", 2000))
+
+	go func() {
+		requester.Publish("events", small)
+		requester.Publish("ignored", small)
+		requester.Publish("events", large)
+		requester.Write([]byte("regular packet"))
+	}()
+
+	// Published documents are delivered while reading, so the regular packet comes back from
+	// Read() after both events have been delivered
+	packet, err := responder.Read()
+	if err != nil {
+		t.Fatalf("Read failed: %s", err.Error())
+	}
+	if string(packet) != "regular packet" {
+		t.Fatalf("Published document was returned by Read()")
+	}
+
+	for _, want := range []*Document{small, large} {
+		got := <-events
+		wantFlat, _ := want.Flatten()
+		gotFlat, _ := got.Flatten()
+		if string(wantFlat) != string(gotFlat) {
+			t.Fatalf("Published document mismatch")
+		}
+	}
+	select {
+	case <-events:
+		t.Fatalf("Document for another topic was delivered")
+	default:
+	}
+
+	responder.Unsubscribe("events")
+	if _, ok := <-events; ok {
+		t.Fatalf("Unsubscribe didn't close the channel")
+	}
+}
+
+func TestPublishFiltered(t *testing.T) {
+	requester, responder := sessionPair(t, nil)
+
+	filter, err := ParseFilter("priority >= 3")
+	if err != nil {
+		t.Fatalf("ParseFilter failed: %s", err.Error())
+	}
+	// The filter frame is read by the publishing side before the packet sent after it
+	var events <-chan *Document
+	readFiltersSent(t, requester, func() {
+		if events, err = responder.SubscribeFiltered("events", filter); err != nil {
+			t.Fatalf("SubscribeFiltered failed: %s", err.Error())
+		}
+		responder.Write([]byte("subscribed"))
+	})
+
+	docs := make([]*Document, 0)
+	for _, priority := range []int64{1, 5} {
+		fields := make(SegmentMap)
+		fields.SetInt64("priority", priority)
+		items, err := keyedItems(fields)
+		if err != nil {
+			t.Fatalf("Error building document: %s", err.Error())
+		}
+		docs = append(docs, &Document{Items: items})
+	}
+	if requester.peerWants("events", docs[0]) || !requester.peerWants("events", docs[1]) {
+		t.Fatalf("Publisher didn't apply the subscriber's filter")
+	}
+
+	go func() {
+		requester.Publish("events", docs[0])
+		requester.Publish("events", docs[1])
+		requester.Write([]byte("regular packet"))
+	}()
+	if _, err := responder.Read(); err != nil {
+		t.Fatalf("Read failed: %s", err.Error())
+	}
+	if v, _ := (<-events).Query("priority"); v != int64(5) {
+		t.Fatalf("Filtered subscription received the wrong document")
+	}
+	select {
+	case <-events:
+		t.Fatalf("Document not matching the filter was delivered")
+	default:
+	}
+
+	// Dropping the subscription tells the publisher that nothing is wanted
+	readFiltersSent(t, requester, func() {
+		responder.Unsubscribe("events")
+		responder.Write([]byte("unsubscribed"))
+	})
+	if requester.peerWants("events", docs[1]) {
+		t.Fatalf("Publisher still sends to a topic without subscribers")
+	}
+}
+
+// readFiltersSent reads the publishing side of a session while send() sends filters and then a
+// packet to it
+func readFiltersSent(t *testing.T, publisher *PacketSession, send func()) {
+
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := publisher.Read()
+		readErr <- err
+	}()
+	send()
+	if err := <-readErr; err != nil {
+		t.Fatalf("Read failed after the filter frame: %s", err.Error())
+	}
+}
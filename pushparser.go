@@ -0,0 +1,336 @@
+package oganesson
+
+import (
+	"io"
+	"math"
+)
+
+// This file contains PushParser, an event-driven parser for when data arrives in chunks which
+// don't line up with segments, such as reads from a socket in a proxy. Each chunk is handed to
+// Feed() and the parser calls back as each segment is completed. Only the part of a segment which
+// hasn't finished arriving is buffered, so Documents can be processed without holding onto the
+// whole thing.
+
+// PushParser parses a series of Documents fed to it in chunks, calling its callbacks as the
+// segments in them are completed. Any of the callbacks may be nil. If a callback returns an
+// error, parsing stops and Feed() returns that error.
+//
+// The Segment passed to OnSegment may refer to the data given to Feed() or to the parser's
+// buffer, so it is only valid until the callback returns and should be copied if it is needed
+// afterward.
+type PushParser struct {
+	// OnDocStart and OnDocEnd are called for the DocumentStart and DocumentEnd segments. The
+	// count passed to OnDocEnd is the number of segments in the Document.
+	OnDocStart func(version uint8) error
+	OnDocEnd   func(count uint64) error
+
+	// OnSegment is called for each segment in a Document which isn't a container index,
+	// including the keys and values of maps and the items of lists
+	OnSegment func(seg Segment) error
+
+	// OnMapBegin and OnListBegin are called for container index segments. The matching end
+	// callback is called after the container's last item.
+	OnMapBegin  func(pairs uint64) error
+	OnMapEnd    func() error
+	OnListBegin func(items uint64) error
+	OnListEnd   func() error
+
+	// Limits is applied to each Document separately. The Budget isn't used because segments
+	// aren't kept once their callback returns.
+	Limits DecodeLimits
+
+	// Profile is the byte order of the data being parsed. Segments are always converted to
+	// network order, like they are by Decoder.
+	Profile EncodingProfile
+
+	buffer []byte
+	err    error
+
+	inDocument   bool
+	bytesRead    uint64
+	segmentCount uint64
+
+	// containerType is the index type of the open container, if there is one, and remaining
+	// the number of segments left in it
+	containerType uint8
+	remaining     uint64
+}
+
+// NewPushParser creates a new PushParser. Its callbacks should be set before Feed() is called.
+func NewPushParser() *PushParser {
+	return &PushParser{}
+}
+
+// Feed parses a chunk of data, calling back for each segment which is completed by it. Once an
+// error has been returned, Feed() keeps returning it until Reset() is called.
+func (p *PushParser) Feed(data []byte) error {
+
+	if p.err != nil {
+		return p.err
+	}
+
+	for len(data) > 0 {
+
+		// A segment which started in an earlier chunk is topped up a piece at a time so that
+		// nothing past its end is copied into the buffer
+		if len(p.buffer) > 0 {
+			length, err := p.segmentLength(p.buffer)
+			if err != nil {
+				return p.fail(err)
+			}
+			need := length
+			if length == 0 {
+				need = 1 + int(sizeSegmentSize(p.buffer[0]))
+			}
+
+			take := need - len(p.buffer)
+			if take > len(data) {
+				take = len(data)
+			}
+			p.buffer = append(p.buffer, data[:take]...)
+			data = data[take:]
+			if length == 0 || len(p.buffer) < length {
+				continue
+			}
+
+			if err := p.handleSegment(p.buffer); err != nil {
+				return p.fail(err)
+			}
+			p.buffer = p.buffer[:0]
+			continue
+		}
+
+		length, err := p.segmentLength(data)
+		if err != nil {
+			return p.fail(err)
+		}
+		if length == 0 || len(data) < length {
+			p.buffer = append(p.buffer, data...)
+			break
+		}
+		if err := p.handleSegment(data[:length]); err != nil {
+			return p.fail(err)
+		}
+		data = data[length:]
+	}
+	return nil
+}
+
+// Close checks that the data fed to the parser didn't end partway through a Document. It
+// returns io.ErrUnexpectedEOF if it did.
+func (p *PushParser) Close() error {
+	if p.err != nil {
+		return p.err
+	}
+	if p.inDocument || len(p.buffer) > 0 {
+		return p.fail(io.ErrUnexpectedEOF)
+	}
+	return nil
+}
+
+// Reset clears the parser's state and any error so that it can be used for a new stream. The
+// callbacks and other settings are kept.
+func (p *PushParser) Reset() {
+	p.buffer = p.buffer[:0]
+	p.err = nil
+	p.inDocument = false
+	p.containerType = 0
+	p.remaining = 0
+}
+
+// fail records an error so that it is returned from then on
+func (p *PushParser) fail(err error) error {
+	p.err = err
+	return err
+}
+
+// segmentLength returns the total size of the segment at the start of the data, or zero if not
+// enough of its header has arrived to tell yet
+func (p *PushParser) segmentLength(data []byte) (int, error) {
+
+	if !isTypeCodeValid(data[0]) {
+		return 0, ErrInvalidSegment
+	}
+
+	var payloadSize uint64
+	sizeSize := int(sizeSegmentSize(data[0]))
+	if sizeSize != 0 {
+		if len(data) < 1+sizeSize {
+			return 0, nil
+		}
+		var sizeBytes [8]byte
+		copy(sizeBytes[:], data[1:1+sizeSize])
+		p.Profile.toNetworkOrder(sizeBytes[:sizeSize])
+		for _, b := range sizeBytes[:sizeSize] {
+			payloadSize = (payloadSize << 8) + uint64(b)
+		}
+	} else {
+		payloadSize = uint64(fixedSegmentSize(data[0]))
+	}
+
+	if p.Limits.MaxSegmentSize > 0 && payloadSize > p.Limits.MaxSegmentSize {
+		return 0, ErrLimitExceeded
+	}
+	if payloadSize > MaxDecodedSize || payloadSize > math.MaxInt-9 {
+		return 0, ErrSize
+	}
+	return 1 + sizeSize + int(payloadSize), nil
+}
+
+// handleSegment applies the limits to a complete segment and calls back for it
+func (p *PushParser) handleSegment(raw []byte) error {
+
+	seg := Segment{Type: raw[0], Value: raw[1+int(sizeSegmentSize(raw[0])):]}
+
+	// Converting the byte order in place would change the caller's data
+	if p.Profile != BigEndianProfile && fixedSegmentSize(seg.Type) > 1 {
+		seg.Value = append([]byte(nil), seg.Value...)
+		p.Profile.toNetworkOrder(seg.Value)
+	}
+
+	if !p.inDocument {
+		if seg.Type != DFDocumentStart {
+			return ErrInvalidMsg
+		}
+		version, err := seg.GetDocStart()
+		if err != nil {
+			return err
+		}
+		p.inDocument = true
+		p.bytesRead = uint64(len(raw))
+		p.segmentCount = 0
+		return callHook(p.OnDocStart, version)
+	}
+
+	p.bytesRead += uint64(len(raw))
+	if p.Limits.MaxSize > 0 && p.bytesRead > p.Limits.MaxSize {
+		return ErrLimitExceeded
+	}
+
+	if seg.Type == DFDocumentEnd {
+		return p.endDocument(seg)
+	}
+
+	p.segmentCount++
+	if p.Limits.MaxSegmentCount > 0 && p.segmentCount > p.Limits.MaxSegmentCount {
+		return ErrLimitExceeded
+	}
+
+	switch seg.Type {
+	case DFDocumentStart:
+		return ErrInvalidMsg
+	case DFMapType, DFLargeMapType, DFKeyedMapType, DFListType, DFLargeListType:
+		return p.beginContainer(seg)
+	}
+
+	if p.remaining > 0 {
+		isKey := p.remaining%2 == 0
+		if p.isMap() && isKey && !isValidMapKey(p.containerType, seg.Type) {
+			return ErrInvalidKey
+		}
+	}
+	if p.OnSegment != nil {
+		if err := p.OnSegment(seg); err != nil {
+			return err
+		}
+	}
+	return p.itemDone()
+}
+
+// beginContainer opens the container started by an index segment. Containers hold only
+// scalar segments, so they can't be nested.
+func (p *PushParser) beginContainer(seg Segment) error {
+
+	if p.remaining > 0 {
+		return ErrInvalidContainer
+	}
+
+	p.containerType = seg.Type
+	var err error
+	if p.isMap() {
+		var pairs uint64
+		if pairs, err = seg.GetMapIndex(); err != nil {
+			return err
+		}
+		if pairs > math.MaxUint64/2 {
+			return ErrInvalidContainer
+		}
+		p.remaining = pairs * 2
+		err = callHook(p.OnMapBegin, pairs)
+	} else {
+		if p.remaining, err = seg.GetListIndex(); err != nil {
+			return err
+		}
+		err = callHook(p.OnListBegin, p.remaining)
+	}
+	if err != nil {
+		return err
+	}
+
+	if p.remaining == 0 {
+		return p.endContainer()
+	}
+	return nil
+}
+
+// itemDone counts a segment against the open container, if there is one, and ends the container
+// after its last item
+func (p *PushParser) itemDone() error {
+	if p.remaining == 0 {
+		return nil
+	}
+	p.remaining--
+	if p.remaining == 0 {
+		return p.endContainer()
+	}
+	return nil
+}
+
+// endContainer calls back for the end of the open container
+func (p *PushParser) endContainer() error {
+
+	hook := p.OnListEnd
+	if p.isMap() {
+		hook = p.OnMapEnd
+	}
+	p.containerType = 0
+	if hook != nil {
+		return hook()
+	}
+	return nil
+}
+
+// endDocument checks a DocumentEnd segment and calls back for it
+func (p *PushParser) endDocument(seg Segment) error {
+
+	if p.remaining > 0 {
+		return ErrInvalidContainer
+	}
+	segCount, err := seg.GetDocEnd()
+	if err != nil {
+		return err
+	}
+	if err := checkDocEndCount(segCount, p.segmentCount); err != nil {
+		return err
+	}
+
+	p.inDocument = false
+	return callHook(p.OnDocEnd, p.segmentCount)
+}
+
+// isMap returns true if the open container is a map
+func (p *PushParser) isMap() bool {
+	switch p.containerType {
+	case DFMapType, DFLargeMapType, DFKeyedMapType:
+		return true
+	}
+	return false
+}
+
+// callHook calls a callback which takes a value, if it is set
+func callHook[T uint8 | uint64](hook func(T) error, value T) error {
+	if hook == nil {
+		return nil
+	}
+	return hook(value)
+}
@@ -0,0 +1,159 @@
+package oganesson
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// pushParserDocument returns a flattened document holding a map, a list, and a loose value
+func pushParserDocument(t *testing.T) []byte {
+
+	doc := NewDocument()
+	items := make([]Segment, 0)
+
+	var index Segment
+	if err := index.setContainerIndex(DFMapType, DFLargeMapType, 2); err != nil {
+		t.Fatalf("Error setting map index: %s", err.Error())
+	}
+	items = append(items, index)
+	for _, pair := range [][2]string{{"name", "Alice"}, {"city", "Paris"}} {
+		var key, value Segment
+		key.SetString(pair[0])
+		value.SetString(pair[1])
+		items = append(items, key, value)
+	}
+
+	index = Segment{}
+	if err := index.setContainerIndex(DFListType, DFLargeListType, 2); err != nil {
+		t.Fatalf("Error setting list index: %s", err.Error())
+	}
+	items = append(items, index)
+	for _, v := range []int64{1, 2} {
+		var item Segment
+		item.SetInt64(v)
+		items = append(items, item)
+	}
+
+	var loose Segment
+	loose.SetString(strings.Repeat("x", 300))
+	items = append(items, loose)
+
+	for i := range items {
+		doc.appendItem(&items[i])
+	}
+	data, err := doc.Flatten()
+	if err != nil {
+		t.Fatalf("Error flattening document: %s", err.Error())
+	}
+	return data
+}
+
+// recordingParser returns a PushParser which records its callbacks in the events slice
+func recordingParser(events *[]string) *PushParser {
+
+	p := NewPushParser()
+	p.OnDocStart = func(version uint8) error {
+		*events = append(*events, "docstart")
+		return nil
+	}
+	p.OnDocEnd = func(count uint64) error {
+		*events = append(*events, fmt.Sprintf("docend %d", count))
+		return nil
+	}
+	p.OnSegment = func(seg Segment) error {
+		*events = append(*events, fmt.Sprintf("%s %d", TypeName(seg.Type), len(seg.Value)))
+		return nil
+	}
+	p.OnMapBegin = func(pairs uint64) error {
+		*events = append(*events, fmt.Sprintf("map %d", pairs))
+		return nil
+	}
+	p.OnMapEnd = func() error {
+		*events = append(*events, "mapend")
+		return nil
+	}
+	p.OnListBegin = func(items uint64) error {
+		*events = append(*events, fmt.Sprintf("list %d", items))
+		return nil
+	}
+	p.OnListEnd = func() error {
+		*events = append(*events, "listend")
+		return nil
+	}
+	return p
+}
+
+func TestPushParser(t *testing.T) {
+	data := pushParserDocument(t)
+
+	var whole []string
+	p := recordingParser(&whole)
+	if err := p.Feed(append(append([]byte(nil), data...), data...)); err != nil {
+		t.Fatalf("Error feeding whole documents: %s", err.Error())
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close failed after complete documents: %s", err.Error())
+	}
+
+	want := "docstart,map 2,String 4,String 5,String 4,String 5,mapend,list 2,Int64 8,Int64 8," +
+		"listend,String 300,docend 9"
+	if got := strings.Join(whole, ","); got != want+","+want {
+		t.Fatalf("PushParser event mismatch:\n%s", got)
+	}
+
+	// Feeding one byte at a time has to produce the same events
+	var bytewise []string
+	p = recordingParser(&bytewise)
+	for i := range data {
+		if err := p.Feed(data[i : i+1]); err != nil {
+			t.Fatalf("Error feeding byte %d: %s", i, err.Error())
+		}
+	}
+	if got := strings.Join(bytewise, ","); got != want {
+		t.Fatalf("PushParser bytewise event mismatch:\n%s", got)
+	}
+}
+
+func TestPushParserErrors(t *testing.T) {
+	data := pushParserDocument(t)
+
+	var events []string
+	p := recordingParser(&events)
+	if err := p.Feed(data[:len(data)-3]); err != nil {
+		t.Fatalf("Error feeding partial document: %s", err.Error())
+	}
+	if err := p.Close(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("Close didn't catch the partial document: %v", err)
+	}
+
+	p = recordingParser(&events)
+	p.Limits.MaxSegmentSize = 100
+	if err := p.Feed(data); err != ErrLimitExceeded {
+		t.Fatalf("PushParser didn't enforce the segment size limit: %v", err)
+	}
+	if err := p.Feed(data); err != ErrLimitExceeded {
+		t.Fatalf("PushParser didn't keep its error")
+	}
+	p.Reset()
+	p.Limits = DecodeLimits{}
+	if err := p.Feed(data); err != nil {
+		t.Fatalf("PushParser failed after Reset(): %s", err.Error())
+	}
+
+	stop := errors.New("stop")
+	p = recordingParser(&events)
+	p.OnListBegin = func(items uint64) error {
+		return stop
+	}
+	if err := p.Feed(data); err != stop {
+		t.Fatalf("PushParser didn't return the callback's error: %v", err)
+	}
+
+	p = recordingParser(&events)
+	if err := p.Feed(data[2:]); err != ErrInvalidMsg {
+		t.Fatalf("PushParser accepted a segment outside of a document: %v", err)
+	}
+}
@@ -0,0 +1,286 @@
+package oganesson
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidPath = errors.New("invalid query path")
+
+// This file contains Document.Query(), which looks up a value in a Document by a path such as
+// "user.roles[2]" without materializing any of the maps or lists along the way. Each step of a
+// path is either a map key, which follows a dot or starts the path, or a list index in
+// brackets:
+//
+//   - A key at the start of the path looks up a field of a keyed Document, one whose items are a
+//     single map of field names to values.
+//   - An index at the start of the path selects one of the Document's top-level values. A map or
+//     list counts as a single value.
+//   - Later steps look inside the map or list found by the step before them.
+//
+// Containers are walked in place, skipping over any values nested in them, so paths work the
+// same way for nested containers as they do for flat ones.
+
+// Query returns the value at the specified path in the Document, converted to the matching Go
+// type: the integer and float types of the same size, bool, string, or []byte for binary data.
+// ErrNotFound is returned if nothing is at the path and ErrTypeError if a step of the path
+// doesn't match the kind of container it is applied to, or if the value is itself a container.
+func (doc *Document) Query(path string) (interface{}, error) {
+
+	seg, err := doc.QuerySegment(path)
+	if err != nil {
+		return nil, err
+	}
+	return segmentValue(seg)
+}
+
+// QuerySegment is the same as Query(), but it returns the value's Segment. For a map or list,
+// this is the container's index segment.
+func (doc *Document) QuerySegment(path string) (Segment, error) {
+
+	steps, err := parseQueryPath(path)
+	if err != nil {
+		return Segment{}, err
+	}
+
+	pos := -1
+	for i, step := range steps {
+		if i == 0 {
+			pos, err = doc.queryRoot(step)
+		} else {
+			pos, err = doc.queryStep(pos, step)
+		}
+		if err != nil {
+			return Segment{}, fmt.Errorf("%w: %s", err, path)
+		}
+	}
+
+	seg, ok := doc.Items[pos].(*Segment)
+	if !ok {
+		return Segment{}, ErrTypeError
+	}
+	return *seg, nil
+}
+
+// queryStep is a single step of a query path. Key is used if isIndex is false.
+type queryStep struct {
+	key     string
+	index   uint64
+	isIndex bool
+}
+
+// parseQueryPath splits a query path into its steps
+func parseQueryPath(path string) ([]queryStep, error) {
+
+	out := make([]queryStep, 0)
+	rest := path
+	for len(rest) > 0 {
+		switch {
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, ErrInvalidPath
+			}
+			index, err := strconv.ParseUint(rest[1:end], 10, 64)
+			if err != nil {
+				return nil, ErrInvalidPath
+			}
+			out = append(out, queryStep{index: index, isIndex: true})
+			rest = rest[end+1:]
+		case rest[0] == '.' && len(out) > 0:
+			rest = rest[1:]
+			fallthrough
+		case len(out) == 0:
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, ErrInvalidPath
+			}
+			out = append(out, queryStep{key: rest[:end]})
+			rest = rest[end:]
+		default:
+			return nil, ErrInvalidPath
+		}
+	}
+
+	if len(out) == 0 {
+		return nil, ErrInvalidPath
+	}
+	return out, nil
+}
+
+// queryRoot applies the first step of a path to the Document and returns the position of the
+// item it selects
+func (doc *Document) queryRoot(step queryStep) (int, error) {
+
+	if !step.isIndex {
+		if len(doc.Items) == 0 || !isMapType(doc.Items[0].GetType()) {
+			return 0, ErrTypeError
+		}
+		return doc.queryStep(0, step)
+	}
+
+	pos := 0
+	for i := uint64(0); pos < len(doc.Items); i++ {
+		if i == step.index {
+			return pos, nil
+		}
+		next, err := doc.skipValue(pos)
+		if err != nil {
+			return 0, err
+		}
+		pos = next
+	}
+	return 0, ErrNotFound
+}
+
+// queryStep applies a step of a path to the container whose index segment is at the specified
+// position and returns the position of the item it selects
+func (doc *Document) queryStep(pos int, step queryStep) (int, error) {
+
+	index, ok := doc.Items[pos].(*Segment)
+	if !ok {
+		return 0, ErrTypeError
+	}
+
+	var count uint64
+	var err error
+	switch {
+	case step.isIndex && isListType(index.Type):
+		count, err = index.GetListIndex()
+	case !step.isIndex && isMapType(index.Type):
+		count, err = index.GetMapIndex()
+	default:
+		return 0, ErrTypeError
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	pos++
+	for i := uint64(0); i < count; i++ {
+		if pos >= len(doc.Items) {
+			return 0, ErrInvalidContainer
+		}
+
+		if step.isIndex {
+			if i == step.index {
+				return pos, nil
+			}
+		} else {
+			key, ok := doc.Items[pos].(*Segment)
+			if !ok || !isValidMapKey(index.Type, key.Type) {
+				return 0, ErrInvalidKey
+			}
+			pos++
+			if pos >= len(doc.Items) {
+				return 0, ErrInvalidContainer
+			}
+			if key.Type == DFStringType && string(key.Value) == step.key {
+				return pos, nil
+			}
+		}
+
+		if pos, err = doc.skipValue(pos); err != nil {
+			return 0, err
+		}
+	}
+	return 0, ErrNotFound
+}
+
+// skipValue returns the position of the item after the value at the specified position,
+// skipping over the contents of a container
+func (doc *Document) skipValue(pos int) (int, error) {
+
+	index, ok := doc.Items[pos].(*Segment)
+	if !ok {
+		return pos + 1, nil
+	}
+
+	var count, perItem int
+	var err error
+	var n uint64
+	switch {
+	case isMapType(index.Type):
+		n, err = index.GetMapIndex()
+		perItem = 2
+	case isListType(index.Type):
+		n, err = index.GetListIndex()
+		perItem = 1
+	default:
+		return pos + 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(len(doc.Items)-pos-1)/uint64(perItem) {
+		return 0, ErrInvalidContainer
+	}
+	count = int(n)
+
+	pos++
+	for i := 0; i < count; i++ {
+
+		// Keys are always scalars, so only the values need to be walked
+		pos += perItem - 1
+		if pos >= len(doc.Items) {
+			return 0, ErrInvalidContainer
+		}
+		if pos, err = doc.skipValue(pos); err != nil {
+			return 0, err
+		}
+	}
+	return pos, nil
+}
+
+// isMapType returns true if the type code is one of the map index types
+func isMapType(typeCode uint8) bool {
+	switch typeCode {
+	case DFMapType, DFLargeMapType, DFKeyedMapType:
+		return true
+	}
+	return false
+}
+
+// isListType returns true if the type code is one of the list index types
+func isListType(typeCode uint8) bool {
+	return typeCode == DFListType || typeCode == DFLargeListType
+}
+
+// segmentValue converts a scalar Segment's value to the matching Go type
+func segmentValue(seg Segment) (interface{}, error) {
+
+	switch seg.Type {
+	case DFInt8Type:
+		return seg.GetInt8()
+	case DFUInt8Type:
+		return seg.GetUInt8()
+	case DFInt16Type:
+		return seg.GetInt16()
+	case DFUInt16Type:
+		return seg.GetUInt16()
+	case DFInt32Type:
+		return seg.GetInt32()
+	case DFUInt32Type:
+		return seg.GetUInt32()
+	case DFInt64Type:
+		return seg.GetInt64()
+	case DFUInt64Type:
+		return seg.GetUInt64()
+	case DFBoolType:
+		return seg.GetBool()
+	case DFFloat32Type:
+		return seg.GetFloat32()
+	case DFFloat64Type:
+		return seg.GetFloat64()
+	case DFStringType, DFHugeStringType:
+		return seg.GetString()
+	case DFBinaryType, DFHugeBinaryType:
+		return seg.GetBinary()
+	}
+	return nil, ErrTypeError
+}
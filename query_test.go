@@ -0,0 +1,89 @@
+package oganesson
+
+import (
+	"errors"
+	"testing"
+)
+
+// queryIndex returns a container index segment for Query tests
+func queryIndex(t *testing.T, indexType uint8, largeType uint8, count uint64) *Segment {
+	var out Segment
+	if err := out.setContainerIndex(indexType, largeType, count); err != nil {
+		t.Fatalf("Error setting container index: %s", err.Error())
+	}
+	return &out
+}
+
+// queryString returns a String segment for Query tests
+func queryString(value string) *Segment {
+	var out Segment
+	out.SetString(value)
+	return &out
+}
+
+func TestDocumentQuery(t *testing.T) {
+	fields := make(SegmentMap)
+	fields.SetString("name", "Alice")
+	fields.SetInt64("age", 42)
+	items, err := keyedItems(fields)
+	if err != nil {
+		t.Fatalf("Error building keyed document: %s", err.Error())
+	}
+	doc := Document{Items: items}
+
+	if v, err := doc.Query("name"); err != nil || v != "Alice" {
+		t.Fatalf("Query mismatch for name: %v, %v", v, err)
+	}
+	if v, err := doc.Query("age"); err != nil || v != int64(42) {
+		t.Fatalf("Query mismatch for age: %v, %v", v, err)
+	}
+	if _, err := doc.Query("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Query didn't return ErrNotFound for a missing field: %v", err)
+	}
+	if _, err := doc.Query("name[1]"); !errors.Is(err, ErrTypeError) {
+		t.Fatalf("Query indexed into a string: %v", err)
+	}
+	if v, err := doc.Query("[0].age"); err != nil || v != int64(42) {
+		t.Fatalf("Query mismatch for a top-level index: %v, %v", v, err)
+	}
+
+	for _, path := range []string{"", ".name", "name.", "name..age", "[x]", "[1", "name[0]x"} {
+		if _, err := doc.Query(path); err != ErrInvalidPath {
+			t.Fatalf("Query accepted invalid path %q: %v", path, err)
+		}
+	}
+}
+
+func TestDocumentQueryNested(t *testing.T) {
+	doc := NewDocument()
+	doc.Items = []SegContainer{
+		queryIndex(t, DFMapType, DFLargeMapType, 2),
+		queryString("user"),
+		queryIndex(t, DFMapType, DFLargeMapType, 2),
+		queryString("roles"),
+		queryIndex(t, DFListType, DFLargeListType, 3),
+		queryString("reader"), queryString("writer"), queryString("admin"),
+		queryString("name"), queryString("Alice"),
+		queryString("group"), queryString("staff"),
+	}
+
+	if v, err := doc.Query("user.roles[2]"); err != nil || v != "admin" {
+		t.Fatalf("Query mismatch for a nested list item: %v, %v", v, err)
+	}
+	if v, err := doc.Query("user.name"); err != nil || v != "Alice" {
+		t.Fatalf("Query mismatch for a nested map value: %v, %v", v, err)
+	}
+	if v, err := doc.Query("group"); err != nil || v != "staff" {
+		t.Fatalf("Query didn't skip over the nested containers: %v, %v", v, err)
+	}
+	if _, err := doc.Query("user.roles[3]"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Query didn't return ErrNotFound past the end of a list: %v", err)
+	}
+	if _, err := doc.Query("user"); !errors.Is(err, ErrTypeError) {
+		t.Fatalf("Query returned a container as a value: %v", err)
+	}
+	seg, err := doc.QuerySegment("user.roles")
+	if err != nil || seg.Type != DFListType {
+		t.Fatalf("QuerySegment mismatch for a list: %v, %v", seg.Type, err)
+	}
+}
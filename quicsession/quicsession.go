@@ -0,0 +1,270 @@
+// Package quicsession carries JBitPack packets and Documents over QUIC. It offers the same Read(),
+// Write(), ReadDocument(), WriteDocument(), and Close() calls as oganesson.PacketSession, but each
+// packet is sent on its own unidirectional QUIC stream instead of being framed on a single
+// connection. This gives a session multiplexing, so a bulk transfer doesn't hold up the
+// Documents sent after it, along with QUIC's loss recovery and connection migration. It is a
+// separate module with its own go.mod so that programs which only use the oganesson package
+// don't pull in a QUIC implementation.
+//
+// Packets arrive in the order in which they finish being received, which isn't necessarily the
+// order in which they were sent. Applications which need ordering should wait for a reply before
+// sending the next packet, as they would with a request/response protocol.
+//
+// Dial() attempts 0-RTT when the tls.Config has a ClientSessionCache which holds a ticket from an
+// earlier connection to the same server, and the server is listening with Allow0RTT set in its
+// quic.Config. Packets written before the handshake finishes are sent as 0-RTT data, which an
+// attacker can replay, so only requests which are safe to repeat should be sent that early.
+package quicsession
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/darkwyrm/oganesson"
+	"github.com/quic-go/quic-go"
+)
+
+// NextProto is the ALPN protocol name used when the tls.Config doesn't list any
+const NextProto = "jbitpack"
+
+// Stream error code sent to a peer whose packet is refused for being too large
+const errCodeTooLarge = quic.StreamErrorCode(1)
+
+// Session is a QUIC connection which sends each packet on its own stream. One goroutine may read
+// from a session while any number of others write to it. The exported fields must be set before
+// the session is first used and not changed afterward.
+type Session struct {
+	Connection *quic.Conn
+
+	// MaxPacketSize is the largest packet which will be accepted from the peer. Larger packets
+	// are refused as they arrive and Read() returns oganesson.ErrSize for them. It starts out as
+	// oganesson.MaxDecodedSize.
+	MaxPacketSize uint64
+
+	// MaxCommandLength is the maximum number of bytes a Document read with ReadDocument() may be.
+	// It starts out as the oganesson package's MaxCommandLength, and 0 means there is no limit.
+	MaxCommandLength int
+
+	// ReadTimeout limits how long Read() waits for a packet to arrive. WriteTimeout limits how
+	// long Write() waits to open a stream and send a packet. A timeout of zero means no limit.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// Limits is applied to all Documents decoded by ReadDocument()
+	Limits oganesson.DecodeLimits
+
+	startReading sync.Once
+	incoming     chan incomingPacket
+}
+
+// incomingPacket is a packet, or the error from receiving one, passed from the goroutine reading
+// its stream to Read()
+type incomingPacket struct {
+	packet []byte
+	err    error
+}
+
+// NewSession wraps a QUIC connection which has already been set up
+func NewSession(conn *quic.Conn) *Session {
+	return &Session{
+		Connection:       conn,
+		MaxPacketSize:    oganesson.MaxDecodedSize,
+		MaxCommandLength: oganesson.MaxCommandLength,
+		ReadTimeout:      oganesson.DefaultReadTimeout,
+		WriteTimeout:     oganesson.DefaultWriteTimeout,
+		incoming:         make(chan incomingPacket),
+	}
+}
+
+// Dial connects to a server and returns a session for the connection. The connection is returned
+// as soon as it can send 0-RTT data, if the server allows it, and otherwise once the handshake
+// has finished. The config may be nil.
+func Dial(ctx context.Context, addr string, tlsConf *tls.Config, conf *quic.Config) (*Session,
+	error) {
+
+	conn, err := quic.DialAddrEarly(ctx, addr, withNextProto(tlsConf), conf)
+	if err != nil {
+		return nil, err
+	}
+	return NewSession(conn), nil
+}
+
+// Listener accepts QUIC connections as sessions
+type Listener struct {
+	listener *quic.EarlyListener
+}
+
+// Listen listens for connections on a UDP address. Set Allow0RTT in the config to accept 0-RTT
+// data from clients which have connected before. The config may be nil.
+func Listen(addr string, tlsConf *tls.Config, conf *quic.Config) (*Listener, error) {
+
+	l, err := quic.ListenAddrEarly(addr, withNextProto(tlsConf), conf)
+	if err != nil {
+		return nil, err
+	}
+	return &Listener{l}, nil
+}
+
+// Accept waits for the next connection and returns a session for it
+func (l *Listener) Accept(ctx context.Context) (*Session, error) {
+
+	conn, err := l.listener.Accept(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewSession(conn), nil
+}
+
+// Addr returns the address the listener is listening on
+func (l *Listener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+// Close stops listening. Sessions which have already been accepted aren't closed.
+func (l *Listener) Close() error {
+	return l.listener.Close()
+}
+
+// withNextProto returns a copy of the TLS config which lists NextProto if it has no protocols
+func withNextProto(tlsConf *tls.Config) *tls.Config {
+
+	if tlsConf == nil {
+		tlsConf = &tls.Config{}
+	}
+	if len(tlsConf.NextProtos) > 0 {
+		return tlsConf
+	}
+	out := tlsConf.Clone()
+	out.NextProtos = []string{NextProto}
+	return out
+}
+
+// Read returns the next packet to finish arriving from the peer
+func (s *Session) Read() ([]byte, error) {
+
+	s.startReading.Do(func() { go s.acceptStreams() })
+
+	ctx := s.Connection.Context()
+	if s.ReadTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.ReadTimeout)
+		defer cancel()
+	}
+
+	select {
+	case in := <-s.incoming:
+		return in.packet, in.err
+	case <-ctx.Done():
+		if cause := context.Cause(s.Connection.Context()); cause != nil {
+			return nil, cause
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// acceptStreams reads each stream the peer opens in its own goroutine so that a large packet
+// doesn't hold up the ones sent after it. It runs until the connection is closed.
+func (s *Session) acceptStreams() {
+
+	ctx := s.Connection.Context()
+	for {
+		stream, err := s.Connection.AcceptUniStream(ctx)
+		if err != nil {
+			return
+		}
+		go func() {
+			packet, err := s.readStream(stream)
+			select {
+			case s.incoming <- incomingPacket{packet, err}:
+			case <-ctx.Done():
+			}
+		}()
+	}
+}
+
+// readStream reads the packet sent on a stream, refusing it once it grows past MaxPacketSize
+func (s *Session) readStream(stream *quic.ReceiveStream) ([]byte, error) {
+
+	readLimit := int64(math.MaxInt64)
+	if s.MaxPacketSize < math.MaxInt64 {
+		readLimit = int64(s.MaxPacketSize) + 1
+	}
+	packet, err := io.ReadAll(io.LimitReader(stream, readLimit))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(packet)) > s.MaxPacketSize {
+		stream.CancelRead(errCodeTooLarge)
+		return nil, oganesson.ErrSize
+	}
+	return packet, nil
+}
+
+// Write sends a packet on a new stream. Several goroutines may write at once, and their packets
+// are sent side by side.
+func (s *Session) Write(packet []byte) error {
+
+	ctx := s.Connection.Context()
+	if s.WriteTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.WriteTimeout)
+		defer cancel()
+	}
+
+	stream, err := s.Connection.OpenUniStreamSync(ctx)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetWriteDeadline(deadline)
+	}
+	if _, err := stream.Write(packet); err != nil {
+		stream.CancelWrite(0)
+		return err
+	}
+	return stream.Close()
+}
+
+// ReadDocument reads a packet and decodes it as a Document. The session's Limits are always
+// applied to the decoding, and any limits passed to the call restrict them further.
+func (s *Session) ReadDocument(limits ...oganesson.DecodeLimits) (*oganesson.Document, error) {
+
+	packet, err := s.Read()
+	if err != nil {
+		return nil, err
+	}
+	if s.MaxCommandLength > 0 && len(packet) > s.MaxCommandLength {
+		return nil, oganesson.ErrSize
+	}
+
+	callLimits := s.Limits
+	for _, l := range limits {
+		callLimits = callLimits.Restrict(l)
+	}
+	out := oganesson.NewDocument()
+	if err := out.UnflattenLimited(packet, callLimits); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// WriteDocument flattens a Document and sends it on its own stream. It is the counterpart to
+// ReadDocument().
+func (s *Session) WriteDocument(doc *oganesson.Document) error {
+
+	packet, err := doc.Flatten()
+	if err != nil {
+		return err
+	}
+	return s.Write(packet)
+}
+
+// Close closes the connection. Packets which are still being sent or received are abandoned.
+func (s *Session) Close() error {
+	return s.Connection.CloseWithError(0, "")
+}
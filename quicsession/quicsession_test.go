@@ -0,0 +1,198 @@
+package quicsession
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/darkwyrm/oganesson"
+	"github.com/quic-go/quic-go"
+)
+
+// testTLSConfigs returns server and client TLS configs which trust a new self-signed certificate
+func testTLSConfigs(t *testing.T) (*tls.Config, *tls.Config) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %s", err.Error())
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %s", err.Error())
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	server := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der},
+		PrivateKey: key}}}
+	client := &tls.Config{RootCAs: pool, ServerName: "localhost",
+		ClientSessionCache: tls.NewLRUClientSessionCache(4)}
+	return server, client
+}
+
+// testSessionPair returns a client and server session connected through a listener. The server
+// hasn't read anything yet, so its settings can still be changed.
+func testSessionPair(t *testing.T, clientTLS *tls.Config, l *Listener) (*Session, *Session) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client, err := Dial(ctx, l.Addr().String(), clientTLS, nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %s", err.Error())
+	}
+
+	// Nothing is sent by the client until it writes, so the server accepts after that
+	if err := client.Write([]byte("hello")); err != nil {
+		t.Fatalf("First write failed: %s", err.Error())
+	}
+	server, err := l.Accept(ctx)
+	if err != nil {
+		t.Fatalf("Accept failed: %s", err.Error())
+	}
+	return client, server
+}
+
+// readHello reads the packet sent by testSessionPair()
+func readHello(t *testing.T, server *Session) {
+	if packet, err := server.Read(); err != nil || string(packet) != "hello" {
+		t.Fatalf("First packet wasn't received: %v", err)
+	}
+}
+
+func TestQUICSession(t *testing.T) {
+	serverTLS, clientTLS := testTLSConfigs(t)
+	l, err := Listen("127.0.0.1:0", serverTLS, nil)
+	if err != nil {
+		t.Fatalf("Listen failed: %s", err.Error())
+	}
+	defer l.Close()
+
+	client, server := testSessionPair(t, clientTLS, l)
+	defer client.Close()
+	defer server.Close()
+	readHello(t, server)
+
+	// A bulk transfer and a Document are sent side by side on their own streams
+	bulk := bytes.Repeat([]byte("ABCDEFGHIJ"), 100000)
+	doc := oganesson.NewDocument("TEST")
+	doc.AttachString("name", "value")
+	errChan := make(chan error, 2)
+	go func() { errChan <- client.Write(bulk) }()
+	go func() { errChan <- client.WriteDocument(doc) }()
+
+	var gotBulk, gotDoc bool
+	for i := 0; i < 2; i++ {
+		packet, err := server.Read()
+		if err != nil {
+			t.Fatalf("Read failed: %s", err.Error())
+		}
+		if bytes.Equal(packet, bulk) {
+			gotBulk = true
+			continue
+		}
+		received := oganesson.NewDocument()
+		if err := received.Unflatten(packet); err != nil {
+			t.Fatalf("Received Document didn't decode: %s", err.Error())
+		}
+		if value, _ := received.GetString("name"); received.MessageCode() != "TEST" ||
+			value != "value" {
+			t.Fatalf("Received Document mismatch")
+		}
+		gotDoc = true
+	}
+	if !gotBulk || !gotDoc {
+		t.Fatalf("Packets went missing")
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errChan; err != nil {
+			t.Fatalf("Write failed: %s", err.Error())
+		}
+	}
+
+	// Replies go the other way on the server's own streams
+	go server.WriteDocument(doc)
+	if received, err := client.ReadDocument(); err != nil || received.MessageCode() != "TEST" {
+		t.Fatalf("ReadDocument failed: %v", err)
+	}
+
+	client.Close()
+	if _, err := server.Read(); err == nil {
+		t.Fatalf("Read after the peer closed didn't fail")
+	}
+}
+
+func TestQUICSessionLimits(t *testing.T) {
+	serverTLS, clientTLS := testTLSConfigs(t)
+	l, err := Listen("127.0.0.1:0", serverTLS, nil)
+	if err != nil {
+		t.Fatalf("Listen failed: %s", err.Error())
+	}
+	defer l.Close()
+
+	client, server := testSessionPair(t, clientTLS, l)
+	defer client.Close()
+	defer server.Close()
+	server.MaxCommandLength = 10
+	server.MaxPacketSize = 1000
+	readHello(t, server)
+
+	// Packets over the limits are refused, and the session keeps going afterward
+	doc := oganesson.NewDocument("TEST")
+	doc.AttachString("name", "value")
+	go client.WriteDocument(doc)
+	if _, err := server.ReadDocument(); !errors.Is(err, oganesson.ErrSize) {
+		t.Fatalf("Document over MaxCommandLength wasn't refused: %v", err)
+	}
+	go client.Write(bytes.Repeat([]byte("ABCDEFGHIJ"), 1000))
+	if _, err := server.Read(); !errors.Is(err, oganesson.ErrSize) {
+		t.Fatalf("Packet over MaxPacketSize wasn't refused: %v", err)
+	}
+	go client.Write([]byte("after"))
+	if packet, err := server.Read(); err != nil || string(packet) != "after" {
+		t.Fatalf("Session didn't recover from a refused packet: %v", err)
+	}
+}
+
+func TestQUICSession0RTT(t *testing.T) {
+	serverTLS, clientTLS := testTLSConfigs(t)
+	l, err := Listen("127.0.0.1:0", serverTLS, &quic.Config{Allow0RTT: true})
+	if err != nil {
+		t.Fatalf("Listen failed: %s", err.Error())
+	}
+	defer l.Close()
+
+	// The first connection gets a session ticket for the second to use
+	client, server := testSessionPair(t, clientTLS, l)
+	readHello(t, server)
+	go server.Write([]byte("ticket"))
+	client.Read()
+	client.Close()
+	server.Close()
+
+	client, server = testSessionPair(t, clientTLS, l)
+	defer client.Close()
+	defer server.Close()
+	readHello(t, server)
+	<-client.Connection.HandshakeComplete()
+	if !client.Connection.ConnectionState().Used0RTT {
+		t.Fatalf("Reconnection didn't use 0-RTT")
+	}
+}
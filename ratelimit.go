@@ -0,0 +1,266 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// This file contains the rate limits applied by SessionServer. Each limit is a pair of token
+// buckets, one counting messages and one counting bytes, which fill at a steady rate up to a
+// burst size. A message which would take more tokens than a bucket holds is over the limit, and
+// the limit's RateAction decides what happens to it.
+
+// RateAction is what a SessionServer does with a message which goes over a RateLimit
+type RateAction int
+
+const (
+	// RateDelay holds the message until it fits within the limit. The session doesn't read
+	// anything else in the meantime, which slows down the sender through TCP flow control.
+	RateDelay RateAction = iota
+
+	// RateDrop throws the message away without a reply
+	RateDrop
+
+	// RateClose closes the session
+	RateClose
+)
+
+// String returns the name of the action
+func (a RateAction) String() string {
+	switch a {
+	case RateDelay:
+		return "delay"
+	case RateDrop:
+		return "drop"
+	case RateClose:
+		return "close"
+	}
+	return "unknown"
+}
+
+// RateLimit limits how fast messages may arrive. Limits which are zero aren't applied.
+type RateLimit struct {
+	MessagesPerSecond float64
+	BytesPerSecond    float64
+
+	// MessageBurst and ByteBurst are how many messages and bytes may arrive at once after a quiet
+	// period. If they are zero, one second's worth is allowed. When the action isn't RateDelay,
+	// messages bigger than ByteBurst are always over the limit.
+	MessageBurst int
+	ByteBurst    int
+
+	Action RateAction
+}
+
+// RateLimitEvent describes a message which went over one of a SessionServer's rate limits
+type RateLimitEvent struct {
+	Session *PacketSession
+
+	// Address is the IP address of the session's peer
+	Address string
+
+	// PerAddress is set if the message went over the server's AddressRate instead of its
+	// SessionRate
+	PerAddress bool
+
+	// Size is the size of the message in bytes
+	Size uint64
+
+	// Action is what was done with the message, and Delay is how long it was held for RateDelay
+	Action RateAction
+	Delay  time.Duration
+}
+
+// tokenBucket holds the tokens for one of the rates of a RateLimit
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket creates a full bucket. A rate of zero makes a bucket which never runs out.
+func newTokenBucket(rate float64, burst int, now time.Time) tokenBucket {
+	out := tokenBucket{rate: rate, burst: float64(burst), last: now}
+	if out.burst <= 0 {
+		out.burst = rate
+	}
+	out.tokens = out.burst
+	return out
+}
+
+// deficit returns how long it will be until the bucket holds the specified number of tokens
+func (b *tokenBucket) deficit(n float64, now time.Time) time.Duration {
+
+	if b.rate <= 0 {
+		return 0
+	}
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+// take removes tokens from the bucket. The bucket can go into debt, which delays the messages
+// after the one which took them.
+func (b *tokenBucket) take(n float64) {
+	if b.rate > 0 {
+		b.tokens -= n
+	}
+}
+
+// rateLimiter applies a RateLimit. Limiters for addresses are shared by all of the sessions from
+// the address, so they are locked.
+type rateLimiter struct {
+	limit    RateLimit
+	lock     sync.Mutex
+	messages tokenBucket
+	bytes    tokenBucket
+}
+
+// newRateLimiter creates a limiter for a RateLimit. It returns nil if the RateLimit doesn't limit
+// anything.
+func newRateLimiter(limit RateLimit) *rateLimiter {
+
+	if limit.MessagesPerSecond <= 0 && limit.BytesPerSecond <= 0 {
+		return nil
+	}
+	now := time.Now()
+	return &rateLimiter{
+		limit:    limit,
+		messages: newTokenBucket(limit.MessagesPerSecond, limit.MessageBurst, now),
+		bytes:    newTokenBucket(limit.BytesPerSecond, limit.ByteBurst, now),
+	}
+}
+
+// check returns how long it will be until a message of the specified size fits within the
+// limit. The message's tokens are taken if it fits now or if the limit's action is RateDelay.
+func (l *rateLimiter) check(size uint64) time.Duration {
+
+	if l == nil {
+		return 0
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+	wait := l.messages.deficit(1, now)
+	if byteWait := l.bytes.deficit(float64(size), now); byteWait > wait {
+		wait = byteWait
+	}
+	if wait == 0 || l.limit.Action == RateDelay {
+		l.messages.take(1)
+		l.bytes.take(float64(size))
+	}
+	return wait
+}
+
+// addressLimiter is the rateLimiter for an address along with the number of sessions using it
+type addressLimiter struct {
+	limiter  *rateLimiter
+	sessions int
+}
+
+// acquireAddressLimiter returns the limiter for an address, creating it if no other session from
+// the address is open
+func (srv *SessionServer) acquireAddressLimiter(address string) *rateLimiter {
+
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	entry, ok := srv.addressLimiters[address]
+	if !ok {
+		limiter := newRateLimiter(srv.AddressRate)
+		if limiter == nil {
+			return nil
+		}
+		if srv.addressLimiters == nil {
+			srv.addressLimiters = make(map[string]*addressLimiter)
+		}
+		entry = &addressLimiter{limiter: limiter}
+		srv.addressLimiters[address] = entry
+	}
+	entry.sessions++
+	return entry.limiter
+}
+
+// releaseAddressLimiter drops a session's use of an address's limiter, removing it once no
+// sessions from the address are left
+func (srv *SessionServer) releaseAddressLimiter(address string) {
+
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	entry, ok := srv.addressLimiters[address]
+	if !ok {
+		return
+	}
+	entry.sessions--
+	if entry.sessions <= 0 {
+		delete(srv.addressLimiters, address)
+	}
+}
+
+// admit applies the server's rate limits to a message which was just received. It returns
+// whether the message should be handled and whether the session should stay open.
+func (srv *SessionServer) admit(session *PacketSession, address string, sessionLimiter,
+	addressLimiter *rateLimiter, doc *Document) (bool, bool) {
+
+	size := doc.GetSize()
+	var delay time.Duration
+	for _, limiter := range []*rateLimiter{sessionLimiter, addressLimiter} {
+		wait := limiter.check(size)
+		if wait == 0 {
+			continue
+		}
+
+		event := RateLimitEvent{Session: session, Address: address,
+			PerAddress: limiter == addressLimiter, Size: size, Action: limiter.limit.Action}
+		switch limiter.limit.Action {
+		case RateDrop:
+			srv.reportRateLimit(event)
+			return false, true
+		case RateClose:
+			srv.reportRateLimit(event)
+			logWarning("closing session %s: rate limit exceeded", session.ID())
+			return false, false
+		}
+		if wait > delay {
+			delay = wait
+		}
+		event.Delay = wait
+		srv.reportRateLimit(event)
+	}
+
+	if delay > 0 {
+		if err := sleepContext(srv.ctx, delay); err != nil {
+			return false, false
+		}
+	}
+	return true, true
+}
+
+// reportRateLimit passes a RateLimitEvent to the server's OnRateLimit callback, if it has one
+func (srv *SessionServer) reportRateLimit(event RateLimitEvent) {
+	if srv.OnRateLimit != nil {
+		srv.OnRateLimit(event)
+	}
+}
+
+// peerAddress returns the IP address of the other side of a session, which is used to group
+// sessions for the server's AddressRate
+func peerAddress(session *PacketSession) string {
+	peer := session.peer()
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		return host
+	}
+	return peer
+}
@@ -0,0 +1,83 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(10, 2, now)
+	for i := 0; i < 2; i++ {
+		if wait := b.deficit(1, now); wait != 0 {
+			t.Fatalf("Burst was limited")
+		}
+		b.take(1)
+	}
+	if wait := b.deficit(1, now); wait != 100*time.Millisecond {
+		t.Fatalf("Wrong wait for an empty bucket: %s", wait)
+	}
+
+	// Delayed messages put the bucket into debt
+	b.take(1)
+	if wait := b.deficit(1, now.Add(100*time.Millisecond)); wait != 100*time.Millisecond {
+		t.Fatalf("Debt wasn't counted: %s", wait)
+	}
+	if wait := b.deficit(1, now.Add(time.Hour)); wait != 0 || b.tokens != 2 {
+		t.Fatalf("Bucket didn't refill to its burst size")
+	}
+}
+
+func TestSessionServerRateLimit(t *testing.T) {
+	srv := NewSessionServer()
+	srv.SessionRate = RateLimit{MessagesPerSecond: 0.01, MessageBurst: 2, Action: RateDrop}
+	srv.AddressRate = RateLimit{MessagesPerSecond: 0.01, MessageBurst: 3, Action: RateClose}
+	events := make(chan RateLimitEvent, 10)
+	srv.OnRateLimit = func(event RateLimitEvent) {
+		events <- event
+	}
+	srv.Handle("ECHO", func(ctx context.Context, doc *Document) (*Document, error) {
+		return doc, nil
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error setting up listener: %s", err.Error())
+	}
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+
+	// The third message goes over the session's limit and is dropped
+	client := testServerClient(t, listener.Addr().String())
+	for i := 0; i < 3; i++ {
+		client.WriteDocument(testMessage(t, "ECHO", "value", "hello"))
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := client.ReadDocument(); err != nil {
+			t.Fatalf("Error reading reply: %s", err.Error())
+		}
+	}
+	event := <-events
+	if event.Action != RateDrop || event.PerAddress || event.Address != "127.0.0.1" {
+		t.Fatalf("Wrong event for a dropped message: %+v", event)
+	}
+
+	// A second session from the same address uses up the rest of the address's limit
+	other := testServerClient(t, listener.Addr().String())
+	other.WriteDocument(testMessage(t, "ECHO", "value", "hello"))
+	if _, err := other.ReadDocument(); err != nil {
+		t.Fatalf("Error reading reply: %s", err.Error())
+	}
+	other.WriteDocument(testMessage(t, "ECHO", "value", "hello"))
+	if _, err := other.ReadDocument(); err == nil {
+		t.Fatalf("Session over the address limit wasn't closed")
+	}
+	event = <-events
+	if event.Action != RateClose || !event.PerAddress {
+		t.Fatalf("Wrong event for a closed session: %+v", event)
+	}
+}
@@ -0,0 +1,101 @@
+package oganesson
+
+import (
+	"errors"
+	"sync"
+)
+
+var ErrTypeCodeRange = errors.New("type code outside of extension ranges")
+var ErrTypeCodeTaken = errors.New("type code already registered")
+
+// Type codes are divided into ranges so that core types, third-party extensions, and experiments
+// can't collide with each other. Core codes are assigned only by this package. Extension codes are
+// for published third-party types and experimental codes are for private or in-development use.
+// Code 0 is never valid and 255 is reserved. See TYPECODES.md for the list of assigned codes.
+const (
+	CoreTypeCodeMin         = uint8(1)
+	CoreTypeCodeMax         = uint8(127)
+	ExtensionTypeCodeMin    = uint8(128)
+	ExtensionTypeCodeMax    = uint8(223)
+	ExperimentalTypeCodeMin = uint8(224)
+	ExperimentalTypeCodeMax = uint8(254)
+)
+
+// TypeCodeInfo describes the wire layout of a registered type code. Exactly one of FixedSize and
+// SizeFieldSize must be set: fixed-size types have a payload of FixedSize bytes and
+// variable-length types have a size field of SizeFieldSize bytes, which must be 2 or 8, followed
+// by the payload.
+type TypeCodeInfo struct {
+	Name          string
+	FixedSize     uint8
+	SizeFieldSize uint8
+}
+
+var typeRegistry struct {
+	sync.RWMutex
+	codes [256]*TypeCodeInfo
+}
+
+// IsCoreTypeCode returns true if the code is in the range reserved for this package's own types.
+// It does not mean that the code is currently assigned.
+func IsCoreTypeCode(typeCode uint8) bool {
+	return typeCode >= CoreTypeCodeMin && typeCode <= CoreTypeCodeMax
+}
+
+// IsExtensionTypeCode returns true if the code is in the third-party extension range
+func IsExtensionTypeCode(typeCode uint8) bool {
+	return typeCode >= ExtensionTypeCodeMin && typeCode <= ExtensionTypeCodeMax
+}
+
+// IsExperimentalTypeCode returns true if the code is in the experimental range
+func IsExperimentalTypeCode(typeCode uint8) bool {
+	return typeCode >= ExperimentalTypeCodeMin && typeCode <= ExperimentalTypeCodeMax
+}
+
+// RegisterTypeCode adds an extension or experimental type code to the registry so that segments
+// using it can be read and written. Core codes can't be registered. It is intended to be called
+// during program initialization.
+func RegisterTypeCode(typeCode uint8, info TypeCodeInfo) error {
+
+	if !IsExtensionTypeCode(typeCode) && !IsExperimentalTypeCode(typeCode) {
+		return ErrTypeCodeRange
+	}
+
+	if info.Name == "" {
+		return ErrEmptyData
+	}
+	switch {
+	case info.FixedSize != 0 && info.SizeFieldSize == 0:
+	case info.FixedSize == 0 && (info.SizeFieldSize == 2 || info.SizeFieldSize == 8):
+	default:
+		return ErrSize
+	}
+
+	typeRegistry.Lock()
+	defer typeRegistry.Unlock()
+
+	if typeRegistry.codes[typeCode] != nil {
+		return ErrTypeCodeTaken
+	}
+	entry := info
+	typeRegistry.codes[typeCode] = &entry
+	return nil
+}
+
+// UnregisterTypeCode removes a type code from the registry. It is mostly useful for tests.
+func UnregisterTypeCode(typeCode uint8) {
+	typeRegistry.Lock()
+	typeRegistry.codes[typeCode] = nil
+	typeRegistry.Unlock()
+}
+
+// LookupTypeCode returns the registry entry for an extension or experimental type code
+func LookupTypeCode(typeCode uint8) (TypeCodeInfo, bool) {
+	typeRegistry.RLock()
+	defer typeRegistry.RUnlock()
+
+	if info := typeRegistry.codes[typeCode]; info != nil {
+		return *info, true
+	}
+	return TypeCodeInfo{}, false
+}
@@ -0,0 +1,47 @@
+package oganesson
+
+import (
+	"testing"
+)
+
+func TestRegisterTypeCode(t *testing.T) {
+
+	if err := RegisterTypeCode(DFStringType, TypeCodeInfo{Name: "Bad", FixedSize: 1}); err !=
+		ErrTypeCodeRange {
+		t.Fatalf("RegisterTypeCode allowed a core type code to be registered")
+	}
+	if err := RegisterTypeCode(200, TypeCodeInfo{Name: "Bad", SizeFieldSize: 4}); err != ErrSize {
+		t.Fatalf("RegisterTypeCode allowed a bad size field size")
+	}
+
+	if isTypeCodeValid(200) {
+		t.Fatalf("Unregistered type code 200 treated as valid")
+	}
+	if err := RegisterTypeCode(200, TypeCodeInfo{Name: "UUID", FixedSize: 16}); err != nil {
+		t.Fatalf("RegisterTypeCode failed: %s", err.Error())
+	}
+	defer UnregisterTypeCode(200)
+
+	if err := RegisterTypeCode(200, TypeCodeInfo{Name: "Other", FixedSize: 1}); err !=
+		ErrTypeCodeTaken {
+		t.Fatalf("RegisterTypeCode allowed a type code to be registered twice")
+	}
+	if !isTypeCodeValid(200) || TypeName(200) != "UUID" {
+		t.Fatalf("Registered type code 200 not recognized")
+	}
+
+	// Segments with registered codes should be readable like any other
+	data := append([]byte{200}, []byte("0123456789abcdef")...)
+	seg, err := UnflattenSegment(data)
+	if err != nil {
+		t.Fatalf("UnflattenSegment failed on a registered type: %s", err.Error())
+	}
+	if seg.Type != 200 || string(seg.Value) != "0123456789abcdef" {
+		t.Fatalf("UnflattenSegment data mismatch on a registered type")
+	}
+
+	if !IsCoreTypeCode(DFUpperBound-1) || !IsExtensionTypeCode(200) ||
+		!IsExperimentalTypeCode(230) || IsExperimentalTypeCode(255) {
+		t.Fatalf("Type code range check failure")
+	}
+}
@@ -0,0 +1,384 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultRequestTimeout is the RequestTimeout given to new sessions
+const DefaultRequestTimeout = 30 * time.Second
+
+// IncomingBufferSize is the number of unsolicited Documents which can wait in the channel returned
+// by Incoming(). Documents which arrive while the channel is full are dropped.
+var IncomingBufferSize = 64
+
+// batchBufferSize is the size of the write buffer used by SendBatch() for sessions which don't
+// have one of their own
+const batchBufferSize = 64 * 1024
+
+// RetryPolicy controls how SendRequest() sends a request again after a failed attempt
+type RetryPolicy struct {
+	// MaxAttempts is the most times a request is sent. Zero or one means requests aren't retried.
+	MaxAttempts int
+
+	// Backoff sets how long to wait between attempts. Only the delay and jitter settings are
+	// used, and those which are zero are taken from DefaultBackoffPolicy.
+	Backoff BackoffPolicy
+
+	// Retryable decides whether an attempt which failed is worth trying again. If it is nil, only
+	// ErrTimedOut is retried.
+	Retryable func(err error) bool
+}
+
+// WithRetryPolicy sets the session's RequestRetry
+func WithRetryPolicy(policy RetryPolicy) SessionOption {
+	return func(s *PacketSession) {
+		s.RequestRetry = policy
+	}
+}
+
+// retryable decides whether an attempt which failed with the specified error should be retried
+func (p RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return errors.Is(err, ErrTimedOut)
+}
+
+// SendRequest sends a keyed Document and waits for the reply to it. The request is given a
+// message ID with Stamp(), and the reply is the Document whose header has the ID as its ReplyTo
+// field, which SessionServer fills in. Any number of goroutines can send requests at once; the
+// replies are read by a goroutine started by the first request, so the session's other read
+// methods must not be used once SendRequest() has been; Documents which aren't replies can be
+// received with Incoming() instead. While it runs, ReadTimeout works as an idle timeout for the
+// session.
+//
+// ErrTimedOut is returned if no reply arrives within RequestTimeout. The session stays open, and
+// if RequestRetry allows it, the request is sent again with the same message ID. Requests which
+// may be retried are also given an idempotency key so that a server using
+// IdempotencyMiddleware only handles them once. Requests which have expired (see SetTTL()) aren't
+// sent again, and ErrExpired is returned for them instead. The Document passed in isn't changed.
+func (s *PacketSession) SendRequest(ctx context.Context, doc *Document) (*Document, error) {
+	replies, err := s.SendBatch(ctx, []*Document{doc})
+	return replies[0], err
+}
+
+// SendBatch sends several requests at once and waits for all of their replies, which are
+// returned in the same order as the requests. The requests are written back to back without
+// waiting for replies, using a write buffer for the batch if the session doesn't have one, so a
+// client making many small requests saves most of the round trips. Requests are handled the same
+// way as by SendRequest(); if some of them time out, only those are sent again. If an error is
+// returned, the replies which did arrive are still filled in.
+func (s *PacketSession) SendBatch(ctx context.Context, docs []*Document) ([]*Document, error) {
+
+	replies := make([]*Document, len(docs))
+	reqs := make([]*Document, len(docs))
+	waiting := make([]chan *Document, len(docs))
+	for i, doc := range docs {
+		req, err := s.prepareRequest(doc)
+		if err != nil {
+			return replies, s.wrapError(err)
+		}
+		id, _ := req.MessageID()
+		if waiting[i], err = s.expectReply(id); err != nil {
+			return replies, err
+		}
+		defer s.forgetRequest(id)
+		reqs[i] = req
+	}
+
+	policy := s.RequestRetry
+	backoff := policy.Backoff.withDefaults()
+	delay := backoff.InitialDelay
+	for attempt := 1; ; attempt++ {
+		err := s.attemptBatch(ctx, reqs, waiting, replies)
+		if err == nil {
+			return replies, nil
+		}
+		if ctx.Err() != nil || attempt >= policy.MaxAttempts || !policy.retryable(err) {
+			return replies, err
+		}
+		if err := sleepContext(ctx, backoff.jittered(delay)); err != nil {
+			return replies, err
+		}
+		delay = backoff.nextDelay(delay)
+	}
+}
+
+// prepareRequest returns a copy of a request with a message ID and, if it may be retried, an
+// idempotency key. The copy shares the original's items, which aren't changed, so the original
+// may be frozen.
+func (s *PacketSession) prepareRequest(doc *Document) (*Document, error) {
+
+	req := &Document{Items: doc.Items, sensitive: doc.sensitive}
+	if err := req.Stamp(); err != nil {
+		return nil, err
+	}
+	if s.RequestRetry.MaxAttempts > 1 {
+		if key, _ := req.IdempotencyKey(); key == "" {
+			if _, err := req.SetIdempotencyKey(""); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return req, nil
+}
+
+// attemptBatch sends the requests which don't have replies yet and waits for their replies.
+// Replies which arrived late for an earlier attempt are picked up without sending their requests
+// again.
+func (s *PacketSession) attemptBatch(ctx context.Context, reqs []*Document,
+	waiting []chan *Document, replies []*Document) error {
+
+	var pending []int
+	var packets [][]byte
+	for i := range reqs {
+		if replies[i] != nil {
+			continue
+		}
+		select {
+		case reply, ok := <-waiting[i]:
+			if !ok {
+				return s.requestError()
+			}
+			replies[i] = reply
+			continue
+		default:
+		}
+
+		if reqs[i].IsExpired() {
+			return s.wrapError(ErrExpired)
+		}
+		if err := runInterceptors(s.Outbound, reqs[i]); err != nil {
+			return s.wrapError(err)
+		}
+		packet, err := s.flattenDocument(reqs[i])
+		if err != nil {
+			return s.wrapError(err)
+		}
+		pending = append(pending, i)
+		packets = append(packets, packet)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	if err := s.writeBatch(packets); err != nil {
+		return err
+	}
+
+	var timeout <-chan time.Time
+	if s.RequestTimeout > 0 {
+		timer := time.NewTimer(s.RequestTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for _, i := range pending {
+		select {
+		case reply, ok := <-waiting[i]:
+			if !ok {
+				return s.requestError()
+			}
+			replies[i] = reply
+		case <-timeout:
+			return s.wrapError(ErrTimedOut)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// writeBatch sends several packets back to back. If the session doesn't have a write buffer, one
+// is used for the batch so that the packets are sent in as few writes as possible.
+func (s *PacketSession) writeBatch(packets [][]byte) error {
+
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+
+	s.frameLock.Lock()
+	temporary := s.writer == nil
+	if temporary {
+		s.writer = bufio.NewWriterSize(s.Connection, batchBufferSize)
+	}
+	s.frameLock.Unlock()
+
+	var err error
+	for _, packet := range packets {
+		if err = s.writePacket(packet); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = s.flushFrames()
+	}
+
+	if temporary {
+		s.frameLock.Lock()
+		s.writer = nil
+		s.frameLock.Unlock()
+	}
+	s.noteError(err)
+	return s.wrapError(err)
+}
+
+// Incoming returns a channel which receives the Documents sent by the other side of the session
+// which aren't replies to requests, such as notifications pushed by a server. A server handler
+// can push Documents to its client with SessionFromContext() and WriteDocument(). Calling
+// Incoming() starts the same reader goroutine as SendRequest(), so the session's other read
+// methods must not be used afterward. The channel is closed when reading stops, such as when the
+// session is closed.
+func (s *PacketSession) Incoming() <-chan *Document {
+
+	s.startReader()
+
+	s.requestLock.Lock()
+	defer s.requestLock.Unlock()
+	if s.unsolicited == nil {
+		s.unsolicited = make(chan *Document, IncomingBufferSize)
+		if s.readerErr != nil {
+			close(s.unsolicited)
+		}
+	}
+	return s.unsolicited
+}
+
+// startReader starts the goroutine which reads replies and unsolicited Documents if it isn't
+// running yet
+func (s *PacketSession) startReader() {
+	s.readerOnce.Do(func() {
+		s.requestLock.Lock()
+		s.requests = make(map[string]chan *Document)
+		s.requestLock.Unlock()
+		go s.runReader()
+	})
+}
+
+// expectReply registers a request so that the reader goroutine passes its reply along, starting
+// the reader if it isn't running yet
+func (s *PacketSession) expectReply(id string) (chan *Document, error) {
+
+	s.startReader()
+
+	s.requestLock.Lock()
+	defer s.requestLock.Unlock()
+	if s.readerErr != nil {
+		return nil, s.readerErr
+	}
+	if _, exists := s.requests[id]; exists {
+		return nil, fmt.Errorf("%w: request %s is already waiting for a reply", ErrInvalidMsg, id)
+	}
+	out := make(chan *Document, 1)
+	s.requests[id] = out
+	return out, nil
+}
+
+// forgetRequest stops waiting for the reply to a request
+func (s *PacketSession) forgetRequest(id string) {
+	s.requestLock.Lock()
+	delete(s.requests, id)
+	s.requestLock.Unlock()
+}
+
+// requestError returns the error which stopped the reader goroutine
+func (s *PacketSession) requestError() error {
+	s.requestLock.Lock()
+	defer s.requestLock.Unlock()
+	return s.readerErr
+}
+
+// runReader reads Documents from the session and hands them out to the requests waiting for them
+// or to Incoming() until reading fails. Packets which can't be decoded are skipped.
+func (s *PacketSession) runReader() {
+
+	for {
+		packet, err := s.Read()
+		if errors.Is(err, ErrFrameChecksum) {
+			continue
+		}
+		if err != nil {
+			s.stopReader(err)
+			return
+		}
+
+		doc, err := s.decodeDocument(packet, nil)
+		if err == errDuplicate {
+			continue
+		}
+		if err != nil {
+			logWarning("session %s skipped a message: %s", s.ID(), err.Error())
+			continue
+		}
+		s.deliverIncoming(doc)
+	}
+}
+
+// deliverIncoming passes a reply to the request it answers and any other Document to the
+// channel returned by Incoming(). Replies which don't answer a waiting request, such as a second
+// reply to a request which was retried, are dropped, as are unsolicited Documents if Incoming()
+// hasn't been called.
+func (s *PacketSession) deliverIncoming(doc *Document) {
+
+	s.requestLock.Lock()
+	defer s.requestLock.Unlock()
+
+	h, err := doc.Header()
+	if err == nil && h.ReplyTo != "" {
+		if replies, ok := s.requests[h.ReplyTo]; ok {
+			delete(s.requests, h.ReplyTo)
+			replies <- doc
+		}
+		return
+	}
+
+	if s.unsolicited == nil {
+		return
+	}
+	select {
+	case s.unsolicited <- doc:
+	default:
+		logWarning("incoming channel of session %s is full, dropping document", s.ID())
+	}
+}
+
+// stopReader records the error which stopped the reader goroutine, wakes up all of the requests
+// waiting for replies, and closes the channel returned by Incoming()
+func (s *PacketSession) stopReader(err error) {
+	s.requestLock.Lock()
+	defer s.requestLock.Unlock()
+	s.readerErr = err
+	for id, replies := range s.requests {
+		close(replies)
+		delete(s.requests, id)
+	}
+	if s.unsolicited != nil {
+		close(s.unsolicited)
+	}
+}
+
+// markReply sets the ReplyTo field in the header of a reply to the message ID of the request it
+// answers so that SendRequest() can match them up. A copy of the reply is marked, so it may be
+// frozen. Replies to requests without IDs and replies which aren't keyed Documents are returned
+// as they are.
+func markReply(request *Document, reply *Document) *Document {
+
+	id, err := request.MessageID()
+	if err != nil || id == "" {
+		return reply
+	}
+	h, err := reply.Header()
+	if err != nil {
+		return reply
+	}
+
+	h.ReplyTo = id
+	out := &Document{Items: reply.Items, sensitive: reply.sensitive}
+	if err := out.SetHeader(h); err != nil {
+		return reply
+	}
+	return out
+}
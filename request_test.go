@@ -0,0 +1,203 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// serveRequests answers requests on a responder session by echoing their "n" field. The skip
+// function, if given, decides which requests go unanswered.
+func serveRequests(responder *PacketSession, skip func(req *Document) bool) {
+	go func() {
+		for {
+			req, err := responder.ReadDocument()
+			if err != nil {
+				return
+			}
+			if skip != nil && skip(req) {
+				continue
+			}
+			fields, _ := req.fieldMap()
+			b := NewDocumentBuilder(nil)
+			b.Add("n", fields["n"])
+			reply, _ := b.Build()
+			if err := responder.WriteDocument(markReply(req, reply)); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+func TestSendRequest(t *testing.T) {
+	requester, responder := sessionPair(t, nil)
+	serveRequests(responder, nil)
+
+	var wg sync.WaitGroup
+	for i := int32(0); i < 20; i++ {
+		req := testMessage(t, "ECHO", "n", i)
+		req.Freeze()
+		wg.Add(1)
+		go func(i int32) {
+			defer wg.Done()
+			reply, err := requester.SendRequest(context.Background(), req)
+			if err != nil {
+				t.Errorf("Request %d failed: %s", i, err.Error())
+				return
+			}
+			fields, _ := reply.fieldMap()
+			if n, _ := fields.GetInt32("n"); n != i {
+				t.Errorf("Request %d got the reply to request %d", i, n)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Once the connection is gone, requests fail instead of waiting
+	responder.Close()
+	req := testMessage(t, "ECHO", "n", int32(0))
+	if _, err := requester.SendRequest(context.Background(), req); err == nil {
+		t.Fatalf("Request on a closed connection succeeded")
+	}
+}
+
+func TestSendRequestRetry(t *testing.T) {
+	requester, responder := sessionPair(t, nil)
+	requester.RequestTimeout = 50 * time.Millisecond
+
+	// The first attempt of each request goes unanswered
+	var lock sync.Mutex
+	seen := make(map[string]DocumentHeader)
+	serveRequests(responder, func(req *Document) bool {
+		h, _ := req.Header()
+		lock.Lock()
+		defer lock.Unlock()
+		if first, ok := seen[h.MessageID]; ok {
+			if first.IdempotencyKey == "" || first.IdempotencyKey != h.IdempotencyKey {
+				t.Errorf("Retry has a different idempotency key")
+			}
+			return false
+		}
+		seen[h.MessageID] = h
+		return true
+	})
+
+	req := testMessage(t, "ECHO", "n", int32(1))
+	_, err := requester.SendRequest(context.Background(), req)
+	if !errors.Is(err, ErrTimedOut) {
+		t.Fatalf("Unanswered request returned %v", err)
+	}
+	if requester.State() == StateClosed {
+		t.Fatalf("Request timeout closed the session")
+	}
+
+	requester.RequestRetry = RetryPolicy{MaxAttempts: 3,
+		Backoff: BackoffPolicy{InitialDelay: 10 * time.Millisecond}}
+	req = testMessage(t, "ECHO", "n", int32(2))
+	reply, err := requester.SendRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Retried request failed: %s", err.Error())
+	}
+	fields, _ := reply.fieldMap()
+	if n, _ := fields.GetInt32("n"); n != 2 {
+		t.Fatalf("Retried request got the wrong reply")
+	}
+
+	// Errors which the policy doesn't consider retryable are returned right away
+	requester.RequestRetry.Retryable = func(err error) bool { return false }
+	start := time.Now()
+	req = testMessage(t, "ECHO", "n", int32(3))
+	requester.SendRequest(context.Background(), req)
+	if time.Since(start) > 4*requester.RequestTimeout {
+		t.Fatalf("Request was retried against the policy")
+	}
+}
+
+func TestSendBatch(t *testing.T) {
+	requester, responder := sessionPair(t, nil)
+	conn := &countingConn{Conn: requester.Connection}
+	requester.Connection = conn
+	serveRequests(responder, nil)
+
+	docs := make([]*Document, 50)
+	for i := range docs {
+		docs[i] = testMessage(t, "ECHO", "n", int32(i))
+	}
+	replies, err := requester.SendBatch(context.Background(), docs)
+	if err != nil {
+		t.Fatalf("Batch failed: %s", err.Error())
+	}
+	for i, reply := range replies {
+		fields, _ := reply.fieldMap()
+		if n, _ := fields.GetInt32("n"); n != int32(i) {
+			t.Fatalf("Reply %d is for request %d", i, n)
+		}
+	}
+
+	// The whole batch fits into one write
+	if conn.writes != 1 {
+		t.Fatalf("Batch took %d writes", conn.writes)
+	}
+	if requester.writer != nil {
+		t.Fatalf("Batch write buffer was left in place")
+	}
+
+	// Requests which aren't keyed Documents are refused before anything is sent
+	bad := NewDocument()
+	bad.AttachInt8("", 1)
+	if _, err := requester.SendBatch(context.Background(), []*Document{docs[0], bad}); err == nil {
+		t.Fatalf("Request which isn't a keyed Document was accepted")
+	}
+	if conn.writes != 1 {
+		t.Fatalf("Part of a refused batch was sent")
+	}
+}
+
+func TestIncoming(t *testing.T) {
+	requester, responder := sessionPair(t, nil)
+	incoming := requester.Incoming()
+
+	// A notification pushed ahead of a reply doesn't get mixed up with it
+	notice := testMessage(t, "ECHO", "n", int32(99))
+	pushedReply := testMessage(t, "ECHO", "n", int32(1))
+	go func() {
+		req, err := responder.ReadDocument()
+		if err != nil {
+			return
+		}
+		responder.WriteDocument(notice)
+		responder.WriteDocument(markReply(req, pushedReply))
+	}()
+
+	req := testMessage(t, "ECHO", "n", int32(1))
+	reply, err := requester.SendRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Request failed: %s", err.Error())
+	}
+	fields, _ := reply.fieldMap()
+	if n, _ := fields.GetInt32("n"); n != 1 {
+		t.Fatalf("Notification was taken as the reply")
+	}
+
+	select {
+	case doc := <-incoming:
+		fields, _ := doc.fieldMap()
+		if n, _ := fields.GetInt32("n"); n != 99 {
+			t.Fatalf("Wrong document on the incoming channel")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Notification didn't arrive")
+	}
+
+	responder.Close()
+	if _, ok := <-incoming; ok {
+		t.Fatalf("Incoming channel wasn't closed with the session")
+	}
+	if _, ok := <-requester.Incoming(); ok {
+		t.Fatalf("Incoming channel of a stopped reader isn't closed")
+	}
+}
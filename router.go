@@ -0,0 +1,97 @@
+package oganesson
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var ErrUnknownMessage = errors.New("unknown message code")
+
+// MsgCodeField is the name of the field which holds a message's code. Routers use it to decide
+// which Handler receives a Document.
+const MsgCodeField = "MsgCode"
+
+// Handler processes a Document received by a server. The Document it returns is sent back to the
+// client as the reply. If it returns nil, no reply is sent.
+type Handler func(ctx context.Context, doc *Document) (*Document, error)
+
+// Router passes each Document it receives to the Handler registered for the Document's message
+// code. Errors returned by Handlers, including for unknown message codes, are turned into error
+// documents (see NewErrorDocument()) so that the client always gets an answer. A Router must be set up before it is used;
+// it is safe for concurrent use after that.
+type Router struct {
+	// OnExpired, if set, receives the Documents which have expired (see SetTTL()) instead of their
+	// Handlers, such as for counting or logging them. Otherwise expired Documents are dropped
+	// without a reply. Middleware isn't run for them either way.
+	OnExpired Handler
+
+	handlers   map[string]Handler
+	middleware []Middleware
+	chain      Handler
+}
+
+// NewRouter creates an empty Router
+func NewRouter() *Router {
+	out := &Router{handlers: make(map[string]Handler)}
+	out.chain = out.dispatch
+	return out
+}
+
+// Handle registers the Handler for a message code, replacing any existing one
+func (r *Router) Handle(code string, h Handler) {
+	r.handlers[code] = h
+}
+
+// Use adds Middleware to the Router. Middleware runs in the order it was added, so the first one
+// added sees each message first. It applies to all messages, including those with unknown codes.
+func (r *Router) Use(mw ...Middleware) {
+	r.middleware = append(r.middleware, mw...)
+	r.chain = Chain(r.middleware...)(r.dispatch)
+}
+
+// ServeDocument routes a Document to its Handler and returns the reply. It is itself a Handler,
+// so Routers can be nested. An error is only returned if an error reply couldn't be created.
+func (r *Router) ServeDocument(ctx context.Context, doc *Document) (*Document, error) {
+
+	var reply *Document
+	var err error
+	if doc.IsExpired() {
+		if r.OnExpired == nil {
+			return nil, nil
+		}
+		reply, err = r.OnExpired(ctx, doc)
+	} else {
+		reply, err = r.chain(ctx, doc)
+	}
+	if err != nil {
+		return errorDocumentFor(err)
+	}
+	return reply, nil
+}
+
+// dispatch looks up the Handler for a Document and calls it
+func (r *Router) dispatch(ctx context.Context, doc *Document) (*Document, error) {
+
+	code, err := messageCode(doc)
+	if err != nil {
+		return nil, err
+	}
+	h, ok := r.handlers[code]
+	if !ok {
+		return nil, fmt.Errorf("%w '%s'", ErrUnknownMessage, code)
+	}
+	return h(ctx, doc)
+}
+
+// messageCode returns the message code of a keyed Document or a message Document
+func messageCode(doc *Document) (string, error) {
+	if doc.isMessage() {
+		return doc.MessageCode(), nil
+	}
+	fields, err := doc.fieldMap()
+	if err != nil {
+		return "", err
+	}
+	return fields.GetString(MsgCodeField)
+}
@@ -0,0 +1,64 @@
+package oganesson
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// replyField returns a string field from a reply Document
+func replyField(t *testing.T, doc *Document, name string) string {
+	if doc == nil {
+		t.Fatalf("Missing reply")
+	}
+	fields, err := doc.fieldMap()
+	if err != nil {
+		t.Fatalf("Error reading reply: %s", err.Error())
+	}
+	value, _ := fields.GetString(name)
+	return value
+}
+
+func TestRouter(t *testing.T) {
+	var logged []string
+	r := NewRouter()
+	r.Use(RecoverMiddleware, LoggingMiddleware(func(msg string) {
+		logged = append(logged, msg)
+	}), AuthMiddleware(func(ctx context.Context, doc *Document) error {
+		return errors.New("not logged in")
+	}, "LOGIN", "PANIC", "BOGUS"))
+
+	r.Handle("LOGIN", func(ctx context.Context, doc *Document) (*Document, error) {
+		return testMessage(t, "WELCOME"), nil
+	})
+	r.Handle("PANIC", func(ctx context.Context, doc *Document) (*Document, error) {
+		panic("oops")
+	})
+	r.Handle("SECRET", func(ctx context.Context, doc *Document) (*Document, error) {
+		return testMessage(t, "SECRET"), nil
+	})
+
+	reply, err := r.ServeDocument(context.Background(), testMessage(t, "LOGIN"))
+	if err != nil || replyField(t, reply, MsgCodeField) != "WELCOME" {
+		t.Fatalf("Router didn't call the LOGIN handler")
+	}
+
+	for code, want := range map[string]error{"PANIC": ErrHandlerPanic, "SECRET": ErrUnauthorized,
+		"BOGUS": ErrUnknownMessage} {
+
+		reply, err = r.ServeDocument(context.Background(), testMessage(t, code))
+		if err != nil {
+			t.Fatalf("ServeDocument failed for %s: %s", code, err.Error())
+		}
+		if replyField(t, reply, MsgCodeField) != ErrorMsgCode ||
+			!strings.HasPrefix(replyField(t, reply, ErrorField), want.Error()) {
+			t.Fatalf("Wrong error reply for %s: %s", code, replyField(t, reply, ErrorField))
+		}
+	}
+
+	// The panic skips the logging middleware because RecoverMiddleware was added first
+	if len(logged) != 3 || !strings.HasPrefix(logged[0], "LOGIN handled") {
+		t.Fatalf("Logging middleware output mismatch: %v", logged)
+	}
+}
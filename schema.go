@@ -0,0 +1,122 @@
+package oganesson
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var ErrUnknownField = errors.New("unknown field")
+var ErrMissingField = errors.New("missing required field")
+var ErrConstraint = errors.New("constraint violation")
+
+// FieldSpec describes a single named field in a Schema
+type FieldSpec struct {
+	Name     string
+	Type     uint8
+	Required bool
+
+	// Check is an optional constraint on the field's value. It should return a non-nil error if
+	// the value is not acceptable.
+	Check func(Segment) error
+}
+
+// Schema describes the named fields which a message is permitted to contain
+type Schema struct {
+	fields map[string]FieldSpec
+
+	// order, specs, and position are set by SetFieldOrder(). Specs holds the fields' specifications
+	// in order, and position maps each field's name to its place in the order.
+	order    []string
+	specs    []FieldSpec
+	position map[string]int
+}
+
+// NewSchema creates a new Schema from a list of field specifications
+func NewSchema(fields ...FieldSpec) *Schema {
+	out := Schema{fields: make(map[string]FieldSpec, len(fields))}
+	for _, f := range fields {
+		out.fields[f.Name] = f
+	}
+	return &out
+}
+
+// Field returns the specification for the named field and whether or not it exists
+func (s *Schema) Field(name string) (FieldSpec, bool) {
+	f, ok := s.fields[name]
+	return f, ok
+}
+
+// ValidateField checks a single field value against the schema
+func (s *Schema) ValidateField(name string, seg Segment) error {
+
+	spec, ok := s.fields[name]
+	if !ok {
+		return fmt.Errorf("%w '%s'", ErrUnknownField, name)
+	}
+	return spec.validate(name, seg)
+}
+
+// validate checks a field value against the specification
+func (spec FieldSpec) validate(name string, seg Segment) error {
+
+	if baseTypeCode(seg.Type) != baseTypeCode(spec.Type) {
+		return &TypeError{Expected: spec.Type, Actual: seg.Type, Key: name}
+	}
+
+	if spec.Check != nil {
+		if err := spec.Check(seg); err != nil {
+			return fmt.Errorf("%w: field '%s': %s", ErrConstraint, name, err.Error())
+		}
+	}
+	return nil
+}
+
+// MissingFields returns the sorted names of the required fields which are not in the SegmentMap
+func (s *Schema) MissingFields(sm SegmentMap) []string {
+	out := make([]string, 0)
+	for name, spec := range s.fields {
+		if _, ok := sm[name]; spec.Required && !ok {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Validate checks all of the fields in a SegmentMap against the schema and makes sure that all
+// required fields are present.
+func (s *Schema) Validate(sm SegmentMap) error {
+
+	for _, name := range sm.Keys() {
+		if IsReservedKey(name) {
+			continue
+		}
+		if err := s.ValidateField(name, sm[name]); err != nil {
+			return err
+		}
+	}
+
+	if missing := s.MissingFields(sm); len(missing) > 0 {
+		return fmt.Errorf("%w: %s", ErrMissingField, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// baseTypeCode maps the Huge and Large type codes to their regular counterparts. SetString() and
+// friends pick the size of the type code based on the size of the data, so schemas treat them as
+// the same type.
+func baseTypeCode(typeCode uint8) uint8 {
+	switch typeCode {
+	case DFHugeStringType:
+		return DFStringType
+	case DFHugeBinaryType:
+		return DFBinaryType
+	case DFLargeMapType:
+		return DFMapType
+	case DFLargeListType:
+		return DFListType
+	}
+	return typeCode
+}
@@ -0,0 +1,50 @@
+package oganesson
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+)
+
+// This file contains comparisons for secrets, such as passwords and tokens, which take the same
+// amount of time however much of the secret an attacker has guessed right. Comparing secrets with
+// == or bytes.Equal() stops at the first byte which differs, which can let an attacker work out
+// a secret a byte at a time by timing the comparisons.
+
+// SecureCompare returns true if two byte slices are the same. Both are hashed before they are
+// compared, so the time it takes doesn't depend on where they differ or on whether their lengths
+// match.
+func SecureCompare(a []byte, b []byte) bool {
+	hashA := sha256.Sum256(a)
+	hashB := sha256.Sum256(b)
+	return subtle.ConstantTimeCompare(hashA[:], hashB[:]) == 1
+}
+
+// SecureCompareBinary compares the value of a Binary field of a keyed Document with an expected
+// value using SecureCompare(). An error is returned if the field is missing or isn't Binary.
+func SecureCompareBinary(doc *Document, key string, expected []byte) (bool, error) {
+
+	fields, err := doc.fieldMap()
+	if err != nil {
+		return false, err
+	}
+	value, err := fields.GetBinary(key)
+	if err != nil {
+		return false, fmt.Errorf("field '%s': %w", key, err)
+	}
+	return SecureCompare(value, expected), nil
+}
+
+// SecureCompareString is the same as SecureCompareBinary() for String fields
+func SecureCompareString(doc *Document, key string, expected string) (bool, error) {
+
+	fields, err := doc.fieldMap()
+	if err != nil {
+		return false, err
+	}
+	value, err := fields.GetString(key)
+	if err != nil {
+		return false, fmt.Errorf("field '%s': %w", key, err)
+	}
+	return SecureCompare([]byte(value), []byte(expected)), nil
+}
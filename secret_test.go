@@ -0,0 +1,35 @@
+package oganesson
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSecureCompare(t *testing.T) {
+	if !SecureCompare([]byte("secret"), []byte("secret")) || SecureCompare([]byte("secret"),
+		[]byte("secreT")) || SecureCompare([]byte("secret"), []byte("secrets")) {
+		t.Fatalf("SecureCompare gave the wrong answer")
+	}
+
+	b := NewDocumentBuilder(nil)
+	b.AddString(MsgCodeField, "LOGIN")
+	b.AddString("password", "hunter2")
+	b.AddBinary("token", []byte{1, 2, 3})
+	doc, _ := b.Build()
+
+	if ok, err := SecureCompareBinary(doc, "token", []byte{1, 2, 3}); !ok || err != nil {
+		t.Fatalf("Matching token didn't compare equal: %v", err)
+	}
+	if ok, _ := SecureCompareBinary(doc, "token", []byte{1, 2, 4}); ok {
+		t.Fatalf("Different token compared equal")
+	}
+	if ok, err := SecureCompareString(doc, "password", "hunter2"); !ok || err != nil {
+		t.Fatalf("Matching password didn't compare equal: %v", err)
+	}
+	if _, err := SecureCompareBinary(doc, "password", nil); !errors.Is(err, ErrTypeError) {
+		t.Fatalf("String field was compared as Binary: %v", err)
+	}
+	if _, err := SecureCompareString(doc, "missing", ""); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Missing field returned %v", err)
+	}
+}
@@ -1,10 +1,14 @@
 package oganesson
 
 import (
+	"bytes"
+	"encoding"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"sort"
 	"strconv"
 
 	"github.com/darkwyrm/oganesson/membufio"
@@ -15,6 +19,36 @@ var ErrInvalidKey = errors.New("invalid key")
 var ErrSegmentSize = errors.New("invalid field size")
 var ErrIO = errors.New("i/o error")
 
+// TypeError is returned when a segment's type doesn't match the one requested. It matches
+// ErrTypeError when used with errors.Is().
+type TypeError struct {
+	Expected uint8
+	Actual   uint8
+
+	// Key is the name of the field, if there is one
+	Key string
+}
+
+func (e *TypeError) Error() string {
+	out := "expected " + TypeName(e.Expected) + ", got " + TypeName(e.Actual)
+	if e.Key != "" {
+		out += " for key '" + e.Key + "'"
+	}
+	return out
+}
+
+func (e *TypeError) Is(target error) bool {
+	return target == ErrTypeError
+}
+
+// withKey adds a field name to a TypeError. Other errors are returned unchanged.
+func withKey(err error, key string) error {
+	if te, ok := err.(*TypeError); ok {
+		te.Key = key
+	}
+	return err
+}
+
 const (
 	DFUnknownType = iota
 
@@ -53,19 +87,95 @@ const (
 	// indicates the number of items to follow that belong to that container. For maps, the item
 	// count indicates the number of key-value pairs and a list indicates the actual number of
 	// fields. For complexity reasons maps and lists may not be nested. The LargeMap and LargeList
-	// types use an unsigned 32-bit integer to indicate the number of items.
+	// types use an unsigned 32-bit integer to indicate the number of items unless they are written
+	// and read with WideLargeContainers set, in which case they use an unsigned 64-bit integer.
 	DFMapType
 	DFListType
 	DFLargeMapType
 	DFLargeListType
 
+	// Keyed maps are LargeMaps whose keys may be any of the integer types or Binary segments as
+	// well as Strings. They have their own type code so that code expecting string keys doesn't
+	// misread them. The index uses the same count field as LargeMap.
+	DFKeyedMapType
+
 	// This code isn't used for anything except for type code validity checking. It MUST be last
-	// in this list!
+	// in this list and, like all core codes, must not go past CoreTypeCodeMax.
 	DFUpperBound
 )
 
+// wideIndexSize is the size of the item count of LargeMap, LargeList, and KeyedMap segments when
+// WideLargeContainers is set on an Encoder, Decoder, or PacketSession. This is the 64-bit count
+// described in the JBitPack spec. Segments in memory always hold the 32-bit count used by
+// earlier versions of this package, so the wider count only exists on the wire.
+const wideIndexSize = 8
+
+// hasWideIndex returns true if the type's item count is widened by WideLargeContainers
+func hasWideIndex(typeCode uint8) bool {
+	return typeCode == DFLargeMapType || typeCode == DFLargeListType || typeCode == DFKeyedMapType
+}
+
+// TypeName returns the name of a segment type code, such as "UInt16" for DFUInt16Type, or
+// "Invalid" if the code isn't valid.
+func TypeName(typeCode uint8) string {
+	switch typeCode {
+	case DFDocumentStart:
+		return "DocumentStart"
+	case DFDocumentEnd:
+		return "DocumentEnd"
+	case DFInt8Type:
+		return "Int8"
+	case DFUInt8Type:
+		return "UInt8"
+	case DFInt16Type:
+		return "Int16"
+	case DFUInt16Type:
+		return "UInt16"
+	case DFInt32Type:
+		return "Int32"
+	case DFUInt32Type:
+		return "UInt32"
+	case DFInt64Type:
+		return "Int64"
+	case DFUInt64Type:
+		return "UInt64"
+	case DFBoolType:
+		return "Bool"
+	case DFFloat32Type:
+		return "Float32"
+	case DFFloat64Type:
+		return "Float64"
+	case DFStringType:
+		return "String"
+	case DFBinaryType:
+		return "Binary"
+	case DFHugeStringType:
+		return "HugeString"
+	case DFHugeBinaryType:
+		return "HugeBinary"
+	case DFMapType:
+		return "Map"
+	case DFListType:
+		return "List"
+	case DFLargeMapType:
+		return "LargeMap"
+	case DFLargeListType:
+		return "LargeList"
+	case DFKeyedMapType:
+		return "KeyedMap"
+	}
+	if info, ok := LookupTypeCode(typeCode); ok {
+		return info.Name
+	}
+	return "Invalid"
+}
+
 func isTypeCodeValid(typecode uint8) bool {
-	return typecode < DFUpperBound && typecode > 0
+	if typecode < DFUpperBound && typecode > 0 {
+		return true
+	}
+	_, ok := LookupTypeCode(typecode)
+	return ok
 }
 
 // sizeSegmentSize returns the number of bytes used by a type's size field, such as 8 for
@@ -78,6 +188,10 @@ func sizeSegmentSize(typeCode uint8) uint8 {
 	case DFHugeStringType, DFHugeBinaryType:
 		return 8
 	}
+	if typeCode >= DFUpperBound {
+		info, _ := LookupTypeCode(typeCode)
+		return info.SizeFieldSize
+	}
 	return 0
 }
 
@@ -88,14 +202,48 @@ func fixedSegmentSize(typeCode uint8) uint8 {
 		return 1
 	case DFInt16Type, DFUInt16Type, DFMapType, DFListType:
 		return 2
-	case DFInt32Type, DFUInt32Type, DFFloat32Type, DFLargeMapType, DFLargeListType:
+	case DFInt32Type, DFUInt32Type, DFFloat32Type:
+		return 4
+	case DFLargeMapType, DFLargeListType, DFKeyedMapType:
 		return 4
 	case DFInt64Type, DFUInt64Type, DFFloat64Type, DFDocumentEnd:
 		return 8
 	}
+	if typeCode >= DFUpperBound {
+		info, _ := LookupTypeCode(typeCode)
+		return info.FixedSize
+	}
 	return 0
 }
 
+// wireSize returns the number of bytes a segment of the specified type takes up when flattened:
+// the type code, the size field if the type has one, and the payload. The payload length is
+// ignored for fixed-size types.
+func wireSize(typeCode uint8, payloadLen uint64) uint64 {
+	if fixed := fixedSegmentSize(typeCode); fixed != 0 {
+		return 1 + uint64(fixed)
+	}
+	return 1 + uint64(sizeSegmentSize(typeCode)) + payloadLen
+}
+
+// stringWireSize returns the flattened size of a String segment holding a string of the
+// specified length, which is a HugeString if it is longer than 65535 bytes
+func stringWireSize(length uint64) uint64 {
+	if length > 65535 {
+		return wireSize(DFHugeStringType, length)
+	}
+	return wireSize(DFStringType, length)
+}
+
+// indexWireSize returns the flattened size of the index segment of a container holding the
+// specified number of items. It is the counterpart to setContainerIndex().
+func indexWireSize(regularType uint8, largeType uint8, count uint64) uint64 {
+	if count > 65535 {
+		return wireSize(largeType, 0)
+	}
+	return wireSize(regularType, 0)
+}
+
 // The Segment structure is the foundation of the JBitPack data serialization format
 type Segment struct {
 	Type  uint8
@@ -131,29 +279,32 @@ func (seg *Segment) GetType() uint8 {
 
 // GetSize returns the size of the field in bytes when serialized
 func (seg *Segment) GetSize() uint64 {
-	intSize := fixedSegmentSize(seg.Type)
-	if intSize != 0 {
-		return uint64(intSize) + 1
-	}
-
-	sizeSize := uint64(sizeSegmentSize(seg.Type))
-	return uint64(len(seg.Value)) + sizeSize + 1
+	return wireSize(seg.Type, uint64(len(seg.Value)))
 }
 
 // Read attempts to set the value of the object from the I/O reader given to it
 func (seg *Segment) Read(r io.Reader) error {
+	_, err := seg.readLimited(r, 0, nil, nil, BigEndianProfile, false)
+	return err
+}
+
+// readLimited does the work for Read(). If maxPayload is not zero, segments with a larger payload
+// are rejected before any memory is allocated for them. The payload is allocated from the arena
+// and charged to the budget, either of which may be nil. If wide is set, container counts are read
+// as described for WideLargeContainers. The number of bytes consumed from the reader is returned
+// on success.
+func (seg *Segment) readLimited(r io.Reader, maxPayload uint64, arena *DecodeArena,
+	budget *MemoryBudget, profile EncodingProfile, wide bool) (uint64, error) {
 
+	// io.ReadFull() is used throughout because network and HTTP readers are allowed to return
+	// less than was asked for, and even to return the last of the data along with io.EOF.
 	typeBuffer := make([]byte, 1)
-	bytesRead, err := r.Read(typeBuffer)
-	if err != nil {
-		return err
-	}
-	if bytesRead != 1 {
-		return ErrIO
+	if _, err := io.ReadFull(r, typeBuffer); err != nil {
+		return 0, err
 	}
 
 	if !isTypeCodeValid(typeBuffer[0]) {
-		return ErrInvalidSegment
+		return 0, ErrInvalidSegment
 	}
 
 	var sizeWriter []byte
@@ -162,36 +313,115 @@ func (seg *Segment) Read(r io.Reader) error {
 	if sizeSize != 0 {
 		sizeWriter = make([]byte, sizeSize)
 
-		bytesRead, err = r.Read(sizeWriter)
-		if err != nil {
-			return err
-		}
-
-		if bytesRead != int(sizeSize) {
-			return ErrIO
+		// The type byte has already been read, so running out of data here means the segment
+		// was cut short rather than the stream having ended cleanly.
+		if _, err := io.ReadFull(r, sizeWriter); err != nil {
+			if err == io.ErrUnexpectedEOF || err == io.EOF {
+				return 0, ErrSegmentSize
+			}
+			return 0, err
 		}
 
 		// The size bytes are in network order (MSB), so this makes dealing with CPU architecture much
 		// less of a headache regardless of what archictecture this is compiled for.
-		payloadSize = (uint64(sizeWriter[0]) << 8) + uint64(sizeWriter[1])
+		profile.toNetworkOrder(sizeWriter)
+		for _, b := range sizeWriter {
+			payloadSize = (payloadSize << 8) + uint64(b)
+		}
+	} else if wide && hasWideIndex(typeBuffer[0]) {
+		payloadSize = wideIndexSize
 	} else {
 		payloadSize = uint64(fixedSegmentSize(typeBuffer[0]))
 	}
 
+	if maxPayload > 0 && payloadSize > maxPayload {
+		return 0, ErrLimitExceeded
+	}
+	if payloadSize > MaxDecodedSize || payloadSize > math.MaxInt {
+		return 0, ErrSize
+	}
+
+	if err := budget.Charge(payloadSize); err != nil {
+		return 0, err
+	}
+
 	seg.Type = typeBuffer[0]
 
-	payloadBuffer := make([]byte, payloadSize)
-	bytesRead, err = r.Read(payloadBuffer)
+	payloadBuffer, err := readPayload(r, payloadSize, arena)
 	if err != nil {
-		return err
+		budget.Release(payloadSize)
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			return 0, ErrSegmentSize
+		}
+		return 0, err
+	}
+	seg.Value = payloadBuffer
+	if fixedSegmentSize(seg.Type) > 1 {
+		profile.toNetworkOrder(seg.Value)
+	}
+	if payloadSize == wideIndexSize && hasWideIndex(seg.Type) {
+		narrow, err := narrowIndex(seg.Value)
+		if err != nil {
+			budget.Release(payloadSize)
+			return 0, err
+		}
+		budget.Release(payloadSize - uint64(len(narrow)))
+		seg.Value = narrow
+	}
+
+	return 1 + uint64(sizeSize) + payloadSize, nil
+}
+
+// payloadChunkSize is the most memory which readPayload() will commit to a payload ahead of the
+// data actually arriving
+const payloadChunkSize = 64 * 1024
+
+// readPayload reads a segment payload of the given size. The size comes from the peer, so unless
+// the payload is small or fits in the arena, the buffer is grown only as the data arrives. A
+// size field which claims far more data than is sent therefore can't make the decoder allocate it.
+func readPayload(r io.Reader, size uint64, arena *DecodeArena) ([]byte, error) {
+
+	if size <= payloadChunkSize || (arena != nil && size <= uint64(arena.Available())) {
+		out := arena.alloc(size)
+		if _, err := io.ReadFull(r, out); err != nil {
+			return nil, err
+		}
+		return out, nil
 	}
 
-	if bytesRead != int(payloadSize) {
-		return ErrSegmentSize
+	var buffer bytes.Buffer
+	buffer.Grow(payloadChunkSize)
+	if _, err := io.CopyN(&buffer, r, int64(size)); err != nil {
+		return nil, err
 	}
-	seg.Value = payloadBuffer[:bytesRead]
+	out := buffer.Bytes()
+	return out[:len(out):len(out)], nil
+}
 
-	return nil
+// widenIndex returns the 64-bit wire form of the 32-bit count of a LargeMap, LargeList, or
+// KeyedMap segment
+func widenIndex(value []byte) []byte {
+	return append(make([]byte, wideIndexSize-len(value), wideIndexSize), value...)
+}
+
+// narrowIndex is the counterpart to widenIndex(). ErrSize is returned for counts which don't fit
+// in 32 bits.
+func narrowIndex(value []byte) ([]byte, error) {
+	high := len(value) - int(fixedSegmentSize(DFLargeMapType))
+	for _, b := range value[:high] {
+		if b != 0 {
+			return nil, ErrSize
+		}
+	}
+	return value[high:], nil
+}
+
+// Clone returns a deep copy of the Segment which doesn't share its Value with the original
+func (seg Segment) Clone() Segment {
+	if seg.Value == nil {
+		return seg
+	}
+	return Segment{Type: seg.Type, Value: append(make([]byte, 0, len(seg.Value)), seg.Value...)}
 }
 
 // Write dumps the flattened version of the field to the writer. It is just a wrapper around
@@ -200,10 +430,29 @@ func (seg Segment) Write(w io.Writer) error {
 	return WriteSegment(w, seg.Type, seg.Value)
 }
 
+// AppendTo appends the flattened version of the segment to a byte slice and returns the
+// extended slice, allowing the caller to reuse buffers
+func (seg Segment) AppendTo(dst []byte) []byte {
+
+	dst = appendSegmentHeader(dst, seg.Type, uint64(len(seg.Value)))
+	return append(dst, seg.Value...)
+}
+
+// appendSegmentHeader appends the type code and, if the type has one, the size field for a
+// segment to a byte slice
+func appendSegmentHeader(dst []byte, fieldType uint8, payloadSize uint64) []byte {
+
+	dst = append(dst, fieldType)
+	for i := int(sizeSegmentSize(fieldType)) - 1; i >= 0; i-- {
+		dst = append(dst, uint8(payloadSize>>(uint(i)*8)))
+	}
+	return dst
+}
+
 // GetDocStart retrieves the version value from a DocumentStart segment or returns an error
 func (seg Segment) GetDocStart() (uint8, error) {
 	if seg.Type != DFDocumentStart {
-		return 0, ErrTypeError
+		return 0, &TypeError{Expected: DFDocumentStart, Actual: seg.Type}
 	}
 	if len(seg.Value) != 1 {
 		return 0, ErrSize
@@ -214,20 +463,18 @@ func (seg Segment) GetDocStart() (uint8, error) {
 // GetDocEnd retrieves the version value from a DocumentEnd segment or returns an error
 func (seg Segment) GetDocEnd() (uint64, error) {
 	if seg.Type != DFDocumentEnd {
-		return 0, ErrTypeError
+		return 0, &TypeError{Expected: DFDocumentEnd, Actual: seg.Type}
 	}
-	bs := membufio.New(seg.Value)
-	var data uint64
-	if err := binary.Read(&bs, binary.BigEndian, &data); err != nil {
-		return 0, err
+	if len(seg.Value) != 8 {
+		return 0, ErrSize
 	}
-	return data, nil
+	return binary.BigEndian.Uint64(seg.Value), nil
 }
 
 // GetInt8 retrieves the value from an Int8 segment or returns an error
 func (seg Segment) GetInt8() (int8, error) {
 	if seg.Type != DFInt8Type {
-		return 0, ErrTypeError
+		return 0, &TypeError{Expected: DFInt8Type, Actual: seg.Type}
 	}
 	if len(seg.Value) != 1 {
 		return 0, ErrSize
@@ -238,7 +485,7 @@ func (seg Segment) GetInt8() (int8, error) {
 // GetUInt8 retrieves the value from a UInt8 segment or returns an error
 func (seg Segment) GetUInt8() (uint8, error) {
 	if seg.Type != DFUInt8Type {
-		return 0, ErrTypeError
+		return 0, &TypeError{Expected: DFUInt8Type, Actual: seg.Type}
 	}
 	if len(seg.Value) != 1 {
 		return 0, ErrSize
@@ -249,87 +496,75 @@ func (seg Segment) GetUInt8() (uint8, error) {
 // GetInt16 retrieves the value from an Int16 segment or returns an error
 func (seg Segment) GetInt16() (int16, error) {
 	if seg.Type != DFInt16Type {
-		return 0, ErrTypeError
+		return 0, &TypeError{Expected: DFInt16Type, Actual: seg.Type}
 	}
-	bs := membufio.New(seg.Value)
-	var data int16
-	if err := binary.Read(&bs, binary.BigEndian, &data); err != nil {
-		return 0, err
+	if len(seg.Value) != 2 {
+		return 0, ErrSize
 	}
-	return data, nil
+	return int16(binary.BigEndian.Uint16(seg.Value)), nil
 }
 
 // GetUInt16 retrieves the value from a UInt16 segment or returns an error
 func (seg Segment) GetUInt16() (uint16, error) {
 
 	if seg.Type != DFUInt16Type {
-		return 0, ErrTypeError
+		return 0, &TypeError{Expected: DFUInt16Type, Actual: seg.Type}
 	}
-	bs := membufio.New(seg.Value)
-	var data uint16
-	if err := binary.Read(&bs, binary.BigEndian, &data); err != nil {
-		return 0, err
+	if len(seg.Value) != 2 {
+		return 0, ErrSize
 	}
-	return data, nil
+	return binary.BigEndian.Uint16(seg.Value), nil
 }
 
 // GetInt32 retrieves the value from an Int32 segment or returns an error
 func (seg Segment) GetInt32() (int32, error) {
 	if seg.Type != DFInt32Type {
-		return 0, ErrTypeError
+		return 0, &TypeError{Expected: DFInt32Type, Actual: seg.Type}
 	}
-	bs := membufio.New(seg.Value)
-	var data int32
-	if err := binary.Read(&bs, binary.BigEndian, &data); err != nil {
-		return 0, err
+	if len(seg.Value) != 4 {
+		return 0, ErrSize
 	}
-	return data, nil
+	return int32(binary.BigEndian.Uint32(seg.Value)), nil
 }
 
 // GetUInt32 retrieves the value from a UInt32 segment or returns an error
 func (seg Segment) GetUInt32() (uint32, error) {
 	if seg.Type != DFUInt32Type {
-		return 0, ErrTypeError
+		return 0, &TypeError{Expected: DFUInt32Type, Actual: seg.Type}
 	}
-	bs := membufio.New(seg.Value)
-	var data uint32
-	if err := binary.Read(&bs, binary.BigEndian, &data); err != nil {
-		return 0, err
+	if len(seg.Value) != 4 {
+		return 0, ErrSize
 	}
-	return data, nil
+	return binary.BigEndian.Uint32(seg.Value), nil
 }
 
 // GetInt64 retrieves the value from an Int64 segment or returns an error
 func (seg Segment) GetInt64() (int64, error) {
 	if seg.Type != DFInt64Type {
-		return 0, ErrTypeError
+		return 0, &TypeError{Expected: DFInt64Type, Actual: seg.Type}
 	}
-	bs := membufio.New(seg.Value)
-	var data int64
-	if err := binary.Read(&bs, binary.BigEndian, &data); err != nil {
-		return 0, err
+	if len(seg.Value) != 8 {
+		return 0, ErrSize
 	}
-	return data, nil
+	return int64(binary.BigEndian.Uint64(seg.Value)), nil
 }
 
 // GetUInt64 retrieves the value from a UInt64 segment or returns an error
 func (seg Segment) GetUInt64() (uint64, error) {
 
 	if seg.Type != DFUInt64Type {
-		return 0, ErrTypeError
+		return 0, &TypeError{Expected: DFUInt64Type, Actual: seg.Type}
 	}
-	bs := membufio.New(seg.Value)
-	var data uint64
-	if err := binary.Read(&bs, binary.BigEndian, &data); err != nil {
-		return 0, err
+	if len(seg.Value) != 8 {
+		return 0, ErrSize
 	}
-	return data, nil
+	return binary.BigEndian.Uint64(seg.Value), nil
 }
 
 // GetBool retrieves the value from a Bool segment or returns an error
 func (seg Segment) GetBool() (bool, error) {
 	if seg.Type != DFBoolType {
-		return false, ErrTypeError
+		return false, &TypeError{Expected: DFBoolType, Actual: seg.Type}
 	}
 	if len(seg.Value) != 1 {
 		return false, ErrSize
@@ -340,33 +575,29 @@ func (seg Segment) GetBool() (bool, error) {
 // GetFloat32 retrieves the value from a Float32 segment or returns an error
 func (seg Segment) GetFloat32() (float32, error) {
 	if seg.Type != DFFloat32Type {
-		return 0, ErrTypeError
+		return 0, &TypeError{Expected: DFFloat32Type, Actual: seg.Type}
 	}
-	bs := membufio.New(seg.Value)
-	var data float32
-	if err := binary.Read(&bs, binary.BigEndian, &data); err != nil {
-		return 0, err
+	if len(seg.Value) != 4 {
+		return 0, ErrSize
 	}
-	return data, nil
+	return math.Float32frombits(binary.BigEndian.Uint32(seg.Value)), nil
 }
 
 // GetFloat64 retrieves the value from a Float64 segment or returns an error
 func (seg Segment) GetFloat64() (float64, error) {
 	if seg.Type != DFFloat64Type {
-		return 0, ErrTypeError
+		return 0, &TypeError{Expected: DFFloat64Type, Actual: seg.Type}
 	}
-	bs := membufio.New(seg.Value)
-	var data float64
-	if err := binary.Read(&bs, binary.BigEndian, &data); err != nil {
-		return 0, err
+	if len(seg.Value) != 8 {
+		return 0, ErrSize
 	}
-	return data, nil
+	return math.Float64frombits(binary.BigEndian.Uint64(seg.Value)), nil
 }
 
 // GetString retrieves the value from a String segment or returns an error
 func (seg Segment) GetString() (string, error) {
 	if seg.Type != DFStringType && seg.Type != DFHugeStringType {
-		return "", ErrTypeError
+		return "", &TypeError{Expected: DFStringType, Actual: seg.Type}
 	}
 	return string(seg.Value), nil
 }
@@ -374,55 +605,43 @@ func (seg Segment) GetString() (string, error) {
 // GetBinary retrieves the value from a Binary segment or returns an error
 func (seg Segment) GetBinary() ([]byte, error) {
 	if seg.Type != DFBinaryType && seg.Type != DFHugeBinaryType {
-		return nil, ErrTypeError
+		return nil, &TypeError{Expected: DFBinaryType, Actual: seg.Type}
 	}
 	return seg.Value, nil
 }
 
 // GetMapIndex retrieves size of a map from its index segment or returns an error
 func (seg Segment) GetMapIndex() (uint64, error) {
-
-	bs := membufio.New(seg.Value)
-
-	switch seg.Type {
-	case DFMapType:
-		var out uint16
-		if err := binary.Read(&bs, binary.BigEndian, &out); err != nil {
-			return 0, err
-		}
-		return uint64(out), nil
-	case DFLargeMapType:
-		var out uint64
-		if err := binary.Read(&bs, binary.BigEndian, &out); err != nil {
-			return 0, err
-		}
-		return out, nil
-	default:
-		return 0, ErrTypeError
+	if seg.Type != DFMapType && seg.Type != DFLargeMapType && seg.Type != DFKeyedMapType {
+		return 0, &TypeError{Expected: DFMapType, Actual: seg.Type}
 	}
+	return seg.getContainerIndex()
 }
 
 // GetListIndex retrieves size of a list from its index segment or returns an error
 func (seg Segment) GetListIndex() (uint64, error) {
+	if seg.Type != DFListType && seg.Type != DFLargeListType {
+		return 0, &TypeError{Expected: DFListType, Actual: seg.Type}
+	}
+	return seg.getContainerIndex()
+}
 
-	bs := membufio.New(seg.Value)
+// getContainerIndex decodes the item count held by a map or list index segment. The size of the
+// count depends on the type code, so the caller is expected to have already checked it.
+func (seg Segment) getContainerIndex() (uint64, error) {
+	if len(seg.Value) != int(fixedSegmentSize(seg.Type)) {
+		return 0, ErrSize
+	}
 
-	switch seg.Type {
-	case DFListType:
-		var out uint16
-		if err := binary.Read(&bs, binary.BigEndian, &out); err != nil {
-			return 0, err
-		}
-		return uint64(out), nil
-	case DFLargeListType:
-		var out uint64
-		if err := binary.Read(&bs, binary.BigEndian, &out); err != nil {
-			return 0, err
-		}
-		return out, nil
-	default:
-		return 0, ErrTypeError
+	switch len(seg.Value) {
+	case 2:
+		return uint64(binary.BigEndian.Uint16(seg.Value)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(seg.Value)), nil
+	case 8:
+		return binary.BigEndian.Uint64(seg.Value), nil
 	}
+	return 0, ErrSize
 }
 
 // SetDocStart sets the Segment's value and type
@@ -664,50 +883,162 @@ func (seg *Segment) SetBinary(value []byte) error {
 
 // SetMapIndex sets the Segment's value and type
 func (seg *Segment) SetMapIndex(value SegmentMap) error {
+	return seg.setContainerIndex(DFMapType, DFLargeMapType, uint64(len(value)))
+}
 
-	if len(value) > 65535 {
-		seg.Type = DFLargeMapType
+// SetListIndex sets the Segment's value and type
+func (seg *Segment) SetListIndex(value SegmentList) error {
+	return seg.setContainerIndex(DFListType, DFLargeListType, uint64(len(value)))
+}
+
+// setContainerIndex encodes the index segment for a container holding the specified number of
+// items. The large type code is used if the count won't fit into the regular one's 16 bits.
+func (seg *Segment) setContainerIndex(regularType uint8, largeType uint8, count uint64) error {
+
+	if count > 65535 {
+		seg.Type = largeType
 	} else {
-		seg.Type = DFMapType
+		seg.Type = regularType
 	}
 
-	valueLen := uint64(fixedSegmentSize(seg.Type))
-	segLen := uint64(len(seg.Value))
-	if segLen != valueLen {
+	valueLen := fixedSegmentSize(seg.Type)
+	if len(seg.Value) != int(valueLen) {
 		seg.Value = make([]byte, valueLen)
 	}
 
-	bs := membufio.New(seg.Value)
-	if seg.Type == DFLargeMapType {
-		itemCount := uint64(len(value))
-		return binary.Write(&bs, binary.BigEndian, itemCount)
+	switch valueLen {
+	case 2:
+		binary.BigEndian.PutUint16(seg.Value, uint16(count))
+	case 4:
+		if count > 0xFFFFFFFF {
+			return ErrSize
+		}
+		binary.BigEndian.PutUint32(seg.Value, uint32(count))
+	case 8:
+		binary.BigEndian.PutUint64(seg.Value, count)
+	default:
+		return ErrTypeError
 	}
-	itemCount := uint16(len(value))
-	return binary.Write(&bs, binary.BigEndian, itemCount)
+	return nil
 }
 
-// SetListIndex sets the Segment's value and type
-func (seg *Segment) SetListIndex(value SegmentList) error {
+// Set sets the Segment's value and type based on the type of the value given to it. Types which
+// implement encoding.BinaryMarshaler are stored as Binary segments and, failing that, types which
+// implement encoding.TextMarshaler are stored as String segments. ErrTypeError is returned for
+// anything else which isn't one of the basic types supported by the other setters.
+func (seg *Segment) Set(value interface{}) error {
+
+	switch v := value.(type) {
+	case int8:
+		return seg.SetInt8(v)
+	case uint8:
+		return seg.SetUInt8(v)
+	case int16:
+		return seg.SetInt16(v)
+	case uint16:
+		return seg.SetUInt16(v)
+	case int32:
+		return seg.SetInt32(v)
+	case uint32:
+		return seg.SetUInt32(v)
+	case int64:
+		return seg.SetInt64(v)
+	case uint64:
+		return seg.SetUInt64(v)
+	case bool:
+		return seg.SetBool(v)
+	case float32:
+		return seg.SetFloat32(v)
+	case float64:
+		return seg.SetFloat64(v)
+	case string:
+		return seg.SetString(v)
+	case []byte:
+		return seg.SetBinary(v)
+	case encoding.BinaryMarshaler:
+		data, err := v.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		return seg.SetBinary(data)
+	case encoding.TextMarshaler:
+		text, err := v.MarshalText()
+		if err != nil {
+			return err
+		}
+		return seg.SetString(string(text))
+	}
+	return ErrTypeError
+}
 
-	if len(value) > 65535 {
-		seg.Type = DFLargeListType
-	} else {
-		seg.Type = DFListType
+// Get is the counterpart to Set(). It stores the Segment's value in the variable pointed to by
+// target, which must be a pointer to one of the basic types supported by the other getters or
+// implement encoding.BinaryUnmarshaler or encoding.TextUnmarshaler.
+func (seg Segment) Get(target interface{}) error {
+
+	var err error
+	switch t := target.(type) {
+	case *int8:
+		*t, err = seg.GetInt8()
+	case *uint8:
+		*t, err = seg.GetUInt8()
+	case *int16:
+		*t, err = seg.GetInt16()
+	case *uint16:
+		*t, err = seg.GetUInt16()
+	case *int32:
+		*t, err = seg.GetInt32()
+	case *uint32:
+		*t, err = seg.GetUInt32()
+	case *int64:
+		*t, err = seg.GetInt64()
+	case *uint64:
+		*t, err = seg.GetUInt64()
+	case *bool:
+		*t, err = seg.GetBool()
+	case *float32:
+		*t, err = seg.GetFloat32()
+	case *float64:
+		*t, err = seg.GetFloat64()
+	case *string:
+		*t, err = seg.GetString()
+	case *[]byte:
+		var value []byte
+		value, err = seg.GetBinary()
+		if err == nil {
+			*t = append([]byte(nil), value...)
+		}
+	default:
+		return seg.unmarshal(target)
 	}
+	return err
+}
 
-	valueLen := uint64(fixedSegmentSize(seg.Type))
-	segLen := uint64(len(seg.Value))
-	if segLen != valueLen {
-		seg.Value = make([]byte, valueLen)
+// unmarshal does the work for Get() when the target implements one of the unmarshaler interfaces.
+// The interface used depends on how the value was stored by Set().
+func (seg Segment) unmarshal(target interface{}) error {
+
+	binUnmarshaler, isBinary := target.(encoding.BinaryUnmarshaler)
+	textUnmarshaler, isText := target.(encoding.TextUnmarshaler)
+
+	switch seg.Type {
+	case DFBinaryType, DFHugeBinaryType:
+		if isBinary {
+			return binUnmarshaler.UnmarshalBinary(seg.Value)
+		}
+	case DFStringType, DFHugeStringType:
+		if isText {
+			return textUnmarshaler.UnmarshalText(seg.Value)
+		}
 	}
 
-	bs := membufio.New(seg.Value)
-	if seg.Type == DFLargeListType {
-		itemCount := uint64(len(value))
-		return binary.Write(&bs, binary.BigEndian, itemCount)
+	switch {
+	case isBinary:
+		return &TypeError{Expected: DFBinaryType, Actual: seg.Type}
+	case isText:
+		return &TypeError{Expected: DFStringType, Actual: seg.Type}
 	}
-	itemCount := uint16(len(value))
-	return binary.Write(&bs, binary.BigEndian, itemCount)
+	return ErrTypeError
 }
 
 // ToString formats a Segment into a string
@@ -811,25 +1142,31 @@ func (seg Segment) ToString() string {
 			return fmt.Sprintf("Binary=%v", seg.Value)
 		}
 	case DFMapType:
-		v, err := seg.GetUInt16()
+		v, err := seg.GetMapIndex()
 		if err != nil {
 			return "Map=" + err.Error()
 		}
 		return fmt.Sprintf("Map=%v", v)
 	case DFLargeMapType:
-		v, err := seg.GetUInt64()
+		v, err := seg.GetMapIndex()
 		if err != nil {
 			return "LargeMap=" + err.Error()
 		}
 		return fmt.Sprintf("LargeMap=%v", v)
+	case DFKeyedMapType:
+		v, err := seg.GetMapIndex()
+		if err != nil {
+			return "KeyedMap=" + err.Error()
+		}
+		return fmt.Sprintf("KeyedMap=%v", v)
 	case DFListType:
-		v, err := seg.GetUInt16()
+		v, err := seg.GetListIndex()
 		if err != nil {
 			return "List=" + err.Error()
 		}
 		return fmt.Sprintf("List=%v", v)
 	case DFLargeListType:
-		v, err := seg.GetUInt64()
+		v, err := seg.GetListIndex()
 		if err != nil {
 			return "LargeList=" + err.Error()
 		}
@@ -860,6 +1197,9 @@ func FlattenSegment(fieldType uint8, fieldValue []byte) ([]byte, error) {
 	}
 
 	sizeSize := sizeSegmentSize(fieldType)
+	if sizeSize == 2 && valueLen > 65535 {
+		return nil, ErrSize
+	}
 
 	bufio := membufio.Make(valueLen + uint64(sizeSize) + 1)
 	bufio.WriteByte(fieldType)
@@ -875,56 +1215,30 @@ func FlattenSegment(fieldType uint8, fieldValue []byte) ([]byte, error) {
 	}
 	bufio.Write(fieldValue)
 
+	// The buffer is a fixed size, so anything which didn't fit would have been cut off
+	if bufio.Index != bufio.BufferLength {
+		return nil, ErrBufferTooSmall
+	}
 	return bufio.Buffer, nil
 }
 
 // WriteSegment exists so that Segments can be written to I/O without necessarily having to create
 // a Segment instance
 func WriteSegment(w io.Writer, fieldType uint8, fieldValue []byte) error {
-	payloadSize := len(fieldValue)
 
-	// Write the type code
+	// The header and payload are handed over together so that network connections can send them
+	// with a single writev call instead of one write each
+	var headerBuffer [9]byte
+	header := appendSegmentHeader(headerBuffer[:0], fieldType, uint64(len(fieldValue)))
 
-	bytesWritten, err := w.Write([]byte{byte(fieldType)})
+	bytesWritten, err := writeBuffers(w, [][]byte{header, fieldValue})
 	if err != nil {
 		return err
 	}
-	if bytesWritten != 1 {
+	if bytesWritten != int64(len(header)+len(fieldValue)) {
 		return ErrIO
 	}
 
-	// Write the size field
-
-	sizeSize := sizeSegmentSize(fieldType)
-	if sizeSize != 0 {
-		sizeWriter := membufio.Make(uint64(sizeSize))
-		switch sizeSize {
-		case 2:
-			binary.Write(&sizeWriter, binary.BigEndian, uint16(payloadSize))
-		case 4:
-			binary.Write(&sizeWriter, binary.BigEndian, uint32(payloadSize))
-		case 8:
-			binary.Write(&sizeWriter, binary.BigEndian, uint64(payloadSize))
-		}
-		bytesWritten, err = w.Write(sizeWriter.Buffer)
-		if err != nil {
-			return err
-		}
-		if bytesWritten != 2 {
-			return ErrIO
-		}
-	}
-
-	// Write the payload itself
-
-	bytesWritten, err = w.Write(fieldValue)
-	if err != nil {
-		return err
-	}
-	if bytesWritten != payloadSize {
-		return ErrNetworkError
-	}
-
 	return nil
 }
 
@@ -1011,12 +1325,49 @@ func (sm SegmentMap) Clear() {
 	}
 }
 
+// Clone returns a deep copy of the SegmentMap. None of the copy's values share memory with the
+// original.
+func (sm SegmentMap) Clone() SegmentMap {
+	if sm == nil {
+		return nil
+	}
+	out := make(SegmentMap, len(sm))
+	for k, v := range sm {
+		out[k] = v.Clone()
+	}
+	return out
+}
+
 // Read attempts to read a string-Segment map from a byte buffer. Note that this call will overwrite
 // existing keys with new data
 func (sm SegmentMap) Read(r io.Reader) error {
+	return sm.ReadLimited(r, DecodeLimits{})
+}
 
-	var countSegment Segment
-	err := countSegment.Read(r)
+// ReadLimited is the same as Read(), but the data read must stay within the limits given to it.
+// Bytes charged to the limits' MemoryBudget stay charged unless reading fails.
+func (sm SegmentMap) ReadLimited(r io.Reader, limits DecodeLimits) error {
+
+	d := NewDecoder(r)
+	d.Limits = limits
+	return sm.Decode(d)
+}
+
+// Decode reads the map from a Decoder. This makes all of the Decoder's options, such as its
+// limits and key interning, available when reading a map.
+func (sm SegmentMap) Decode(d *Decoder) error {
+	charged := d.charged
+	if err := sm.decode(d); err != nil {
+		d.Limits.Budget.Release(d.charged - charged)
+		return err
+	}
+	return nil
+}
+
+// decode does the work for Decode()
+func (sm SegmentMap) decode(d *Decoder) error {
+
+	countSegment, err := d.Next()
 	if err != nil {
 		return err
 	}
@@ -1028,10 +1379,12 @@ func (sm SegmentMap) Read(r io.Reader) error {
 	if pairCount == 0 {
 		return nil
 	}
+	if d.Limits.MaxSegmentCount > 0 && pairCount > d.Limits.MaxSegmentCount/2 {
+		return ErrLimitExceeded
+	}
 
-	var keySegment Segment
 	for i := uint64(0); i < pairCount; i++ {
-		err = keySegment.Read(r)
+		keySegment, err := d.Next()
 		if err != nil {
 			return err
 		}
@@ -1039,13 +1392,12 @@ func (sm SegmentMap) Read(r io.Reader) error {
 			return ErrInvalidKey
 		}
 
-		var valueSegment Segment
-		err = valueSegment.Read(r)
+		valueSegment, err := d.Next()
 		if err != nil {
 			return err
 		}
 
-		sm[string(keySegment.Value)] = valueSegment
+		sm[d.key(keySegment.Value)] = valueSegment
 	}
 
 	return nil
@@ -1055,15 +1407,10 @@ func (sm SegmentMap) Read(r io.Reader) error {
 func (sm SegmentMap) Write(w io.Writer) error {
 
 	var countSegment Segment
-	if uint64(len(sm)) > 65535 {
-		countSegment.SetUInt64(uint64(len(sm)))
-		countSegment.Type = DFLargeMapType
-	} else {
-		countSegment.SetUInt16(uint16(len(sm)))
-		countSegment.Type = DFMapType
+	if err := countSegment.SetMapIndex(sm); err != nil {
+		return err
 	}
-	err := countSegment.Write(w)
-	if err != nil {
+	if err := countSegment.Write(w); err != nil {
 		return err
 	}
 
@@ -1087,15 +1434,297 @@ func (sm SegmentMap) Write(w io.Writer) error {
 
 // GetSize returns the size of the buffer needed to contain all flattened elements
 func (sm SegmentMap) GetSize() uint64 {
-	var out uint64
-	if len(sm) == 0 {
-		return 3
+	out := indexWireSize(DFMapType, DFLargeMapType, uint64(len(sm)))
+	for k, v := range sm {
+		out += stringWireSize(uint64(len(k))) + v.GetSize()
 	}
+	return out
+}
 
-	for k, v := range sm {
-		out += 3 + uint64(len(k)) + v.GetSize()
+// Has returns true if the SegmentMap contains the specified key
+func (sm SegmentMap) Has(key string) bool {
+	_, ok := sm[key]
+	return ok
+}
+
+// Keys returns the SegmentMap's keys in sorted order
+func (sm SegmentMap) Keys() []string {
+	out := make([]string, 0, len(sm))
+	for k := range sm {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Delete removes the specified key from the SegmentMap. It returns ErrNotFound if the key doesn't
+// exist.
+func (sm SegmentMap) Delete(key string) error {
+	if _, ok := sm[key]; !ok {
+		return ErrNotFound
+	}
+	delete(sm, key)
+	return nil
+}
+
+// GetInt8 retrieves the value of an Int8 segment from the SegmentMap or returns an error
+func (sm SegmentMap) GetInt8(key string) (int8, error) {
+	seg, ok := sm[key]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	value, err := seg.GetInt8()
+	return value, withKey(err, key)
+}
+
+// SetInt8 adds an Int8 segment to the SegmentMap. If the key exists, the value is replaced.
+func (sm SegmentMap) SetInt8(key string, value int8) error {
+	var seg Segment
+	if err := seg.SetInt8(value); err != nil {
+		return err
+	}
+	sm[key] = seg
+	return nil
+}
+
+// GetUInt8 retrieves the value of a UInt8 segment from the SegmentMap or returns an error
+func (sm SegmentMap) GetUInt8(key string) (uint8, error) {
+	seg, ok := sm[key]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	value, err := seg.GetUInt8()
+	return value, withKey(err, key)
+}
+
+// SetUInt8 adds a UInt8 segment to the SegmentMap. If the key exists, the value is replaced.
+func (sm SegmentMap) SetUInt8(key string, value uint8) error {
+	var seg Segment
+	if err := seg.SetUInt8(value); err != nil {
+		return err
+	}
+	sm[key] = seg
+	return nil
+}
+
+// GetInt16 retrieves the value of an Int16 segment from the SegmentMap or returns an error
+func (sm SegmentMap) GetInt16(key string) (int16, error) {
+	seg, ok := sm[key]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	value, err := seg.GetInt16()
+	return value, withKey(err, key)
+}
+
+// SetInt16 adds an Int16 segment to the SegmentMap. If the key exists, the value is replaced.
+func (sm SegmentMap) SetInt16(key string, value int16) error {
+	var seg Segment
+	if err := seg.SetInt16(value); err != nil {
+		return err
+	}
+	sm[key] = seg
+	return nil
+}
+
+// GetUInt16 retrieves the value of a UInt16 segment from the SegmentMap or returns an error
+func (sm SegmentMap) GetUInt16(key string) (uint16, error) {
+	seg, ok := sm[key]
+	if !ok {
+		return 0, ErrNotFound
 	}
-	return out + 3
+	value, err := seg.GetUInt16()
+	return value, withKey(err, key)
+}
+
+// SetUInt16 adds a UInt16 segment to the SegmentMap. If the key exists, the value is replaced.
+func (sm SegmentMap) SetUInt16(key string, value uint16) error {
+	var seg Segment
+	if err := seg.SetUInt16(value); err != nil {
+		return err
+	}
+	sm[key] = seg
+	return nil
+}
+
+// GetInt32 retrieves the value of an Int32 segment from the SegmentMap or returns an error
+func (sm SegmentMap) GetInt32(key string) (int32, error) {
+	seg, ok := sm[key]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	value, err := seg.GetInt32()
+	return value, withKey(err, key)
+}
+
+// SetInt32 adds an Int32 segment to the SegmentMap. If the key exists, the value is replaced.
+func (sm SegmentMap) SetInt32(key string, value int32) error {
+	var seg Segment
+	if err := seg.SetInt32(value); err != nil {
+		return err
+	}
+	sm[key] = seg
+	return nil
+}
+
+// GetUInt32 retrieves the value of a UInt32 segment from the SegmentMap or returns an error
+func (sm SegmentMap) GetUInt32(key string) (uint32, error) {
+	seg, ok := sm[key]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	value, err := seg.GetUInt32()
+	return value, withKey(err, key)
+}
+
+// SetUInt32 adds a UInt32 segment to the SegmentMap. If the key exists, the value is replaced.
+func (sm SegmentMap) SetUInt32(key string, value uint32) error {
+	var seg Segment
+	if err := seg.SetUInt32(value); err != nil {
+		return err
+	}
+	sm[key] = seg
+	return nil
+}
+
+// GetInt64 retrieves the value of an Int64 segment from the SegmentMap or returns an error
+func (sm SegmentMap) GetInt64(key string) (int64, error) {
+	seg, ok := sm[key]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	value, err := seg.GetInt64()
+	return value, withKey(err, key)
+}
+
+// SetInt64 adds an Int64 segment to the SegmentMap. If the key exists, the value is replaced.
+func (sm SegmentMap) SetInt64(key string, value int64) error {
+	var seg Segment
+	if err := seg.SetInt64(value); err != nil {
+		return err
+	}
+	sm[key] = seg
+	return nil
+}
+
+// GetUInt64 retrieves the value of a UInt64 segment from the SegmentMap or returns an error
+func (sm SegmentMap) GetUInt64(key string) (uint64, error) {
+	seg, ok := sm[key]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	value, err := seg.GetUInt64()
+	return value, withKey(err, key)
+}
+
+// SetUInt64 adds a UInt64 segment to the SegmentMap. If the key exists, the value is replaced.
+func (sm SegmentMap) SetUInt64(key string, value uint64) error {
+	var seg Segment
+	if err := seg.SetUInt64(value); err != nil {
+		return err
+	}
+	sm[key] = seg
+	return nil
+}
+
+// GetBool retrieves the value of a Bool segment from the SegmentMap or returns an error
+func (sm SegmentMap) GetBool(key string) (bool, error) {
+	seg, ok := sm[key]
+	if !ok {
+		return false, ErrNotFound
+	}
+	value, err := seg.GetBool()
+	return value, withKey(err, key)
+}
+
+// SetBool adds a Bool segment to the SegmentMap. If the key exists, the value is replaced.
+func (sm SegmentMap) SetBool(key string, value bool) error {
+	var seg Segment
+	if err := seg.SetBool(value); err != nil {
+		return err
+	}
+	sm[key] = seg
+	return nil
+}
+
+// GetFloat32 retrieves the value of a Float32 segment from the SegmentMap or returns an error
+func (sm SegmentMap) GetFloat32(key string) (float32, error) {
+	seg, ok := sm[key]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	value, err := seg.GetFloat32()
+	return value, withKey(err, key)
+}
+
+// SetFloat32 adds a Float32 segment to the SegmentMap. If the key exists, the value is replaced.
+func (sm SegmentMap) SetFloat32(key string, value float32) error {
+	var seg Segment
+	if err := seg.SetFloat32(value); err != nil {
+		return err
+	}
+	sm[key] = seg
+	return nil
+}
+
+// GetFloat64 retrieves the value of a Float64 segment from the SegmentMap or returns an error
+func (sm SegmentMap) GetFloat64(key string) (float64, error) {
+	seg, ok := sm[key]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	value, err := seg.GetFloat64()
+	return value, withKey(err, key)
+}
+
+// SetFloat64 adds a Float64 segment to the SegmentMap. If the key exists, the value is replaced.
+func (sm SegmentMap) SetFloat64(key string, value float64) error {
+	var seg Segment
+	if err := seg.SetFloat64(value); err != nil {
+		return err
+	}
+	sm[key] = seg
+	return nil
+}
+
+// GetString retrieves the value of a String segment from the SegmentMap or returns an error
+func (sm SegmentMap) GetString(key string) (string, error) {
+	seg, ok := sm[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	value, err := seg.GetString()
+	return value, withKey(err, key)
+}
+
+// SetString adds a String segment to the SegmentMap. If the key exists, the value is replaced.
+func (sm SegmentMap) SetString(key string, value string) error {
+	var seg Segment
+	if err := seg.SetString(value); err != nil {
+		return err
+	}
+	sm[key] = seg
+	return nil
+}
+
+// GetBinary retrieves the value of a Binary segment from the SegmentMap or returns an error
+func (sm SegmentMap) GetBinary(key string) ([]byte, error) {
+	seg, ok := sm[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	value, err := seg.GetBinary()
+	return value, withKey(err, key)
+}
+
+// SetBinary adds a Binary segment to the SegmentMap. If the key exists, the value is replaced.
+func (sm SegmentMap) SetBinary(key string, value []byte) error {
+	var seg Segment
+	if err := seg.SetBinary(value); err != nil {
+		return err
+	}
+	sm[key] = seg
+	return nil
 }
 
 // Clear empties the SegmentList instance
@@ -1104,17 +1733,26 @@ func (sl *SegmentList) Clear() SegmentList {
 	return *sl
 }
 
-// GetSize returns the size of the buffer needed to contain all flattened elements
-func (sl SegmentList) GetSize() uint64 {
-	var out uint64
-	if len(sl) == 0 {
-		return 3
+// Clone returns a deep copy of the SegmentList. None of the copy's items share memory with the
+// original.
+func (sl SegmentList) Clone() SegmentList {
+	if sl == nil {
+		return nil
 	}
+	out := make(SegmentList, len(sl))
+	for i, item := range sl {
+		out[i] = item.Clone()
+	}
+	return out
+}
 
+// GetSize returns the size of the buffer needed to contain all flattened elements
+func (sl SegmentList) GetSize() uint64 {
+	out := indexWireSize(DFListType, DFLargeListType, uint64(len(sl)))
 	for _, item := range sl {
 		out += item.GetSize()
 	}
-	return out + 3
+	return out
 }
 
 // Read attempts to read a SegmentList from a byte buffer. Note that this call will append the
@@ -1129,25 +1767,10 @@ func (sl *SegmentList) Read(p []byte) error {
 		return err
 	}
 
-	// Like SegmentMap::Read(), we're going to hack around using using Get() because of language
-	// limitations. :(
-	var itemCount uint64
-	countReader := membufio.New(countSegment.Value)
-	switch countSegment.Type {
-	case DFListType:
-		var tempInt uint16
-		err = binary.Read(&countReader, binary.BigEndian, &tempInt)
-		if err != nil {
-			return err
-		}
-		itemCount = uint64(tempInt)
-	case DFLargeListType:
-		err = binary.Read(&countReader, binary.BigEndian, &itemCount)
-		if err != nil {
-			return err
-		}
+	itemCount, err := countSegment.GetListIndex()
+	if err != nil {
+		return err
 	}
-
 	if itemCount == 0 {
 		return nil
 	}
@@ -1169,15 +1792,10 @@ func (sl *SegmentList) Read(p []byte) error {
 func (sl SegmentList) Write(w io.Writer) error {
 
 	var countSegment Segment
-	if uint64(len(sl)) > 65535 {
-		countSegment.SetUInt64(uint64(len(sl)))
-		countSegment.Type = DFLargeListType
-	} else {
-		countSegment.SetUInt16(uint16(len(sl)))
-		countSegment.Type = DFListType
+	if err := countSegment.SetListIndex(sl); err != nil {
+		return err
 	}
-	err := countSegment.Write(w)
-	if err != nil {
+	if err := countSegment.Write(w); err != nil {
 		return err
 	}
 
@@ -1,8 +1,15 @@
 package oganesson
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/darkwyrm/oganesson/membufio"
 )
@@ -108,6 +115,29 @@ func TestFlatUnflatSegment(t *testing.T) {
 	}
 }
 
+func TestTruncatedSizeField(t *testing.T) {
+
+	doc := NewDocument()
+	doc.AttachBinary("data", []byte("abc"))
+	data, err := doc.Flatten()
+	if err != nil {
+		t.Fatalf("Flatten failed: %s", err.Error())
+	}
+
+	// Cut the stream right after the Binary segment's type byte and partway into its size field
+	typeIndex := bytes.IndexByte(data[2:], DFBinaryType) + 2
+	for _, cut := range []int{typeIndex + 1, typeIndex + 2} {
+		var readDoc Document
+		if err := readDoc.Unflatten(data[:cut]); err != ErrSegmentSize {
+			t.Fatalf("Segment cut at byte %d wasn't reported as truncated: %v", cut, err)
+		}
+		var seg Segment
+		if err := seg.Read(bytes.NewReader(data[typeIndex:cut])); err != ErrSegmentSize {
+			t.Fatalf("Segment.Read of a cut size field returned %v", err)
+		}
+	}
+}
+
 func TestCountSegments(t *testing.T) {
 	buffer := []byte("\x0e\x00\x03ABC\x0e\x00\x03DEF\x0e\x00\x03GHI")
 	fieldCount, err := CountSegments(buffer)
@@ -432,3 +462,616 @@ func TestGetSet(t *testing.T) {
 	}
 
 }
+
+func TestSegmentMapGetSet(t *testing.T) {
+	sm := make(SegmentMap)
+
+	if err := sm.SetInt64("testInt", 42); err != nil {
+		t.Fatalf("SegmentMap.SetInt64 failed: %s", err.Error())
+	}
+	testInt, err := sm.GetInt64("testInt")
+	if err != nil {
+		t.Fatalf("SegmentMap.GetInt64 failed: %s", err.Error())
+	}
+	if testInt != 42 {
+		t.Fatalf("SegmentMap.GetInt64 value failure: wanted 42, got %v", testInt)
+	}
+
+	if err := sm.SetString("testString", "abcdef"); err != nil {
+		t.Fatalf("SegmentMap.SetString failed: %s", err.Error())
+	}
+	testString, err := sm.GetString("testString")
+	if err != nil {
+		t.Fatalf("SegmentMap.GetString failed: %s", err.Error())
+	}
+	if testString != "abcdef" {
+		t.Fatalf("SegmentMap.GetString value failure: wanted 'abcdef', got '%s'", testString)
+	}
+
+	_, err = sm.GetString("testInt")
+	if !errors.Is(err, ErrTypeError) {
+		t.Fatalf("SegmentMap.GetString didn't catch a type mismatch")
+	}
+	if err.Error() != "expected String, got Int64 for key 'testInt'" {
+		t.Fatalf("SegmentMap.GetString type error message mismatch: %s", err.Error())
+	}
+	if _, err := sm.GetString("missing"); err != ErrNotFound {
+		t.Fatalf("SegmentMap.GetString didn't catch a missing key")
+	}
+
+	keys := sm.Keys()
+	if len(keys) != 2 || keys[0] != "testInt" || keys[1] != "testString" {
+		t.Fatalf("SegmentMap.Keys mismatch: got %v", keys)
+	}
+
+	if err := sm.Delete("testInt"); err != nil {
+		t.Fatalf("SegmentMap.Delete failed: %s", err.Error())
+	}
+	if sm.Has("testInt") {
+		t.Fatalf("SegmentMap.Delete didn't remove the key")
+	}
+	if err := sm.Delete("testInt"); err != ErrNotFound {
+		t.Fatalf("SegmentMap.Delete didn't catch a missing key")
+	}
+}
+
+func largeContainerRoundTrip(t *testing.T, countSize int) {
+
+	itemCount := 70000
+
+	sm := make(SegmentMap, itemCount)
+	for i := 0; i < itemCount; i++ {
+		sm.SetUInt32(fmt.Sprintf("key%d", i), uint32(i))
+	}
+
+	var buffer bytes.Buffer
+	if err := sm.Write(&buffer); err != nil {
+		t.Fatalf("Error writing large map: %s", err.Error())
+	}
+	if buffer.Bytes()[0] != DFLargeMapType {
+		t.Fatalf("Large map written with type code %d", buffer.Bytes()[0])
+	}
+	countSeg, err := UnflattenSegment(buffer.Bytes()[:countSize+1])
+	if err != nil {
+		t.Fatalf("Error unflattening large map index: %s", err.Error())
+	}
+	if len(countSeg.Value) != countSize {
+		t.Fatalf("Large map index size mismatch: wanted %d, got %d", countSize,
+			len(countSeg.Value))
+	}
+
+	readMap := make(SegmentMap)
+	if err := readMap.Read(&buffer); err != nil {
+		t.Fatalf("Error reading large map: %s", err.Error())
+	}
+	if len(readMap) != itemCount {
+		t.Fatalf("Large map read %d items, expected %d", len(readMap), itemCount)
+	}
+	testValue, err := readMap.GetUInt32("key69999")
+	if err != nil {
+		t.Fatalf("Error getting value from large map: %s", err.Error())
+	}
+	if testValue != 69999 {
+		t.Fatalf("Large map value mismatch: wanted 69999, got %d", testValue)
+	}
+
+	sl := make(SegmentList, itemCount)
+	for i := range sl {
+		sl[i].SetUInt32(uint32(i))
+	}
+
+	buffer.Reset()
+	if err := sl.Write(&buffer); err != nil {
+		t.Fatalf("Error writing large list: %s", err.Error())
+	}
+	if buffer.Bytes()[0] != DFLargeListType {
+		t.Fatalf("Large list written with type code %d", buffer.Bytes()[0])
+	}
+
+	readList := make(SegmentList, 0)
+	if err := readList.Read(buffer.Bytes()); err != nil {
+		t.Fatalf("Error reading large list: %s", err.Error())
+	}
+	if len(readList) != itemCount {
+		t.Fatalf("Large list read %d items, expected %d", len(readList), itemCount)
+	}
+	testValue, err = readList[itemCount-1].GetUInt32()
+	if err != nil {
+		t.Fatalf("Error getting value from large list: %s", err.Error())
+	}
+	if testValue != uint32(itemCount-1) {
+		t.Fatalf("Large list value mismatch: wanted %d, got %d", itemCount-1, testValue)
+	}
+}
+
+func TestLargeContainers(t *testing.T) {
+	largeContainerRoundTrip(t, 4)
+}
+
+func TestWideLargeContainers(t *testing.T) {
+
+	sm := make(SegmentMap, 70000)
+	for i := 0; i < 70000; i++ {
+		sm.SetUInt32(fmt.Sprintf("key%d", i), uint32(i))
+	}
+	items, err := keyedItems(sm)
+	if err != nil {
+		t.Fatalf("Error building keyed document: %s", err.Error())
+	}
+	doc := &Document{Items: items}
+
+	for _, profile := range []EncodingProfile{BigEndianProfile, LittleEndianProfile} {
+		var buffer bytes.Buffer
+		e := NewEncoder(&buffer)
+		e.Profile = profile
+		e.WideLargeContainers = true
+		if err := e.EncodeDocument(doc); err != nil {
+			t.Fatalf("Error encoding wide %s document: %s", profile, err.Error())
+		}
+		data := buffer.Bytes()
+		if data[2] != DFLargeMapType || len(data) != int(doc.GetSize())+4 {
+			t.Fatalf("Wide %s map index written wrong", profile)
+		}
+
+		var out Document
+		d := NewDecoder(bytes.NewReader(data))
+		d.Profile = profile
+		d.WideLargeContainers = true
+		if err := out.Decode(context.Background(), d); err != nil {
+			t.Fatalf("Error decoding wide %s document: %s", profile, err.Error())
+		}
+		if count, err := out.Items[0].(*Segment).GetMapIndex(); err != nil || count != 70000 ||
+			len(out.Items) != len(doc.Items) {
+			t.Fatalf("Wide %s document round trip mismatch", profile)
+		}
+
+		// Data written with wide counts can't be read as narrow ones
+		d = NewDecoder(bytes.NewReader(data))
+		d.Profile = profile
+		if err := out.Decode(context.Background(), d); err == nil {
+			t.Fatalf("Narrow decoder read wide %s data", profile)
+		}
+	}
+
+	// Counts which don't fit in 32 bits can't be held in memory
+	wire := append([]byte{DFLargeListType, 0, 0, 0, 1}, 0, 0, 0, 0)
+	d := NewDecoder(bytes.NewReader(wire))
+	d.WideLargeContainers = true
+	if _, err := d.Next(); err != ErrSize {
+		t.Fatalf("Oversized wide count wasn't caught: %v", err)
+	}
+}
+
+// textID implements only encoding.TextMarshaler and encoding.TextUnmarshaler
+type textID struct {
+	value string
+}
+
+func (id textID) MarshalText() ([]byte, error) {
+	return []byte("id:" + id.value), nil
+}
+
+func (id *textID) UnmarshalText(text []byte) error {
+	id.value = strings.TrimPrefix(string(text), "id:")
+	return nil
+}
+
+func TestGenericGetSet(t *testing.T) {
+	var seg Segment
+
+	if err := seg.Set(int32(-5)); err != nil {
+		t.Fatalf("Set failed for int32: %s", err.Error())
+	}
+	var i32 int32
+	if err := seg.Get(&i32); err != nil || i32 != -5 {
+		t.Fatalf("Get failed for int32")
+	}
+	var s string
+	if err := seg.Get(&s); !errors.Is(err, ErrTypeError) {
+		t.Fatalf("Get didn't catch a type mismatch")
+	}
+
+	// time.Time implements both marshaler interfaces, so the binary one should be used
+	now := time.Now().UTC()
+	if err := seg.Set(now); err != nil {
+		t.Fatalf("Set failed for time.Time: %s", err.Error())
+	}
+	if seg.Type != DFBinaryType {
+		t.Fatalf("Set used type %s for time.Time", TypeName(seg.Type))
+	}
+	var ts time.Time
+	if err := seg.Get(&ts); err != nil {
+		t.Fatalf("Get failed for time.Time: %s", err.Error())
+	}
+	if !ts.Equal(now) {
+		t.Fatalf("Get time.Time mismatch: %v vs %v", ts, now)
+	}
+
+	if err := seg.Set(textID{"abc"}); err != nil {
+		t.Fatalf("Set failed for TextMarshaler: %s", err.Error())
+	}
+	if seg.Type != DFStringType || string(seg.Value) != "id:abc" {
+		t.Fatalf("Set TextMarshaler mismatch: %s", seg.ToString())
+	}
+	var id textID
+	if err := seg.Get(&id); err != nil || id.value != "abc" {
+		t.Fatalf("Get failed for TextUnmarshaler")
+	}
+
+	if err := seg.Set(struct{}{}); err != ErrTypeError {
+		t.Fatalf("Set didn't reject an unsupported type")
+	}
+}
+
+func TestSegmentAppendTo(t *testing.T) {
+	segments := []Segment{
+		{DFInt16Type, []byte{1, 2}},
+		{DFStringType, []byte("abc")},
+		{DFHugeBinaryType, []byte{9}},
+	}
+
+	buf := make([]byte, 0, 64)
+	for _, seg := range segments {
+		var flat bytes.Buffer
+		if err := seg.Write(&flat); err != nil {
+			t.Fatalf("Error writing %s: %s", seg.ToString(), err.Error())
+		}
+		buf = seg.AppendTo(buf[:0])
+		if !bytes.Equal(buf, flat.Bytes()) {
+			t.Fatalf("AppendTo mismatch for %s: %v vs %v", seg.ToString(), buf, flat.Bytes())
+		}
+	}
+
+	doc := NewDocument()
+	doc.AttachString("testString", "abcdef")
+	doc.AttachInt64("testInt", 42)
+	flat, err := doc.Flatten()
+	if err != nil {
+		t.Fatalf("Error flattening document: %s", err.Error())
+	}
+
+	buf = make([]byte, 128)
+	n, err := doc.FlattenTo(buf)
+	if err != nil {
+		t.Fatalf("FlattenTo failed: %s", err.Error())
+	}
+	if !bytes.Equal(buf[:n], flat) {
+		t.Fatalf("FlattenTo output mismatch")
+	}
+	if _, err := doc.FlattenTo(buf[:n-1]); err != ErrBufferTooSmall {
+		t.Fatalf("FlattenTo didn't reject a small buffer")
+	}
+}
+
+func TestFlattenMiscalculatedSize(t *testing.T) {
+
+	// Fixed-size segments are sized by their type, so values of the wrong length throw off
+	// GetSize() in both directions
+	for _, bad := range []Segment{{DFInt8Type, []byte{1, 2, 3}}, {DFInt64Type, []byte{1}}} {
+		doc := NewDocument()
+		doc.AttachString("testString", "abcdef")
+		doc.Items = append(doc.Items, &Segment{bad.Type, bad.Value})
+
+		buf := make([]byte, doc.GetSize())
+		if _, err := doc.FlattenTo(buf); err != ErrBufferTooSmall && err != ErrSize {
+			t.Fatalf("FlattenTo didn't catch the miscalculated size: %v", err)
+		}
+		if _, err := doc.Flatten(); err != ErrBufferTooSmall && err != ErrSize {
+			t.Fatalf("Flatten didn't return an error for the miscalculated size: %v", err)
+		}
+		if _, err := EncodeBatch([]*Document{doc}, 1); err == nil {
+			t.Fatalf("EncodeBatch didn't return an error for the miscalculated size")
+		}
+	}
+
+	if _, err := FlattenSegment(DFStringType, make([]byte, 65536)); err != ErrSize {
+		t.Fatalf("FlattenSegment accepted a value too large for its size field: %v", err)
+	}
+}
+
+func TestDocumentSize(t *testing.T) {
+	doc := NewDocument()
+	doc.AttachString("testString", "abcdef")
+	doc.AttachInt64("testInt", 42)
+
+	// 2 + (1 + 2 + 6) + (1 + 8) + 9
+	if doc.GetSize() != 29 {
+		t.Fatalf("GetSize mismatch after attaching: %d", doc.GetSize())
+	}
+
+	doc.Items = append(doc.Items, &Segment{DFBoolType, []byte{1}})
+	if doc.GetSize() != 31 {
+		t.Fatalf("GetSize mismatch after appending directly: %d", doc.GetSize())
+	}
+
+	doc.Items = []SegContainer{&Segment{DFUInt8Type, []byte{1}}}
+	if doc.GetSize() != 13 {
+		t.Fatalf("GetSize mismatch after replacing Items: %d", doc.GetSize())
+	}
+
+	// Changing a Segment in place is picked up, too
+	doc.AttachString("testString", "abc")
+	doc.Items[1].(*Segment).SetString(strings.Repeat("a", 38))
+	if doc.GetSize() != 2+2+41+9 {
+		t.Fatalf("GetSize mismatch after changing an item in place: %d", doc.GetSize())
+	}
+	flat, err := doc.Flatten()
+	if err != nil || uint64(len(flat)) != doc.GetSize() {
+		t.Fatalf("Flatten didn't match GetSize after changing an item in place: %v", err)
+	}
+
+	doc.Freeze()
+	if doc.GetSize() != 2+2+41+9 {
+		t.Fatalf("GetSize mismatch after Freeze: %d", doc.GetSize())
+	}
+}
+
+func TestClone(t *testing.T) {
+	var seg Segment
+	seg.SetString("original")
+	clone := seg.Clone()
+	clone.Value[0] = 'O'
+	if string(seg.Value) != "original" {
+		t.Fatalf("Segment.Clone shares its value with the original")
+	}
+
+	sm := make(SegmentMap)
+	sm.SetString("key", "value")
+	smClone := sm.Clone()
+	smClone["key"].Value[0] = 'V'
+	if v, _ := sm.GetString("key"); v != "value" {
+		t.Fatalf("SegmentMap.Clone shares values with the original")
+	}
+	if SegmentMap(nil).Clone() != nil {
+		t.Fatalf("SegmentMap.Clone of a nil map isn't nil")
+	}
+
+	sl := SegmentList{seg}
+	slClone := sl.Clone()
+	slClone[0].Value[0] = 'O'
+	if string(sl[0].Value) != "original" {
+		t.Fatalf("SegmentList.Clone shares items with the original")
+	}
+
+	doc := NewDocument()
+	doc.AttachString("password", "secret")
+	doc.MarkSensitive("password")
+	doc.Freeze()
+	docClone, err := doc.Clone()
+	if err != nil {
+		t.Fatalf("Document.Clone failed: %s", err.Error())
+	}
+	if docClone.IsFrozen() || !docClone.IsSensitive("password") {
+		t.Fatalf("Document.Clone didn't copy the document's flags correctly")
+	}
+	docClone.Items[0].(*Segment).Value[0] = 'S'
+	if string(doc.Items[0].(*Segment).Value) != "secret" {
+		t.Fatalf("Document.Clone shares items with the original")
+	}
+
+	// Other containers are copied as the one segment they write, and can't write more
+	multi := multiContainer{{DFStringType, []byte("a")}, {DFStringType, []byte("b")}}
+	doc = &Document{Items: []SegContainer{&multi[0], multi[:1]}}
+	if docClone, err = doc.Clone(); err != nil || len(docClone.Items) != 2 {
+		t.Fatalf("Document.Clone failed for a single-segment container: %v", err)
+	}
+	doc = &Document{Items: []SegContainer{multi}}
+	if _, err := doc.Clone(); !errors.Is(err, ErrSize) {
+		t.Fatalf("Document.Clone truncated a multiple-segment container: %v", err)
+	}
+}
+
+// multiContainer is a SegContainer which writes all of its segments
+type multiContainer []Segment
+
+func (mc multiContainer) GetType() uint8 {
+	return mc[0].Type
+}
+
+func (mc multiContainer) GetSize() uint64 {
+	var out uint64
+	for _, seg := range mc {
+		out += seg.GetSize()
+	}
+	return out
+}
+
+func (mc multiContainer) Read(r io.Reader) error {
+	return ErrNotFound
+}
+
+func (mc multiContainer) Write(w io.Writer) error {
+	for _, seg := range mc {
+		if err := seg.Write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestDocumentView(t *testing.T) {
+	fields := make(SegmentMap)
+	fields.SetString("password", "secret")
+	items, err := keyedItems(fields)
+	if err != nil {
+		t.Fatalf("Error building keyed document: %s", err.Error())
+	}
+	doc := &Document{Items: items}
+	doc.MarkSensitive("password")
+
+	view := doc.View()
+	if view.Items[0] != doc.Items[0] {
+		t.Fatalf("Document.View copied the items")
+	}
+
+	// Changing the view gives it its own copy
+	view.AttachInt64("count", 1)
+	view.MarkSensitive("count")
+	if len(doc.Items) != 3 || doc.IsSensitive("count") {
+		t.Fatalf("Change to a view was seen by the original")
+	}
+	if view.Items[0] == doc.Items[0] || len(view.Items) != 5 {
+		t.Fatalf("View didn't copy its items when changed")
+	}
+
+	// So does changing the original
+	other := doc.View()
+	if err := doc.Rename("password", "passcode"); err != nil {
+		t.Fatalf("Error renaming field in the original: %s", err.Error())
+	}
+	if v, _ := other.Query("password"); v != "secret" {
+		t.Fatalf("Changing the original changed a view")
+	}
+	if v, _ := doc.Query("passcode"); v != "secret" {
+		t.Fatalf("Rename didn't change the original")
+	}
+	if other.GetSize() != doc.GetSize() {
+		t.Fatalf("View size mismatch: %d != %d", other.GetSize(), doc.GetSize())
+	}
+}
+
+func TestGetterSizes(t *testing.T) {
+	for _, typeCode := range []uint8{DFInt16Type, DFUInt32Type, DFInt64Type, DFFloat32Type,
+		DFFloat64Type, DFDocumentEnd} {
+		seg := Segment{typeCode, []byte{1}}
+		if _, err := segmentValue(seg); typeCode != DFDocumentEnd && err != ErrSize {
+			t.Fatalf("Short %s value wasn't caught: %v", TypeName(typeCode), err)
+		}
+	}
+	if _, err := (Segment{DFDocumentEnd, []byte{1}}).GetDocEnd(); err != ErrSize {
+		t.Fatalf("Short DocumentEnd value wasn't caught: %v", err)
+	}
+
+	var seg Segment
+	seg.SetFloat64(-2.5)
+	if v, err := seg.GetFloat64(); err != nil || v != -2.5 {
+		t.Fatalf("Float64 round trip mismatch: %v, %v", v, err)
+	}
+	seg.SetInt32(-70000)
+	if v, err := seg.GetInt32(); err != nil || v != -70000 {
+		t.Fatalf("Int32 round trip mismatch: %v, %v", v, err)
+	}
+}
+
+func BenchmarkGetInt64(b *testing.B) {
+	var seg Segment
+	seg.SetInt64(-1234567890)
+	for i := 0; i < b.N; i++ {
+		if _, err := seg.GetInt64(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetFloat64(b *testing.B) {
+	var seg Segment
+	seg.SetFloat64(3.14159)
+	for i := 0; i < b.N; i++ {
+		if _, err := seg.GetFloat64(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeNumbers(b *testing.B) {
+	fields := make(SegmentMap)
+	for i := 0; i < 16; i++ {
+		fields.SetInt32(fmt.Sprintf("int%d", i), int32(i))
+		fields.SetFloat64(fmt.Sprintf("float%d", i), float64(i)/3)
+	}
+	var buf bytes.Buffer
+	fields.Write(&buf)
+	data := buf.Bytes()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		out := make(SegmentMap)
+		if err := out.Read(bytes.NewReader(data)); err != nil {
+			b.Fatal(err)
+		}
+		for _, seg := range out {
+			if _, err := segmentValue(seg); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestWireSize(t *testing.T) {
+
+	// Every fixed and sized type, including the sizes right at the String/HugeString boundary
+	segs := make([]Segment, 0)
+	for _, set := range []func(*Segment) error{
+		func(s *Segment) error { return s.SetInt8(-1) },
+		func(s *Segment) error { return s.SetUInt16(2) },
+		func(s *Segment) error { return s.SetInt32(-3) },
+		func(s *Segment) error { return s.SetUInt64(4) },
+		func(s *Segment) error { return s.SetBool(true) },
+		func(s *Segment) error { return s.SetFloat32(5) },
+		func(s *Segment) error { return s.SetFloat64(6) },
+		func(s *Segment) error { return s.SetString("") },
+		func(s *Segment) error { return s.SetString(strings.Repeat("a", 65535)) },
+		func(s *Segment) error { return s.SetString(strings.Repeat("a", 65536)) },
+		func(s *Segment) error { return s.SetBinary(make([]byte, 70000)) },
+		func(s *Segment) error { return s.SetDocEnd(7) },
+	} {
+		var seg Segment
+		if err := set(&seg); err != nil {
+			t.Fatalf("Error setting segment: %s", err.Error())
+		}
+		segs = append(segs, seg)
+	}
+	for _, seg := range segs {
+		if size := uint64(len(seg.AppendTo(nil))); seg.GetSize() != size {
+			t.Fatalf("%s size mismatch: %d vs %d", TypeName(seg.Type), seg.GetSize(), size)
+		}
+	}
+
+	check := func(name string, item interface {
+		GetSize() uint64
+		Write(w io.Writer) error
+	}) {
+		var buf bytes.Buffer
+		if err := item.Write(&buf); err != nil {
+			t.Fatalf("Error writing %s: %s", name, err.Error())
+		}
+		if item.GetSize() != uint64(buf.Len()) {
+			t.Fatalf("%s size mismatch: %d vs %d", name, item.GetSize(), buf.Len())
+		}
+	}
+
+	check("empty map", SegmentMap{})
+	check("empty list", SegmentList{})
+	sm := SegmentMap{"short": segs[0], strings.Repeat("k", 65536): segs[1]}
+	check("map with a huge key", sm)
+	check("list", SegmentList(segs))
+
+	large := make(SegmentMap, 70000)
+	list := make(SegmentList, 70000)
+	for i := range list {
+		list[i] = segs[i%7]
+		large[strconv.Itoa(i)] = list[i]
+	}
+	check("large map", large)
+	check("large list", list)
+
+	keyed := make(SegmentMapOf[uint32], 70000)
+	for i := uint32(0); i < 70000; i++ {
+		keyed[i] = segs[0]
+	}
+	check("keyed map", keyed)
+
+	items, err := keyedItems(large)
+	if err != nil {
+		t.Fatalf("Error building keyed document: %s", err.Error())
+	}
+	doc := &Document{Items: append(items, &segs[9])}
+	data, err := doc.Flatten()
+	if err != nil {
+		t.Fatalf("Error flattening document: %s", err.Error())
+	}
+	if doc.GetSize() != uint64(len(data)) {
+		t.Fatalf("Document size mismatch: %d vs %d", doc.GetSize(), len(data))
+	}
+}
@@ -0,0 +1,138 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"net"
+	"time"
+)
+
+// DefaultSendQueueSize is the number of packets Send() can queue when a session's SendQueueSize
+// is zero
+const DefaultSendQueueSize = 64
+
+// Send queues a Document to be sent by the session's writer goroutine, which is started by the
+// first call. Any number of goroutines can call it at once without locking of their own, and
+// Documents queued by one goroutine are sent in the order it queued them. The session's Outbound
+// interceptors are run and the Document is flattened before Send returns, so errors from them
+// are returned right away and the Document may be changed or reused afterward. Documents which
+// expire (see SetTTL()) while waiting in the queue are dropped instead of being sent.
+//
+// Send blocks while the queue is full. If the writer fails to send a packet, that error is
+// returned by every call after it and nothing else is sent. Close() sends everything which has
+// already been queued before closing the connection.
+func (s *PacketSession) Send(doc *Document) error {
+
+	if err := runInterceptors(s.Outbound, doc); err != nil {
+		return s.wrapError(err)
+	}
+	packet, err := s.flattenDocument(doc)
+	if err != nil {
+		return s.wrapError(err)
+	}
+
+	// The writer is never started once the session has been closed
+	s.senderOnce.Do(s.startSender)
+	if s.sendQueue == nil {
+		return s.wrapError(net.ErrClosed)
+	}
+	if err := s.senderError(); err != nil {
+		return err
+	}
+
+	select {
+	case <-s.sendStop:
+		return s.wrapError(net.ErrClosed)
+	default:
+	}
+	select {
+	case s.sendQueue <- queuedSend{packet: packet, expires: doc.expiry()}:
+		return nil
+	case <-s.sendStop:
+		return s.wrapError(net.ErrClosed)
+	}
+}
+
+// startSender starts the goroutine which writes the packets queued by Send()
+func (s *PacketSession) startSender() {
+
+	size := s.SendQueueSize
+	if size <= 0 {
+		size = DefaultSendQueueSize
+	}
+	s.sendQueue = make(chan queuedSend, size)
+	s.sendStop = make(chan struct{})
+	s.senderDone = make(chan struct{})
+	go s.runSender()
+}
+
+// queuedSend is a packet waiting to be written by the writer goroutine along with the expiry time
+// of the Document it holds, if any
+type queuedSend struct {
+	packet  []byte
+	expires time.Time
+}
+
+// runSender writes queued packets until the session is closed. Once a write fails, the rest of
+// the queue is thrown away so that callers of Send() aren't left blocked on a full queue.
+func (s *PacketSession) runSender() {
+
+	defer close(s.senderDone)
+	send := func(queued queuedSend) {
+		if s.senderError() != nil {
+			return
+		}
+		if !queued.expires.IsZero() && time.Now().After(queued.expires) {
+			return
+		}
+		if err := s.Write(queued.packet); err != nil {
+			s.senderLock.Lock()
+			s.sendErr = err
+			s.senderLock.Unlock()
+		}
+	}
+
+	for {
+		select {
+		case queued := <-s.sendQueue:
+			send(queued)
+		case <-s.sendStop:
+			for {
+				select {
+				case queued := <-s.sendQueue:
+					send(queued)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// senderError returns the error which stopped the writer goroutine, if any
+func (s *PacketSession) senderError() error {
+	s.senderLock.Lock()
+	defer s.senderLock.Unlock()
+	return s.sendErr
+}
+
+// stopSender makes the writer goroutine send what is left in its queue and waits for it to
+// finish. It does nothing if Send() has never been called.
+func (s *PacketSession) stopSender() {
+
+	started := true
+	s.senderOnce.Do(func() {
+		started = false
+	})
+	if !started {
+		return
+	}
+
+	s.senderLock.Lock()
+	if !s.senderStopped {
+		s.senderStopped = true
+		close(s.sendStop)
+	}
+	s.senderLock.Unlock()
+	<-s.senderDone
+}
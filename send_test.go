@@ -0,0 +1,134 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSend(t *testing.T) {
+	requester, responder := sessionPair(t, nil)
+
+	const senders, perSender = 8, 25
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			doc := NewDocument()
+			for j := 0; j < perSender; j++ {
+				doc.Items = doc.Items[:0]
+				doc.AttachString("id", fmt.Sprintf("%d:%d", i, j))
+				if err := requester.Send(doc); err != nil {
+					t.Errorf("Error queueing document: %s", err.Error())
+					return
+				}
+			}
+		}(i)
+	}
+
+	// Each sender's Documents arrive in the order it sent them
+	next := make(map[int]int)
+	for n := 0; n < senders*perSender; n++ {
+		doc, err := responder.ReadDocument()
+		if err != nil {
+			t.Fatalf("Error reading sent document: %s", err.Error())
+		}
+		id, _ := doc.Items[0].(*Segment).GetString()
+		var i, j int
+		fmt.Sscanf(id, "%d:%d", &i, &j)
+		if next[i] != j {
+			t.Fatalf("Document %s arrived out of order", id)
+		}
+		next[i]++
+	}
+	wg.Wait()
+}
+
+func TestSendClose(t *testing.T) {
+	requester, responder := sessionPair(t, nil)
+
+	// Everything queued before Close() is still sent
+	received := make(chan int)
+	go func() {
+		count := 0
+		for {
+			if _, err := responder.Read(); err != nil {
+				received <- count
+				return
+			}
+			count++
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		doc := NewDocument()
+		doc.AttachInt32("index", int32(i))
+		if err := requester.Send(doc); err != nil {
+			t.Fatalf("Error queueing document: %s", err.Error())
+		}
+	}
+	requester.Close()
+	if count := <-received; count != 10 {
+		t.Fatalf("Only %d of 10 queued documents were sent", count)
+	}
+
+	if err := requester.Send(NewDocument()); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Send after Close() returned %v", err)
+	}
+
+	// A session which never used Send() can be closed and then refuses it
+	requester, _ = sessionPair(t, nil)
+	requester.Close()
+	if err := requester.Send(NewDocument()); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Send on a closed session returned %v", err)
+	}
+}
+
+func TestSendWriteError(t *testing.T) {
+	requester, responder := sessionPair(t, nil)
+	responder.Connection.Close()
+
+	// The failed write stops the writer, and the error comes back from a later call
+	var err error
+	for i := 0; i < 1000 && err == nil; i++ {
+		err = requester.Send(NewDocument())
+	}
+	if err == nil {
+		t.Fatalf("Write error wasn't returned by Send()")
+	}
+	requester.Close()
+}
+
+func TestSendExpired(t *testing.T) {
+	requester, responder := sessionPair(t, nil)
+
+	// Documents which expire while queued are dropped
+	stale := testMessage(t, "ECHO", "n", int32(1))
+	stale.SetTTL(time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if err := requester.Send(stale); err != nil {
+		t.Fatalf("Error queueing document: %s", err.Error())
+	}
+	fresh := testMessage(t, "ECHO", "n", int32(2))
+	fresh.SetTTL(time.Minute)
+	requester.Send(fresh)
+
+	doc, err := responder.ReadDocument()
+	if err != nil {
+		t.Fatalf("Error reading sent document: %s", err.Error())
+	}
+	fields, _ := doc.fieldMap()
+	if n, _ := fields.GetInt32("n"); n != 2 {
+		t.Fatalf("Expired document was sent")
+	}
+
+	if _, err := requester.SendRequest(context.Background(), stale); !errors.Is(err, ErrExpired) {
+		t.Fatalf("Expired request returned %v", err)
+	}
+}
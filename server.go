@@ -0,0 +1,294 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrServerClosed = errors.New("server closed")
+
+// SessionServer takes care of the work needed to accept PacketSessions from clients: it listens
+// for connections, sets up a session for each one in its own goroutine, and passes each Document
+// received to its Router. Handlers and middleware must be registered before Serve() is called.
+type SessionServer struct {
+	// Router dispatches the Documents received by the server
+	Router *Router
+
+	// BufferSize is passed to NewPacketResponder() for each session. DefaultBufferSize is used if
+	// it is zero.
+	BufferSize uint16
+
+	// MaxConnections limits the number of sessions open at once. Connections past the limit are
+	// closed right away. Zero means no limit.
+	MaxConnections int
+
+	// IdleTimeout is how long a session may go without receiving a message before it is closed.
+	// If it is zero, the session's usual ReadTimeout is used.
+	IdleTimeout time.Duration
+
+	// Configure, if set, is called for each new session before setup so that options such as
+	// Limits or an Authenticator can be applied.
+	Configure func(s *PacketSession)
+
+	// SessionRate limits how fast each session may send messages, and AddressRate limits all of
+	// the sessions from the same IP address together. OnRateLimit, if set, is called for each
+	// message which goes over either one. The limits must be set before Serve() is called.
+	SessionRate RateLimit
+	AddressRate RateLimit
+	OnRateLimit func(event RateLimitEvent)
+
+	lock         sync.Mutex
+	listeners    map[net.Listener]bool
+	sessions     map[*PacketSession]bool
+	shuttingDown bool
+	wg           sync.WaitGroup
+
+	// addressLimiters holds the rate limiter for each address with open sessions
+	addressLimiters map[string]*addressLimiter
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewSessionServer creates a new SessionServer with an empty Router
+func NewSessionServer() *SessionServer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &SessionServer{
+		Router:    NewRouter(),
+		listeners: make(map[net.Listener]bool),
+		sessions:  make(map[*PacketSession]bool),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+// Handle registers the Handler for a message code with the server's Router
+func (srv *SessionServer) Handle(code string, h Handler) {
+	srv.Router.Handle(code, h)
+}
+
+// ListenAndServe listens on the specified TCP address and then calls Serve()
+func (srv *SessionServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return srv.Serve(l)
+}
+
+// Serve accepts connections from the listener until Shutdown() is called, at which point it
+// returns ErrServerClosed. The listener is closed when Serve() returns.
+func (srv *SessionServer) Serve(l net.Listener) error {
+
+	if srv.bufferSize() < 1024 {
+		l.Close()
+		return ErrSize
+	}
+	if !srv.trackListener(l, true) {
+		l.Close()
+		return ErrServerClosed
+	}
+	defer srv.trackListener(l, false)
+	defer l.Close()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if srv.isShuttingDown() {
+				return ErrServerClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		session := NewPacketResponder(conn, srv.bufferSize())
+		if !srv.trackSession(session, false) {
+			conn.Close()
+			continue
+		}
+
+		srv.wg.Add(1)
+		go srv.serveSession(session)
+	}
+}
+
+// Shutdown stops the server gracefully. It stops accepting connections, closes sessions which
+// are waiting for a message, and waits for the rest to finish the message they are handling. If
+// the context ends first, the remaining sessions are closed and the context's error is returned.
+func (srv *SessionServer) Shutdown(ctx context.Context) error {
+
+	srv.lock.Lock()
+	srv.shuttingDown = true
+	for l := range srv.listeners {
+		l.Close()
+	}
+	for session, busy := range srv.sessions {
+		if busy {
+			session.setState(StateDraining, nil)
+		} else {
+			closeServerSession(session)
+		}
+	}
+	srv.lock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		srv.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	srv.cancel()
+	srv.lock.Lock()
+	for session := range srv.sessions {
+		closeServerSession(session)
+	}
+	srv.lock.Unlock()
+	<-done
+	return ctx.Err()
+}
+
+// serveSession sets up a session and handles its messages until it is closed
+func (srv *SessionServer) serveSession(session *PacketSession) {
+
+	defer srv.wg.Done()
+	defer srv.removeSession(session)
+	defer session.Close()
+
+	if srv.Configure != nil {
+		srv.Configure(session)
+	}
+	if srv.IdleTimeout > 0 {
+		session.ReadTimeout = srv.IdleTimeout
+	}
+	if err := session.InitResponder(); err != nil {
+		logWarning("session setup with %s failed: %s", session.peer(), err.Error())
+		return
+	}
+
+	address := peerAddress(session)
+	sessionLimiter := newRateLimiter(srv.SessionRate)
+	addressLimiter := srv.acquireAddressLimiter(address)
+	defer srv.releaseAddressLimiter(address)
+
+	for {
+		doc, err := session.ReadDocument()
+		if err != nil {
+			return
+		}
+		handle, open := srv.admit(session, address, sessionLimiter, addressLimiter, doc)
+		if !open {
+			return
+		}
+		if !handle {
+			continue
+		}
+		if !srv.trackSession(session, true) {
+			return
+		}
+
+		if err := srv.dispatch(session, doc); err != nil {
+			logWarning("closing session %s: %s", session.ID(), err.Error())
+			return
+		}
+
+		if !srv.trackSession(session, false) {
+			return
+		}
+	}
+}
+
+// dispatch passes a Document to the Router and sends back the reply
+func (srv *SessionServer) dispatch(session *PacketSession, doc *Document) error {
+
+	ctx := context.WithValue(srv.ctx, sessionContextKey{}, session)
+	reply, err := srv.Router.ServeDocument(ctx, doc)
+	if err != nil || reply == nil {
+		return err
+	}
+	return session.WriteDocument(markReply(doc, reply))
+}
+
+// SessionFromContext returns the session which received the Document being handled, such as for
+// checking its HandshakeInfo() in an authentication middleware. It returns nil if the context
+// didn't come from a SessionServer.
+func SessionFromContext(ctx context.Context) *PacketSession {
+	s, _ := ctx.Value(sessionContextKey{}).(*PacketSession)
+	return s
+}
+
+// trackSession records a session as busy handling a message or waiting for one. It returns
+// false if the server is shutting down or, for new sessions, has too many connections.
+func (srv *SessionServer) trackSession(session *PacketSession, busy bool) bool {
+
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	if srv.shuttingDown {
+		return false
+	}
+	if _, ok := srv.sessions[session]; !ok && srv.MaxConnections > 0 &&
+		len(srv.sessions) >= srv.MaxConnections {
+		logWarning("refusing connection from %s: connection limit reached", session.peer())
+		return false
+	}
+	srv.sessions[session] = busy
+	return true
+}
+
+// closeServerSession closes a session from outside of the goroutine serving it. The session's
+// Close() method isn't used because it flushes the write buffer, which the session's own
+// goroutine could be using.
+func closeServerSession(session *PacketSession) {
+	session.setClosed(CloseLocal, nil)
+	session.Connection.Close()
+}
+
+func (srv *SessionServer) removeSession(session *PacketSession) {
+	srv.lock.Lock()
+	delete(srv.sessions, session)
+	srv.lock.Unlock()
+}
+
+// trackListener adds or removes a listener from the set closed by Shutdown()
+func (srv *SessionServer) trackListener(l net.Listener, add bool) bool {
+
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	if !add {
+		delete(srv.listeners, l)
+		return true
+	}
+	if srv.shuttingDown {
+		return false
+	}
+	srv.listeners[l] = true
+	return true
+}
+
+func (srv *SessionServer) isShuttingDown() bool {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	return srv.shuttingDown
+}
+
+func (srv *SessionServer) bufferSize() uint16 {
+	if srv.BufferSize == 0 {
+		return DefaultBufferSize
+	}
+	return srv.BufferSize
+}
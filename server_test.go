@@ -0,0 +1,95 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// testServerClient connects a new session to a test server
+func testServerClient(t *testing.T, addr string) *PacketSession {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Error connecting to test server: %s", err.Error())
+	}
+	s := NewPacketRequester(conn)
+	if err := s.InitRequester(); err != nil {
+		t.Fatalf("Requester init failed: %s", err.Error())
+	}
+	return s
+}
+
+func TestSessionServer(t *testing.T) {
+	srv := NewSessionServer()
+	srv.MaxConnections = 1
+	srv.Handle("ECHO", func(ctx context.Context, doc *Document) (*Document, error) {
+		return doc, nil
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error setting up listener: %s", err.Error())
+	}
+	serveErr := make(chan error)
+	go func() {
+		serveErr <- srv.Serve(listener)
+	}()
+
+	client := testServerClient(t, listener.Addr().String())
+	if err := client.WriteDocument(testMessage(t, "ECHO", "value", "hello")); err != nil {
+		t.Fatalf("Error sending message: %s", err.Error())
+	}
+	reply, err := client.ReadDocument()
+	if err != nil {
+		t.Fatalf("Error reading reply: %s", err.Error())
+	}
+	fields, _ := reply.fieldMap()
+	if value, _ := fields.GetString("value"); value != "hello" {
+		t.Fatalf("Reply mismatch: %s", value)
+	}
+
+	// The second connection is past the limit, so it's closed without being set up
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Error connecting to test server: %s", err.Error())
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 4)); err == nil {
+		t.Fatalf("Connection past the limit wasn't closed")
+	}
+	conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %s", err.Error())
+	}
+	if err := <-serveErr; !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("Serve returned the wrong error after shutdown: %v", err)
+	}
+	if _, err := client.Read(); err == nil {
+		t.Fatalf("Idle session wasn't closed by Shutdown")
+	}
+}
+
+func TestSessionServerIdleTimeout(t *testing.T) {
+	srv := NewSessionServer()
+	srv.IdleTimeout = 100 * time.Millisecond
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error setting up listener: %s", err.Error())
+	}
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+
+	client := testServerClient(t, listener.Addr().String())
+	client.ReadTimeout = 5 * time.Second
+	if _, err := client.Read(); err == nil {
+		t.Fatalf("Idle session wasn't closed by the server")
+	}
+}
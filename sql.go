@@ -0,0 +1,31 @@
+package oganesson
+
+import (
+	"database/sql/driver"
+)
+
+// Value implements driver.Valuer so that a Document can be stored in a database BLOB column as its
+// flattened bytes
+func (doc Document) Value() (driver.Value, error) {
+	return doc.Flatten()
+}
+
+// Scan implements sql.Scanner so that a Document can be read back from a column written using
+// Value(). A NULL column produces an empty Document.
+func (doc *Document) Scan(src interface{}) error {
+
+	if doc.frozen {
+		return ErrFrozen
+	}
+	switch v := src.(type) {
+	case nil:
+		doc.Items = make([]SegContainer, 0)
+		doc.updateWireMsgFields()
+		return nil
+	case []byte:
+		return doc.Unflatten(v)
+	case string:
+		return doc.Unflatten([]byte(v))
+	}
+	return ErrTypeError
+}
@@ -0,0 +1,41 @@
+package oganesson
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+)
+
+// Make sure that Document satisfies the database interfaces
+var _ driver.Valuer = Document{}
+var _ sql.Scanner = &Document{}
+
+func TestDocumentSQL(t *testing.T) {
+	doc := NewDocument()
+	doc.AttachString("testString", "abcdef")
+	doc.AttachInt64("testInt", 42)
+
+	value, err := doc.Value()
+	if err != nil {
+		t.Fatalf("Document.Value failed: %s", err.Error())
+	}
+	data, ok := value.([]byte)
+	if !ok {
+		t.Fatalf("Document.Value returned %T instead of []byte", value)
+	}
+
+	var out Document
+	if err := out.Scan(data); err != nil {
+		t.Fatalf("Document.Scan failed: %s", err.Error())
+	}
+	if len(out.Items) != 2 {
+		t.Fatalf("Document.Scan item count mismatch: %d", len(out.Items))
+	}
+
+	if err := out.Scan(nil); err != nil || len(out.Items) != 0 {
+		t.Fatalf("Document.Scan failed for NULL")
+	}
+	if err := out.Scan(int64(1)); err != ErrTypeError {
+		t.Fatalf("Document.Scan accepted an unsupported type")
+	}
+}
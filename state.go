@@ -0,0 +1,128 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"time"
+)
+
+// StateEventBufferSize is the number of state changes which can wait in the channel returned by
+// StateChanges(). Changes which happen while the channel is full are dropped.
+var StateEventBufferSize = 16
+
+// SessionState is a stage in the life of a PacketSession
+type SessionState int
+
+const (
+	// StateInit means setup hasn't started yet
+	StateInit SessionState = iota
+
+	// StateHandshaking means InitRequester() or InitResponder() is running
+	StateHandshaking
+
+	// StateReady means setup has finished and the session can be used
+	StateReady
+
+	// StateDraining means the session is finishing its outstanding work before closing, either
+	// because Close() is sending the write buffer or because its SessionServer is shutting down
+	StateDraining
+
+	// StateClosed means the session can no longer be used. CloseReason() says why.
+	StateClosed
+)
+
+func (st SessionState) String() string {
+	switch st {
+	case StateInit:
+		return "init"
+	case StateHandshaking:
+		return "handshaking"
+	case StateReady:
+		return "ready"
+	case StateDraining:
+		return "draining"
+	case StateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// StateEvent describes a change in a session's state
+type StateEvent struct {
+	From SessionState
+	To   SessionState
+
+	// Reason and Err are the session's CloseReason() and LastError() when it moves to
+	// StateClosed, such as when setup fails or the connection breaks
+	Reason CloseReason
+	Err    error
+}
+
+// State returns the session's current state
+func (s *PacketSession) State() SessionState {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	return s.state
+}
+
+// StateChanges returns a channel which receives the session's state changes from then on, so that
+// supervisory code can react to setup failures and closures without polling for errors. The
+// channel is closed after the session reaches StateClosed. Every call returns the same channel.
+func (s *PacketSession) StateChanges() <-chan StateEvent {
+
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	if s.stateChanges == nil {
+		s.stateChanges = make(chan StateEvent, StateEventBufferSize)
+		if s.state == StateClosed {
+			close(s.stateChanges)
+		}
+	}
+	return s.stateChanges
+}
+
+// setState moves the session to a new state and reports the change. Nothing leaves StateClosed.
+func (s *PacketSession) setState(to SessionState, err error) {
+
+	var event StateEvent
+	if to == StateClosed {
+		event.Reason = s.CloseReason()
+	}
+
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	if s.state == to || s.state == StateClosed {
+		return
+	}
+	event.From, event.To, event.Err = s.state, to, err
+	s.state = to
+
+	if s.stateChanges == nil {
+		return
+	}
+	select {
+	case s.stateChanges <- event:
+	default:
+		logWarning("state change channel for session %s is full, dropping %s event", s.id,
+			to)
+	}
+	if to == StateClosed {
+		close(s.stateChanges)
+	}
+}
+
+// finishSetup records the outcome of InitRequester() or InitResponder()
+func (s *PacketSession) finishSetup(err error) {
+
+	s.noteError(err)
+	if err != nil {
+		s.setClosed(CloseSetupFailed, err)
+		return
+	}
+
+	// From here on, each read and write sets its own deadline
+	s.Connection.SetDeadline(time.Time{})
+	s.setState(StateReady, nil)
+}
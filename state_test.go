@@ -0,0 +1,86 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+// checkStates reads events from a state change channel and compares their destinations
+func checkStates(t *testing.T, events <-chan StateEvent, states ...SessionState) []StateEvent {
+
+	out := make([]StateEvent, 0, len(states))
+	for _, state := range states {
+		event, ok := <-events
+		if !ok {
+			t.Fatalf("State change channel closed before %s", state)
+		}
+		if event.To != state {
+			t.Fatalf("State mismatch: wanted %s, got %s", state, event.To)
+		}
+		out = append(out, event)
+	}
+	return out
+}
+
+func TestSessionStates(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	requester := NewPacketRequester(clientConn)
+	responder := NewPacketResponder(serverConn, 4096)
+	reqEvents := requester.StateChanges()
+	respEvents := responder.StateChanges()
+	if requester.State() != StateInit {
+		t.Fatalf("New session isn't in StateInit")
+	}
+
+	errChan := make(chan error)
+	go func() {
+		errChan <- requester.InitRequester()
+	}()
+	if err := responder.InitResponder(); err != nil {
+		t.Fatalf("Responder init failed: %s", err.Error())
+	}
+	if err := <-errChan; err != nil {
+		t.Fatalf("Requester init failed: %s", err.Error())
+	}
+	checkStates(t, reqEvents, StateHandshaking, StateReady)
+	checkStates(t, respEvents, StateHandshaking, StateReady)
+
+	requester.Close()
+	events := checkStates(t, reqEvents, StateDraining, StateClosed)
+	if events[1].From != StateDraining || events[1].Reason != CloseLocal {
+		t.Fatalf("Close event mismatch: %+v", events[1])
+	}
+	if _, ok := <-reqEvents; ok {
+		t.Fatalf("State change channel wasn't closed")
+	}
+
+	responder.Read()
+	events = checkStates(t, respEvents, StateClosed)
+	if events[0].Reason != ClosePeer || events[0].Err == nil {
+		t.Fatalf("Peer close event mismatch: %+v", events[0])
+	}
+	if responder.State() != StateClosed {
+		t.Fatalf("Session isn't in StateClosed")
+	}
+}
+
+func TestSetupFailureState(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	requester := NewPacketRequester(clientConn)
+	requester.SequenceNumbers = true
+	responder := NewPacketResponder(serverConn, 4096)
+	events := responder.StateChanges()
+
+	go requester.InitRequester()
+	if err := responder.InitResponder(); err == nil {
+		t.Fatalf("Mismatched setup succeeded")
+	}
+
+	failed := checkStates(t, events, StateHandshaking, StateClosed)[1]
+	if failed.Reason != CloseSetupFailed || !errors.Is(failed.Err, ErrSessionSetup) {
+		t.Fatalf("Setup failure event mismatch: %+v", failed)
+	}
+}
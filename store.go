@@ -0,0 +1,100 @@
+package oganesson
+
+import (
+	"sync"
+)
+
+// MessageStore holds the packets sent with PacketSession.Deliver() until the other side
+// acknowledges them. Sharing one MessageStore between the sessions made by each reconnect lets
+// the packets which weren't acknowledged before a connection was lost be sent again over the next
+// one, and a FileMessageStore keeps them across restarts of the process as well.
+// Implementations must be safe for concurrent use.
+type MessageStore interface {
+	// Put saves a packet under the message ID of the Document it holds
+	Put(id string, packet []byte) error
+
+	// MarkAcked drops the packet with the specified message ID once it has been acknowledged.
+	// Marking an ID which isn't there is not an error.
+	MarkAcked(id string) error
+
+	// PendingIter calls fn for each packet which hasn't been acknowledged, in the order they were
+	// saved, stopping at the first error fn returns. fn may call the store's other methods.
+	PendingIter(fn func(id string, packet []byte) error) error
+}
+
+// MemoryMessageStore is a MessageStore which keeps its packets in memory, so they are lost if the
+// process exits
+type MemoryMessageStore struct {
+	lock    sync.Mutex
+	packets map[string][]byte
+	order   []string
+}
+
+// NewMemoryMessageStore creates an empty MemoryMessageStore
+func NewMemoryMessageStore() *MemoryMessageStore {
+	return &MemoryMessageStore{packets: make(map[string][]byte)}
+}
+
+// Put saves a packet. Saving a packet under an ID which is already in use replaces the packet
+// but keeps its place in line.
+func (m *MemoryMessageStore) Put(id string, packet []byte) error {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, exists := m.packets[id]; !exists {
+		m.order = append(m.order, id)
+	}
+	m.packets[id] = packet
+	return nil
+}
+
+// MarkAcked drops the packet with the specified ID
+func (m *MemoryMessageStore) MarkAcked(id string) error {
+	m.remove(id)
+	return nil
+}
+
+// remove drops a packet and reports whether it was there
+func (m *MemoryMessageStore) remove(id string) bool {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, exists := m.packets[id]; !exists {
+		return false
+	}
+	delete(m.packets, id)
+	for i, queued := range m.order {
+		if queued == id {
+			m.order = append(m.order[:i], m.order[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// PendingIter calls fn for each packet waiting for acknowledgement, oldest first. The packets are
+// gathered before fn is first called, so changes made while iterating aren't seen.
+func (m *MemoryMessageStore) PendingIter(fn func(id string, packet []byte) error) error {
+
+	m.lock.Lock()
+	ids := append([]string(nil), m.order...)
+	packets := make([][]byte, len(ids))
+	for i, id := range ids {
+		packets[i] = m.packets[id]
+	}
+	m.lock.Unlock()
+
+	for i, id := range ids {
+		if err := fn(id, packets[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Len returns the number of packets waiting for acknowledgement
+func (m *MemoryMessageStore) Len() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return len(m.order)
+}
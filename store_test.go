@@ -0,0 +1,44 @@
+package oganesson
+
+import (
+	"testing"
+)
+
+// pendingIDs returns the IDs of the packets waiting in a MessageStore
+func pendingIDs(t *testing.T, store MessageStore) []string {
+	var out []string
+	err := store.PendingIter(func(id string, packet []byte) error {
+		out = append(out, id)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("PendingIter failed: %s", err.Error())
+	}
+	return out
+}
+
+func TestMemoryMessageStore(t *testing.T) {
+	m := NewMemoryMessageStore()
+	m.Put("a", []byte{1})
+	m.Put("b", []byte{2})
+	m.Put("c", []byte{3})
+	m.Put("a", []byte{4})
+	m.MarkAcked("b")
+	m.MarkAcked("missing")
+
+	ids := pendingIDs(t, m)
+	if len(ids) != 2 || ids[0] != "a" || ids[1] != "c" {
+		t.Fatalf("Wrong pending packets: %v", ids)
+	}
+
+	// Packets can be acknowledged while iterating
+	m.PendingIter(func(id string, packet []byte) error {
+		if id == "a" && packet[0] != 4 {
+			t.Fatalf("Packet wasn't replaced")
+		}
+		return m.MarkAcked(id)
+	})
+	if m.Len() != 0 {
+		t.Fatalf("Packets weren't acknowledged while iterating")
+	}
+}
@@ -0,0 +1,218 @@
+package oganesson
+
+import (
+	"bytes"
+	"io"
+	"math"
+)
+
+// This file contains the DocumentStream API, which handles a series of Documents sent back-to-back
+// over a single stream, such as a file or a socket. The DocumentStart and DocumentEnd segments of
+// each Document are used as delimiters, so no extra framing is needed.
+
+// DocumentStreamReader reads a series of Documents from an io.Reader. If a corrupt Document is
+// found in the stream, Read() returns an error for it and skips ahead to the next DocumentStart
+// segment, so one bad Document doesn't spoil the rest of the stream.
+type DocumentStreamReader struct {
+	// MaxDocumentSize is the largest Document, in bytes, the reader will accept. Larger Documents
+	// are treated as corrupt. It is zero, i.e. unlimited, by default.
+	MaxDocumentSize uint64
+
+	// OnAnomaly, if set, is called each time the reader has to resynchronize. Peer is passed
+	// along in the Anomaly to identify the source of the stream.
+	OnAnomaly AnomalyHook
+	Peer      string
+
+	r       io.Reader
+	buffer  []byte
+	err     error
+	resyncs int
+}
+
+// NewDocumentStreamReader creates a DocumentStreamReader which reads from the specified Reader
+func NewDocumentStreamReader(r io.Reader) *DocumentStreamReader {
+	return &DocumentStreamReader{r: r, buffer: make([]byte, 0)}
+}
+
+// Read returns the next Document in the stream. io.EOF is returned when the stream ends cleanly
+// and io.ErrUnexpectedEOF if it ends partway through a Document. Other I/O errors are returned
+// as-is. Any other error means the Document was corrupt and the stream has been resynchronized,
+// so Read() can be called again to get the next one.
+func (ds *DocumentStreamReader) Read() (*Document, error) {
+
+	if err := ds.fill(1); err != nil {
+		if err == io.EOF && len(ds.buffer) == 0 {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+
+	end, err := ds.scanDocument()
+	if err != nil {
+		if err == io.EOF {
+			ds.buffer = ds.buffer[:0]
+			return nil, io.ErrUnexpectedEOF
+		}
+		if ds.err != nil && err == ds.err {
+			return nil, err
+		}
+		ds.resync(err)
+		return nil, err
+	}
+
+	dv, err := ViewDocument(ds.buffer[:end])
+	if err != nil {
+		ds.resync(err)
+		return nil, err
+	}
+
+	// Materialize copies all of the data, so the buffer can safely be reused after this
+	out, err := dv.Materialize()
+	ds.buffer = ds.buffer[end:]
+	ds.resyncs = 0
+	return out, err
+}
+
+// scanDocument makes sure that the entire Document at the beginning of the buffer has been read
+// and returns its size in bytes.
+func (ds *DocumentStreamReader) scanDocument() (int, error) {
+
+	if ds.buffer[0] != DFDocumentStart {
+		return 0, ErrInvalidMsg
+	}
+
+	offset := 0
+	for {
+		seg, end, err := ds.nextSegment(offset)
+		if err != nil {
+			return 0, err
+		}
+		if ds.MaxDocumentSize > 0 && uint64(end) > ds.MaxDocumentSize {
+			return 0, ErrSize
+		}
+
+		switch seg.Type {
+		case DFDocumentStart:
+			// A DocumentStart anywhere but the beginning means the previous Document was cut
+			// short
+			if offset != 0 {
+				return 0, ErrInvalidMsg
+			}
+		case DFDocumentEnd:
+			return end, nil
+		}
+		offset = end
+	}
+}
+
+// nextSegment reads in the segment which starts at the specified offset in the buffer
+func (ds *DocumentStreamReader) nextSegment(offset int) (Segment, int, error) {
+
+	if err := ds.fill(offset + 1); err != nil {
+		return Segment{}, offset, err
+	}
+
+	typeCode := ds.buffer[offset]
+	if !isTypeCodeValid(typeCode) {
+		return Segment{}, offset, ErrInvalidSegment
+	}
+
+	headerSize := offset + 1 + int(sizeSegmentSize(typeCode))
+	if err := ds.fill(headerSize); err != nil {
+		return Segment{}, offset, err
+	}
+
+	// viewSegment() will complain about the size of the segment until all of it has been read in,
+	// so keep reading until it's happy or we run out of data
+	for {
+		seg, end, err := viewSegment(ds.buffer, offset)
+		if err != ErrSegmentSize {
+			return seg, end, err
+		}
+
+		payloadSize, err := ds.payloadSize(offset)
+		if err != nil {
+			return Segment{}, offset, err
+		}
+		if payloadSize > uint64(math.MaxInt-headerSize) ||
+			(ds.MaxDocumentSize > 0 && payloadSize > ds.MaxDocumentSize) {
+			return Segment{}, offset, ErrSize
+		}
+		if err := ds.fill(headerSize + int(payloadSize)); err != nil {
+			return Segment{}, offset, err
+		}
+	}
+}
+
+// payloadSize returns the size of the payload of a variable-sized segment from its size field.
+// The segment's header is expected to already be in the buffer.
+func (ds *DocumentStreamReader) payloadSize(offset int) (uint64, error) {
+
+	var out uint64
+	sizeSize := int(sizeSegmentSize(ds.buffer[offset]))
+	if sizeSize == 0 {
+		return 0, ErrInvalidSegment
+	}
+	for _, b := range ds.buffer[offset+1 : offset+1+sizeSize] {
+		out = (out << 8) + uint64(b)
+	}
+	return out, nil
+}
+
+// fill reads from the underlying Reader until at least the specified number of bytes is in the
+// buffer.
+func (ds *DocumentStreamReader) fill(size int) error {
+
+	chunk := make([]byte, 4096)
+	for len(ds.buffer) < size {
+		if ds.err != nil {
+			return ds.err
+		}
+
+		bytesRead, err := ds.r.Read(chunk)
+		ds.buffer = append(ds.buffer, chunk[:bytesRead]...)
+		if err != nil {
+			ds.err = err
+		}
+	}
+	return nil
+}
+
+// resync throws away data until the next possible DocumentStart segment in the buffer. The error
+// which caused the resync is passed along to the anomaly hook.
+func (ds *DocumentStreamReader) resync(cause error) {
+
+	ds.resyncs++
+	ds.OnAnomaly.report(Anomaly{Kind: AnomalyResync, Peer: ds.Peer, Count: ds.resyncs,
+		Err: cause})
+
+	if len(ds.buffer) == 0 {
+		return
+	}
+
+	index := bytes.IndexByte(ds.buffer[1:], DFDocumentStart)
+	if index < 0 {
+		ds.buffer = ds.buffer[:0]
+		return
+	}
+	ds.buffer = ds.buffer[index+1:]
+}
+
+// DocumentStreamWriter writes a series of Documents to an io.Writer for use with
+// DocumentStreamReader.
+type DocumentStreamWriter struct {
+	w io.Writer
+}
+
+// NewDocumentStreamWriter creates a DocumentStreamWriter which writes to the specified Writer
+func NewDocumentStreamWriter(w io.Writer) *DocumentStreamWriter {
+	return &DocumentStreamWriter{w}
+}
+
+// Write adds a Document to the stream
+func (ds *DocumentStreamWriter) Write(doc *Document) error {
+	if doc == nil {
+		return ErrEmptyData
+	}
+	return doc.Write(ds.w)
+}
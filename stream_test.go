@@ -0,0 +1,84 @@
+package oganesson
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestDocumentStream(t *testing.T) {
+
+	var buffer bytes.Buffer
+	ds := NewDocumentStreamWriter(&buffer)
+
+	for _, value := range []string{"first", "second", "third"} {
+		doc := NewDocument()
+		doc.AttachString("testString", value)
+		if err := ds.Write(doc); err != nil {
+			t.Fatalf("DocumentStreamWriter.Write failed: %s", err.Error())
+		}
+	}
+
+	dr := NewDocumentStreamReader(&buffer)
+	for _, value := range []string{"first", "second", "third"} {
+		doc, err := dr.Read()
+		if err != nil {
+			t.Fatalf("DocumentStreamReader.Read failed: %s", err.Error())
+		}
+		if len(doc.Items) != 1 {
+			t.Fatalf("DocumentStreamReader.Read item count mismatch: wanted 1, got %d",
+				len(doc.Items))
+		}
+		seg := doc.Items[0].(*Segment)
+		if string(seg.Value) != value {
+			t.Fatalf("DocumentStreamReader.Read value mismatch: wanted '%s', got '%s'", value,
+				string(seg.Value))
+		}
+	}
+
+	if _, err := dr.Read(); err != io.EOF {
+		t.Fatalf("DocumentStreamReader.Read didn't return EOF at the end of the stream")
+	}
+}
+
+func TestDocumentStreamResync(t *testing.T) {
+
+	goodDoc := "\x01\x01\x0e\x00\x03ABC\x02\x00\x00\x00\x00\x00\x00\x00\x01"
+
+	// The second document has a bad type code in the middle of it and the third one is missing
+	// its DocumentEnd segment
+	stream := goodDoc +
+		"\x01\x01\xff\x00\x03ABC\x02\x00\x00\x00\x00\x00\x00\x00\x01" +
+		"\x01\x01\x0e\x00\x03ABC" +
+		goodDoc
+
+	dr := NewDocumentStreamReader(bytes.NewReader([]byte(stream)))
+
+	if _, err := dr.Read(); err != nil {
+		t.Fatalf("DocumentStreamReader.Read failed on first document: %s", err.Error())
+	}
+
+	goodCount := 1
+	for i := 0; i < 10; i++ {
+		doc, err := dr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			goodCount++
+			if len(doc.Items) != 1 {
+				t.Fatalf("DocumentStreamReader resynced into a bad document")
+			}
+		}
+	}
+
+	if goodCount != 2 {
+		t.Fatalf("DocumentStreamReader recovered %d good documents, expected 2", goodCount)
+	}
+
+	// Truncated streams should give an unexpected EOF error
+	dr = NewDocumentStreamReader(bytes.NewReader([]byte(goodDoc[:8])))
+	if _, err := dr.Read(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("DocumentStreamReader.Read didn't catch a truncated document")
+	}
+}
@@ -0,0 +1,263 @@
+package oganesson
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// This file contains a simple text format for writing Documents by hand, such as for test
+// fixtures and configuration files. It looks like a small subset of YAML. Each line holds one
+// field of a keyed Document and the field's type is given with a tag:
+//
+//	# Lines starting with # are comments
+//	name: !String Example Corp
+//	port: !UInt16 2001
+//	motd: "  quoted values keep their spaces\n"
+//	key: !Binary 0a1b2c
+//
+// Values without a tag are Strings. Strings may be quoted using Go syntax to include leading or
+// trailing spaces and special characters, and keys may be quoted the same way. Binary values are
+// written in hex. Container segments aren't supported because each line is one field.
+
+// ParseDocumentText creates a keyed Document from the text format
+func ParseDocumentText(src []byte) (*Document, error) {
+
+	b := NewDocumentBuilder(nil)
+	for i, line := range strings.Split(string(src), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		key, seg, err := parseTextLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%w on line %d", err, i+1)
+		}
+		if b.fields.Has(key) {
+			return nil, fmt.Errorf("%w: duplicate key '%s' on line %d", ErrInvalidKey, key, i+1)
+		}
+
+		// Header fields are accepted so that formatted Documents can be parsed back
+		if IsReservedKey(key) {
+			if err := checkHeaderField(key, seg); err != nil {
+				return nil, fmt.Errorf("%w on line %d", err, i+1)
+			}
+			b.fields[key] = seg
+			continue
+		}
+		if err := b.Add(key, seg); err != nil {
+			return nil, err
+		}
+	}
+	return b.Build()
+}
+
+// parseTextLine parses a single line of the text format
+func parseTextLine(line string) (string, Segment, error) {
+
+	var out Segment
+	var key, rest string
+	if line[0] == '"' {
+		quoted, err := strconv.QuotedPrefix(line)
+		if err != nil {
+			return "", out, ErrInvalidKey
+		}
+		key, _ = strconv.Unquote(quoted)
+		rest = strings.TrimSpace(line[len(quoted):])
+		if !strings.HasPrefix(rest, ":") {
+			return "", out, ErrInvalidKey
+		}
+		rest = rest[1:]
+	} else {
+		var found bool
+		key, rest, found = strings.Cut(line, ":")
+		if !found {
+			return "", out, ErrInvalidKey
+		}
+		key = strings.TrimSpace(key)
+	}
+	if key == "" {
+		return "", out, ErrInvalidKey
+	}
+
+	typeCode := uint8(DFStringType)
+	rest = strings.TrimSpace(rest)
+	if strings.HasPrefix(rest, "!") {
+		var tag string
+		tag, rest, _ = strings.Cut(rest[1:], " ")
+		rest = strings.TrimSpace(rest)
+
+		var ok bool
+		if typeCode, ok = typeCodeForName(tag); !ok {
+			return "", out, fmt.Errorf("%w: unknown type '%s'", ErrInvalidSegment, tag)
+		}
+	}
+
+	err := setTextValue(&out, typeCode, rest)
+	return key, out, err
+}
+
+// setTextValue sets a segment from the text form of its value
+func setTextValue(seg *Segment, typeCode uint8, value string) error {
+
+	var err error
+	var i int64
+	var u uint64
+	var f float64
+	switch typeCode {
+	case DFInt8Type:
+		if i, err = strconv.ParseInt(value, 0, 8); err == nil {
+			return seg.SetInt8(int8(i))
+		}
+	case DFInt16Type:
+		if i, err = strconv.ParseInt(value, 0, 16); err == nil {
+			return seg.SetInt16(int16(i))
+		}
+	case DFInt32Type:
+		if i, err = strconv.ParseInt(value, 0, 32); err == nil {
+			return seg.SetInt32(int32(i))
+		}
+	case DFInt64Type:
+		if i, err = strconv.ParseInt(value, 0, 64); err == nil {
+			return seg.SetInt64(i)
+		}
+	case DFUInt8Type:
+		if u, err = strconv.ParseUint(value, 0, 8); err == nil {
+			return seg.SetUInt8(uint8(u))
+		}
+	case DFUInt16Type:
+		if u, err = strconv.ParseUint(value, 0, 16); err == nil {
+			return seg.SetUInt16(uint16(u))
+		}
+	case DFUInt32Type:
+		if u, err = strconv.ParseUint(value, 0, 32); err == nil {
+			return seg.SetUInt32(uint32(u))
+		}
+	case DFUInt64Type:
+		if u, err = strconv.ParseUint(value, 0, 64); err == nil {
+			return seg.SetUInt64(u)
+		}
+	case DFBoolType:
+		var b bool
+		if b, err = strconv.ParseBool(value); err == nil {
+			return seg.SetBool(b)
+		}
+	case DFFloat32Type:
+		if f, err = strconv.ParseFloat(value, 32); err == nil {
+			return seg.SetFloat32(float32(f))
+		}
+	case DFFloat64Type:
+		if f, err = strconv.ParseFloat(value, 64); err == nil {
+			return seg.SetFloat64(f)
+		}
+	case DFStringType, DFHugeStringType:
+		if strings.HasPrefix(value, "\"") {
+			if value, err = strconv.Unquote(value); err != nil {
+				break
+			}
+		}
+		return seg.SetString(value)
+	case DFBinaryType, DFHugeBinaryType:
+		var data []byte
+		if data, err = hex.DecodeString(value); err == nil {
+			return seg.SetBinary(data)
+		}
+	default:
+		return fmt.Errorf("%w: type %s can't be used in text", ErrTypeError, TypeName(typeCode))
+	}
+	return fmt.Errorf("%w: bad %s value '%s'", ErrInvalidSegment, TypeName(typeCode), value)
+}
+
+// FormatDocumentText converts a keyed Document to the text format. Fields are written in key
+// order.
+func FormatDocumentText(doc *Document) ([]byte, error) {
+
+	fields, err := doc.fieldMap()
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	for _, key := range fields.Keys() {
+		seg := fields[key]
+		value, err := formatTextValue(seg)
+		if err != nil {
+			return nil, fmt.Errorf("%w for key '%s'", err, key)
+		}
+
+		out.WriteString(quoteTextIfNeeded(key, true))
+		out.WriteString(": !" + TypeName(seg.Type) + " " + value + "\n")
+	}
+	return out.Bytes(), nil
+}
+
+// formatTextValue returns the text form of a segment's value
+func formatTextValue(seg Segment) (string, error) {
+
+	switch seg.Type {
+	case DFStringType, DFHugeStringType:
+		return quoteTextIfNeeded(string(seg.Value), false), nil
+	case DFBinaryType, DFHugeBinaryType:
+		return hex.EncodeToString(seg.Value), nil
+	case DFFloat32Type:
+		v, err := seg.GetFloat32()
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), err
+	case DFFloat64Type:
+		v, err := seg.GetFloat64()
+		return strconv.FormatFloat(v, 'g', -1, 64), err
+	case DFBoolType:
+		v, err := seg.GetBool()
+		return strconv.FormatBool(v), err
+	case DFInt8Type, DFInt16Type, DFInt32Type, DFInt64Type, DFUInt8Type, DFUInt16Type,
+		DFUInt32Type, DFUInt64Type:
+
+		if err := validateSegment(seg); err != nil {
+			return "", err
+		}
+		return strings.SplitN(seg.ToString(), "=", 2)[1], nil
+	}
+	return "", fmt.Errorf("%w: type %s can't be used in text", ErrTypeError, TypeName(seg.Type))
+}
+
+// quoteTextIfNeeded quotes a key or string value if it wouldn't survive being parsed as-is
+func quoteTextIfNeeded(s string, isKey bool) string {
+
+	needsQuotes := s == "" || strings.TrimSpace(s) != s || strings.HasPrefix(s, "\"") ||
+		strings.HasPrefix(s, "!") || (isKey && (strings.Contains(s, ":") ||
+		strings.HasPrefix(s, "#")))
+	for _, r := range s {
+		if !unicode.IsPrint(r) {
+			needsQuotes = true
+			break
+		}
+	}
+
+	if needsQuotes {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// fieldMap reads the fields of a keyed Document, i.e. one which contains a single map
+func (doc *Document) fieldMap() (SegmentMap, error) {
+
+	var buf bytes.Buffer
+	for _, item := range doc.Items {
+		if err := item.Write(&buf); err != nil {
+			return nil, err
+		}
+	}
+
+	out := make(SegmentMap)
+	if err := out.Read(&buf); err != nil {
+		return nil, err
+	}
+	if buf.Len() != 0 {
+		return nil, ErrInvalidContainer
+	}
+	return out, nil
+}
@@ -0,0 +1,67 @@
+package oganesson
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDocumentText(t *testing.T) {
+	src := []byte(`
+# Test fixture
+name: Example Corp
+port: !UInt16 2001
+offset: !Int32 -12
+ratio: !Float64 0.25
+enabled: !Bool true
+motd: !String "  two lines\nof text  "
+"odd: key": !Binary 0a1b2c
+`)
+
+	doc, err := ParseDocumentText(src)
+	if err != nil {
+		t.Fatalf("ParseDocumentText failed: %s", err.Error())
+	}
+
+	fields, err := doc.fieldMap()
+	if err != nil {
+		t.Fatalf("Error reading parsed fields: %s", err.Error())
+	}
+	if len(fields) != 7 {
+		t.Fatalf("ParseDocumentText field count mismatch: %d", len(fields))
+	}
+	if v, _ := fields.GetString("name"); v != "Example Corp" {
+		t.Fatalf("ParseDocumentText untagged string mismatch: %s", v)
+	}
+	if v, _ := fields.GetUInt16("port"); v != 2001 {
+		t.Fatalf("ParseDocumentText UInt16 mismatch: %d", v)
+	}
+	if v, _ := fields.GetString("motd"); v != "  two lines\nof text  " {
+		t.Fatalf("ParseDocumentText quoted string mismatch: %q", v)
+	}
+	if v, _ := fields.GetBinary("odd: key"); len(v) != 3 || v[2] != 0x2c {
+		t.Fatalf("ParseDocumentText quoted key or binary mismatch")
+	}
+
+	text, err := FormatDocumentText(doc)
+	if err != nil {
+		t.Fatalf("FormatDocumentText failed: %s", err.Error())
+	}
+	doc2, err := ParseDocumentText(text)
+	if err != nil {
+		t.Fatalf("ParseDocumentText failed on exported text: %s\n%s", err.Error(), text)
+	}
+	text2, _ := FormatDocumentText(doc2)
+	if string(text) != string(text2) {
+		t.Fatalf("Text round trip mismatch:\n%s\n%s", text, text2)
+	}
+
+	if _, err := ParseDocumentText([]byte("a: !Int8 300")); !errors.Is(err, ErrInvalidSegment) {
+		t.Fatalf("ParseDocumentText accepted an out-of-range value")
+	}
+	if _, err := ParseDocumentText([]byte("a: 1\na: 2")); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("ParseDocumentText accepted a duplicate key")
+	}
+	if _, err := ParseDocumentText([]byte("a !Int8 3")); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("ParseDocumentText accepted a line without a key")
+	}
+}
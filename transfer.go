@@ -0,0 +1,79 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var ErrTransferCanceled = errors.New("transfer canceled")
+
+// This file handles canceling multipart packets partway through sending them. Each multipart
+// packet sent by a session is a transfer with its own ID. When a transfer is canceled, the
+// sending side stops after the frame it is working on and sends a cancel frame in place of the
+// rest of the packet. Both the Write() sending it and the Read() receiving it on the other side
+// return ErrTransferCanceled, and the session can go on being used afterward.
+
+// ActiveTransfer returns the ID of the multipart packet the session is sending, or 0 if it isn't
+// sending one. Packets small enough to fit in a single frame aren't transfers and can't be
+// canceled.
+func (s *PacketSession) ActiveTransfer() uint64 {
+	s.transferLock.Lock()
+	defer s.transferLock.Unlock()
+	return s.activeTransfer
+}
+
+// CancelTransfer stops sending the multipart packet with the specified ID. ErrNotFound is
+// returned if the transfer isn't in progress, such as when it has already finished.
+func (s *PacketSession) CancelTransfer(id uint64) error {
+
+	s.transferLock.Lock()
+	defer s.transferLock.Unlock()
+
+	if id == 0 || id != s.activeTransfer {
+		return ErrNotFound
+	}
+	s.cancelRequested = true
+	return nil
+}
+
+// startTransfer assigns an ID to a multipart packet which is about to be sent
+func (s *PacketSession) startTransfer() uint64 {
+	s.transferLock.Lock()
+	defer s.transferLock.Unlock()
+
+	s.lastTransfer++
+	s.activeTransfer = s.lastTransfer
+	s.cancelRequested = false
+	return s.activeTransfer
+}
+
+// endTransfer clears the active transfer once its packet is finished
+func (s *PacketSession) endTransfer() {
+	s.transferLock.Lock()
+	defer s.transferLock.Unlock()
+
+	s.activeTransfer = 0
+	s.cancelRequested = false
+}
+
+// transferCanceled returns true if the active transfer has been canceled
+func (s *PacketSession) transferCanceled() bool {
+	s.transferLock.Lock()
+	defer s.transferLock.Unlock()
+	return s.cancelRequested
+}
+
+// writeCancelFrame tells the other side that the transfer has been abandoned. The frame holds the
+// transfer's ID, which is only informational because a session sends one packet at a time.
+func (s *PacketSession) writeCancelFrame(w io.Writer, id uint64) error {
+
+	payload := make([]byte, 8)
+	binary.BigEndian.PutUint64(payload, id)
+	if err := s.writeDataFrame(w, CancelFrame, payload); err != nil {
+		return err
+	}
+	return ErrTransferCanceled
+}
@@ -0,0 +1,81 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestCancelTransfer(t *testing.T) {
+	requester, responder := sessionPair(t, nil)
+
+	done := make(chan error)
+	go func() {
+		done <- requester.Write(bytes.Repeat([]byte("0123456789"), 10000))
+	}()
+
+	// Read the frames by hand so that the transfer is canceled partway through
+	chunk := NewDataFrame(responder.BufferSize)
+	if err := chunk.Read(responder.Connection); err != nil {
+		t.Fatalf("Failed to read start frame: %s", err.Error())
+	}
+	id := requester.ActiveTransfer()
+	if chunk.GetType() != MultipartFrameStart || id == 0 {
+		t.Fatalf("Multipart packet isn't an active transfer")
+	}
+	if err := requester.CancelTransfer(id + 1); err != ErrNotFound {
+		t.Fatalf("Canceled a transfer which doesn't exist")
+	}
+	if err := requester.CancelTransfer(id); err != nil {
+		t.Fatalf("CancelTransfer failed: %s", err.Error())
+	}
+
+	frames := 0
+	for chunk.GetType() != CancelFrame {
+		if err := chunk.Read(responder.Connection); err != nil {
+			t.Fatalf("Failed to read frame: %s", err.Error())
+		}
+		if chunk.GetType() == MultipartFrameFinal {
+			t.Fatalf("Canceled transfer was finished")
+		}
+		frames++
+	}
+	if frames > 2 {
+		t.Fatalf("Canceled transfer kept sending %d frames", frames)
+	}
+	if err := <-done; !errors.Is(err, ErrTransferCanceled) {
+		t.Fatalf("Write didn't return ErrTransferCanceled: %v", err)
+	}
+	if requester.ActiveTransfer() != 0 || requester.CancelTransfer(id) != ErrNotFound {
+		t.Fatalf("Canceled transfer is still active")
+	}
+}
+
+func TestReadCanceledTransfer(t *testing.T) {
+	requester, responder := sessionPair(t, nil)
+
+	go func() {
+		WriteFrame(requester.Connection, MultipartFrameStart, []byte("5000"))
+		WriteFrame(requester.Connection, MultipartFrame, bytes.Repeat([]byte("A"), 1000))
+		WriteFrame(requester.Connection, CancelFrame, make([]byte, 8))
+		requester.Write([]byte("after"))
+	}()
+
+	if _, err := responder.Read(); !errors.Is(err, ErrTransferCanceled) {
+		t.Fatalf("Read didn't return ErrTransferCanceled: %v", err)
+	}
+
+	// The session is still usable afterward
+	packet, err := responder.Read()
+	if err != nil {
+		t.Fatalf("Read after canceled transfer failed: %s", err.Error())
+	}
+	if string(packet) != "after" {
+		t.Fatalf("Packet mismatch after canceled transfer: %s", string(packet))
+	}
+	if responder.CloseReason() != CloseNone {
+		t.Fatalf("Canceled transfer closed the session")
+	}
+}
@@ -0,0 +1,31 @@
+package oganesson
+
+import (
+	"unsafe"
+)
+
+// GetStringUnsafe is the same as GetString(), but the returned string shares its memory with the
+// segment's value instead of being a copy. This saves an allocation and a copy for read-heavy
+// code, but the value must not be changed for as long as the string is in use because Go strings
+// are assumed to be immutable. That includes reusing a DecodeArena or a buffer passed to
+// ViewDocument() which the value came from.
+func (seg Segment) GetStringUnsafe() (string, error) {
+	if seg.Type != DFStringType && seg.Type != DFHugeStringType {
+		return "", &TypeError{Expected: DFStringType, Actual: seg.Type}
+	}
+	if len(seg.Value) == 0 {
+		return "", nil
+	}
+	return unsafe.String(unsafe.SliceData(seg.Value), len(seg.Value)), nil
+}
+
+// GetStringUnsafe is the SegmentMap counterpart to Segment.GetStringUnsafe() and has the same
+// restrictions
+func (sm SegmentMap) GetStringUnsafe(key string) (string, error) {
+	seg, ok := sm[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	value, err := seg.GetStringUnsafe()
+	return value, withKey(err, key)
+}
@@ -0,0 +1,33 @@
+package oganesson
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGetStringUnsafe(t *testing.T) {
+	seg := Segment{DFStringType, []byte("abcdef")}
+
+	value, err := seg.GetStringUnsafe()
+	if err != nil || value != "abcdef" {
+		t.Fatalf("GetStringUnsafe mismatch: %s", value)
+	}
+
+	// The string shares memory with the segment, so changes show through
+	seg.Value[0] = 'X'
+	if value != "Xbcdef" {
+		t.Fatalf("GetStringUnsafe returned a copy")
+	}
+
+	if value, err := (Segment{DFStringType, nil}).GetStringUnsafe(); err != nil || value != "" {
+		t.Fatalf("GetStringUnsafe failed for an empty string")
+	}
+
+	sm := SegmentMap{"key": Segment{DFInt8Type, []byte{1}}}
+	if _, err := sm.GetStringUnsafe("key"); !errors.Is(err, ErrTypeError) {
+		t.Fatalf("SegmentMap.GetStringUnsafe didn't catch a type mismatch")
+	}
+	if _, err := sm.GetStringUnsafe("missing"); err != ErrNotFound {
+		t.Fatalf("SegmentMap.GetStringUnsafe didn't catch a missing key")
+	}
+}
@@ -0,0 +1,128 @@
+package oganesson
+
+import (
+	"fmt"
+)
+
+// Validate performs structural checks on the Document to make sure that it is safe to send or to
+// trust after receiving it. It checks that all type codes are valid, that all payloads are the
+// right size for their types, that no segments are out of place, and that the item counts of maps
+// and lists match the segments which follow them.
+func (doc *Document) Validate() error {
+
+	items := make([]Segment, len(doc.Items))
+	for i, item := range doc.Items {
+		switch v := item.(type) {
+		case *Segment:
+			items[i] = *v
+		default:
+			return fmt.Errorf("%w: unsupported item type at index %d", ErrInvalidSegment, i)
+		}
+	}
+
+	return validateItems(items)
+}
+
+// ValidateDocument performs the same checks as Document.Validate() on a flattened Document and
+// also checks that its DocumentStart and DocumentEnd segments are consistent with its contents.
+func ValidateDocument(frame []byte) error {
+
+	dv, err := ViewDocument(frame)
+	if err != nil {
+		return err
+	}
+	return validateItems(dv.items)
+}
+
+// validateItems does the work for Validate() and ValidateDocument()
+func validateItems(items []Segment) error {
+
+	for i := 0; i < len(items); i++ {
+		if err := validateSegment(items[i]); err != nil {
+			return fmt.Errorf("%w at index %d", err, i)
+		}
+
+		switch items[i].Type {
+		case DFDocumentStart, DFDocumentEnd:
+			return fmt.Errorf("%w: document delimiter at index %d", ErrInvalidMsg, i)
+
+		case DFMapType, DFLargeMapType, DFKeyedMapType:
+			pairCount, err := items[i].GetMapIndex()
+			if err != nil {
+				return fmt.Errorf("%w at index %d", err, i)
+			}
+			if pairCount > uint64(len(items)-i-1)/2 {
+				return fmt.Errorf("%w: map at index %d is missing items", ErrInvalidContainer, i)
+			}
+
+			for j := uint64(0); j < pairCount; j++ {
+				keyIndex := i + 1 + int(j*2)
+				if !isValidMapKey(items[i].Type, items[keyIndex].Type) {
+					return fmt.Errorf("%w at index %d", ErrInvalidKey, keyIndex)
+				}
+				if err := validateContainerItem(items, keyIndex); err != nil {
+					return err
+				}
+				if err := validateContainerItem(items, keyIndex+1); err != nil {
+					return err
+				}
+			}
+			i += int(pairCount * 2)
+
+		case DFListType, DFLargeListType:
+			itemCount, err := items[i].GetListIndex()
+			if err != nil {
+				return fmt.Errorf("%w at index %d", err, i)
+			}
+			if itemCount > uint64(len(items)-i-1) {
+				return fmt.Errorf("%w: list at index %d is missing items", ErrInvalidContainer, i)
+			}
+
+			for j := uint64(0); j < itemCount; j++ {
+				if err := validateContainerItem(items, i+1+int(j)); err != nil {
+					return err
+				}
+			}
+			i += int(itemCount)
+		}
+	}
+
+	return nil
+}
+
+// validateContainerItem checks a segment which belongs to a map or a list
+func validateContainerItem(items []Segment, index int) error {
+
+	if err := validateSegment(items[index]); err != nil {
+		return fmt.Errorf("%w at index %d", err, index)
+	}
+
+	switch items[index].Type {
+	case DFMapType, DFLargeMapType, DFKeyedMapType, DFListType, DFLargeListType:
+		return fmt.Errorf("%w: nested container at index %d", ErrInvalidContainer, index)
+	case DFDocumentStart, DFDocumentEnd:
+		return fmt.Errorf("%w: document delimiter at index %d", ErrInvalidMsg, index)
+	}
+	return nil
+}
+
+// validateSegment checks the type code and payload size of an individual segment
+func validateSegment(seg Segment) error {
+
+	if !isTypeCodeValid(seg.Type) {
+		return ErrInvalidSegment
+	}
+
+	fixedSize := fixedSegmentSize(seg.Type)
+	if fixedSize != 0 {
+		if len(seg.Value) != int(fixedSize) {
+			return ErrSegmentSize
+		}
+		return nil
+	}
+
+	if sizeSegmentSize(seg.Type) == 2 && len(seg.Value) > 65535 {
+		return ErrSegmentSize
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package oganesson
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDocumentValidate(t *testing.T) {
+	doc := NewDocument()
+	doc.AttachString("testString", "abcdef")
+	doc.AttachInt64("testInt", 42)
+
+	if err := doc.Validate(); err != nil {
+		t.Fatalf("Validate failed on a good document: %s", err.Error())
+	}
+
+	// A map with one pair followed by a list of two items
+	var mapIndex, listIndex Segment
+	mapIndex.SetMapIndex(SegmentMap{"key": Segment{}})
+	listIndex.SetListIndex(make(SegmentList, 2))
+	doc.Items = append(doc.Items,
+		&mapIndex,
+		&Segment{DFStringType, []byte("key")},
+		&Segment{DFBoolType, []byte{1}},
+		&listIndex,
+		&Segment{DFUInt8Type, []byte{1}},
+		&Segment{DFUInt8Type, []byte{2}})
+	if err := doc.Validate(); err != nil {
+		t.Fatalf("Validate failed on a good document with containers: %s", err.Error())
+	}
+
+	// Missing list item
+	doc.Items = doc.Items[:len(doc.Items)-1]
+	if err := doc.Validate(); !errors.Is(err, ErrInvalidContainer) {
+		t.Fatalf("Validate didn't catch a list count mismatch")
+	}
+
+	// Nested container
+	doc.Items = append(doc.Items[:3], &listIndex)
+	if err := doc.Validate(); !errors.Is(err, ErrInvalidContainer) {
+		t.Fatalf("Validate didn't catch a nested container")
+	}
+
+	// Bad payload size
+	doc.Items = []SegContainer{&Segment{DFInt32Type, []byte{1, 2}}}
+	if err := doc.Validate(); !errors.Is(err, ErrSegmentSize) {
+		t.Fatalf("Validate didn't catch a bad payload size")
+	}
+
+	// Bad map key
+	doc.Items = []SegContainer{&mapIndex, &Segment{DFUInt8Type, []byte{1}},
+		&Segment{DFUInt8Type, []byte{1}}}
+	if err := doc.Validate(); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("Validate didn't catch a bad map key")
+	}
+}
+
+func TestValidateDocument(t *testing.T) {
+
+	frame := []byte("\x01\x01\x0e\x00\x03ABC\x02\x00\x00\x00\x00\x00\x00\x00\x01")
+	if err := ValidateDocument(frame); err != nil {
+		t.Fatalf("ValidateDocument failed on a good frame: %s", err.Error())
+	}
+
+	frame = []byte("\x01\x01\x0e\x00\x03ABC\x02\x00\x00\x00\x00\x00\x00\x00\x02")
+	if err := ValidateDocument(frame); err == nil {
+		t.Fatalf("ValidateDocument didn't catch a segment count mismatch")
+	}
+}
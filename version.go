@@ -0,0 +1,111 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"io"
+)
+
+// This file handles picking the version of the wire protocol for a session. Requesters which
+// support version negotiation set setupVersions in their setup request, and once the setup
+// frames are exchanged they send the list of versions they support. The responder answers with
+// the newest version both sides have, or 0 if there isn't one. Peers which don't set the flag
+// are older ones which only speak version 1. The offer and answer are included in the handshake
+// transcript, so authentication bound to it, such as PSKAuthenticator, catches a downgrade.
+
+// ProtocolVersion is the newest version of the wire protocol supported by this package
+const ProtocolVersion = uint8(1)
+
+// legacyVersion is the version spoken by peers which don't negotiate versions
+const legacyVersion = uint8(1)
+
+// NegotiatedVersion returns the protocol version agreed on during setup. It returns 0 until setup
+// has finished.
+func (s *PacketSession) NegotiatedVersion() uint8 {
+	if !s.isInit {
+		return 0
+	}
+	return s.version
+}
+
+// supportedVersions returns the versions the session will agree to
+func (s *PacketSession) supportedVersions() []uint8 {
+	if len(s.SupportedVersions) == 0 {
+		return []uint8{ProtocolVersion}
+	}
+	return s.SupportedVersions
+}
+
+// supportsVersion returns true if the session will agree to the specified version
+func (s *PacketSession) supportsVersion(version uint8) bool {
+	for _, v := range s.supportedVersions() {
+		if v == version && v != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateVersion picks the protocol version during setup, once the setup frames have been
+// exchanged. peerNegotiates is false if the other side is too old to send its versions.
+func (s *PacketSession) negotiateVersion(isRequester bool, peerNegotiates bool) error {
+
+	if !peerNegotiates {
+		if !s.supportsVersion(legacyVersion) {
+			return ErrVersionMismatch
+		}
+		s.version = legacyVersion
+		return nil
+	}
+
+	if isRequester {
+		versions := s.supportedVersions()
+		if len(versions) > 255 {
+			versions = versions[:255]
+		}
+		request := append([]byte{uint8(len(versions))}, versions...)
+		if _, err := s.Connection.Write(request); err != nil {
+			return err
+		}
+
+		response := []byte{0}
+		if _, err := io.ReadFull(s.Connection, response); err != nil {
+			return err
+		}
+		s.versionExchange = append(request, response[0])
+		if !s.supportsVersion(response[0]) {
+			return ErrVersionMismatch
+		}
+		s.version = response[0]
+		return nil
+	}
+
+	count := []byte{0}
+	if _, err := io.ReadFull(s.Connection, count); err != nil {
+		return err
+	}
+	offered := make([]byte, count[0])
+	if _, err := io.ReadFull(s.Connection, offered); err != nil {
+		return err
+	}
+
+	var chosen uint8
+	for _, v := range offered {
+		if v > chosen && s.supportsVersion(v) {
+			chosen = v
+		}
+	}
+
+	// Like the setup response, the answer is sent even if there is no common version so that the
+	// requester doesn't wait for it
+	if _, err := s.Connection.Write([]byte{chosen}); err != nil {
+		return err
+	}
+	s.versionExchange = append(append(count, offered...), chosen)
+	if chosen == 0 {
+		s.Connection.Close()
+		return ErrVersionMismatch
+	}
+	s.version = chosen
+	return nil
+}
@@ -0,0 +1,123 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestVersionNegotiation(t *testing.T) {
+	versions := func(requesterVersions, responderVersions []uint8) func(req, resp *PacketSession) {
+		return func(req, resp *PacketSession) {
+			req.SupportedVersions = requesterVersions
+			resp.SupportedVersions = responderVersions
+		}
+	}
+	requester, responder, reqErr, respErr := setupSessionPair(nil)
+	if reqErr != nil || respErr != nil {
+		t.Fatalf("Setup with default versions failed: %v, %v", reqErr, respErr)
+	}
+	if requester.NegotiatedVersion() != ProtocolVersion ||
+		responder.NegotiatedVersion() != ProtocolVersion {
+		t.Fatalf("Wrong default version: %d, %d", requester.NegotiatedVersion(),
+			responder.NegotiatedVersion())
+	}
+	if info, err := requester.HandshakeInfo(); err != nil || info.Version != ProtocolVersion {
+		t.Fatalf("Version missing from handshake info")
+	}
+
+	// The newest version in common is picked
+	requester, responder, reqErr, respErr = setupSessionPair(versions([]uint8{1, 2, 3},
+		[]uint8{4, 3, 2}))
+	if reqErr != nil || respErr != nil {
+		t.Fatalf("Setup with overlapping versions failed: %v, %v", reqErr, respErr)
+	}
+	if requester.NegotiatedVersion() != 3 || responder.NegotiatedVersion() != 3 {
+		t.Fatalf("Wrong version picked: %d, %d", requester.NegotiatedVersion(),
+			responder.NegotiatedVersion())
+	}
+
+	_, _, reqErr, respErr = setupSessionPair(versions([]uint8{2}, []uint8{3}))
+	if !errors.Is(reqErr, ErrVersionMismatch) || !errors.Is(respErr, ErrVersionMismatch) {
+		t.Fatalf("Wrong errors for no common version: %v, %v", reqErr, respErr)
+	}
+	if retryable(reqErr) {
+		t.Fatalf("Version mismatch is treated as retryable")
+	}
+}
+
+func TestVersionNegotiationLegacyPeer(t *testing.T) {
+
+	// A requester which doesn't negotiate versions gets version 1
+	clientConn, serverConn := net.Pipe()
+	responder := NewPacketResponder(serverConn, 4096)
+	errChan := make(chan error)
+	go func() {
+		errChan <- responder.InitResponder()
+	}()
+	clientConn.Write([]byte{SessionSetupRequest, 16, 0, 0})
+	response := make([]byte, 4)
+	if _, err := clientConn.Read(response); err != nil {
+		t.Fatalf("Error reading setup response: %s", err.Error())
+	}
+	if err := <-errChan; err != nil {
+		t.Fatalf("Setup with legacy requester failed: %s", err.Error())
+	}
+	if response[3]&setupVersions != 0 {
+		t.Fatalf("Responder offered version negotiation to a legacy requester")
+	}
+	if responder.NegotiatedVersion() != legacyVersion {
+		t.Fatalf("Wrong version for legacy requester: %d", responder.NegotiatedVersion())
+	}
+
+	// A responder which doesn't support version 1 turns it away
+	clientConn, serverConn = net.Pipe()
+	responder = NewPacketResponder(serverConn, 4096)
+	responder.SupportedVersions = []uint8{2}
+	go func() {
+		errChan <- responder.InitResponder()
+	}()
+	clientConn.Write([]byte{SessionSetupRequest, 16, 0, 0})
+	clientConn.Read(response)
+	if err := <-errChan; !errors.Is(err, ErrVersionMismatch) {
+		t.Fatalf("Wrong error for legacy requester: %v", err)
+	}
+}
+
+// downgradingConn removes all but the oldest version from the version offer written through it
+type downgradingConn struct {
+	net.Conn
+}
+
+func (c downgradingConn) Write(p []byte) (int, error) {
+	if len(p) > 2 && int(p[0]) == len(p)-1 {
+		if _, err := c.Conn.Write([]byte{1, p[1]}); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	return c.Conn.Write(p)
+}
+
+func TestVersionDowngrade(t *testing.T) {
+	auth := PSKAuthenticator{Key: []byte("shared secret")}
+	clientConn, serverConn := net.Pipe()
+	requester := NewPacketRequester(downgradingConn{clientConn})
+	requester.SupportedVersions = []uint8{1, 2}
+	requester.Authenticator = auth
+	responder := NewPacketResponder(serverConn, 4096)
+	responder.SupportedVersions = []uint8{1, 2}
+	responder.Authenticator = auth
+
+	errChan := make(chan error)
+	go func() {
+		errChan <- requester.InitRequester()
+	}()
+	respErr := responder.InitResponder()
+	reqErr := <-errChan
+	if !errors.Is(respErr, ErrAuthFailed) || !errors.Is(reqErr, ErrAuthFailed) {
+		t.Fatalf("Tampered version offer wasn't caught: %v, %v", reqErr, respErr)
+	}
+}
@@ -0,0 +1,165 @@
+package oganesson
+
+import (
+	"encoding/binary"
+)
+
+// This file contains the read-only DocumentView API. A DocumentView is built directly over a
+// received frame and does not copy any payload data, which makes it a cheap way for routers,
+// filters, and the like to look at a message without paying for a full Document.
+
+// DocumentView is a read-only view of a flattened Document. All data returned by a DocumentView
+// is a sub-slice of the frame it was created from, so the view and anything obtained from it are
+// only valid for as long as the caller leaves that frame untouched. If the frame is going to be
+// reused, such as a network read buffer, call Materialize() to get a Document which owns its data.
+type DocumentView struct {
+	frame   []byte
+	version uint8
+	items   []Segment
+}
+
+// ViewDocument creates a DocumentView over the flattened Document in the frame passed to it. No
+// payload data is copied. The frame must contain exactly one Document.
+func ViewDocument(frame []byte) (*DocumentView, error) {
+
+	start, index, err := viewSegment(frame, 0)
+	if err != nil {
+		return nil, err
+	}
+	version, err := start.GetDocStart()
+	if err != nil {
+		return nil, ErrInvalidMsg
+	}
+
+	out := DocumentView{frame: frame, version: version, items: make([]Segment, 0)}
+	for {
+		if index == len(frame) && lenientDocumentEnd.Load() {
+			logWarning("document is missing its DocumentEnd segment")
+			break
+		}
+
+		var seg Segment
+		seg, index, err = viewSegment(frame, index)
+		if err != nil {
+			return nil, err
+		}
+		if seg.Type == DFDocumentEnd {
+			segCount, err := seg.GetDocEnd()
+			if err != nil {
+				return nil, err
+			}
+			if err := checkDocEndCount(segCount, uint64(len(out.items))); err != nil {
+				return nil, err
+			}
+			break
+		}
+		out.items = append(out.items, seg)
+	}
+
+	if index != len(frame) {
+		return nil, ErrSize
+	}
+
+	return &out, nil
+}
+
+// Frame returns the byte slice the view was created from
+func (dv *DocumentView) Frame() []byte {
+	return dv.frame
+}
+
+// Version returns the version value from the Document's DocumentStart segment
+func (dv *DocumentView) Version() uint8 {
+	return dv.version
+}
+
+// Count returns the number of segments in the Document, not including the DocumentStart and
+// DocumentEnd segments
+func (dv *DocumentView) Count() int {
+	return len(dv.items)
+}
+
+// GetType returns the type code of the segment at the specified index
+func (dv *DocumentView) GetType(index int) (uint8, error) {
+	if index < 0 || index >= len(dv.items) {
+		return DFUnknownType, ErrNotFound
+	}
+	return dv.items[index].Type, nil
+}
+
+// GetValue returns the payload of the segment at the specified index. The returned slice points
+// into the view's frame and must not be modified.
+func (dv *DocumentView) GetValue(index int) ([]byte, error) {
+	if index < 0 || index >= len(dv.items) {
+		return nil, ErrNotFound
+	}
+	return dv.items[index].Value, nil
+}
+
+// GetSegment returns the segment at the specified index. The Value field of the returned Segment
+// points into the view's frame, so the typed getters, e.g. GetInt32(), can be used on it directly,
+// but it must not be modified.
+func (dv *DocumentView) GetSegment(index int) (Segment, error) {
+	if index < 0 || index >= len(dv.items) {
+		return Segment{}, ErrNotFound
+	}
+	return dv.items[index], nil
+}
+
+// Materialize creates a Document from the view which owns copies of all of its data and is,
+// therefore, independent of the view's frame.
+func (dv *DocumentView) Materialize() (*Document, error) {
+
+	out := NewDocument()
+	for _, item := range dv.items {
+		seg := Segment{item.Type, make([]byte, len(item.Value))}
+		copy(seg.Value, item.Value)
+		out.Items = append(out.Items, &seg)
+	}
+	out.updateWireMsgFields()
+	return out, nil
+}
+
+// viewSegment parses the segment which begins at the specified offset in the byte slice. The
+// returned Segment's Value is a sub-slice of p, and the offset of the byte following the segment
+// is returned with it.
+func viewSegment(p []byte, offset int) (Segment, int, error) {
+
+	if offset < 0 || offset >= len(p) {
+		return Segment{}, offset, ErrSize
+	}
+
+	typeCode := p[offset]
+	if !isTypeCodeValid(typeCode) {
+		return Segment{}, offset, ErrInvalidSegment
+	}
+	index := offset + 1
+
+	var payloadSize uint64
+	switch sizeSize := int(sizeSegmentSize(typeCode)); sizeSize {
+	case 0:
+		payloadSize = uint64(fixedSegmentSize(typeCode))
+	case 2:
+		if len(p)-index < sizeSize {
+			return Segment{}, offset, ErrSegmentSize
+		}
+		payloadSize = uint64(binary.BigEndian.Uint16(p[index:]))
+		index += sizeSize
+	case 8:
+		if len(p)-index < sizeSize {
+			return Segment{}, offset, ErrSegmentSize
+		}
+		payloadSize = binary.BigEndian.Uint64(p[index:])
+		index += sizeSize
+	default:
+		return Segment{}, offset, ErrInvalidSegment
+	}
+
+	if payloadSize > uint64(len(p)-index) {
+		return Segment{}, offset, ErrSegmentSize
+	}
+	end := index + int(payloadSize)
+
+	// The capacity is clamped so that an append by the caller can't scribble over the frame
+	return Segment{typeCode, p[index:end:end]}, end, nil
+}
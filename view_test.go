@@ -0,0 +1,97 @@
+package oganesson
+
+import (
+	"testing"
+)
+
+func TestViewDocument(t *testing.T) {
+	doc := NewDocument()
+	doc.AttachString("testString", "abcdef")
+	doc.AttachInt64("testInt", 42)
+
+	frame, err := doc.Flatten()
+	if err != nil {
+		t.Fatalf("Error flattening document: %s", err.Error())
+	}
+
+	dv, err := ViewDocument(frame)
+	if err != nil {
+		t.Fatalf("ViewDocument failed: %s", err.Error())
+	}
+	if dv.Version() != 1 {
+		t.Fatalf("ViewDocument version mismatch: wanted 1, got %d", dv.Version())
+	}
+	if dv.Count() != 2 {
+		t.Fatalf("ViewDocument count mismatch: wanted 2, got %d", dv.Count())
+	}
+
+	seg, err := dv.GetSegment(0)
+	if err != nil {
+		t.Fatalf("GetSegment failed: %s", err.Error())
+	}
+	teststr, err := seg.GetString()
+	if err != nil {
+		t.Fatalf("GetString failed on viewed segment: %s", err.Error())
+	}
+	if teststr != "abcdef" {
+		t.Fatalf("Viewed string mismatch: wanted 'abcdef', got '%s'", teststr)
+	}
+
+	// The view is supposed to point into the frame, so changes to the frame should show up
+	value, err := dv.GetValue(0)
+	if err != nil {
+		t.Fatalf("GetValue failed: %s", err.Error())
+	}
+	frame[6] = 'X'
+	if string(value) != "aXcdef" {
+		t.Fatalf("GetValue did not return a sub-slice of the frame: got '%s'", string(value))
+	}
+
+	typeCode, err := dv.GetType(1)
+	if err != nil {
+		t.Fatalf("GetType failed: %s", err.Error())
+	}
+	if typeCode != DFInt64Type {
+		t.Fatalf("GetType mismatch: wanted %d, got %d", DFInt64Type, typeCode)
+	}
+	if _, err := dv.GetType(2); err != ErrNotFound {
+		t.Fatalf("GetType didn't catch an out-of-range index")
+	}
+
+	// Materialized documents should not be affected by later changes to the frame
+	owned, err := dv.Materialize()
+	if err != nil {
+		t.Fatalf("Materialize failed: %s", err.Error())
+	}
+	frame[6] = 'Y'
+	if len(owned.Items) != 2 {
+		t.Fatalf("Materialize item count mismatch: wanted 2, got %d", len(owned.Items))
+	}
+	ownedFrame, err := owned.Flatten()
+	if err != nil {
+		t.Fatalf("Error flattening materialized document: %s", err.Error())
+	}
+	if ownedFrame[6] != 'X' {
+		t.Fatalf("Materialized document shares data with the frame")
+	}
+}
+
+func TestViewDocumentBadFrames(t *testing.T) {
+
+	// Truncated string payload
+	if _, err := ViewDocument([]byte("\x01\x01\x0e\x00\x06abc")); err == nil {
+		t.Fatal("ViewDocument didn't catch a truncated segment")
+	}
+
+	// Segment count mismatch
+	if _, err := ViewDocument([]byte("\x01\x01\x0e\x00\x03abc" +
+		"\x02\x00\x00\x00\x00\x00\x00\x00\x02")); err != ErrSize {
+		t.Fatal("ViewDocument didn't catch a segment count mismatch")
+	}
+
+	// Missing DocumentStart
+	if _, err := ViewDocument([]byte("\x0e\x00\x03abc" +
+		"\x02\x00\x00\x00\x00\x00\x00\x00\x01")); err != ErrInvalidMsg {
+		t.Fatal("ViewDocument didn't catch a missing DocumentStart")
+	}
+}
@@ -0,0 +1,54 @@
+package oganesson
+
+// This file contains support for wiping secrets, such as keys and passwords, out of memory once
+// they are no longer needed so that they don't linger until the garbage collector reuses the
+// memory. Go may still have copied a value elsewhere, such as when converting it to a string, so
+// wiping narrows the window in which a secret can be found rather than closing it.
+
+// Wipe overwrites the segment's value with zeroes. The segment keeps its type and size, so a
+// wiped String is a string of NUL characters and a wiped number is zero.
+func (seg *Segment) Wipe() {
+	for i := range seg.Value {
+		seg.Value[i] = 0
+	}
+}
+
+// WipeSensitive wipes the values of the fields of the Document's maps which have been marked
+// with MarkSensitive(). The values are wiped in place, so any views of the Document which share
+// them are wiped, too; the Document then gets its own copy of its items. ErrFrozen is returned for
+// a frozen Document, since other goroutines may be reading it.
+func (doc *Document) WipeSensitive() error {
+
+	if doc.frozen {
+		return ErrFrozen
+	}
+	if len(doc.sensitive) == 0 {
+		return nil
+	}
+
+	// Calling own() first would only wipe a fresh copy of each secret
+	for i := 0; i < len(doc.Items); i++ {
+		seg, ok := doc.Items[i].(*Segment)
+		if !ok {
+			continue
+		}
+
+		var itemCount uint64
+		switch seg.Type {
+		case DFMapType, DFLargeMapType, DFKeyedMapType:
+			itemCount, _ = seg.GetMapIndex()
+		default:
+			continue
+		}
+		for j := uint64(0); j < itemCount && i+2 < len(doc.Items); j++ {
+			key, keyOK := doc.Items[i+1].(*Segment)
+			value, valueOK := doc.Items[i+2].(*Segment)
+			i += 2
+			if keyOK && valueOK && key.Type == DFStringType && doc.IsSensitive(string(key.Value)) {
+				value.Wipe()
+			}
+		}
+	}
+	doc.own()
+	return nil
+}
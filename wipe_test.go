@@ -0,0 +1,60 @@
+package oganesson
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWipeSensitive(t *testing.T) {
+	b := NewDocumentBuilder(nil)
+	b.AddString(MsgCodeField, "LOGIN")
+	b.AddString("user", "alice")
+	b.AddString("password", "hunter2")
+	b.AddBinary("key", []byte{1, 2, 3})
+	doc, _ := b.Build()
+	doc.MarkSensitive("password", "key")
+
+	if err := doc.WipeSensitive(); err != nil {
+		t.Fatalf("Error wiping sensitive fields: %s", err.Error())
+	}
+	fields, _ := doc.fieldMap()
+	password, _ := fields.GetString("password")
+	key, _ := fields.GetBinary("key")
+	user, _ := fields.GetString("user")
+	if password != "\x00\x00\x00\x00\x00\x00\x00" || !bytes.Equal(key, []byte{0, 0, 0}) {
+		t.Fatalf("Sensitive fields weren't wiped: %q %v", password, key)
+	}
+	if user != "alice" {
+		t.Fatalf("Field which isn't sensitive was wiped")
+	}
+}
+
+func TestWipeSensitiveView(t *testing.T) {
+	b := NewDocumentBuilder(nil)
+	b.AddString(MsgCodeField, "LOGIN")
+	b.AddString("password", "hunter2")
+	doc, _ := b.Build()
+	doc.MarkSensitive("password")
+
+	// The secret must be wiped where it is, not in a copy made for the Document
+	view := doc.View()
+	if err := doc.WipeSensitive(); err != nil {
+		t.Fatalf("Error wiping sensitive fields: %s", err.Error())
+	}
+	for _, d := range []*Document{doc, view} {
+		if v, _ := d.Query("password"); v != "\x00\x00\x00\x00\x00\x00\x00" {
+			t.Fatalf("Shared secret wasn't wiped: %q", v)
+		}
+	}
+
+	// The Document no longer shares its items, so later changes don't reach the view
+	doc.AttachString("user", "alice")
+	if view.Has("user") {
+		t.Fatalf("Document still shares its items after WipeSensitive")
+	}
+
+	view.Freeze()
+	if err := view.WipeSensitive(); err != ErrFrozen {
+		t.Fatalf("Frozen Document wasn't refused: %v", err)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"io"
+	"net"
+)
+
+// writeBuffers writes a series of buffers to a Writer. Connections which support vectored I/O,
+// such as TCP connections, receive them all in one writev call.
+func writeBuffers(w io.Writer, bufs [][]byte) (int64, error) {
+	vectors := net.Buffers(bufs)
+	return vectors.WriteTo(w)
+}
@@ -0,0 +1,20 @@
+//go:build nonet
+
+package oganesson
+
+import "io"
+
+// writeBuffers writes a series of buffers to a Writer. Builds without networking support don't
+// have net.Buffers, so the buffers are written one at a time.
+func writeBuffers(w io.Writer, bufs [][]byte) (int64, error) {
+
+	var out int64
+	for _, buf := range bufs {
+		n, err := w.Write(buf)
+		out += int64(n)
+		if err != nil {
+			return out, err
+		}
+	}
+	return out, nil
+}
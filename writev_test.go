@@ -0,0 +1,68 @@
+//go:build !nonet
+
+package oganesson
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestVectoredWrite(t *testing.T) {
+	doc := NewDocument()
+	doc.AttachString("testString", "abcdef")
+	doc.AttachInt64("testInt", 42)
+	var huge Segment
+	huge.Type = DFHugeStringType
+	huge.Value = []byte("huge")
+	doc.Items = append(doc.Items, &huge)
+
+	flat, err := doc.Flatten()
+	if err != nil {
+		t.Fatalf("Error flattening document: %s", err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := doc.Write(&buf); err != nil {
+		t.Fatalf("Error writing document: %s", err.Error())
+	}
+	if !bytes.Equal(buf.Bytes(), flat) {
+		t.Fatalf("Document.Write output doesn't match Flatten()")
+	}
+
+	// TCP connections take the writev path, so make sure the data survives it
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error setting up listener: %s", err.Error())
+	}
+	defer listener.Close()
+
+	errChan := make(chan error)
+	go func() {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			errChan <- err
+			return
+		}
+		defer conn.Close()
+		errChan <- doc.Write(conn)
+	}()
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Error accepting a connection: %s", err.Error())
+	}
+	defer conn.Close()
+
+	var received Document
+	if err := received.Read(conn); err != nil {
+		t.Fatalf("Error reading document over TCP: %s", err.Error())
+	}
+	if err := <-errChan; err != nil {
+		t.Fatalf("Error writing document over TCP: %s", err.Error())
+	}
+	receivedFlat, _ := received.Flatten()
+	if !bytes.Equal(receivedFlat, flat) {
+		t.Fatalf("Document mismatch after vectored write")
+	}
+}